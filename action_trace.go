@@ -0,0 +1,132 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// actionTrace records the time spent in the GenerateBuildActions method of each module.
+type actionTrace struct {
+	lock   sync.Mutex
+	start  time.Time
+	events []actionTraceEvent
+}
+
+type actionTraceEvent struct {
+	name     string
+	start    time.Time
+	duration time.Duration
+}
+
+func newActionTrace() *actionTrace {
+	return &actionTrace{start: time.Now()}
+}
+
+// add records a call to GenerateBuildActions.  Each call is timed by the goroutine that ran it, so
+// add may be called concurrently.
+func (t *actionTrace) add(name string, start time.Time, duration time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.events = append(t.events, actionTraceEvent{name, start, duration})
+}
+
+// actionTraceName returns the name of a module variant in the action timings.
+func (c *Context) actionTraceName(module *moduleInfo) string {
+	name := c.nameInterface.UniqueName(newNamespaceContext(module), module.group.name)
+	if module.variant.name != "" {
+		name += "{" + module.variant.name + "}"
+	}
+	return name
+}
+
+// SetActionTracing controls whether PrepareBuildActions records the wall time spent in the
+// GenerateBuildActions method of each module, which can then be retrieved with
+// ModuleActionTimings or WriteActionTrace.  It is disabled by default.
+func (c *Context) SetActionTracing(actionTracing bool) {
+	c.actionTracing = actionTracing
+}
+
+// ModuleActionTimings returns the wall time spent in the GenerateBuildActions method of each
+// module variant during the last call to PrepareBuildActions, indexed by the module name followed
+// by the variant name in braces if the variant name is not empty.  It returns nil if action
+// tracing was not enabled with SetActionTracing.
+func (c *Context) ModuleActionTimings() map[string]time.Duration {
+	if c.actionTrace == nil {
+		return nil
+	}
+
+	c.actionTrace.lock.Lock()
+	defer c.actionTrace.lock.Unlock()
+	timings := make(map[string]time.Duration, len(c.actionTrace.events))
+	for _, event := range c.actionTrace.events {
+		timings[event.name] += event.duration
+	}
+	return timings
+}
+
+// WriteActionTrace writes the GenerateBuildActions calls recorded during the last call to
+// PrepareBuildActions to w in the Chrome trace event format, which can be loaded into
+// chrome://tracing or Perfetto.  Calls that ran concurrently are written on separate threads.
+// It returns ErrBuildActionsNotReady if action tracing was not enabled with SetActionTracing or
+// PrepareBuildActions has not been called.
+func (c *Context) WriteActionTrace(w io.Writer) error {
+	if c.actionTrace == nil {
+		return ErrBuildActionsNotReady
+	}
+
+	c.actionTrace.lock.Lock()
+	events := append([]actionTraceEvent(nil), c.actionTrace.events...)
+	c.actionTrace.lock.Unlock()
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].start.Equal(events[j].start) {
+			return events[i].start.Before(events[j].start)
+		}
+		return events[i].name < events[j].name
+	})
+
+	// Assign each event to the first thread that is idle when it starts.
+	var threadEnds []time.Time
+	traceEvents := make([]traceEvent, 0, len(events))
+	for _, event := range events {
+		tid := 0
+		for tid < len(threadEnds) && threadEnds[tid].After(event.start) {
+			tid++
+		}
+		end := event.start.Add(event.duration)
+		if tid == len(threadEnds) {
+			threadEnds = append(threadEnds, end)
+		} else {
+			threadEnds[tid] = end
+		}
+
+		traceEvents = append(traceEvents, traceEvent{
+			Name:  event.name,
+			Phase: "X",
+			Time:  event.start.Sub(c.actionTrace.start).Microseconds(),
+			Dur:   event.duration.Microseconds(),
+			Tid:   tid,
+		})
+	}
+
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}{traceEvents})
+}
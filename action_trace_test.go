@@ -0,0 +1,121 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type actionTraceTestModule struct {
+	SimpleName
+	properties struct {
+		Slow bool
+	}
+}
+
+func newActionTraceTestModule() (Module, []interface{}) {
+	m := &actionTraceTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *actionTraceTestModule) GenerateBuildActions(ModuleContext) {
+	if m.properties.Slow {
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func prepareActionTraceTestContext(t *testing.T, tracing bool) *Context {
+	t.Helper()
+	ctx := NewContext()
+	ctx.SetActionTracing(tracing)
+	ctx.RegisterModuleType("test", newActionTraceTestModule)
+	ctx.RegisterBottomUpMutator("variants", func(ctx BottomUpMutatorContext) {
+		if ctx.ModuleName() == "fast" {
+			ctx.CreateVariations("a", "b")
+		}
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test { name: "slow", slow: true }
+			test { name: "fast" }
+		`),
+	})
+	if _, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	if _, errs := ctx.ResolveDependencies(nil); len(errs) > 0 {
+		t.Fatalf("unexpected dependency errors: %v", errs)
+	}
+	if _, errs := ctx.PrepareBuildActions(nil); len(errs) > 0 {
+		t.Fatalf("unexpected build action errors: %v", errs)
+	}
+	return ctx
+}
+
+func TestModuleActionTimings(t *testing.T) {
+	t.Run("enabled", func(t *testing.T) {
+		ctx := prepareActionTraceTestContext(t, true)
+
+		timings := ctx.ModuleActionTimings()
+		if len(timings) != 3 {
+			t.Errorf("expected timings for 3 module variants, got %v", timings)
+		}
+		if got := timings["slow"]; got < 20*time.Millisecond {
+			t.Errorf("expected slow module to take at least 20ms, got %v", got)
+		}
+		for _, name := range []string{"fast{a}", "fast{b}"} {
+			if _, ok := timings[name]; !ok {
+				t.Errorf("missing timing for %q in %v", name, timings)
+			}
+		}
+
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteActionTrace(buf); err != nil {
+			t.Fatal(err)
+		}
+		var trace struct {
+			TraceEvents []traceEvent `json:"traceEvents"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+			t.Fatalf("invalid trace %q: %s", buf.String(), err)
+		}
+		found := false
+		for _, event := range trace.TraceEvents {
+			if event.Name == "slow" {
+				found = true
+				if event.Phase != "X" || event.Dur < 20000 {
+					t.Errorf("unexpected trace event for slow module: %+v", event)
+				}
+			}
+		}
+		if !found || len(trace.TraceEvents) != 3 {
+			t.Errorf("expected 3 trace events including slow, got %+v", trace.TraceEvents)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		ctx := prepareActionTraceTestContext(t, false)
+
+		if timings := ctx.ModuleActionTimings(); timings != nil {
+			t.Errorf("expected no timings, got %v", timings)
+		}
+		if err := ctx.WriteActionTrace(&bytes.Buffer{}); err != ErrBuildActionsNotReady {
+			t.Errorf("expected ErrBuildActionsNotReady, got %v", err)
+		}
+	})
+}
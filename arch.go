@@ -0,0 +1,79 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+// An ArchConfig is a config that lists the architectures that the mutator registered by
+// RegisterArchMutator splits modules into.
+type ArchConfig interface {
+	// Arches returns the names of the architectures, the first one is used by the modules that
+	// are not split when they depend on a module that is split.
+	Arches() []string
+}
+
+// An ArchModule is a Module that is split into a variant per architecture by the mutator
+// registered by RegisterArchMutator.  Module types usually implement it by embedding SimpleArch
+// and returning its Properties from the factory, so that the architecture is kept when the
+// modules are cloned after the mutators have run.
+type ArchModule interface {
+	Module
+
+	// SetArch is called on each new variant with its architecture.
+	SetArch(arch string)
+}
+
+// SimpleArch is an embeddable implementation of ArchModule that records the architecture of the
+// variant in a property that can't be set in Blueprints files.
+type SimpleArch struct {
+	Properties struct {
+		Arch string `blueprint:"mutated"`
+	}
+}
+
+func (a *SimpleArch) SetArch(arch string) {
+	a.Properties.Arch = arch
+}
+
+// Arch returns the architecture of the variant, or "" if it was not split.
+func (a *SimpleArch) Arch() string {
+	return a.Properties.Arch
+}
+
+// RegisterArchMutator registers a parallel bottom up mutator called name that splits each
+// ArchModule into a variant for each architecture returned by the config passed to
+// ResolveDependencies, which must implement ArchConfig for any module to be split.  The variation
+// of each variant for the mutator is its architecture, so each variant of a module depends on the
+// variant of its dependencies with the same architecture, and modules that are not split depend
+// on the variant of the first architecture.
+func (c *Context) RegisterArchMutator(name string) MutatorHandle {
+	return c.RegisterBottomUpMutator(name, archMutator).Parallel()
+}
+
+func archMutator(ctx BottomUpMutatorContext) {
+	if _, ok := ctx.Module().(ArchModule); !ok {
+		return
+	}
+	config, ok := ctx.Config().(ArchConfig)
+	if !ok {
+		return
+	}
+	arches := config.Arches()
+	if len(arches) == 0 {
+		return
+	}
+
+	for i, variant := range ctx.CreateVariations(arches...) {
+		variant.(ArchModule).SetArch(arches[i])
+	}
+}
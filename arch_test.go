@@ -0,0 +1,115 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"testing"
+)
+
+type archTestConfig []string
+
+func (c archTestConfig) Arches() []string { return c }
+
+type archTestModule struct {
+	SimpleName
+	SimpleArch
+	properties struct {
+		Deps []string
+	}
+}
+
+func newArchTestModule() (Module, []interface{}) {
+	m := &archTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleArch.Properties}
+}
+
+func (m *archTestModule) DynamicDependencies(DynamicDependerModuleContext) []string {
+	return m.properties.Deps
+}
+
+func (m *archTestModule) GenerateBuildActions(ModuleContext) {}
+
+// archTestToolModule is not an ArchModule, so it is not split.
+type archTestToolModule struct {
+	fooModule
+}
+
+func newArchTestToolModule() (Module, []interface{}) {
+	m := &archTestToolModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *archTestToolModule) DynamicDependencies(DynamicDependerModuleContext) []string {
+	return m.properties.Deps
+}
+
+func TestArchMutator(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newArchTestModule)
+	ctx.RegisterModuleType("tool", newArchTestToolModule)
+	ctx.RegisterArchMutator("arch")
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test {
+				name: "lib",
+			}
+
+			test {
+				name: "app",
+				deps: ["lib"],
+			}
+
+			tool {
+				name: "tool",
+				deps: ["app"],
+			}
+		`),
+	})
+
+	config := archTestConfig{"arm64", "x86_64"}
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, config)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	_, errs = ctx.ResolveDependencies(config)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected dep errors: %v", errs)
+	}
+
+	for _, arch := range config {
+		lib := ctx.moduleGroupFromName("lib", nil).moduleByVariantName(arch)
+		app := ctx.moduleGroupFromName("app", nil).moduleByVariantName(arch)
+		if lib == nil || app == nil {
+			t.Fatalf("expected %s variants of lib and app", arch)
+		}
+		for _, m := range []*moduleInfo{lib, app} {
+			if got := m.logicModule.(*archTestModule).Arch(); got != arch {
+				t.Errorf("expected %s arch %q, got %q", m, arch, got)
+			}
+		}
+		if len(app.directDeps) != 1 || app.directDeps[0].module != lib {
+			t.Errorf("expected app{%s} to depend on lib{%s}, got %v", arch, arch, app.directDeps)
+		}
+	}
+
+	tool := ctx.moduleGroupFromName("tool", nil).modules.firstModule()
+	if len(ctx.moduleGroupFromName("tool", nil).modules) != 1 {
+		t.Errorf("expected tool to not be split")
+	}
+	firstApp := ctx.moduleGroupFromName("app", nil).moduleByVariantName(config[0])
+	if len(tool.directDeps) != 1 || tool.directDeps[0].module != firstApp {
+		t.Errorf("expected tool to depend on app{%s}, got %v", config[0], tool.directDeps)
+	}
+}
@@ -212,7 +212,8 @@ func nestedPropertyStructs(s reflect.Value) []nestedProperty {
 			fieldValue := structValue.Field(i)
 
 			switch fieldValue.Kind() {
-			case reflect.Bool, reflect.String, reflect.Slice, reflect.Int, reflect.Uint:
+			case reflect.Bool, reflect.String, reflect.Slice, reflect.Map, reflect.Int, reflect.Uint,
+				reflect.Int64, reflect.Float64:
 				// Nothing
 			case reflect.Struct:
 				nestStruct(field, fieldValue, field.Name)
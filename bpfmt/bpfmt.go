@@ -26,6 +26,7 @@ var (
 	writeToStout        = flag.Bool("o", false, "write result to stdout")
 	doDiff              = flag.Bool("d", false, "display diffs instead of rewriting files")
 	sortLists           = flag.Bool("s", false, "sort arrays")
+	keepFirst           = flag.Bool("keep_first", false, "with -s, keep the first element of each array in place")
 )
 
 var (
@@ -75,7 +76,7 @@ func processReader(filename string, in io.Reader, out io.Writer) error {
 	}
 
 	if *sortLists {
-		parser.SortLists(file)
+		parser.SortListsWithOptions(file, parser.SortOptions{KeepFirst: *keepFirst})
 	}
 
 	res, err := parser.Print(file)
@@ -138,6 +139,10 @@ func main() {
 		usageViolation("one of -d, -l, -o, or -w is required")
 	}
 
+	if *keepFirst && !*sortLists {
+		usageViolation("-keep_first requires -s")
+	}
+
 	if flag.NArg() == 0 {
 		// file to parse is stdin
 		if *overwriteSourceFile {
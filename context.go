@@ -19,6 +19,8 @@ import (
 	"bytes"
 	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -33,11 +35,13 @@ import (
 	"runtime/pprof"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"text/scanner"
 	"text/template"
+	"time"
 	"unsafe"
 
 	"github.com/google/blueprint/metrics"
@@ -47,6 +51,7 @@ import (
 )
 
 var ErrBuildActionsNotReady = errors.New("build actions are not ready")
+var ErrDependenciesNotReady = errors.New("dependencies are not resolved")
 
 const maxErrors = 10
 const MockModuleListFile = "bplist"
@@ -96,6 +101,10 @@ type Context struct {
 
 	transitionMutators []*transitionMutatorImpl
 
+	// positions of the later definitions of module names defined more than once in the parsed
+	// Blueprints files, keyed by the group of the first definition, see duplicateModuleErrors
+	duplicateModules map[*moduleGroup][]scanner.Position
+
 	depsModified uint32 // positive if a mutator modified the dependencies
 
 	dependenciesReady bool // set to true on a successful ResolveDependencies
@@ -107,8 +116,68 @@ type Context struct {
 	// set by SetAllowMissingDependencies
 	allowMissingDependencies bool
 
+	// set by AllowDependencyCycles
+	allowedCycleTags []DependencyTag
+
 	verifyProvidersAreUnchanged bool
 
+	// set by SetTrackLiveReferences
+	trackLiveReferences bool
+
+	// set by SetDeduplicateRules
+	deduplicateRules bool
+
+	// set by SetValidateRuleVariables
+	validateRuleVariables bool
+
+	// set by SetNinjaLineWidth
+	ninjaLineWidth int
+
+	// set by SetNinjaModuleComments
+	ninjaModuleComments bool
+
+	// set by SetSortBuildStatements
+	sortBuildStatements bool
+
+	// set by SetCommandTransform
+	commandTransform func(ruleName, command string) string
+
+	// set by SetNinjaSandboxComments
+	ninjaSandboxComments bool
+
+	// set by SetParallelSingletons
+	parallelSingletons bool
+
+	// set by SetNinjaOutputSplitting
+	ninjaOutputSplitting NinjaOutputSplitting
+
+	// set by SetActionTracing
+	actionTracing bool
+
+	// set by SetPhaseTracing
+	phaseTracer *phaseTracer
+
+	// set by SetIncrementalReparse
+	reparse *reparseState
+
+	// set by SetEnv
+	env *parser.Environment
+
+	// set by SetVisibilityWarnings
+	visibilityWarnings bool
+
+	// reported by warn, see Warnings
+	warningsLock sync.Mutex
+	warnings     []Warning
+	// number of warnings reported before the build actions were generated, see resetBuildActions
+	resolveWarnings int
+
+	// set by TopDownMutatorContext.CreateAlias
+	nameAliases map[nameAliasKey]string
+
+	// set during PrepareBuildActions if actionTracing is set
+	actionTrace *actionTrace
+
 	// set during PrepareBuildActions
 	nameTracker     *nameTracker
 	liveGlobals     *liveTracker
@@ -132,10 +201,38 @@ type Context struct {
 	globs    map[globKey]pathtools.GlobResult
 	globLock sync.Mutex
 
+	// set by SetGlobCacheFile
+	globCacheFile string
+
+	globCacheOnce    sync.Once
+	globCache        map[globKey]globCacheEntry // globs read from globCacheFile
+	globCacheResults map[globKey][]globCacheDep // dependency state of globs evaluated in this run
+
+	// values of the variables created by CacheableVariableFunc
+	variableFuncCache     map[variableFuncCacheKey]*variableFuncCacheEntry
+	variableFuncCacheLock sync.Mutex
+
+	// files returned by the parse, resolve and prepare phases, see GeneratorDependencies
+	generatorDeps []string
+	// number of generatorDeps returned before the build actions were generated, see
+	// resetBuildActions
+	resolveGeneratorDeps int
+
+	// set by SetGlobDirectoryDeps
+	globDirectoryDeps bool
+
+	// set by SetReportEmptyGlobs
+	reportEmptyGlobs bool
+	// warnings about the globs in globs that matched nothing, returned by Warnings
+	emptyGlobWarnings map[globKey]Warning
+
 	srcDir         string
 	fs             pathtools.FileSystem
 	moduleListFile string
 
+	// set by SetFileLoader
+	fileLoader func(path string) ([]byte, error)
+
 	// Mutators indexed by the ID of the provider associated with them.  Not all mutators will
 	// have providers, and not all providers will have a mutator, or if they do the mutator may
 	// not be registered in this Context.
@@ -255,6 +352,15 @@ type localBuildActions struct {
 	variables []*localVariable
 	rules     []*localRule
 	buildDefs []*buildDef
+	phonys    []phonyDef
+	defaults  []string
+}
+
+// phonyDef is a phony target declared by ModuleContext.Phony.  Phony targets with the same name
+// across all modules are merged into a single build statement when the ninja file is written.
+type phonyDef struct {
+	name string
+	deps []string
 }
 
 type moduleAlias struct {
@@ -340,6 +446,15 @@ type moduleInfo struct {
 	missingDeps   []string
 	newDirectDeps []depInfo
 
+	// set by AddOptionalDependency
+	missingOptionalDeps []string
+
+	// set by blueprintDisabledMutator
+	disabled bool
+
+	// set by updateDirectDependents at the end of ResolveDependencies
+	directDependents []*moduleInfo
+
 	// set during updateDependencies
 	reverseDeps []*moduleInfo
 	forwardDeps []*moduleInfo
@@ -367,6 +482,12 @@ type moduleInfo struct {
 	providers                  []interface{}
 	providerInitialValueHashes []uint64
 
+	// the other modules whose providers this module read, see InvalidateProviders
+	providerReads map[*moduleInfo]bool
+
+	// the ninja file deps added by GenerateBuildActions
+	ninjaFileDeps []string
+
 	startedMutator  *mutatorInfo
 	finishedMutator *mutatorInfo
 
@@ -454,6 +575,9 @@ type singletonInfo struct {
 	name      string
 	parallel  bool
 
+	// set by MustRunAfter
+	runAfter []string
+
 	// set during PrepareBuildActions
 	actionDefs localBuildActions
 }
@@ -465,6 +589,9 @@ type mutatorInfo struct {
 	name              string
 	parallel          bool
 	transitionMutator *transitionMutatorImpl
+
+	// set by MustRunAfter
+	runAfter []string
 }
 
 func newContext() *Context {
@@ -475,7 +602,11 @@ func newContext() *Context {
 		moduleFactories:             make(map[string]ModuleFactory),
 		nameInterface:               NewSimpleNameInterface(),
 		moduleInfo:                  make(map[Module]*moduleInfo),
+		duplicateModules:            make(map[*moduleGroup][]scanner.Position),
 		globs:                       make(map[globKey]pathtools.GlobResult),
+		globCacheResults:            make(map[globKey][]globCacheDep),
+		variableFuncCache:           make(map[variableFuncCacheKey]*variableFuncCacheEntry),
+		emptyGlobWarnings:           make(map[globKey]Warning),
 		fs:                          pathtools.OsFs,
 		finishedMutators:            make(map[*mutatorInfo]bool),
 		includeTags:                 &IncludeTags{},
@@ -485,6 +616,10 @@ func newContext() *Context {
 		requiredNinjaMinor:          7,
 		requiredNinjaMicro:          0,
 		verifyProvidersAreUnchanged: true,
+		deduplicateRules:            true,
+		ninjaLineWidth:              lineWidth,
+		ninjaModuleComments:         true,
+		sortBuildStatements:         true,
 	}
 }
 
@@ -495,7 +630,11 @@ func newContext() *Context {
 func NewContext() *Context {
 	ctx := newContext()
 
+	ctx.RegisterModuleType("defaults", newDefaultsModule)
+
+	ctx.RegisterBottomUpMutator("blueprint_defaults", blueprintDefaultsMutator).Parallel()
 	ctx.RegisterBottomUpMutator("blueprint_deps", blueprintDepsMutator)
+	ctx.RegisterBottomUpMutator("blueprint_disabled", blueprintDisabledMutator).Parallel()
 
 	return ctx
 }
@@ -583,24 +722,43 @@ type SingletonFactory func() Singleton
 // and invoked exactly once as part of the generate phase.
 //
 // Those singletons registered with parallel=true are run in parallel, after
-// which the other registered singletons are run in registration order.
+// which the other registered singletons are run in registration order.  A
+// parallel singleton that must run after another singleton (see
+// SingletonHandle.MustRunAfter) is run with the non-parallel singletons instead.
 //
 // The singleton type names given here must be unique for the context.  The
 // factory function should be a named function so that its package and name can
 // be included in the generated Ninja file for debugging purposes.
-func (c *Context) RegisterSingletonType(name string, factory SingletonFactory, parallel bool) {
+func (c *Context) RegisterSingletonType(name string, factory SingletonFactory, parallel bool) SingletonHandle {
 	for _, s := range c.singletonInfo {
 		if s.name == name {
 			panic(fmt.Errorf("singleton %q is already registered", name))
 		}
 	}
 
-	c.singletonInfo = append(c.singletonInfo, &singletonInfo{
+	info := &singletonInfo{
 		factory:   factory,
 		singleton: factory(),
 		name:      name,
 		parallel:  parallel,
-	})
+	}
+	c.singletonInfo = append(c.singletonInfo, info)
+
+	return info
+}
+
+type SingletonHandle interface {
+	// MustRunAfter declares that the singleton must run after the singleton registered with the
+	// given name, regardless of the order in which they were registered, so that it can use data
+	// produced by that singleton.  Singletons are otherwise run in registration order.
+	// PrepareBuildActions reports an error if the name is not registered or the constraints form a
+	// cycle.
+	MustRunAfter(name string) SingletonHandle
+}
+
+func (s *singletonInfo) MustRunAfter(name string) SingletonHandle {
+	s.runAfter = append(s.runAfter, name)
+	return s
 }
 
 func (c *Context) SetNameInterface(i NameInterface) {
@@ -634,8 +792,9 @@ func singletonTypeName(singleton Singleton) string {
 
 // RegisterTopDownMutator registers a mutator that will be invoked to propagate dependency info
 // top-down between Modules.  Each registered mutator is invoked in registration order (mixing
-// TopDownMutators and BottomUpMutators) once per Module, and the invocation on any module will
-// have returned before it is in invoked on any of its dependencies.
+// TopDownMutators and BottomUpMutators, unless reordered by MutatorHandle.MustRunAfter) once per
+// Module, and the invocation on any module will have returned before it is in invoked on any of
+// its dependencies.
 //
 // The mutator type names given here must be unique to all top down mutators in
 // the Context.
@@ -661,8 +820,8 @@ func (c *Context) RegisterTopDownMutator(name string, mutator TopDownMutator) Mu
 
 // RegisterBottomUpMutator registers a mutator that will be invoked to split Modules into variants.
 // Each registered mutator is invoked in registration order (mixing TopDownMutators and
-// BottomUpMutators) once per Module, will not be invoked on a module until the invocations on all
-// of the modules dependencies have returned.
+// BottomUpMutators, unless reordered by MutatorHandle.MustRunAfter) once per Module, will not be
+// invoked on a module until the invocations on all of the modules dependencies have returned.
 //
 // The mutator type names given here must be unique to all bottom up or early
 // mutators in the Context.
@@ -691,8 +850,16 @@ type MutatorHandle interface {
 	// Set the mutator to visit modules in parallel while maintaining ordering.  Calling any
 	// method on the mutator context is thread-safe, but the mutator must handle synchronization
 	// for any modifications to global state or any modules outside the one it was invoked on.
+	// A parallel top down mutator visits independent subtrees concurrently, but is still only
+	// invoked on a module once the invocations on every module that depends on it have returned.
 	Parallel() MutatorHandle
 
+	// MustRunAfter declares that the mutator must run after all mutators registered with the given
+	// name, regardless of the order in which they were registered.  Mutators are otherwise run in
+	// registration order.  ResolveDependencies reports an error if the name is not registered or
+	// the constraints form a cycle.
+	MustRunAfter(name string) MutatorHandle
+
 	setTransitionMutator(impl *transitionMutatorImpl) MutatorHandle
 }
 
@@ -701,6 +868,11 @@ func (mutator *mutatorInfo) Parallel() MutatorHandle {
 	return mutator
 }
 
+func (mutator *mutatorInfo) MustRunAfter(name string) MutatorHandle {
+	mutator.runAfter = append(mutator.runAfter, name)
+	return mutator
+}
+
 func (mutator *mutatorInfo) setTransitionMutator(impl *transitionMutatorImpl) MutatorHandle {
 	mutator.transitionMutator = impl
 	return mutator
@@ -738,12 +910,170 @@ func (c *Context) GetVerifyProvidersAreUnchanged() bool {
 	return c.verifyProvidersAreUnchanged
 }
 
+// SetTrackLiveReferences makes blueprint record which entity caused each variable, rule and pool
+// to be written to the ninja file, so that LiveVariableReferencePath can explain why a variable was
+// emitted.  It must be called before ResolveDependencies, and is disabled by default because it
+// retains an additional entry for every live entity.
+func (c *Context) SetTrackLiveReferences(trackLiveReferences bool) {
+	c.trackLiveReferences = trackLiveReferences
+}
+
+// SetDeduplicateRules controls whether WriteBuildFile collapses global rules that are identical
+// after variable evaluation into a single ninja rule.  It is enabled by default.
+func (c *Context) SetDeduplicateRules(deduplicateRules bool) {
+	c.deduplicateRules = deduplicateRules
+}
+
+// SetValidateRuleVariables makes PrepareBuildActions check that every variable referenced by a
+// live global rule is an argument of the rule, a Ninja built-in such as $in and $out, or a live
+// global variable, and report an error naming the rule for each one that isn't.  It is disabled
+// by default.
+func (c *Context) SetValidateRuleVariables(validateRuleVariables bool) {
+	c.validateRuleVariables = validateRuleVariables
+}
+
+// SetNinjaLineWidth sets the width at which WriteBuildFile wraps the comments and the build and
+// default statements of the ninja file, which is 80 by default.  Build and default statements are
+// only wrapped between paths using "$\n" continuations, so lines with a single long path may still
+// exceed the width.  A width of 0 or less disables wrapping.
+func (c *Context) SetNinjaLineWidth(width int) {
+	c.ninjaLineWidth = width
+}
+
+// SetNinjaModuleComments controls whether WriteBuildFile writes a comment before the build actions
+// of each module that lists its name, variant, type, factory and the location of its definition.
+// The comments don't affect the build, and are enabled by default.
+func (c *Context) SetNinjaModuleComments(moduleComments bool) {
+	c.ninjaModuleComments = moduleComments
+}
+
+// SetSortBuildStatements controls whether WriteBuildFile sorts the local rules of each module and
+// singleton by name and their build statements by primary output, so that the ninja file doesn't
+// depend on the order in which they were created.  Modules are always written in order of name
+// and variant, and local variables in the order they were created as they may refer to each
+// other.  It is enabled by default.
+func (c *Context) SetSortBuildStatements(sortBuildStatements bool) {
+	c.sortBuildStatements = sortBuildStatements
+}
+
+// SetCommandTransform sets a function that WriteBuildFile calls with the name and the command of
+// each rule, global or local, and whose result replaces the command in the ninja file.  It can be
+// used to rewrite path separators or to wrap the commands in a sandbox.  The command is passed in
+// ninja syntax, after the global variables have been replaced by their ninja names and before
+// line breaks are escaped, so references such as ${in}, ${out} and ${g.pkg.var} are intact and
+// "$$" is a literal "$".  The transform must keep them intact and return a valid ninja command.
+// Passing nil removes the transform.
+func (c *Context) SetCommandTransform(transform func(ruleName, command string) string) {
+	c.commandTransform = transform
+}
+
+// SetNinjaSandboxComments controls whether WriteBuildFile writes a comment before each build
+// statement that declares a BuildParams.Sandbox, listing its inputs, outputs and tools.  It is
+// disabled by default.
+func (c *Context) SetNinjaSandboxComments(sandboxComments bool) {
+	c.ninjaSandboxComments = sandboxComments
+}
+
+// AllowDependencyCycles makes dependency cycles in which every dependency has one of the given
+// tags warnings in the DiagnosticCodeDependencyCycle category instead of errors, for tags of
+// dependencies that are informational and don't order the mutators or the build actions of the
+// modules.  Visitors still see those dependencies, but the order in which bottom up visitors see
+// the modules of an allowed cycle is unspecified.  Cycles that include any dependency with another
+// tag, or between variants of the same module, remain errors.  Passing no tags makes all cycles
+// errors again.
+func (c *Context) AllowDependencyCycles(tags []DependencyTag) {
+	c.allowedCycleTags = slices.Clone(tags)
+}
+
+// newNinjaWriter returns a ninjaWriter that wraps lines at the width set by SetNinjaLineWidth.
+func (c *Context) newNinjaWriter(w StringWriterWriter) *ninjaWriter {
+	nw := newNinjaWriter(w)
+	nw.lineWidth = c.ninjaLineWidth
+	return nw
+}
+
+// SetParallelSingletons controls whether PrepareBuildActions runs every singleton in parallel, as if
+// it had been registered with parallel=true.  It should only be enabled if none of the singletons
+// modify state shared with other singletons.  Singletons that must run after another singleton are
+// still run in order after the parallel singletons.  The build actions of each singleton are
+// collected separately and written in registration order, so the output is the same whether or
+// not singletons are run in parallel.
+func (c *Context) SetParallelSingletons(parallelSingletons bool) {
+	c.parallelSingletons = parallelSingletons
+}
+
+// NinjaOutputSplitting selects how WriteBuildFile divides the build actions of modules between
+// ninja files.
+type NinjaOutputSplitting int
+
+const (
+	// NinjaOutputMonolithic writes all build actions to the file passed to WriteBuildFile.
+	NinjaOutputMonolithic NinjaOutputSplitting = iota
+
+	// NinjaOutputPerPackage writes the build actions of the modules in each directory that contains
+	// a Blueprints file to a separate ninja file, which is included with subninja from the file
+	// passed to WriteBuildFile.  Global variables, pools and rules and the build actions of
+	// singletons are still written to the top-level file.
+	NinjaOutputPerPackage
+)
+
+// SetNinjaOutputSplitting controls how WriteBuildFile divides the build actions of modules between
+// ninja files.  The default is NinjaOutputMonolithic.
+func (c *Context) SetNinjaOutputSplitting(mode NinjaOutputSplitting) {
+	c.ninjaOutputSplitting = mode
+}
+
+// SetEnv sets the environment variables that can be read by soong_env expressions in Blueprints
+// files, which are otherwise all undefined.  The variables that were read are returned by
+// EnvDeps.  If incremental reparsing was enabled with SetIncrementalReparse, SetEnv discards the
+// parsed files that it would have reused, since they may have read the previous environment.
+func (c *Context) SetEnv(env map[string]string) {
+	c.env = parser.NewEnvironment(env)
+	if c.reparse != nil {
+		c.reparse = newReparseState()
+	}
+}
+
+// EnvDeps returns the environment variables that were read by soong_env expressions in the
+// Blueprints files parsed so far, and their values, with an empty value for variables that were
+// not defined.  The output of the Context depends on them, so the caller must arrange for it to
+// be regenerated when any of them changes, for example by comparing them to the environment
+// before running ninja.  It returns nil if SetEnv was not called.
+func (c *Context) EnvDeps() map[string]string {
+	if c.env == nil {
+		return nil
+	}
+	return c.env.Deps()
+}
+
+// GeneratorDependencies returns the sorted list of files and directories that the output of the
+// Context depends on, so that the caller can regenerate it when any of them changes, for example
+// by writing them to a ninja depfile.  It combines the module list file and the Blueprints files
+// parsed so far, the directories searched by globs, and the files returned by
+// ResolveDependencies and PrepareBuildActions, which include the files passed to
+// AddNinjaFileDeps.  The environment variables read by Blueprints files are returned by EnvDeps
+// instead.
+func (c *Context) GeneratorDependencies() []string {
+	deps := slices.Concat(c.generatorDeps, c.Globs().Deps())
+	slices.Sort(deps)
+	return slices.Compact(deps)
+}
+
+// newRootScope returns the scope of a Blueprints file that isn't included by another one.
+func (c *Context) newRootScope() *parser.Scope {
+	scope := parser.NewScope(nil)
+	if c.env != nil {
+		scope.SetEnvironment(c.env)
+	}
+	return scope
+}
+
 func (c *Context) SetModuleListFile(listFile string) {
 	c.moduleListFile = listFile
 }
 
 func (c *Context) ListModulePaths(baseDir string) (paths []string, err error) {
-	reader, err := c.fs.Open(c.moduleListFile)
+	reader, err := c.openFile(c.moduleListFile)
 	if err != nil {
 		return nil, err
 	}
@@ -760,6 +1090,7 @@ func (c *Context) ListModulePaths(baseDir string) (paths []string, err error) {
 		lines[i] = filepath.Join(baseDir, lines[i])
 	}
 
+	c.generatorDeps = append(c.generatorDeps, c.moduleListFile)
 	return lines, nil
 }
 
@@ -841,8 +1172,15 @@ func (c *Context) ParseFileList(rootDir string, filePaths []string,
 		return nil, []error{fmt.Errorf("no paths provided to parse")}
 	}
 
+	c.BeginEvent("parse")
+	defer c.EndEvent("parse")
+
 	c.dependenciesReady = false
 
+	if c.reparse != nil {
+		c.reparse.recordParse(rootDir, filePaths)
+	}
+
 	type newModuleInfo struct {
 		*moduleInfo
 		deps  []string
@@ -893,6 +1231,9 @@ func (c *Context) ParseFileList(rootDir string, filePaths []string,
 			return nil
 		}
 		shouldVisitInfo := shouldVisitFile(c, file)
+		if c.reparse != nil {
+			c.reparse.recordFile(rootDir, file, !shouldVisitInfo.shouldVisitFile)
+		}
 		errs := shouldVisitInfo.errs
 		if len(errs) > 0 {
 			atomic.AddUint32(&numErrs, uint32(len(errs)))
@@ -910,7 +1251,8 @@ func (c *Context) ParseFileList(rootDir string, filePaths []string,
 		for _, def := range file.Defs {
 			switch def := def.(type) {
 			case *parser.Module:
-				module, errs := processModuleDef(def, file.Name, c.moduleFactories, scopedModuleFactories, c.ignoreUnknownModuleTypes)
+				module, warnings, errs := processModuleDef(def, file.Name, c.moduleFactories, scopedModuleFactories, c.ignoreUnknownModuleTypes)
+				c.warn(warnings...)
 				if len(errs) == 0 && module != nil {
 					errs = addModule(module)
 				}
@@ -947,6 +1289,14 @@ loop:
 			errs = append(errs, newErrs...)
 		case module := <-moduleCh:
 			newErrs := c.addModule(module.moduleInfo)
+			if previous := alreadyDefinedGroup(newErrs); previous != nil {
+				// Keep parsing, all the definitions of the name are reported together once
+				// every file has been parsed.
+				c.duplicateModules[previous] = append(c.duplicateModules[previous], module.moduleInfo.pos)
+				newErrs = nil
+			} else if len(newErrs) == 0 && c.reparse != nil {
+				c.reparse.recordModule(module.moduleInfo)
+			}
 			hookDeps = append(hookDeps, module.deps...)
 			if module.added != nil {
 				module.added <- struct{}{}
@@ -970,7 +1320,10 @@ loop:
 		}
 	}
 
+	errs = append(errs, c.duplicateModuleErrors()...)
+
 	deps = append(deps, hookDeps...)
+	c.generatorDeps = append(c.generatorDeps, deps...)
 	return deps, errs
 }
 
@@ -1082,7 +1435,7 @@ func (c *Context) WalkBlueprintsFiles(rootDir string, filePaths []string,
 	}
 
 	// begin parsing any files that have no ancestors
-	startParseDescendants(fileParseContext{"", parser.NewScope(nil), nil, nil})
+	startParseDescendants(fileParseContext{"", c.newRootScope(), nil, nil})
 
 loop:
 	for {
@@ -1150,24 +1503,57 @@ func (c *Context) MockFileSystem(files map[string][]byte) {
 	c.fs = pathtools.MockFs(files)
 }
 
+// SetFs sets the filesystem that Blueprint uses to read Blueprints files and the module list
+// file, to evaluate globs and to check the dependencies of cached globs.  It defaults to the
+// real disk.  pathtools.NewIOFs can be used to run Blueprint against an fs.FS, for example an
+// in-memory filesystem or an archive.  The glob cache file set by SetGlobCacheFile is still read
+// from and written to the real disk.
 func (c *Context) SetFs(fs pathtools.FileSystem) {
 	c.fs = fs
 }
 
+// SetFileLoader sets a function that Blueprint calls to read the contents of Blueprints files and
+// of the module list file instead of reading them from the filesystem, for example to preprocess
+// them or to serve them from memory.  The loader is also used by globs, including those of
+// "build" and of module glob functions, to check that files exist, with an error for which
+// errors.Is(err, os.ErrNotExist) is true meaning the file does not exist.  The loader can't list
+// directories, so wildcards are expanded against the filesystem set by SetFs and only the matching
+// files that the loader can load are returned, while a pattern without wildcards matches a file
+// that the loader can load even if it doesn't exist on the filesystem.
+func (c *Context) SetFileLoader(loader func(path string) ([]byte, error)) {
+	c.fileLoader = loader
+}
+
+// openFile opens a Blueprints file or the module list file through the loader set by
+// SetFileLoader, or from the filesystem if there is none.
+func (c *Context) openFile(filename string) (io.ReadCloser, error) {
+	if c.fileLoader == nil {
+		return c.fs.Open(filename)
+	}
+	contents, err := c.fileLoader(filename)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(contents)), nil
+}
+
 // openAndParse opens and parses a single Blueprints file, and returns the results
 func (c *Context) openAndParse(filename string, scope *parser.Scope, rootDir string,
 	parent *fileParseContext) (file *parser.File,
 	subBlueprints []fileParseContext, deps []string, errs []error) {
 
-	f, err := c.fs.Open(filename)
+	f, err := c.openFile(filename)
 	if err != nil {
+		if c.fileLoader != nil {
+			return nil, nil, nil, []error{fmt.Errorf("could not load %v: %v", filename, err)}
+		}
 		// couldn't open the file; see if we can provide a clearer error than "could not open file"
 		stats, statErr := c.fs.Lstat(filename)
 		if statErr == nil {
 			isSymlink := stats.Mode()&os.ModeSymlink != 0
 			if isSymlink {
 				err = fmt.Errorf("could not open symlink %v : %v", filename, err)
-				target, readlinkErr := os.Readlink(filename)
+				target, readlinkErr := c.fs.Readlink(filename)
 				if readlinkErr == nil {
 					_, targetStatsErr := c.fs.Lstat(target)
 					if targetStatsErr != nil {
@@ -1229,7 +1615,7 @@ func (c *Context) parseOne(rootDir, filename string, reader io.Reader,
 		for i, err := range errs {
 			if parseErr, ok := err.(*parser.ParseError); ok {
 				err = &BlueprintError{
-					Err: parseErr.Err,
+					Err: withDiagnosticCode(parseErr.Err, DiagnosticCodeSyntax),
 					Pos: parseErr.Pos,
 				}
 				errs[i] = err
@@ -1242,6 +1628,10 @@ func (c *Context) parseOne(rootDir, filename string, reader io.Reader,
 	}
 	file.Name = relBlueprintsFile
 
+	if c.reparse != nil {
+		c.reparse.recordScope(relBlueprintsFile, scope)
+	}
+
 	build, buildPos, err := getLocalStringListFromScope(scope, "build")
 	if err != nil {
 		errs = append(errs, err)
@@ -1463,6 +1853,7 @@ func (c *Context) createVariations(origModule *moduleInfo, mutator *mutatorInfo,
 		m := *origModule
 		newModule := &m
 		newModule.directDeps = slices.Clone(origModule.directDeps)
+		newModule.missingOptionalDeps = slices.Clone(origModule.missingOptionalDeps)
 		newModule.reverseDeps = nil
 		newModule.forwardDeps = nil
 		newModule.logicModule = newLogicModule
@@ -1470,6 +1861,7 @@ func (c *Context) createVariations(origModule *moduleInfo, mutator *mutatorInfo,
 		newModule.properties = newProperties
 		newModule.providers = slices.Clone(origModule.providers)
 		newModule.providerInitialValueHashes = slices.Clone(origModule.providerInitialValueHashes)
+		newModule.providerReads = maps.Clone(origModule.providerReads)
 
 		newModules = append(newModules, newModule)
 
@@ -1584,8 +1976,11 @@ func newModule(factory ModuleFactory) *moduleInfo {
 	}
 }
 
+// processModuleDef creates a module from its definition in a Blueprints file.  It returns the
+// module, warnings about its properties and any errors.
 func processModuleDef(moduleDef *parser.Module,
-	relBlueprintsFile string, moduleFactories, scopedModuleFactories map[string]ModuleFactory, ignoreUnknownModuleTypes bool) (*moduleInfo, []error) {
+	relBlueprintsFile string, moduleFactories, scopedModuleFactories map[string]ModuleFactory,
+	ignoreUnknownModuleTypes bool) (module *moduleInfo, warnings []Warning, errs []error) {
 
 	factory, ok := moduleFactories[moduleDef.Type]
 	if !ok && scopedModuleFactories != nil {
@@ -1593,34 +1988,61 @@ func processModuleDef(moduleDef *parser.Module,
 	}
 	if !ok {
 		if ignoreUnknownModuleTypes {
-			return nil, nil
+			return nil, nil, nil
 		}
 
-		return nil, []error{
+		return nil, nil, []error{
 			&BlueprintError{
-				Err: fmt.Errorf("unrecognized module type %q", moduleDef.Type),
+				Err: withDiagnosticCode(fmt.Errorf("unrecognized module type %q", moduleDef.Type),
+					DiagnosticCodeUnknownModuleType),
 				Pos: moduleDef.TypePos,
 			},
 		}
 	}
 
-	module := newModule(factory)
+	module = newModule(factory)
 	module.typeName = moduleDef.Type
 
 	module.relBlueprintsFile = relBlueprintsFile
 
-	propertyMap, errs := proptools.UnpackProperties(moduleDef.Properties, module.properties...)
+	properties := moduleDef.Properties
+	if defaults, ok := module.logicModule.(*defaultsModule); ok {
+		properties = defaults.takeProperties(properties)
+	}
+
+	propertyMap, unpackWarnings, errs := proptools.UnpackPropertiesWithWarnings(properties, module.properties...)
+	for _, warning := range unpackWarnings {
+		warnings = append(warnings, Warning{
+			Pos:      warning.Pos,
+			Message:  warning.Err.Error(),
+			Category: DiagnosticCodeDeprecatedProperty,
+		})
+	}
+	if m, ok := module.logicModule.(DefaultableModule); ok && len(m.Defaults()) > 0 {
+		// Required properties may be set by the defaults, blueprintDefaultsMutator checks them
+		// after applying the defaults.
+		errs = slices.DeleteFunc(errs, func(err error) bool {
+			unpackErr, ok := err.(*proptools.UnpackError)
+			return ok && !unpackErr.Pos.IsValid() &&
+				errors.Is(unpackErr.Err, proptools.ErrMissingRequiredProperty)
+		})
+	}
 	if len(errs) > 0 {
 		for i, err := range errs {
 			if unpackErr, ok := err.(*proptools.UnpackError); ok {
+				pos := unpackErr.Pos
+				if !pos.IsValid() {
+					// Missing required properties have no position, report them at the module.
+					pos = moduleDef.TypePos
+				}
 				err = &BlueprintError{
-					Err: unpackErr.Err,
-					Pos: unpackErr.Pos,
+					Err: withDiagnosticCode(unpackErr.Err, DiagnosticCodeProperty),
+					Pos: pos,
 				}
 				errs[i] = err
 			}
 		}
-		return nil, errs
+		return nil, warnings, errs
 	}
 
 	module.pos = moduleDef.TypePos
@@ -1629,7 +2051,7 @@ func processModuleDef(moduleDef *parser.Module,
 		module.propertyPos[name] = propertyDef.ColonPos
 	}
 
-	return module, nil
+	return module, warnings, nil
 }
 
 func (c *Context) addModule(module *moduleInfo) []error {
@@ -1654,6 +2076,7 @@ func (c *Context) addModule(module *moduleInfo) []error {
 		ModuleGroup{moduleGroup: group},
 		module.logicModule)
 	if len(errs) > 0 {
+		delete(c.moduleInfo, module.logicModule)
 		for i := range errs {
 			errs[i] = &BlueprintError{Err: errs[i], Pos: module.pos}
 		}
@@ -1666,6 +2089,49 @@ func (c *Context) addModule(module *moduleInfo) []error {
 	return nil
 }
 
+// alreadyDefinedGroup returns the group of the previous definition if errs, as returned by
+// addModule, only reports that the name of the module is already defined.
+func alreadyDefinedGroup(errs []error) *moduleGroup {
+	if len(errs) != 1 {
+		return nil
+	}
+	if err, ok := errs[0].(*BlueprintError); ok {
+		if alreadyDefined, ok := err.Err.(*ModuleAlreadyDefinedError); ok && alreadyDefined.Previous.moduleGroup != nil {
+			return alreadyDefined.Previous.moduleGroup
+		}
+	}
+	return nil
+}
+
+// duplicateModuleErrors returns an error for each module name that was defined more than once in
+// the parsed Blueprints files, listing the positions of all the definitions, and forgets them.
+func (c *Context) duplicateModuleErrors() []error {
+	var errs []error
+	for previous, positions := range c.duplicateModules {
+		positions = append([]scanner.Position{previous.modules.firstModule().pos}, positions...)
+		sort.Slice(positions, func(i, j int) bool {
+			if positions[i].Filename != positions[j].Filename {
+				return positions[i].Filename < positions[j].Filename
+			}
+			return positions[i].Offset < positions[j].Offset
+		})
+		var definitions strings.Builder
+		for _, pos := range positions[1:] {
+			// seven characters at the start of each line to align with the string "error: "
+			fmt.Fprintf(&definitions, "\n       %s <-- also defined here", pos)
+		}
+		errs = append(errs, &BlueprintError{
+			Err: fmt.Errorf("module %q defined %d times%s", previous.name, len(positions),
+				definitions.String()),
+			Pos: positions[0],
+		})
+	}
+	clear(c.duplicateModules)
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errs
+}
+
 // ResolveDependencies checks that the dependencies specified by all of the
 // modules defined in the parsed Blueprints files are valid.  This means that
 // the modules depended upon are defined and that no circular dependencies
@@ -1678,6 +2144,18 @@ func (c *Context) ResolveDependencies(config interface{}) (deps []string, errs [
 
 func (c *Context) resolveDependencies(ctx context.Context, config interface{}) (deps []string, errs []error) {
 	pprof.Do(ctx, pprof.Labels("blueprint", "ResolveDependencies"), func(ctx context.Context) {
+		if namespaces, ok := c.nameInterface.(*namespaceNameInterface); ok {
+			errs = namespaces.resolveImports()
+			if len(errs) > 0 {
+				return
+			}
+		}
+
+		errs = c.sortMutators()
+		if len(errs) > 0 {
+			return
+		}
+
 		c.initProviders()
 
 		c.liveGlobals = newLiveTracker(c, config)
@@ -1692,6 +2170,16 @@ func (c *Context) resolveDependencies(ctx context.Context, config interface{}) (
 			return
 		}
 
+		errs = c.pruneDisabledDependencies()
+		if len(errs) > 0 {
+			return
+		}
+
+		errs = c.checkVisibility()
+		if len(errs) > 0 {
+			return
+		}
+
 		c.BeginEvent("clone_modules")
 		if !c.SkipCloneModulesAfterMutators {
 			c.cloneModules()
@@ -1700,16 +2188,42 @@ func (c *Context) resolveDependencies(ctx context.Context, config interface{}) (
 
 		c.clearTransitionMutatorInputVariants()
 
+		c.updateDirectDependents()
+
 		c.dependenciesReady = true
+		c.resolveWarnings = c.warningCount()
 	})
 
 	if len(errs) > 0 {
 		return nil, errs
 	}
 
+	c.generatorDeps = append(c.generatorDeps, deps...)
+	c.resolveGeneratorDeps = len(c.generatorDeps)
 	return deps, nil
 }
 
+// updateDirectDependents indexes the direct dependencies of all the modules by the variant they
+// depend on, including dependencies that close cycles allowed by AllowDependencyCycles.  Each
+// dependent is listed once, even if it has multiple dependencies on the same variant, and the
+// dependents of each variant are sorted by name and variant.
+func (c *Context) updateDirectDependents() {
+	for _, module := range c.modulesSorted {
+		module.directDependents = module.directDependents[:0]
+	}
+	for _, module := range c.modulesSorted {
+		for _, dep := range module.directDeps {
+			dependents := dep.module.directDependents
+			if len(dependents) == 0 || dependents[len(dependents)-1] != module {
+				dep.module.directDependents = append(dependents, module)
+			}
+		}
+	}
+	for _, module := range c.modulesSorted {
+		sort.Sort(moduleSorter{module.directDependents, c.nameInterface})
+	}
+}
+
 // Default dependencies handling.  If the module implements the (deprecated)
 // DynamicDependerModule interface then this set consists of the union of those
 // module names returned by its DynamicDependencies method and those added by calling
@@ -1764,7 +2278,13 @@ func (c *Context) addDependency(module *moduleInfo, config any, tag DependencyTa
 		}}
 	}
 
-	possibleDeps := c.moduleGroupFromName(depName, module.namespace())
+	possibleDeps, err := c.dependencyGroupFromName(depName, module.namespace())
+	if err != nil {
+		return nil, []error{&BlueprintError{
+			Err: err,
+			Pos: module.pos,
+		}}
+	}
 	if possibleDeps == nil {
 		return nil, c.discoveredMissingDependencies(module, depName, nil)
 	}
@@ -1797,7 +2317,13 @@ func (c *Context) findReverseDependency(module *moduleInfo, config any, destName
 		}}
 	}
 
-	possibleDeps := c.moduleGroupFromName(destName, module.namespace())
+	possibleDeps, err := c.dependencyGroupFromName(destName, module.namespace())
+	if err != nil {
+		return nil, []error{&BlueprintError{
+			Err: err,
+			Pos: module.pos,
+		}}
+	}
 	if possibleDeps == nil {
 		return nil, []error{&BlueprintError{
 			Err: fmt.Errorf("%q has a reverse dependency on undefined module %q",
@@ -1919,18 +2445,33 @@ func (c *Context) findVariant(module *moduleInfo, config any,
 }
 
 func (c *Context) addVariationDependency(module *moduleInfo, config any, variations []Variation,
-	tag DependencyTag, depName string, far bool) (*moduleInfo, []error) {
+	tag DependencyTag, depName string, far bool, fallback MissingVariantFallback) (*moduleInfo, []error) {
 	if _, ok := tag.(BaseDependencyTag); ok {
 		panic("BaseDependencyTag is not allowed to be used directly!")
 	}
 
-	possibleDeps := c.moduleGroupFromName(depName, module.namespace())
+	possibleDeps, err := c.dependencyGroupFromName(depName, module.namespace())
+	if err != nil {
+		return nil, []error{&BlueprintError{
+			Err: err,
+			Pos: module.pos,
+		}}
+	}
 	if possibleDeps == nil {
 		return nil, c.discoveredMissingDependencies(module, depName, nil)
 	}
 
 	foundDep, newVariant := c.findVariant(module, config, possibleDeps, variations, far, false)
 
+	if foundDep == nil {
+		switch fallback {
+		case MissingVariantSkip:
+			return nil, nil
+		case MissingVariantNearest:
+			foundDep = nearestVariant(possibleDeps, newVariant)
+		}
+	}
+
 	if foundDep == nil {
 		if c.allowMissingDependencies {
 			// Allow missing variants.
@@ -1965,6 +2506,67 @@ func (c *Context) addVariationDependency(module *moduleInfo, config any, variati
 	return foundDep, nil
 }
 
+// nearestVariant returns the variant in group that has the most of the variations in variant, or
+// nil if none of them has any of the variations.
+func nearestVariant(group *moduleGroup, variant variationMap) *moduleInfo {
+	var nearest *moduleInfo
+	best := 0
+	for _, m := range group.modules {
+		matches := 0
+		for mutator, variation := range variant {
+			if v, ok := m.moduleOrAliasVariant().variations[mutator]; ok && v == variation {
+				matches++
+			}
+		}
+		if matches > best {
+			nearest, best = m.moduleOrAliasTarget(), matches
+		}
+	}
+	return nearest
+}
+
+func (c *Context) addSingleVariantDependency(module *moduleInfo, tag DependencyTag, depName string) (*moduleInfo, []error) {
+	if _, ok := tag.(BaseDependencyTag); ok {
+		panic("BaseDependencyTag is not allowed to be used directly!")
+	}
+
+	if depName == module.Name() {
+		return nil, []error{&BlueprintError{
+			Err: fmt.Errorf("%q depends on itself", depName),
+			Pos: module.pos,
+		}}
+	}
+
+	possibleDeps, err := c.dependencyGroupFromName(depName, module.namespace())
+	if err != nil {
+		return nil, []error{&BlueprintError{
+			Err: err,
+			Pos: module.pos,
+		}}
+	}
+	if possibleDeps == nil {
+		return nil, c.discoveredMissingDependencies(module, depName, nil)
+	}
+
+	var foundDep *moduleInfo
+	for _, moduleOrAlias := range possibleDeps.modules {
+		if m := moduleOrAlias.module(); m != nil {
+			if foundDep != nil {
+				return nil, []error{&BlueprintError{
+					Err: fmt.Errorf("dependency %q of %q has multiple variants:\n  %s",
+						depName, module.Name(), c.prettyPrintGroupVariants(possibleDeps)),
+					Pos: module.pos,
+				}}
+			}
+			foundDep = m
+		}
+	}
+
+	module.newDirectDeps = append(module.newDirectDeps, depInfo{foundDep, tag})
+	atomic.AddUint32(&c.depsModified, 1)
+	return foundDep, nil
+}
+
 func (c *Context) addInterVariantDependency(origModule *moduleInfo, tag DependencyTag,
 	from, to Module) *moduleInfo {
 	if _, ok := tag.(BaseDependencyTag); ok {
@@ -2328,11 +2930,15 @@ func parallelVisit(modules []*moduleInfo, order visitOrderer, limit int,
 }
 
 func cycleError(cycle []*moduleInfo) (errs []error) {
-	// The cycle list is in reverse order because all the 'check' calls append
-	// their own module to the list.
+	// The cycle list starts with the module that closes the cycle, followed by the rest of the
+	// modules in reverse order because all the 'check' calls append their own module to the list.
+	path := []*moduleInfo{cycle[0]}
+	for i := len(cycle) - 1; i > 0; i-- {
+		path = append(path, cycle[i])
+	}
 	errs = append(errs, &BlueprintError{
-		Err: fmt.Errorf("encountered dependency cycle:"),
-		Pos: cycle[len(cycle)-1].pos,
+		Err: fmt.Errorf("encountered dependency cycle: %s", cyclePathString(path)),
+		Pos: cycle[0].pos,
 	})
 
 	// Iterate backwards through the cycle list.
@@ -2340,8 +2946,8 @@ func cycleError(cycle []*moduleInfo) (errs []error) {
 	for i := len(cycle) - 1; i >= 0; i-- {
 		nextModule := cycle[i]
 		errs = append(errs, &BlueprintError{
-			Err: fmt.Errorf("    %s depends on %s",
-				curModule, nextModule),
+			Err: fmt.Errorf("    %s depends on %s%s",
+				curModule, nextModule, cycleEdgeDescription(curModule, nextModule)),
 			Pos: curModule.pos,
 		})
 		curModule = nextModule
@@ -2350,6 +2956,27 @@ func cycleError(cycle []*moduleInfo) (errs []error) {
 	return errs
 }
 
+// cycleEdgeDescription describes why module depends on dep in a cycle error: the tags of its
+// direct dependencies on dep, or that dep is an earlier variant of the same module.  It returns
+// "" for a cycle formed by paused mutators, where there may be no dependency.
+func cycleEdgeDescription(module, dep *moduleInfo) string {
+	var tags []string
+	for _, d := range module.directDeps {
+		if d.module == dep {
+			if tag := dependencyTagString(d.tag); tag != "" && !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	if len(tags) > 0 {
+		return " with tag " + strings.Join(tags, ", ")
+	}
+	if module.group == dep.group && module != dep {
+		return " (an earlier variant of the same module)"
+	}
+	return ""
+}
+
 // updateDependencies recursively walks the module dependency graph and updates
 // additional fields based on the dependencies.  It builds a sorted list of modules
 // such that dependencies of a module always appear first, and populates reverse
@@ -2363,12 +2990,21 @@ func (c *Context) updateDependencies() (errs []error) {
 
 	sorted := make([]*moduleInfo, 0, len(c.moduleInfo))
 
+	// The modules being checked in order, and the dependencies that closed cycles allowed by
+	// AllowDependencyCycles, which are dropped from forwardDeps.
+	var stack []*moduleInfo
+	var allowedCycles [][]*moduleInfo
+
 	var check func(group *moduleInfo) []*moduleInfo
 
 	check = func(module *moduleInfo) []*moduleInfo {
 		visited[module] = true
 		checking[module] = true
-		defer delete(checking, module)
+		stack = append(stack, module)
+		defer func() {
+			delete(checking, module)
+			stack = stack[:len(stack)-1]
+		}()
 
 		// Reset the forward and reverse deps without reducing their capacity to avoid reallocation.
 		module.reverseDeps = module.reverseDeps[:0]
@@ -2395,9 +3031,14 @@ func (c *Context) updateDependencies() (errs []error) {
 			module.forwardDeps = append(module.forwardDeps, dep.module)
 		}
 
+		n := 0
 		for _, dep := range module.forwardDeps {
 			if checking[dep] {
 				// This is a cycle.
+				if path := stack[slices.Index(stack, dep):]; c.dependencyCycleAllowed(path) {
+					allowedCycles = append(allowedCycles, slices.Clone(path))
+					continue
+				}
 				return []*moduleInfo{dep, module}
 			}
 
@@ -2422,7 +3063,10 @@ func (c *Context) updateDependencies() (errs []error) {
 			}
 
 			dep.reverseDeps = append(dep.reverseDeps, module)
+			module.forwardDeps[n] = dep
+			n++
 		}
+		module.forwardDeps = module.forwardDeps[:n]
 
 		sorted = append(sorted, module)
 
@@ -2441,23 +3085,164 @@ func (c *Context) updateDependencies() (errs []error) {
 		}
 	}
 
+	for _, path := range allowedCycles {
+		if len(errs) > 0 {
+			// The cycles found so far may be reported again through the allowed ones.
+			break
+		}
+		// Dropping the dependency that closed an allowed cycle may have hidden other cycles
+		// through it that include dependencies with tags that are not allowed.
+		if mixed := c.mixedDependencyCycle(path[len(path)-1], path[0]); mixed != nil {
+			errs = append(errs, cycleError(mixed)...)
+			continue
+		}
+		// Start the cycle at the same module every time updateDependencies runs so that the
+		// warning is reported once.
+		first := 0
+		for i, module := range path {
+			if module.Name() < path[first].Name() ||
+				(module.Name() == path[first].Name() && module.variant.name < path[first].variant.name) {
+				first = i
+			}
+		}
+		path = slices.Concat(path[first:], path[:first])
+		c.warn(Warning{
+			Pos:      path[0].pos,
+			Message:  "encountered dependency cycle with allowed tags: " + cyclePathString(path),
+			Category: DiagnosticCodeDependencyCycle,
+		})
+	}
+
 	c.modulesSorted = sorted
 
 	return
 }
 
-type jsonVariations []Variation
-
-type jsonModuleName struct {
-	Name                 string
-	Variant              string
-	Variations           jsonVariations
-	DependencyVariations jsonVariations
+// dependencyCycleAllowed returns true if every dependency in the cycle formed by path, in which
+// each module depends on the next and the last module depends on the first, has a tag allowed by
+// AllowDependencyCycles.
+func (c *Context) dependencyCycleAllowed(path []*moduleInfo) bool {
+	if len(c.allowedCycleTags) == 0 {
+		return false
+	}
+	for i, module := range path {
+		if !c.dependencyTagsAllowed(module, path[(i+1)%len(path)]) {
+			return false
+		}
+	}
+	return true
 }
 
-type jsonDep struct {
-	jsonModuleName
-	Tag string
+// dependencyTagsAllowed returns true if module has direct dependencies on dep and all of them have
+// tags allowed by AllowDependencyCycles.
+func (c *Context) dependencyTagsAllowed(module, dep *moduleInfo) bool {
+	found := false
+	for _, d := range module.directDeps {
+		if d.module == dep {
+			if !slices.Contains(c.allowedCycleTags, d.tag) {
+				return false
+			}
+			found = true
+		}
+	}
+	return found
+}
+
+// mixedDependencyCycle looks for a cycle through the dependency of module on dep, which closed an
+// allowed cycle and was dropped from the forward dependencies of module, that includes a
+// dependency with a tag that is not allowed.  It returns the cycle in the order used by
+// cycleError, or nil if there is none.
+func (c *Context) mixedDependencyCycle(module, dep *moduleInfo) []*moduleInfo {
+	// Search for a path from dep back to module, following the forward dependencies and all
+	// direct dependencies, with a second state for having followed a dependency that isn't
+	// allowed.
+	type state struct {
+		module  *moduleInfo
+		blocked bool
+	}
+	parent := make(map[state]state)
+	start := state{dep, false}
+	parent[start] = start
+	queue := []state{start}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		var next []*moduleInfo
+		next = append(next, cur.module.forwardDeps...)
+		for _, d := range cur.module.directDeps {
+			next = append(next, d.module)
+		}
+		for _, n := range next {
+			s := state{n, cur.blocked || !c.dependencyTagsAllowed(cur.module, n)}
+			if _, seen := parent[s]; seen {
+				continue
+			}
+			parent[s] = cur
+			if n == module && s.blocked {
+				// Walk back to dep to get the path from dep to module, which may pass
+				// through the same module more than once.
+				walk := []*moduleInfo{module}
+				for p := parent[s]; ; p = parent[p] {
+					walk = append(walk, p.module)
+					if p == start {
+						break
+					}
+				}
+				slices.Reverse(walk)
+				return c.blockedSimpleCycle(append([]*moduleInfo{module}, walk...))
+			}
+			queue = append(queue, s)
+		}
+	}
+	return nil
+}
+
+// blockedSimpleCycle splits walk, a path in which each module depends on the next that starts and
+// ends with the same module, into simple cycles and returns the first one that isn't allowed by
+// AllowDependencyCycles in the order used by cycleError.  One always exists if walk follows a
+// dependency with a tag that isn't allowed.
+func (c *Context) blockedSimpleCycle(walk []*moduleInfo) []*moduleInfo {
+	var stack []*moduleInfo
+	for _, module := range walk {
+		if i := slices.Index(stack, module); i >= 0 {
+			if path := stack[i:]; !c.dependencyCycleAllowed(path) {
+				cycle := []*moduleInfo{path[0]}
+				for j := len(path) - 1; j > 0; j-- {
+					cycle = append(cycle, path[j])
+				}
+				return cycle
+			}
+			stack = stack[:i]
+		}
+		stack = append(stack, module)
+	}
+	return nil
+}
+
+// cyclePathString formats a cycle in which each module depends on the next and the last module
+// depends on the first as a list of module names.
+func cyclePathString(path []*moduleInfo) string {
+	names := make([]string, 0, len(path)+1)
+	for _, module := range path {
+		names = append(names, fmt.Sprintf("%q", module.Name()))
+	}
+	names = append(names, names[0])
+	return strings.Join(names, " -> ")
+}
+
+type jsonVariations []Variation
+
+type jsonModuleName struct {
+	Name                 string
+	Variant              string
+	Variations           jsonVariations
+	DependencyVariations jsonVariations
+}
+
+type jsonDep struct {
+	jsonModuleName
+	Tag string
 }
 
 type JsonModule struct {
@@ -2647,6 +3432,300 @@ func writeJson(w io.Writer, modules []*JsonModule) {
 	e.Encode(modules)
 }
 
+// JSONModuleGraph is the node/edge document written by ModuleGraphJSON.
+type JSONModuleGraph struct {
+	Modules []JSONModuleGraphNode
+	Edges   []JSONModuleGraphEdge
+}
+
+// JSONModuleGraphNode describes a single module variant in a JSONModuleGraph.  ID is the index
+// of the node in JSONModuleGraph.Modules.
+type JSONModuleGraphNode struct {
+	ID       int
+	Name     string
+	Type     string
+	Variant  string
+	Position string
+
+	// Sandboxes lists the BuildParams.Sandbox of the build statements of the module that declare
+	// one, once PrepareBuildActions has generated them.
+	Sandboxes []*Sandbox `json:",omitempty"`
+}
+
+// JSONModuleGraphEdge describes a direct dependency from the module with ID From on the module
+// with ID To.
+type JSONModuleGraphEdge struct {
+	From int
+	To   int
+	Tag  string
+}
+
+// dependencyTagString returns the string form of a dependency tag, using its String method if it
+// has one.
+func dependencyTagString(tag DependencyTag) string {
+	if tag == nil {
+		return ""
+	}
+	if s, ok := tag.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T %+v", tag, tag)
+}
+
+// ModuleGraphJSON writes the module graph to w as a JSONModuleGraph document.  Every module
+// variant is a node identified by its position in the sorted module list, and every direct
+// dependency is an edge tagged with the string form of its dependency tag.  It returns
+// ErrDependenciesNotReady if called before ResolveDependencies has completed successfully.
+func (c *Context) ModuleGraphJSON(w io.Writer) error {
+	if !c.dependenciesReady {
+		return ErrDependenciesNotReady
+	}
+
+	graph := JSONModuleGraph{
+		Modules: make([]JSONModuleGraphNode, 0, len(c.modulesSorted)),
+		Edges:   make([]JSONModuleGraphEdge, 0),
+	}
+
+	ids := make(map[*moduleInfo]int, len(c.modulesSorted))
+	for i, m := range c.modulesSorted {
+		ids[m] = i
+		node := JSONModuleGraphNode{
+			ID:       i,
+			Name:     m.Name(),
+			Type:     m.typeName,
+			Variant:  m.variant.name,
+			Position: m.pos.String(),
+		}
+		for _, def := range m.actionDefs.buildDefs {
+			if def.Sandbox != nil {
+				node.Sandboxes = append(node.Sandboxes, def.Sandbox)
+			}
+		}
+		graph.Modules = append(graph.Modules, node)
+	}
+
+	for _, m := range c.modulesSorted {
+		c.walkDeps(m, true, func(dep depInfo, parent *moduleInfo) bool {
+			graph.Edges = append(graph.Edges, JSONModuleGraphEdge{
+				From: ids[parent],
+				To:   ids[dep.module],
+				Tag:  dependencyTagString(dep.tag),
+			})
+			// Only direct dependencies are edges, don't recurse.
+			return false
+		}, nil)
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	return e.Encode(graph)
+}
+
+// OrderOnlyDependencyTag can be implemented by a DependencyTag whose dependencies only affect
+// ordering.  WriteDepGraphDot draws dependencies with such tags as dashed edges.
+type OrderOnlyDependencyTag interface {
+	OrderOnlyDependency() bool
+}
+
+// WriteDepGraphDot writes the module dependency graph to w as a Graphviz digraph.  Nodes are
+// labeled with the module name and type, variants of the same name are grouped into clusters, and
+// dependencies whose tag implements OrderOnlyDependencyTag are drawn dashed.  It returns
+// ErrDependenciesNotReady if called before ResolveDependencies has completed successfully.
+func (c *Context) WriteDepGraphDot(w io.Writer) error {
+	if !c.dependenciesReady {
+		return ErrDependenciesNotReady
+	}
+	return c.writeDepGraphDot(w, c.modulesSorted)
+}
+
+// WriteDepGraphDotFrom is like WriteDepGraphDot, but limits the graph to the variants of the named
+// module and their transitive dependencies.
+func (c *Context) WriteDepGraphDotFrom(w io.Writer, name string) error {
+	if !c.dependenciesReady {
+		return ErrDependenciesNotReady
+	}
+
+	group := c.moduleGroupFromName(name, nil)
+	if group == nil {
+		return fmt.Errorf("module %q not found", name)
+	}
+
+	included := make(map[*moduleInfo]bool)
+	for _, moduleOrAlias := range group.modules {
+		if m := moduleOrAlias.module(); m != nil && !included[m] {
+			included[m] = true
+			c.walkDeps(m, false, func(dep depInfo, parent *moduleInfo) bool {
+				included[dep.module] = true
+				return true
+			}, nil)
+		}
+	}
+
+	var modules []*moduleInfo
+	for _, m := range c.modulesSorted {
+		if included[m] {
+			modules = append(modules, m)
+		}
+	}
+	return c.writeDepGraphDot(w, modules)
+}
+
+func (c *Context) writeDepGraphDot(w io.Writer, modules []*moduleInfo) error {
+	// Node IDs are based on the position in modulesSorted so they are stable between runs.
+	ids := make(map[*moduleInfo]string, len(c.modulesSorted))
+	for i, m := range c.modulesSorted {
+		ids[m] = fmt.Sprintf("m%d", i)
+	}
+
+	included := make(map[*moduleInfo]bool, len(modules))
+	for _, m := range modules {
+		included[m] = true
+	}
+
+	buf := &strings.Builder{}
+	buf.WriteString("digraph blueprint {\n")
+
+	writeNode := func(indent string, m *moduleInfo) {
+		label := dotEscape(m.Name())
+		if m.variant.name != "" {
+			label += `\n` + dotEscape(m.variant.name)
+		}
+		label += `\n` + dotEscape(m.typeName)
+		fmt.Fprintf(buf, "%s%s [label=\"%s\"];\n", indent, ids[m], label)
+	}
+
+	// Group variants of the same module into a cluster, keeping the clusters in the order the
+	// first variant of each appears.
+	var groups []*moduleGroup
+	variants := make(map[*moduleGroup][]*moduleInfo)
+	for _, m := range modules {
+		if _, exists := variants[m.group]; !exists {
+			groups = append(groups, m.group)
+		}
+		variants[m.group] = append(variants[m.group], m)
+	}
+
+	for i, group := range groups {
+		if len(variants[group]) == 1 && variants[group][0].variant.name == "" {
+			writeNode("\t", variants[group][0])
+			continue
+		}
+		fmt.Fprintf(buf, "\tsubgraph cluster_%d {\n", i)
+		fmt.Fprintf(buf, "\t\tlabel=\"%s\";\n", dotEscape(group.name))
+		for _, m := range variants[group] {
+			writeNode("\t\t", m)
+		}
+		buf.WriteString("\t}\n")
+	}
+
+	for _, m := range modules {
+		for _, dep := range m.directDeps {
+			if !included[dep.module] {
+				continue
+			}
+			style := "solid"
+			if tag, ok := dep.tag.(OrderOnlyDependencyTag); ok && tag.OrderOnlyDependency() {
+				style = "dashed"
+			}
+			fmt.Fprintf(buf, "\t%s -> %s [style=%s];\n", ids[m], ids[dep.module], style)
+		}
+	}
+
+	buf.WriteString("}\n")
+
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+// dotEscape escapes a string for use inside a double quoted Graphviz ID.
+func dotEscape(s string) string {
+	return dotEscaper.Replace(s)
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// VerifyAcyclic checks that the dependencies with the given tag do not form a cycle between
+// modules.  Dependency cycles between variants are always rejected by ResolveDependencies, but
+// the variants of one module may depend on the variants of another in both directions, for
+// example when a host variant of a tool is used to build a device variant of a library that the
+// tool also links against.  VerifyAcyclic treats all variants of a module as a single node and
+// only follows dependencies whose tag is equal to tag, and returns one error for each cycle it
+// finds.  Each error lists the names of the modules in the cycle in dependency order, starting
+// and ending with the same module.  It returns ErrDependenciesNotReady if called before
+// ResolveDependencies has completed successfully.
+func (c *Context) VerifyAcyclic(tag DependencyTag) []error {
+	if !c.dependenciesReady {
+		return []error{ErrDependenciesNotReady}
+	}
+
+	// Collect the edges between module groups in the order the dependencies were added so that
+	// the cycles are reported in a stable order.
+	edges := make(map[*moduleGroup][]*moduleGroup)
+	seenEdge := make(map[[2]*moduleGroup]bool)
+	for _, group := range c.moduleGroups {
+		for _, moduleOrAlias := range group.modules {
+			m := moduleOrAlias.module()
+			if m == nil {
+				continue
+			}
+			for _, dep := range m.directDeps {
+				if dep.tag != tag || dep.module.group == group {
+					continue
+				}
+				edge := [2]*moduleGroup{group, dep.module.group}
+				if !seenEdge[edge] {
+					seenEdge[edge] = true
+					edges[group] = append(edges[group], dep.module.group)
+				}
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*moduleGroup]int)
+	var stack []*moduleGroup
+	var errs []error
+
+	var visit func(group *moduleGroup)
+	visit = func(group *moduleGroup) {
+		state[group] = visiting
+		stack = append(stack, group)
+		for _, dep := range edges[group] {
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				// Each back edge to a module that is still being visited closes a distinct cycle.
+				cycle := stack[slices.Index(stack, dep):]
+				names := make([]string, 0, len(cycle)+1)
+				for _, g := range cycle {
+					names = append(names, fmt.Sprintf("%q", g.name))
+				}
+				names = append(names, fmt.Sprintf("%q", dep.name))
+				errs = append(errs, &BlueprintError{
+					Err: fmt.Errorf("encountered dependency cycle with tag %s: %s",
+						dependencyTagString(tag), strings.Join(names, " -> ")),
+					Pos: dep.modules.firstModule().pos,
+				})
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[group] = visited
+	}
+
+	for _, group := range c.moduleGroups {
+		if state[group] == unvisited {
+			visit(group)
+		}
+	}
+
+	return errs
+}
+
 // PrepareBuildActions generates an internal representation of all the build
 // actions that need to be performed.  This process involves invoking the
 // GenerateBuildActions method on each of the Module objects created during the
@@ -2681,21 +3760,54 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 			deps = append(deps, extraDeps...)
 		}
 
+		if c.actionTracing {
+			c.actionTrace = newActionTrace()
+		} else {
+			c.actionTrace = nil
+		}
+
 		var depsModules []string
 		depsModules, errs = c.generateModuleBuildActions(config, c.liveGlobals)
 		if len(errs) > 0 {
 			return
 		}
 
+		var singletons []*singletonInfo
+		singletons, errs = c.sortSingletons()
+		if len(errs) > 0 {
+			return
+		}
+
 		var depsSingletons []string
-		depsSingletons, errs = c.generateSingletonBuildActions(config, c.singletonInfo, c.liveGlobals)
+		depsSingletons, errs = c.generateSingletonBuildActions(config, singletons, c.liveGlobals)
 		if len(errs) > 0 {
 			return
 		}
 
+		if errs = c.deduplicateBuildDefs(); len(errs) > 0 {
+			return
+		}
+
 		deps = append(deps, depsModules...)
 		deps = append(deps, depsSingletons...)
 
+		if c.globCacheFile != "" || c.globDirectoryDeps {
+			// Regenerate when a file is added to or removed from a directory searched by a
+			// glob, including every directory traversed by a recursive glob.
+			globDeps := c.Globs().Deps()
+			slices.Sort(globDeps)
+			deps = append(deps, slices.Compact(globDeps)...)
+		}
+
+		if c.globCacheFile != "" {
+			if err := c.writeGlobCache(); err != nil {
+				errs = []error{err}
+				return
+			}
+			// Regenerate when the cached results are updated.
+			deps = append(deps, c.globCacheFile)
+		}
+
 		if c.outDir != nil {
 			err := c.liveGlobals.addNinjaStringDeps(c.outDir)
 			if err != nil {
@@ -2704,6 +3816,12 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 			}
 		}
 
+		if c.validateRuleVariables {
+			if errs = c.liveGlobals.checkRuleVariables(); len(errs) > 0 {
+				return
+			}
+		}
+
 		pkgNames, depsPackages := c.makeUniquePackageNames(c.liveGlobals)
 
 		deps = append(deps, depsPackages...)
@@ -2725,9 +3843,115 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 		return nil, errs
 	}
 
+	c.generatorDeps = append(c.generatorDeps, deps...)
 	return deps, nil
 }
 
+// sortMutators reorders the registered mutators so that every mutator runs after the mutators
+// named by its MustRunAfter calls.  Mutators that are not constrained relative to each other stay
+// in registration order.
+func (c *Context) sortMutators() []error {
+	sorted, errs := sortByRunAfter("mutator", c.mutatorInfo,
+		func(m *mutatorInfo) string { return m.name },
+		func(m *mutatorInfo) []string { return m.runAfter })
+	if len(errs) > 0 {
+		return errs
+	}
+	c.mutatorInfo = sorted
+	return nil
+}
+
+// sortSingletons returns the registered singletons ordered so that every singleton runs after the
+// singletons named by its MustRunAfter calls.  Singletons that are not constrained relative to each
+// other stay in registration order.  c.singletonInfo is left in registration order, which is the
+// order the build actions of the singletons are written in.
+func (c *Context) sortSingletons() ([]*singletonInfo, []error) {
+	return sortByRunAfter("singleton", c.singletonInfo,
+		func(s *singletonInfo) string { return s.name },
+		func(s *singletonInfo) []string { return s.runAfter })
+}
+
+// sortByRunAfter returns items reordered so that every item comes after the items whose name is
+// returned by its runAfter function.  Items that are not constrained relative to each other stay in
+// their original order.  kind is used in error messages for unknown names and ordering cycles.
+func sortByRunAfter[T comparable](kind string, items []T, name func(T) string, runAfter func(T) []string) ([]T, []error) {
+	byName := make(map[string][]T)
+	for _, item := range items {
+		byName[name(item)] = append(byName[name(item)], item)
+	}
+
+	var errs []error
+	for _, item := range items {
+		for _, after := range runAfter(item) {
+			if _, ok := byName[after]; !ok {
+				errs = append(errs, fmt.Errorf("%s %q must run after unknown %s %q",
+					kind, name(item), kind, after))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	// unmetDep returns an item that must come before item but hasn't been placed yet.
+	var zero T
+	placed := make(map[T]bool)
+	unmetDep := func(item T) (T, bool) {
+		for _, after := range runAfter(item) {
+			for _, dep := range byName[after] {
+				if !placed[dep] {
+					return dep, true
+				}
+			}
+		}
+		return zero, false
+	}
+
+	sorted := make([]T, 0, len(items))
+	for len(sorted) < len(items) {
+		// Pick the first item in the original order whose constraints are satisfied.
+		next, found := zero, false
+		for _, item := range items {
+			if _, unmet := unmetDep(item); !placed[item] && !unmet {
+				next, found = item, true
+				break
+			}
+		}
+
+		if !found {
+			// Every remaining item is waiting on another one, follow the unmet
+			// constraints from the first remaining item until one repeats.
+			var start T
+			for _, item := range items {
+				if !placed[item] {
+					start = item
+					break
+				}
+			}
+			var cycle []T
+			seen := make(map[T]int)
+			for item := start; ; item, _ = unmetDep(item) {
+				if i, ok := seen[item]; ok {
+					cycle = append(cycle[i:], item)
+					break
+				}
+				seen[item] = len(cycle)
+				cycle = append(cycle, item)
+			}
+			names := make([]string, len(cycle))
+			for i, item := range cycle {
+				names[i] = fmt.Sprintf("%q", name(item))
+			}
+			return nil, []error{fmt.Errorf("%s ordering cycle: %s", kind, strings.Join(names, " must run after "))}
+		}
+
+		placed[next] = true
+		sorted = append(sorted, next)
+	}
+
+	return sorted, nil
+}
+
 func (c *Context) runMutators(ctx context.Context, config interface{}) (deps []string, errs []error) {
 	pprof.Do(ctx, pprof.Labels("blueprint", "runMutators"), func(ctx context.Context) {
 		for _, mutator := range c.mutatorInfo {
@@ -2813,11 +4037,12 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 	}
 
 	type globalStateChange struct {
-		reverse    []reverseDep
-		rename     []rename
-		replace    []replace
-		newModules []*moduleInfo
-		deps       []string
+		reverse     []reverseDep
+		rename      []rename
+		replace     []replace
+		nameAliases []nameAlias
+		newModules  []*moduleInfo
+		deps        []string
 	}
 
 	type newVariationPair struct {
@@ -2828,6 +4053,7 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 	reverseDeps := make(map[*moduleInfo][]depInfo)
 	var rename []rename
 	var replace []replace
+	var nameAliases []nameAlias
 	var newModules []*moduleInfo
 
 	errsCh := make(chan []error)
@@ -2852,8 +4078,20 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 			pauseCh: pause,
 		}
 
+		if module.disabled && mutator.transitionMutator == nil {
+			// Mutators that run after blueprint_disabled skip disabled modules, transition
+			// mutators still visit them to keep the variants of their dependencies consistent.
+			module.startedMutator = mutator
+			module.finishedMutator = mutator
+			return false
+		}
+
 		origLogicModule := module.logicModule
 
+		if c.phaseTracer != nil {
+			defer c.phaseTracer.traceMutatorVisit(mutator.name, module)()
+		}
+
 		module.startedMutator = mutator
 
 		func() {
@@ -2882,13 +4120,15 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 			newVariationsCh <- newVariationPair{mctx.newVariations, origLogicModule}
 		}
 
-		if len(mctx.reverseDeps) > 0 || len(mctx.replace) > 0 || len(mctx.rename) > 0 || len(mctx.newModules) > 0 || len(mctx.ninjaFileDeps) > 0 {
+		if len(mctx.reverseDeps) > 0 || len(mctx.replace) > 0 || len(mctx.rename) > 0 || len(mctx.nameAliases) > 0 ||
+			len(mctx.newModules) > 0 || len(mctx.ninjaFileDeps) > 0 {
 			globalStateCh <- globalStateChange{
-				reverse:    mctx.reverseDeps,
-				replace:    mctx.replace,
-				rename:     mctx.rename,
-				newModules: mctx.newModules,
-				deps:       mctx.ninjaFileDeps,
+				reverse:     mctx.reverseDeps,
+				replace:     mctx.replace,
+				rename:      mctx.rename,
+				nameAliases: mctx.nameAliases,
+				newModules:  mctx.newModules,
+				deps:        mctx.ninjaFileDeps,
 			}
 		}
 
@@ -2909,6 +4149,7 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 				}
 				replace = append(replace, globalStateChange.replace...)
 				rename = append(rename, globalStateChange.rename...)
+				nameAliases = append(nameAliases, globalStateChange.nameAliases...)
 				newModules = append(newModules, globalStateChange.newModules...)
 				deps = append(deps, globalStateChange.deps...)
 			case newVariations := <-newVariationsCh:
@@ -3049,6 +4290,11 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 		return nil, errs
 	}
 
+	errs = c.handleNameAliases(nameAliases)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
 	errs = c.handleReplacements(replace)
 	if len(errs) > 0 {
 		return nil, errs
@@ -3158,8 +4404,27 @@ func (c *Context) generateModuleBuildActions(config interface{},
 		}
 	}()
 
+	trace := c.actionTrace
+
 	visitErrs := parallelVisit(c.modulesSorted, bottomUpVisitor, parallelVisitLimit,
 		func(module *moduleInfo, pause chan<- pauseSpec) bool {
+			if module.finishedGenerateBuildActions {
+				// The build actions of the module were kept by InvalidateProviders.  Their
+				// order-only dependencies are compared with those of the new build actions by
+				// deduplicateBuildDefs, so undo the deduplication done when they were written.
+				for _, def := range module.actionDefs.buildDefs {
+					def.restoreOrderOnly()
+				}
+				depsCh <- module.ninjaFileDeps
+				return false
+			}
+
+			if module.disabled {
+				module.startedGenerateBuildActions = true
+				module.finishedGenerateBuildActions = true
+				return false
+			}
+
 			uniqueName := c.nameInterface.UniqueName(newNamespaceContext(module), module.group.name)
 			sanitizedName := toNinjaName(uniqueName)
 			sanitizedVariant := toNinjaName(module.variant.name)
@@ -3195,6 +4460,12 @@ func (c *Context) generateModuleBuildActions(config interface{},
 						}
 					}
 				}()
+				if trace != nil {
+					start := time.Now()
+					defer func() {
+						trace.add(c.actionTraceName(module), start, time.Since(start))
+					}()
+				}
 				mctx.module.logicModule.GenerateBuildActions(mctx)
 			}()
 
@@ -3214,6 +4485,7 @@ func (c *Context) generateModuleBuildActions(config interface{},
 				return true
 			}
 
+			module.ninjaFileDeps = mctx.ninjaFileDeps
 			depsCh <- mctx.ninjaFileDeps
 
 			newErrs := c.processLocalBuildActions(&module.actionDefs,
@@ -3280,6 +4552,12 @@ func (c *Context) generateOneSingletonBuildActions(config interface{},
 	return deps, errs
 }
 
+// singletonRunsInParallel returns true if the singleton is run concurrently with the other
+// parallel singletons before the rest of the singletons are run.
+func (c *Context) singletonRunsInParallel(info *singletonInfo) bool {
+	return (info.parallel || c.parallelSingletons) && len(info.runAfter) == 0
+}
+
 func (c *Context) generateParallelSingletonBuildActions(config interface{},
 	singletons []*singletonInfo, liveGlobals *liveTracker) ([]string, []error) {
 
@@ -3311,8 +4589,9 @@ func (c *Context) generateParallelSingletonBuildActions(config interface{},
 	}()
 
 	for _, info := range singletons {
-		if !info.parallel {
-			// Skip any singletons registered with parallel=false.
+		if !c.singletonRunsInParallel(info) {
+			// Skip any singletons registered with parallel=false or that must run after another
+			// singleton.
 			continue
 		}
 		wg.Add(1)
@@ -3356,8 +4635,10 @@ func (c *Context) generateSingletonBuildActions(config interface{},
 	// First, take care of any singletons that want to run in parallel.
 	deps, errs = c.generateParallelSingletonBuildActions(config, singletons, liveGlobals)
 
+	// Then run the rest in order, singletons ordered by MustRunAfter always run after the parallel
+	// singletons, and after the singletons they must run after.
 	for _, info := range singletons {
-		if !info.parallel {
+		if !c.singletonRunsInParallel(info) {
 			runSingleton(info)
 			if len(errs) > maxErrors {
 				break
@@ -3388,6 +4669,8 @@ func (c *Context) processLocalBuildActions(out, in *localBuildActions,
 	}
 
 	out.buildDefs = append(out.buildDefs, in.buildDefs...)
+	out.phonys = append(out.phonys, in.phonys...)
+	out.defaults = append(out.defaults, in.defaults...)
 
 	// We use the now-incorrect set of live "globals" to determine which local
 	// definitions are live.  As we go through copying those live locals to the
@@ -3455,6 +4738,18 @@ type rename struct {
 	name  string
 }
 
+type nameAlias struct {
+	alias, target string
+	module        *moduleInfo // The module that created the alias
+}
+
+// nameAliasKey identifies an alias, aliases are only visible in the namespace of the module that
+// created them.
+type nameAliasKey struct {
+	namespace Namespace
+	name      string
+}
+
 // moduleVariantsThatDependOn takes the name of a module and a dependency and returns the all the variants of the
 // module that depends on the dependency.
 func (c *Context) moduleVariantsThatDependOn(name string, dep *moduleInfo) []*moduleInfo {
@@ -3494,32 +4789,110 @@ func (c *Context) handleRenames(renames []rename) []error {
 	return errs
 }
 
-func (c *Context) handleReplacements(replacements []replace) []error {
-	var errs []error
-	changedDeps := false
-	for _, replace := range replacements {
-		for _, m := range replace.from.reverseDeps {
-			for i, d := range m.directDeps {
-				if d.module == replace.from {
-					// If the replacement has a predicate then check it.
-					if replace.predicate == nil || replace.predicate(m.logicModule, d.tag, d.module.logicModule) {
-						m.directDeps[i].module = replace.to
-						changedDeps = true
-					}
-				}
-			}
-		}
-
+// handleNameAliases records the aliases created by a mutator pass, reporting aliases that collide
+// with module names, that were already created with a different target, or that form a cycle.
+func (c *Context) handleNameAliases(aliases []nameAlias) []error {
+	if len(aliases) == 0 {
+		return nil
 	}
 
-	if changedDeps {
-		atomic.AddUint32(&c.depsModified, 1)
+	// Aliases may have been created in any order by a parallel mutator, sort them so that
+	// errors are deterministic.
+	slices.SortFunc(aliases, func(a, b nameAlias) int {
+		return cmp.Or(cmp.Compare(a.alias, b.alias), cmp.Compare(a.target, b.target))
+	})
+
+	if c.nameAliases == nil {
+		c.nameAliases = make(map[nameAliasKey]string)
 	}
-	return errs
-}
 
-func (c *Context) discoveredMissingDependencies(module *moduleInfo, depName string, depVariations variationMap) (errs []error) {
-	if depVariations != nil {
+	var errs []error
+	for _, alias := range aliases {
+		namespace := alias.module.namespace()
+		if c.moduleGroupFromName(alias.alias, namespace) != nil {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("alias %q collides with an existing module", alias.alias),
+				Pos: alias.module.pos,
+			})
+			continue
+		}
+
+		if target, exists := c.nameAliases[nameAliasKey{namespace, alias.alias}]; exists {
+			if target != alias.target {
+				errs = append(errs, &BlueprintError{
+					Err: fmt.Errorf("alias %q to %q already refers to %q", alias.alias, alias.target, target),
+					Pos: alias.module.pos,
+				})
+			}
+			continue
+		}
+
+		chain := []string{alias.alias}
+		for name := alias.target; ; {
+			chain = append(chain, name)
+			if name == alias.alias {
+				errs = append(errs, &BlueprintError{
+					Err: fmt.Errorf("alias cycle: %s", strings.Join(chain, " -> ")),
+					Pos: alias.module.pos,
+				})
+				break
+			}
+			next, ok := c.nameAliases[nameAliasKey{namespace, name}]
+			if !ok {
+				c.nameAliases[nameAliasKey{namespace, alias.alias}] = alias.target
+				break
+			}
+			name = next
+		}
+	}
+
+	return errs
+}
+
+// dependencyGroupFromName returns the module group for a dependency name, following any aliases
+// created with TopDownMutatorContext.CreateAlias in the given namespace.  The targets of an alias
+// are resolved in the namespace of the module that created it.  Modules created after an alias
+// may have the same name, an error is returned if the name is both an alias and a module.
+func (c *Context) dependencyGroupFromName(name string, namespace Namespace) (*moduleGroup, error) {
+	for {
+		group := c.moduleGroupFromName(name, namespace)
+		target, isAlias := c.nameAliases[nameAliasKey{namespace, name}]
+		if group != nil && isAlias {
+			return nil, fmt.Errorf("alias %q collides with an existing module", name)
+		}
+		if !isAlias {
+			return group, nil
+		}
+		name = target
+	}
+}
+
+func (c *Context) handleReplacements(replacements []replace) []error {
+	var errs []error
+	changedDeps := false
+	for _, replace := range replacements {
+		for _, m := range replace.from.reverseDeps {
+			for i, d := range m.directDeps {
+				if d.module == replace.from {
+					// If the replacement has a predicate then check it.
+					if replace.predicate == nil || replace.predicate(m.logicModule, d.tag, d.module.logicModule) {
+						m.directDeps[i].module = replace.to
+						changedDeps = true
+					}
+				}
+			}
+		}
+
+	}
+
+	if changedDeps {
+		atomic.AddUint32(&c.depsModified, 1)
+	}
+	return errs
+}
+
+func (c *Context) discoveredMissingDependencies(module *moduleInfo, depName string, depVariations variationMap) (errs []error) {
+	if depVariations != nil {
 		depName = depName + "{" + c.prettyPrintVariant(depVariations) + "}"
 	}
 	if c.allowMissingDependencies {
@@ -3530,7 +4903,7 @@ func (c *Context) discoveredMissingDependencies(module *moduleInfo, depName stri
 }
 
 func (c *Context) missingDependencyError(module *moduleInfo, depName string) (errs error) {
-	guess := namesLike(depName, module.Name(), c.moduleGroups)
+	guess := namesLike(depName, module.Name(), module.namespace(), c.moduleGroups)
 	err := c.nameInterface.MissingDependencyError(module.Name(), module.namespace(), depName, guess)
 	return &BlueprintError{
 		Err: err,
@@ -3858,6 +5231,456 @@ func (c *Context) AllTargets() (map[string]string, error) {
 	return targets, nil
 }
 
+// TransitiveInputs returns the sorted source files that transitively feed the outputs of the
+// module.  It follows the dependencies of the module and the inputs, implicit inputs and command
+// dependencies of the build statements of the module and its dependencies.  An input that is the
+// output of a build statement of any module or singleton is followed to the inputs of that build
+// statement instead of being returned.  The paths are evaluated with the values of the global
+// variables and of the local variables of the modules and singletons.  If this is called before
+// PrepareBuildActions successfully completes then ErrBuildActionsNotReady is returned.
+func (c *Context) TransitiveInputs(logicModule Module) ([]string, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+	module := c.moduleInfo[logicModule]
+	if module == nil {
+		return nil, fmt.Errorf("module %q is not known to the context", logicModule.Name())
+	}
+
+	// Index the build statements of every module and singleton by output.
+	variables := c.buildDefVariables()
+	producers := make(map[string]*buildDef)
+	err := c.visitAllBuildDefs(func(def *buildDef, _ *moduleInfo) error {
+		outputs, err := buildDefOutputPaths(variables, def)
+		for _, output := range outputs {
+			producers[output] = def
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var queue []*buildDef
+	visited := make(map[*buildDef]bool)
+	enqueue := func(def *buildDef) {
+		if !visited[def] {
+			visited[def] = true
+			queue = append(queue, def)
+		}
+	}
+
+	enqueueModule := func(m *moduleInfo) {
+		for _, def := range m.actionDefs.buildDefs {
+			enqueue(def)
+		}
+	}
+	enqueueModule(module)
+	c.walkDeps(module, false, nil, func(dep depInfo, parent *moduleInfo) {
+		enqueueModule(dep.module)
+	})
+
+	sources := make(map[string]bool)
+	for len(queue) > 0 {
+		def := queue[0]
+		queue = queue[1:]
+
+		inputs, err := buildDefInputPaths(variables, def)
+		if err != nil {
+			return nil, err
+		}
+		for _, input := range inputs {
+			if producer, ok := producers[input]; ok {
+				// Visiting each build statement once also terminates cycles.
+				enqueue(producer)
+			} else {
+				sources[input] = true
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(sources))
+	for source := range sources {
+		ret = append(ret, source)
+	}
+	slices.Sort(ret)
+	return ret, nil
+}
+
+// AffectedModules returns the modules whose outputs could change when the given source files
+// change, sorted by name and variant.  A module is affected if a changed file is an input,
+// implicit input or command dependency of one of its build statements, directly or through the
+// outputs of other build statements, if one of its dependencies is affected, or if it is defined
+// in a changed Blueprints file.  The paths are compared with the inputs evaluated with the values
+// of the global variables and of the local variables of the modules and singletons, and with the
+// paths of the Blueprints files relative to the source root.
+// If this is called before PrepareBuildActions successfully completes then ErrBuildActionsNotReady
+// is returned.
+func (c *Context) AffectedModules(changed []string) ([]Module, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+
+	// Index the build statements of every module and singleton by input.
+	type consumer struct {
+		def    *buildDef
+		module *moduleInfo // nil for singletons
+	}
+	variables := c.buildDefVariables()
+	consumers := make(map[string][]consumer)
+	err := c.visitAllBuildDefs(func(def *buildDef, module *moduleInfo) error {
+		inputs, err := buildDefInputPaths(variables, def)
+		for _, input := range inputs {
+			consumers[input] = append(consumers[input], consumer{def, module})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	affected := make(map[*moduleInfo]bool)
+	var affectedQueue []*moduleInfo
+	markAffected := func(module *moduleInfo) {
+		if module != nil && !affected[module] {
+			affected[module] = true
+			affectedQueue = append(affectedQueue, module)
+		}
+	}
+
+	changedFiles := make(map[string]bool)
+	var queue []string
+	for _, path := range changed {
+		path = filepath.Clean(path)
+		if !changedFiles[path] {
+			changedFiles[path] = true
+			queue = append(queue, path)
+		}
+	}
+	for _, module := range c.moduleInfo {
+		if changedFiles[module.relBlueprintsFile] {
+			markAffected(module)
+		}
+	}
+
+	// Follow the changed files to the outputs of the build statements that use them.  Visiting
+	// each build statement once terminates cycles.
+	visited := make(map[*buildDef]bool)
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		for _, consumer := range consumers[path] {
+			if visited[consumer.def] {
+				continue
+			}
+			visited[consumer.def] = true
+			markAffected(consumer.module)
+			outputs, err := buildDefOutputPaths(variables, consumer.def)
+			if err != nil {
+				return nil, err
+			}
+			queue = append(queue, outputs...)
+		}
+	}
+
+	// Walk the reverse dependencies of the affected modules.
+	for len(affectedQueue) > 0 {
+		module := affectedQueue[0]
+		affectedQueue = affectedQueue[1:]
+		for _, dependent := range module.reverseDeps {
+			markAffected(dependent)
+		}
+	}
+
+	modules := make([]*moduleInfo, 0, len(affected))
+	for module := range affected {
+		modules = append(modules, module)
+	}
+	sort.Sort(moduleSorter{modules, c.nameInterface})
+
+	ret := make([]Module, len(modules))
+	for i, module := range modules {
+		ret[i] = module.logicModule
+	}
+	return ret, nil
+}
+
+// visitAllBuildDefs calls visit with each build statement of every module, and of every singleton
+// with a nil module.  It stops at the first error returned by visit.
+func (c *Context) visitAllBuildDefs(visit func(def *buildDef, module *moduleInfo) error) error {
+	for _, module := range c.moduleInfo {
+		for _, def := range module.actionDefs.buildDefs {
+			if err := visit(def, module); err != nil {
+				return err
+			}
+		}
+	}
+	for _, info := range c.singletonInfo {
+		for _, def := range info.actionDefs.buildDefs {
+			if err := visit(def, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// buildDefVariables returns the values of the variables that build statements can reference: the
+// live global variables, and the local variables of every module and singleton.  Local variables
+// are distinct values, so the variables of different modules can share one map.
+func (c *Context) buildDefVariables() map[Variable]*ninjaString {
+	variables := maps.Clone(c.liveGlobals.variables)
+	addLocals := func(actionDefs localBuildActions) {
+		for _, v := range actionDefs.variables {
+			variables[v] = v.value_
+		}
+	}
+	for _, module := range c.moduleInfo {
+		addLocals(module.actionDefs)
+	}
+	for _, info := range c.singletonInfo {
+		addLocals(info.actionDefs)
+	}
+	return variables
+}
+
+// buildDefOutputPaths returns the explicit and implicit outputs of the build statement evaluated
+// with the values of variables.
+func buildDefOutputPaths(variables map[Variable]*ninjaString, def *buildDef) ([]string, error) {
+	return evalNinjaStringPaths(variables, slices.Concat(def.Outputs, def.ImplicitOutputs),
+		slices.Concat(def.OutputStrings, def.ImplicitOutputStrings))
+}
+
+// buildDefInputPaths returns the command dependencies, inputs and implicit inputs of the build
+// statement evaluated with the values of variables.
+func buildDefInputPaths(variables map[Variable]*ninjaString, def *buildDef) ([]string, error) {
+	deps := slices.Concat(def.Inputs, def.Implicits)
+	if def.RuleDef != nil {
+		deps = slices.Concat(def.RuleDef.CommandDeps, deps)
+	}
+	return evalNinjaStringPaths(variables, deps, slices.Concat(def.InputStrings, def.ImplicitStrings))
+}
+
+// evalNinjaStringPaths appends the values of strs evaluated with the values of variables to paths.
+func evalNinjaStringPaths(variables map[Variable]*ninjaString, strs []*ninjaString, paths []string) ([]string, error) {
+	for _, str := range strs {
+		path, err := str.Eval(variables)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// LiveGlobalVariables returns the global variables that were referenced directly or indirectly by
+// a build definition, sorted by package path and then name.  If this is called before
+// PrepareBuildActions successfully completes then ErrBuildActionsNotReady is returned.
+func (c *Context) LiveGlobalVariables() ([]Variable, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+	return c.liveGlobals.liveVariables(), nil
+}
+
+// LiveRules returns the global rules that were referenced by a build definition, sorted by package
+// path and then name.  If this is called before PrepareBuildActions successfully completes then
+// ErrBuildActionsNotReady is returned.
+func (c *Context) LiveRules() ([]Rule, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+	return c.liveGlobals.liveRules(), nil
+}
+
+// LivePools returns the global pools that were referenced by a live rule, sorted by package path
+// and then name.  If this is called before PrepareBuildActions successfully completes then
+// ErrBuildActionsNotReady is returned.
+func (c *Context) LivePools() ([]Pool, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+	return c.liveGlobals.livePools(), nil
+}
+
+// ResolveVariable returns the value of the global variable v evaluated under the config passed to
+// ResolveDependencies, with the values of the variables it references substituted, whether or not
+// it is live.  It uses the same values as the ninja file, but doesn't make v or the variables it
+// references live.  It returns ErrDependenciesNotReady if called before ResolveDependencies has
+// completed successfully.
+func (c *Context) ResolveVariable(v Variable) (string, error) {
+	if !c.dependenciesReady {
+		return "", ErrDependenciesNotReady
+	}
+	return c.liveGlobals.resolveVariable(v)
+}
+
+// LiveVariableReferencePath returns a description of the chain of references that caused the
+// variable to be written to the ninja file.  The first entry names the module or singleton that
+// created the build statement, if any, followed by the build statement and each rule and
+// variable down to v.  It returns an error if it is called before PrepareBuildActions successfully
+// completes, if SetTrackLiveReferences was not enabled, or if v is not live.
+func (c *Context) LiveVariableReferencePath(v Variable) ([]string, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+	if !c.trackLiveReferences {
+		return nil, fmt.Errorf("live reference tracking was not enabled with SetTrackLiveReferences")
+	}
+
+	path := c.liveGlobals.VariableReferencePath(v)
+	if path == nil {
+		return nil, fmt.Errorf("variable %s is not live", v)
+	}
+
+	var ret []string
+	for _, entity := range path {
+		switch entity := entity.(type) {
+		case *buildDef:
+			if owner := c.buildDefOwner(entity); owner != "" {
+				ret = append(ret, owner)
+			}
+			var outputs []string
+			outputs = append(outputs, entity.OutputStrings...)
+			outputs = append(outputs, getNinjaStrings(entity.Outputs, c.nameTracker)...)
+			ret = append(ret, "build "+strings.Join(outputs, " "))
+		case Rule:
+			ret = append(ret, "rule "+c.nameTracker.Rule(entity))
+		case Pool:
+			ret = append(ret, "pool "+c.nameTracker.Pool(entity))
+		case Variable:
+			ret = append(ret, "variable "+c.nameTracker.Variable(entity))
+		default:
+			panic(fmt.Errorf("unknown live entity type %T", entity))
+		}
+	}
+
+	return ret, nil
+}
+
+// buildDefOwner returns a description of the module or singleton that created the build
+// definition, or an empty string if it was not found.
+func (c *Context) buildDefOwner(def *buildDef) string {
+	for _, module := range c.modulesSorted {
+		if slices.Contains(module.actionDefs.buildDefs, def) {
+			return module.String()
+		}
+	}
+	for _, info := range c.singletonInfo {
+		if slices.Contains(info.actionDefs.buildDefs, def) {
+			return fmt.Sprintf("singleton %q", info.name)
+		}
+	}
+	return ""
+}
+
+// buildDefOutput is a build definition that declared an output, and the module or singleton that
+// created it.
+type buildDefOutput struct {
+	def       *buildDef
+	module    *moduleInfo
+	singleton *singletonInfo
+}
+
+func (o *buildDefOutput) owner() string {
+	if o.module != nil {
+		return fmt.Sprintf("%s: %s", o.module.pos, o.module)
+	}
+	return fmt.Sprintf("singleton %q", o.singleton.name)
+}
+
+// deduplicateBuildDefs indexes the outputs of the build definitions of all the modules and
+// singletons to find the outputs that are declared more than once, which ninja would reject as
+// multiple rules generating the same file.  A build definition that is identical to the one that
+// first declared one of its outputs is removed, even if the same module declared both.  Otherwise
+// a single error is returned for each output, listing all the modules and singletons that build it.
+// The outputs are compared after evaluating their variables, as ninja sees them.
+func (c *Context) deduplicateBuildDefs() []error {
+	outputs := make(map[string]*buildDefOutput)
+
+	// The build definitions that conflict with the first one that declared an output, and the
+	// conflicting outputs in the order they were found.
+	conflicts := make(map[*buildDefOutput][]*buildDefOutput)
+	var conflictOutputs []*buildDefOutput
+	conflictKeys := make(map[*buildDefOutput]string)
+
+	variables := c.buildDefVariables()
+	var errs []error
+	scan := func(actionDefs *localBuildActions, module *moduleInfo, singleton *singletonInfo) {
+		actionDefs.buildDefs = slices.DeleteFunc(actionDefs.buildDefs, func(def *buildDef) bool {
+			declared, err := buildDefOutputPaths(variables, def)
+			if err != nil {
+				errs = append(errs, err)
+				return false
+			}
+
+			var previous *buildDefOutput
+			var conflict string
+			for _, output := range declared {
+				if previous = outputs[output]; previous != nil {
+					conflict = output
+					break
+				}
+			}
+			if previous == nil {
+				for _, output := range declared {
+					outputs[output] = &buildDefOutput{
+						def:       def,
+						module:    module,
+						singleton: singleton,
+					}
+				}
+				return false
+			}
+
+			if buildDefsEqual(previous.def, def) {
+				// The duplicate references the same globals as the build definition that is
+				// kept, so releasing its references doesn't change the live globals.
+				if err := c.liveGlobals.RemoveBuildDefDeps(def); err != nil {
+					errs = append(errs, err)
+				}
+				return true
+			}
+
+			if _, exists := conflicts[previous]; !exists {
+				conflictOutputs = append(conflictOutputs, previous)
+				conflictKeys[previous] = conflict
+			}
+			conflicts[previous] = append(conflicts[previous], &buildDefOutput{
+				def:       def,
+				module:    module,
+				singleton: singleton,
+			})
+			return false
+		})
+	}
+
+	// Visit the modules in the order they are written so that the build definition that is kept
+	// and the order of the error messages are stable.
+	modules := make([]*moduleInfo, 0, len(c.moduleInfo))
+	for _, module := range c.moduleInfo {
+		modules = append(modules, module)
+	}
+	sort.Sort(moduleSorter{modules, c.nameInterface})
+	for _, module := range modules {
+		scan(&module.actionDefs, module, nil)
+	}
+	for _, info := range c.singletonInfo {
+		scan(&info.actionDefs, nil, info)
+	}
+
+	for _, first := range conflictOutputs {
+		var producers []string
+		for _, producer := range append([]*buildDefOutput{first}, conflicts[first]...) {
+			// seven characters at the start of each line to align with the string "error: "
+			producers = append(producers, "       "+producer.owner())
+		}
+		errs = append(errs, fmt.Errorf("output %q is built by different build statements in:\n%s",
+			conflictKeys[first], strings.Join(producers, "\n")))
+	}
+	return errs
+}
+
 func (c *Context) OutDir() (string, error) {
 	if c.outDir != nil {
 		return c.outDir.Eval(c.globalVariables)
@@ -3877,6 +5700,33 @@ func (c *Context) ModuleTypePropertyStructs() map[string][]interface{} {
 	return ret
 }
 
+// ModuleTypeDoc describes the properties of a module type, see Context.ModuleTypeDocs.
+type ModuleTypeDoc struct {
+	Name       string
+	Properties []proptools.PropertyDoc
+}
+
+// ModuleTypeDocs returns the documentation of each registered module type sorted by name, found
+// by reflecting over the property structs returned by its factory, see proptools.PropertyDocs.
+// The defaults are the values set by the factory.
+func (c *Context) ModuleTypeDocs() []ModuleTypeDoc {
+	names := make([]string, 0, len(c.moduleFactories))
+	for name := range c.moduleFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	docs := make([]ModuleTypeDoc, len(names))
+	for i, name := range names {
+		_, props := c.moduleFactories[name]()
+		docs[i] = ModuleTypeDoc{
+			Name:       name,
+			Properties: proptools.PropertyDocs(props...),
+		}
+	}
+	return docs
+}
+
 func (c *Context) ModuleTypeFactories() map[string]ModuleFactory {
 	ret := make(map[string]ModuleFactory)
 	for k, v := range c.moduleFactories {
@@ -3899,6 +5749,38 @@ func (c *Context) ModuleSubDir(logicModule Module) string {
 	return module.variant.name
 }
 
+// ModuleVariations returns the values of the variations that identify the variant of the module,
+// keyed by the name of the mutator that created them.  Variations with an empty value are omitted,
+// as they are from ModuleSubDir, which joins the values in the order the mutators ran.  It returns
+// an empty map for a module that has no variants.  The map may be modified by the caller.
+func (c *Context) ModuleVariations(logicModule Module) map[string]string {
+	module := c.moduleInfo[logicModule]
+	variations := make(map[string]string, len(module.variant.variations))
+	for mutator, variation := range module.variant.variations {
+		if variation != "" {
+			variations[mutator] = variation
+		}
+	}
+	return variations
+}
+
+// ModuleStableID returns an identifier for the module that is the same in every run that parses
+// the same Blueprints files, unlike the Module itself, so that it can be used to refer to the
+// module from caches or other processes.  It is a hash of the name and variant of the module and
+// the file, line and column of its definition, which together identify one module in a build.
+func (c *Context) ModuleStableID(logicModule Module) string {
+	module := c.moduleInfo[logicModule]
+	identity := strings.Join([]string{
+		module.Name(),
+		module.variant.name,
+		module.relBlueprintsFile,
+		strconv.Itoa(module.pos.Line),
+		strconv.Itoa(module.pos.Column),
+	}, "\x00")
+	hash := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(hash[:16])
+}
+
 func (c *Context) ModuleType(logicModule Module) string {
 	module := c.moduleInfo[logicModule]
 	return module.typeName
@@ -3918,6 +5800,12 @@ func (c *Context) BlueprintFile(logicModule Module) string {
 	return module.relBlueprintsFile
 }
 
+// ModulePosition returns the position of the module type in the definition of the given module.
+func (c *Context) ModulePosition(logicModule Module) scanner.Position {
+	module := c.moduleInfo[logicModule]
+	return module.pos
+}
+
 func (c *Context) ModuleErrorf(logicModule Module, format string,
 	args ...interface{}) error {
 
@@ -3976,6 +5864,31 @@ func (c *Context) VisitAllModulesIf(pred func(Module) bool,
 	c.visitAllModulesIf(pred, visit)
 }
 
+// FilterModules returns every variant of every module for which pred returns true, in the same
+// order as VisitAllModules.  It reflects the state of the modules after all mutators have run, so
+// it should be called after ResolveDependencies.  It doesn't modify the Context, so it may be
+// called concurrently as long as nothing else modifies the modules.
+func (c *Context) FilterModules(pred func(Module) bool) []Module {
+	var modules []Module
+	// Use the NameInterface directly instead of sortedModuleGroups, which updates its cache.
+	for _, group := range c.nameInterface.AllModules() {
+		for _, moduleOrAlias := range group.modules {
+			if module := moduleOrAlias.module(); module != nil && pred(module.logicModule) {
+				modules = append(modules, module.logicModule)
+			}
+		}
+	}
+	return modules
+}
+
+// ModulesOfType returns every variant of every module of the module type registered as typeName,
+// see FilterModules.
+func (c *Context) ModulesOfType(typeName string) []Module {
+	return c.FilterModules(func(m Module) bool {
+		return c.moduleInfo[m].typeName == typeName
+	})
+}
+
 func (c *Context) VisitDirectDeps(module Module, visit func(Module)) {
 	topModule := c.moduleInfo[module]
 
@@ -4142,8 +6055,14 @@ func (c *Context) VerifyProvidersWereUnchanged() []error {
 
 // WriteBuildFile writes the Ninja manifest text for the generated build
 // actions to w.  If this is called before PrepareBuildActions successfully
-// completes then ErrBuildActionsNotReady is returned.
+// completes then ErrBuildActionsNotReady is returned.  If shardNinja is set or
+// SetNinjaOutputSplitting selected NinjaOutputPerPackage, the build actions of
+// modules are written to separate files named after ninjaFileName relative to
+// the source directory, which w includes with subninja.
 func (c *Context) WriteBuildFile(w StringWriterWriter, shardNinja bool, ninjaFileName string) error {
+	c.BeginEvent("write_build_file")
+	defer c.EndEvent("write_build_file")
+
 	var err error
 	pprof.Do(c.Context, pprof.Labels("blueprint", "WriteBuildFile"), func(ctx context.Context) {
 		if !c.buildActionsReady {
@@ -4151,7 +6070,7 @@ func (c *Context) WriteBuildFile(w StringWriterWriter, shardNinja bool, ninjaFil
 			return
 		}
 
-		nw := newNinjaWriter(w)
+		nw := c.newNinjaWriter(w)
 
 		if err = c.writeBuildFileHeader(nw); err != nil {
 			return
@@ -4165,6 +6084,8 @@ func (c *Context) WriteBuildFile(w StringWriterWriter, shardNinja bool, ninjaFil
 			return
 		}
 
+		duplicateRules := c.deduplicateGlobalRules()
+
 		// TODO: Group the globals by package.
 
 		if err = c.writeGlobalVariables(nw); err != nil {
@@ -4179,7 +6100,7 @@ func (c *Context) WriteBuildFile(w StringWriterWriter, shardNinja bool, ninjaFil
 			return
 		}
 
-		if err = c.writeGlobalRules(nw); err != nil {
+		if err = c.writeGlobalRules(nw, duplicateRules); err != nil {
 			return
 		}
 
@@ -4190,11 +6111,35 @@ func (c *Context) WriteBuildFile(w StringWriterWriter, shardNinja bool, ninjaFil
 		if err = c.writeAllSingletonActions(nw); err != nil {
 			return
 		}
+
+		if err = c.writeDefaults(nw); err != nil {
+			return
+		}
 	})
 
 	return err
 }
 
+// WriteBuildFileStreaming writes the Ninja manifest text for the generated build actions to w in
+// the same form as WriteBuildFile without sharding.  Declarations of variables, pools and rules are
+// written first, followed by the build statements of each module as it is visited, through a small
+// fixed size buffer so that the output is never held in memory in its entirety.  Per-package
+// output selected by SetNinjaOutputSplitting is not supported, as there is no ninja file name to
+// name the package files after.
+func (c *Context) WriteBuildFileStreaming(w io.Writer) error {
+	buf := bufio.NewWriterSize(w, streamingWriteBufferSize)
+
+	if err := c.WriteBuildFile(buf, false, ""); err != nil {
+		return err
+	}
+
+	return buf.Flush()
+}
+
+// streamingWriteBufferSize is the size of the buffer used by WriteBuildFileStreaming between the
+// ninja writer and the caller's io.Writer.
+const streamingWriteBufferSize = 64 * 1024
+
 type pkgAssociation struct {
 	PkgName string
 	PkgPath string
@@ -4373,11 +6318,47 @@ func (c *Context) writeGlobalPools(nw *ninjaWriter) error {
 	return nil
 }
 
-func (c *Context) writeGlobalRules(nw *ninjaWriter) error {
+// deduplicateGlobalRules finds global rules whose definitions are identical after evaluating the
+// global variables they reference, and renames every duplicate to the rule with the lowest full
+// name so that build statements reference a single ninja rule.  Rules that use different pools are
+// never merged.  It returns the set of rules that should not be written.
+func (c *Context) deduplicateGlobalRules() map[Rule]bool {
+	if !c.deduplicateRules {
+		return nil
+	}
+
+	// Sort by the original full names rather than the names in the nameTracker so that the
+	// canonical rule is stable, even if WriteBuildFile is called more than once.
 	globalRules := make([]Rule, 0, len(c.globalRules))
 	for rule := range c.globalRules {
 		globalRules = append(globalRules, rule)
 	}
+	slices.SortFunc(globalRules, func(a, b Rule) int {
+		return cmp.Compare(a.fullName(c.nameTracker.pkgNames), b.fullName(c.nameTracker.pkgNames))
+	})
+
+	canonical := make(map[string]Rule)
+	duplicates := make(map[Rule]bool)
+	for _, rule := range globalRules {
+		key := c.globalRules[rule].dedupKey(c.globalVariables, c.nameTracker)
+		if first, exists := canonical[key]; exists {
+			c.nameTracker.rules[rule] = c.nameTracker.rules[first]
+			duplicates[rule] = true
+		} else {
+			canonical[key] = rule
+		}
+	}
+
+	return duplicates
+}
+
+func (c *Context) writeGlobalRules(nw *ninjaWriter, skip map[Rule]bool) error {
+	globalRules := make([]Rule, 0, len(c.globalRules))
+	for rule := range c.globalRules {
+		if !skip[rule] {
+			globalRules = append(globalRules, rule)
+		}
+	}
 
 	slices.SortFunc(globalRules, func(a, b Rule) int {
 		return cmp.Compare(c.nameTracker.Rule(a), c.nameTracker.Rule(b))
@@ -4386,7 +6367,7 @@ func (c *Context) writeGlobalRules(nw *ninjaWriter) error {
 	for _, rule := range globalRules {
 		name := c.nameTracker.Rule(rule)
 		def := c.globalRules[rule]
-		err := def.WriteTo(nw, name, c.nameTracker)
+		err := def.WriteTo(nw, name, c.nameTracker, c.commandTransform)
 		if err != nil {
 			return err
 		}
@@ -4473,6 +6454,7 @@ func (c *Context) writeAllModuleActions(nw *ninjaWriter, shardNinja bool, ninjaF
 	sort.Sort(moduleSorter{modules, c.nameInterface})
 
 	phonys := c.deduplicateOrderOnlyDeps(modules)
+	phonys.buildDefs = append(phonys.buildDefs, mergePhonys(modules)...)
 	if err := c.writeLocalBuildActions(nw, phonys); err != nil {
 		return err
 	}
@@ -4483,58 +6465,108 @@ func (c *Context) writeAllModuleActions(nw *ninjaWriter, shardNinja bool, ninjaF
 		panic(err)
 	}
 
-	if shardNinja {
-		var wg sync.WaitGroup
-		errorCh := make(chan error)
+	if c.ninjaOutputSplitting == NinjaOutputPerPackage {
+		if shardNinja {
+			return fmt.Errorf("sharded ninja output can't be combined with per-package ninja output")
+		}
+		if ninjaFileName == "" {
+			return fmt.Errorf("per-package ninja output requires a ninja file name")
+		}
+		files, packageModules := packageNinjaFiles(modules, ninjaFileName)
+		return c.writeModuleActionFiles(nw, files, packageModules, headerTemplate)
+	} else if shardNinja {
 		files := GetNinjaShardFiles(ninjaFileName)
 		shardedModules := proptools.ShardByCount(modules, len(files))
-		for i, batchModules := range shardedModules {
-			file := files[i]
-			wg.Add(1)
-			go func(file string, batchModules []*moduleInfo) {
-				defer wg.Done()
-				f, err := os.OpenFile(JoinPath(c.SrcDir(), file), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, OutFilePermissions)
+		return c.writeModuleActionFiles(nw, files, shardedModules, headerTemplate)
+	} else {
+		return c.writeModuleAction(modules, nw, headerTemplate)
+	}
+}
+
+// packageNinjaFiles groups the modules by the directory of their Blueprints file, and returns
+// the names of the ninja files for the directories in sorted order.  Directories that contain no
+// modules with build actions are skipped.
+func packageNinjaFiles(modules []*moduleInfo, ninjaFileName string) ([]string, [][]*moduleInfo) {
+	byDir := make(map[string][]*moduleInfo)
+	for _, module := range modules {
+		if len(module.actionDefs.variables)+len(module.actionDefs.rules)+len(module.actionDefs.buildDefs) == 0 {
+			continue
+		}
+		dir := filepath.Dir(module.relBlueprintsFile)
+		byDir[dir] = append(byDir[dir], module)
+	}
+
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	files := make([]string, len(dirs))
+	packageModules := make([][]*moduleInfo, len(dirs))
+	for i, dir := range dirs {
+		files[i] = filepath.Join(ninjaFileName+".pkgs", dir, "package.ninja")
+		packageModules[i] = byDir[dir]
+	}
+	return files, packageModules
+}
+
+// writeModuleActionFiles writes the build actions of each batch of modules to the corresponding
+// file relative to the source directory, and includes the files from nw with subninja.
+func (c *Context) writeModuleActionFiles(nw *ninjaWriter, files []string, batches [][]*moduleInfo,
+	headerTemplate *template.Template) error {
+
+	var wg sync.WaitGroup
+	errorCh := make(chan error)
+	for i, batchModules := range batches {
+		file := files[i]
+		wg.Add(1)
+		go func(file string, batchModules []*moduleInfo) {
+			defer wg.Done()
+			path := JoinPath(c.SrcDir(), file)
+			if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+				errorCh <- fmt.Errorf("error creating Ninja file directory: %s", err)
+				return
+			}
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, OutFilePermissions)
+			if err != nil {
+				errorCh <- fmt.Errorf("error opening Ninja file shard: %s", err)
+				return
+			}
+			defer func() {
+				err := f.Close()
 				if err != nil {
-					errorCh <- fmt.Errorf("error opening Ninja file shard: %s", err)
-					return
+					errorCh <- err
 				}
-				defer func() {
-					err := f.Close()
-					if err != nil {
-						errorCh <- err
-					}
-				}()
-				buf := bufio.NewWriterSize(f, 16*1024*1024)
-				defer func() {
-					err := buf.Flush()
-					if err != nil {
-						errorCh <- err
-					}
-				}()
-				writer := newNinjaWriter(buf)
-				err = c.writeModuleAction(batchModules, writer, headerTemplate)
+			}()
+			buf := bufio.NewWriterSize(f, 16*1024*1024)
+			defer func() {
+				err := buf.Flush()
 				if err != nil {
 					errorCh <- err
 				}
-			}(file, batchModules)
-			nw.Subninja(file)
-		}
-		go func() {
-			wg.Wait()
-			close(errorCh)
-		}()
+			}()
+			writer := c.newNinjaWriter(buf)
+			err = c.writeModuleAction(batchModules, writer, headerTemplate)
+			if err != nil {
+				errorCh <- err
+			}
+		}(file, batchModules)
+		nw.Subninja(proptools.NinjaEscape(file))
+	}
+	go func() {
+		wg.Wait()
+		close(errorCh)
+	}()
 
-		var errors []error
-		for newErrors := range errorCh {
-			errors = append(errors, newErrors)
-		}
-		if len(errors) > 0 {
-			return proptools.MergeErrors(errors)
-		}
-		return nil
-	} else {
-		return c.writeModuleAction(modules, nw, headerTemplate)
+	var errors []error
+	for newErrors := range errorCh {
+		errors = append(errors, newErrors)
+	}
+	if len(errors) > 0 {
+		return proptools.MergeErrors(errors)
 	}
+	return nil
 }
 
 func (c *Context) writeModuleAction(modules []*moduleInfo, nw *ninjaWriter, headerTemplate *template.Template) error {
@@ -4545,35 +6577,37 @@ func (c *Context) writeModuleAction(modules []*moduleInfo, nw *ninjaWriter, head
 			continue
 		}
 
-		buf.Reset()
+		if c.ninjaModuleComments {
+			buf.Reset()
 
-		// In order to make the bootstrap build manifest independent of the
-		// build dir we need to output the Blueprints file locations in the
-		// comments as paths relative to the source directory.
-		relPos := module.pos
-		relPos.Filename = module.relBlueprintsFile
+			// In order to make the bootstrap build manifest independent of the
+			// build dir we need to output the Blueprints file locations in the
+			// comments as paths relative to the source directory.
+			relPos := module.pos
+			relPos.Filename = module.relBlueprintsFile
 
-		// Get the name and location of the factory function for the module.
-		factoryFunc := runtime.FuncForPC(reflect.ValueOf(module.factory).Pointer())
-		factoryName := factoryFunc.Name()
+			// Get the name and location of the factory function for the module.
+			factoryFunc := runtime.FuncForPC(reflect.ValueOf(module.factory).Pointer())
+			factoryName := factoryFunc.Name()
 
-		infoMap := map[string]interface{}{
-			"name":      module.Name(),
-			"typeName":  module.typeName,
-			"goFactory": factoryName,
-			"pos":       relPos,
-			"variant":   module.variant.name,
-		}
-		if err := headerTemplate.Execute(buf, infoMap); err != nil {
-			return err
-		}
+			infoMap := map[string]interface{}{
+				"name":      module.Name(),
+				"typeName":  module.typeName,
+				"goFactory": factoryName,
+				"pos":       relPos,
+				"variant":   module.variant.name,
+			}
+			if err := headerTemplate.Execute(buf, infoMap); err != nil {
+				return err
+			}
 
-		if err := nw.Comment(buf.String()); err != nil {
-			return err
-		}
+			if err := nw.Comment(buf.String()); err != nil {
+				return err
+			}
 
-		if err := nw.BlankLine(); err != nil {
-			return err
+			if err := nw.BlankLine(); err != nil {
+				return err
+			}
 		}
 
 		if err := c.writeLocalBuildActions(nw, &module.actionDefs); err != nil {
@@ -4650,10 +6684,16 @@ func (c *Context) GetEventHandler() *metrics.EventHandler {
 
 func (c *Context) BeginEvent(name string) {
 	c.EventHandler.Begin(name)
+	if c.phaseTracer != nil {
+		c.phaseTracer.record(name, "B", 0, nil)
+	}
 }
 
 func (c *Context) EndEvent(name string) {
 	c.EventHandler.End(name)
+	if c.phaseTracer != nil {
+		c.phaseTracer.record(name, "E", 0, nil)
+	}
 }
 
 func (c *Context) SetBeforePrepareBuildActionsHook(hookFn func() error) {
@@ -4724,12 +6764,10 @@ func scanBuildDef(candidates *sync.Map, b *buildDef) {
 				}
 				// the previously recorded build-def, which first had these deps as its
 				// order-only deps, should now use this phony output instead
-				m.first.OrderOnlyStrings = m.phony.OutputStrings
-				m.first.OrderOnly = nil
+				m.first.replaceOrderOnly(m.phony.OutputStrings)
 				m.first = nil
 			})
-			b.OrderOnlyStrings = m.phony.OutputStrings
-			b.OrderOnly = nil
+			b.replaceOrderOnly(m.phony.OutputStrings)
 		}
 	}
 }
@@ -4737,7 +6775,8 @@ func scanBuildDef(candidates *sync.Map, b *buildDef) {
 // deduplicateOrderOnlyDeps searches for common sets of order-only dependencies across all
 // buildDef instances in the provided moduleInfo instances. Each such
 // common set forms a new buildDef representing a phony output that then becomes
-// the sole order-only dependency of those buildDef instances
+// the sole order-only dependency of those buildDef instances.  The original order-only
+// dependencies are restored first, so the phonys created by a previous call are recomputed.
 func (c *Context) deduplicateOrderOnlyDeps(modules []*moduleInfo) *localBuildActions {
 	c.BeginEvent("deduplicate_order_only_deps")
 	defer c.EndEvent("deduplicate_order_only_deps")
@@ -4746,6 +6785,7 @@ func (c *Context) deduplicateOrderOnlyDeps(modules []*moduleInfo) *localBuildAct
 	parallelVisit(modules, unorderedVisitorImpl{}, parallelVisitLimit,
 		func(m *moduleInfo, pause chan<- pauseSpec) bool {
 			for _, b := range m.actionDefs.buildDefs {
+				b.restoreOrderOnly()
 				if len(b.OrderOnly) > 0 || len(b.OrderOnlyStrings) > 0 {
 					scanBuildDef(&candidates, b)
 				}
@@ -4773,6 +6813,53 @@ func (c *Context) deduplicateOrderOnlyDeps(modules []*moduleInfo) *localBuildAct
 	return &localBuildActions{buildDefs: phonys}
 }
 
+// mergePhonys combines the phony targets declared by the provided modules into one phony build
+// statement per name.  The dependencies of each statement are the sorted union of the
+// dependencies declared for that name by all modules.
+func mergePhonys(modules []*moduleInfo) []*buildDef {
+	phonys := make(map[string][]string)
+	var names []string
+	for _, m := range modules {
+		for _, p := range m.actionDefs.phonys {
+			if _, exists := phonys[p.name]; !exists {
+				names = append(names, p.name)
+			}
+			phonys[p.name] = append(phonys[p.name], p.deps...)
+		}
+	}
+
+	sort.Strings(names)
+	defs := make([]*buildDef, 0, len(names))
+	for _, name := range names {
+		deps := phonys[name]
+		slices.Sort(deps)
+		defs = append(defs, &buildDef{
+			Rule:          Phony,
+			OutputStrings: []string{name},
+			InputStrings:  slices.Compact(deps),
+		})
+	}
+	return defs
+}
+
+// writeDefaults writes a single default statement at the end of the ninja file for the sorted
+// union of the targets passed to ModuleContext.AddDefault by all modules.
+func (c *Context) writeDefaults(nw *ninjaWriter) error {
+	var defaults []string
+	for _, m := range c.moduleInfo {
+		defaults = append(defaults, m.actionDefs.defaults...)
+	}
+	if len(defaults) == 0 {
+		return nil
+	}
+	slices.Sort(defaults)
+
+	if err := nw.Default(c.nameTracker, nil, slices.Compact(defaults)); err != nil {
+		return err
+	}
+	return nw.BlankLine()
+}
+
 func (c *Context) writeLocalBuildActions(nw *ninjaWriter,
 	defs *localBuildActions) error {
 
@@ -4798,8 +6885,17 @@ func (c *Context) writeLocalBuildActions(nw *ninjaWriter,
 		}
 	}
 
+	rules, buildDefs := defs.rules, defs.buildDefs
+	if c.sortBuildStatements {
+		rules = slices.Clone(rules)
+		slices.SortStableFunc(rules, func(a, b *localRule) int {
+			return cmp.Compare(a.fullName(nil), b.fullName(nil))
+		})
+		buildDefs = c.sortedBuildDefs(buildDefs)
+	}
+
 	// Write the local rules.
-	for _, r := range defs.rules {
+	for _, r := range rules {
 		// A localRule doesn't need the package names or config to determine
 		// its name or definition.
 		name := r.fullName(nil)
@@ -4808,7 +6904,7 @@ func (c *Context) writeLocalBuildActions(nw *ninjaWriter,
 			panic(err)
 		}
 
-		err = def.WriteTo(nw, name, c.nameTracker)
+		err = def.WriteTo(nw, name, c.nameTracker, c.commandTransform)
 		if err != nil {
 			return err
 		}
@@ -4820,7 +6916,14 @@ func (c *Context) writeLocalBuildActions(nw *ninjaWriter,
 	}
 
 	// Write the build definitions.
-	for _, buildDef := range defs.buildDefs {
+	for _, buildDef := range buildDefs {
+		if c.ninjaSandboxComments && buildDef.Sandbox != nil {
+			err := nw.Comment(buildDef.Sandbox.comment())
+			if err != nil {
+				return err
+			}
+		}
+
 		err := buildDef.WriteTo(nw, c.nameTracker)
 		if err != nil {
 			return err
@@ -4837,6 +6940,26 @@ func (c *Context) writeLocalBuildActions(nw *ninjaWriter,
 	return nil
 }
 
+// sortedBuildDefs returns a copy of defs sorted by the first output that is written for each one.
+func (c *Context) sortedBuildDefs(defs []*buildDef) []*buildDef {
+	primaryOutput := func(def *buildDef) string {
+		if len(def.OutputStrings) > 0 {
+			return def.OutputStrings[0]
+		}
+		return def.Outputs[0].Value(c.nameTracker)
+	}
+
+	keys := make(map[*buildDef]string, len(defs))
+	for _, def := range defs {
+		keys[def] = primaryOutput(def)
+	}
+	sorted := slices.Clone(defs)
+	slices.SortStableFunc(sorted, func(a, b *buildDef) int {
+		return cmp.Compare(keys[a], keys[b])
+	})
+	return sorted
+}
+
 func beforeInModuleList(a, b *moduleInfo, list modulesOrAliases) bool {
 	found := false
 	if a == b {
@@ -4959,7 +7082,7 @@ func debugValue(value reflect.Value) interface{} {
 	}
 
 	switch kind := value.Kind(); kind {
-	case reflect.Bool, reflect.String, reflect.Int, reflect.Uint:
+	case reflect.Bool, reflect.String, reflect.Int, reflect.Uint, reflect.Int64, reflect.Float64:
 		return value.Interface()
 	case reflect.Slice:
 		return debugSlice(value)
@@ -16,10 +16,15 @@ package blueprint
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,6 +32,8 @@ import (
 	"time"
 
 	"github.com/google/blueprint/parser"
+	"github.com/google/blueprint/pathtools"
+	"github.com/google/blueprint/proptools"
 )
 
 type Walker interface {
@@ -400,6 +407,94 @@ func TestWalkDepsDuplicates_IgnoreFirstPath(t *testing.T) {
 	}
 }
 
+// > |===B---D           B is a bar_module, so the walk stops descending at B
+// > A                   and D is never visited.  B is still visited through
+// > |===C===B---D       every other path that reaches it.
+// > |   |===E===B---D   E is visited via both C and F, but only walked once.
+// > |===F===E
+func TestBottomUpMutatorWalkDepsPrune(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B", "C", "F"],
+			}
+
+			bar_module {
+			    name: "B",
+			    deps: ["D"],
+			}
+
+			foo_module {
+			    name: "C",
+			    deps: ["B", "E"],
+			}
+
+			foo_module {
+			    name: "D",
+			}
+
+			foo_module {
+			    name: "E",
+			    deps: ["B"],
+			}
+
+			foo_module {
+			    name: "F",
+			    deps: ["E"],
+			}
+		`),
+	})
+
+	var visits []string
+	var nearest []string
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterBottomUpMutator("walk", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() != "A" {
+			return
+		}
+		mctx.WalkDeps(func(child, parent Module) bool {
+			visits = append(visits, mctx.OtherModuleName(parent)+"->"+mctx.OtherModuleName(child))
+			if _, ok := child.(*barModule); ok {
+				nearest = append(nearest, mctx.OtherModuleName(child))
+				return false
+			}
+			return true
+		})
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	expectedVisits := []string{"A->B", "A->C", "C->B", "C->E", "E->B", "A->F", "F->E"}
+	if !reflect.DeepEqual(visits, expectedVisits) {
+		t.Errorf("unexpected WalkDeps visits:\n got: %q\nwant: %q", visits, expectedVisits)
+	}
+	expectedNearest := []string{"B", "B", "B"}
+	if !reflect.DeepEqual(nearest, expectedNearest) {
+		t.Errorf("unexpected pruned modules:\n got: %q\nwant: %q", nearest, expectedNearest)
+	}
+}
+
 func TestCreateModule(t *testing.T) {
 	ctx := newContext()
 	ctx.MockFileSystem(map[string][]byte{
@@ -583,6 +678,129 @@ func TestWalkingWithSyntaxError(t *testing.T) {
 
 }
 
+func TestSetFileLoader(t *testing.T) {
+	files := map[string]string{
+		"Android.bp": `
+			build = ["build.bp", "preprocessed.bp"]
+			foo_module {
+			    name: "a",
+			}
+		`,
+		"build.bp": `
+			foo_module {
+			    name: "b",
+			}
+		`,
+		"preprocessed.bp": `
+			foo_module {
+			    name: "MODULE_NAME",
+			}
+		`,
+	}
+
+	ctx := newContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.SetFileLoader(func(path string) ([]byte, error) {
+		contents, ok := files[path]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		// Preprocess the files while loading them.
+		return []byte(strings.ReplaceAll(contents, "MODULE_NAME", "c")), nil
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %s", errs)
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		if ctx.moduleGroupFromName(name, nil) == nil {
+			t.Errorf("missing module %q", name)
+		}
+	}
+	if ctx.moduleGroupFromName("MODULE_NAME", nil) != nil {
+		t.Errorf("expected the loader to preprocess preprocessed.bp")
+	}
+
+	ctx = newContext()
+	ctx.SetFileLoader(func(path string) ([]byte, error) {
+		if path == "Android.bp" {
+			return []byte(`build = ["missing.bp"]`), nil
+		}
+		return nil, os.ErrNotExist
+	})
+	_, errs = ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+	expectedErrors(t, errs, `Android.bp:1:7: "missing.bp": not found`)
+
+	// Wildcards are expanded against the filesystem, and only the matches that the loader can
+	// load are used.
+	ctx = newContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.SetFs(pathtools.MockFs(map[string][]byte{
+		"a.bp":         nil,
+		"b.bp":         nil,
+		"disk_only.bp": nil,
+		"disk.bp.in":   nil,
+	}))
+	ctx.SetFileLoader(func(path string) ([]byte, error) {
+		switch path {
+		case "Android.bp":
+			return []byte(`build = ["*.bp"]`), nil
+		case "a.bp":
+			return []byte(`foo_module { name: "a" }`), nil
+		case "b.bp":
+			return []byte(`foo_module { name: "b" }`), nil
+		case "loaded.bp.in":
+			return []byte(`foo_module { name: "c" }`), nil
+		}
+		return nil, os.ErrNotExist
+	})
+	_, errs = ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %s", errs)
+	}
+	for _, name := range []string{"a", "b"} {
+		if ctx.moduleGroupFromName(name, nil) == nil {
+			t.Errorf("missing module %q", name)
+		}
+	}
+
+	// Module globs go through the loader too.
+	for _, tt := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"*.bp.in", nil},
+		{"loaded.bp.in", []string{"loaded.bp.in"}},
+		{"disk.bp.in", nil},
+		{"*.bp", []string{"a.bp", "b.bp"}},
+	} {
+		matches, err := ctx.glob(tt.pattern, nil)
+		if err != nil {
+			t.Errorf("unexpected error globbing %q: %s", tt.pattern, err)
+		} else if !reflect.DeepEqual(matches, tt.want) {
+			t.Errorf("expected %q to match %q, got %q", tt.pattern, tt.want, matches)
+		}
+	}
+
+	ctx = newContext()
+	ctx.SetModuleListFile("modules.list")
+	ctx.SetFileLoader(func(path string) ([]byte, error) {
+		if path == "modules.list" {
+			return []byte("a/Android.bp\nb/Android.bp\n"), nil
+		}
+		return nil, os.ErrNotExist
+	})
+	paths, err := ctx.ListModulePaths(".")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"a/Android.bp", "b/Android.bp"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("expected module paths %q, got %q", want, paths)
+	}
+}
+
 func TestParseFailsForModuleWithoutName(t *testing.T) {
 	ctx := NewContext()
 	ctx.MockFileSystem(map[string][]byte{
@@ -609,6 +827,224 @@ func TestParseFailsForModuleWithoutName(t *testing.T) {
 	}
 }
 
+func TestModuleVariations(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterBottomUpMutator("os", func(ctx BottomUpMutatorContext) {
+		if _, ok := ctx.Module().(*fooModule); ok {
+			ctx.CreateVariations("linux", "darwin")
+		}
+	})
+	ctx.RegisterBottomUpMutator("arch", func(ctx BottomUpMutatorContext) {
+		if _, ok := ctx.Module().(*fooModule); ok {
+			ctx.CreateVariations("x86_64", "arm64")
+		}
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+				name: "foo",
+			}
+
+			bar_module {
+				name: "bar",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected dep errors: %v", errs)
+	}
+
+	got := make(map[string]map[string]string)
+	ctx.VisitAllModules(func(m Module) {
+		got[ctx.ModuleName(m)+"{"+ctx.ModuleSubDir(m)+"}"] = ctx.ModuleVariations(m)
+	})
+	want := map[string]map[string]string{
+		"foo{linux_x86_64}":  {"os": "linux", "arch": "x86_64"},
+		"foo{linux_arm64}":   {"os": "linux", "arch": "arm64"},
+		"foo{darwin_x86_64}": {"os": "darwin", "arch": "x86_64"},
+		"foo{darwin_arm64}":  {"os": "darwin", "arch": "arm64"},
+		"bar{}":              {},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected variations %v, got %v", want, got)
+	}
+}
+
+func TestFilterModules(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterBottomUpMutator("variants", func(ctx BottomUpMutatorContext) {
+		if _, ok := ctx.Module().(*fooModule); ok {
+			ctx.CreateVariations("a", "b")
+		}
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+				name: "foo2",
+				foo: "set",
+			}
+
+			bar_module {
+				name: "bar",
+				bar: true,
+			}
+
+			foo_module {
+				name: "foo1",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected dep errors: %v", errs)
+	}
+
+	names := func(modules []Module) []string {
+		var names []string
+		for _, m := range modules {
+			names = append(names, ctx.ModuleName(m)+"{"+ctx.ModuleSubDir(m)+"}")
+		}
+		return names
+	}
+
+	if got, want := names(ctx.ModulesOfType("foo_module")), []string{"foo1{a}", "foo1{b}", "foo2{a}", "foo2{b}"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected foo_modules %q, got %q", want, got)
+	}
+	if got := ctx.ModulesOfType("baz_module"); len(got) != 0 {
+		t.Errorf("expected no baz_modules, got %q", names(got))
+	}
+
+	got := names(ctx.FilterModules(func(m Module) bool {
+		foo, ok := m.(*fooModule)
+		return ok && foo.properties.Foo != ""
+	}))
+	if want := []string{"foo2{a}", "foo2{b}"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected modules with foo set %q, got %q", want, got)
+	}
+}
+
+func TestSoongEnv(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.SetEnv(map[string]string{"FOO": "from_env"})
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+				name: "a",
+				foo: soong_env("FOO"),
+			}
+		`),
+		"sub/Android.bp": []byte(`
+			foo_module {
+				name: "b",
+				foo: soong_env("BAR", "default"),
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp", "sub/Android.bp"}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	for name, want := range map[string]string{"a": "from_env", "b": "default"} {
+		m := ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule.(*fooModule)
+		if got := m.Foo(); got != want {
+			t.Errorf("expected module %q foo %q, got %q", name, want, got)
+		}
+	}
+
+	want := map[string]string{"FOO": "from_env", "BAR": ""}
+	if got := ctx.EnvDeps(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected env deps %q, got %q", want, got)
+	}
+}
+
+func TestDuplicateModuleNames(t *testing.T) {
+	t.Run("triple", func(t *testing.T) {
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				foo_module {
+					name: "a",
+				}
+
+				foo_module {
+					name: "a",
+				}
+
+				foo_module {
+					name: "b",
+				}
+
+				foo_module {
+					name: "a",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+		expectedErrors(t, errs,
+			"Android.bp:2:5: module \"a\" defined 3 times\n"+
+				"       Android.bp:6:5 <-- also defined here\n"+
+				"       Android.bp:14:5 <-- also defined here")
+		if len(ctx.moduleInfo) != 2 {
+			t.Errorf("expected 2 modules, got %d", len(ctx.moduleInfo))
+		}
+	})
+
+	t.Run("different files", func(t *testing.T) {
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				foo_module {
+					name: "a",
+				}
+
+				foo_module {
+					name: "b",
+				}
+			`),
+			"sub/Android.bp": []byte(`
+				foo_module {
+					name: "b",
+				}
+
+				foo_module {
+					name: "a",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseFileList(".", []string{"Android.bp", "sub/Android.bp"}, nil)
+		expectedErrors(t, errs,
+			"Android.bp:2:5: module \"a\" defined 2 times\n"+
+				"       sub/Android.bp:6:5 <-- also defined here",
+			"Android.bp:6:5: module \"b\" defined 2 times\n"+
+				"       sub/Android.bp:2:5 <-- also defined here")
+		if len(ctx.moduleInfo) != 2 {
+			t.Errorf("expected 2 modules, got %d", len(ctx.moduleInfo))
+		}
+	})
+}
+
 func Test_findVariant(t *testing.T) {
 	module := &moduleInfo{
 		variant: variant{
@@ -1489,3 +1925,1001 @@ func TestSourceRootDirs(t *testing.T) {
 		})
 	}
 }
+
+func TestModuleGraphJSON(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B", "C"],
+			    ignored_deps: ["D"],
+			}
+
+			bar_module {
+			    name: "B",
+			    deps: ["D"],
+			}
+
+			foo_module {
+			    name: "C",
+			    deps: ["D"],
+			}
+
+			foo_module {
+			    name: "D",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+
+	if err := ctx.ModuleGraphJSON(&bytes.Buffer{}); err != ErrDependenciesNotReady {
+		t.Errorf("expected ErrDependenciesNotReady before ResolveDependencies, got %v", err)
+	}
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.ModuleGraphJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var graph JSONModuleGraph
+	if err := json.Unmarshal(buf.Bytes(), &graph); err != nil {
+		t.Fatalf("failed to decode module graph: %s\n%s", err, buf.String())
+	}
+
+	for i, node := range graph.Modules {
+		if node.ID != i {
+			t.Errorf("expected module %q to have ID %d, got %d", node.Name, i, node.ID)
+		}
+		if !strings.HasPrefix(node.Position, "Android.bp:") {
+			t.Errorf("expected module %q to have a position in Android.bp, got %q", node.Name, node.Position)
+		}
+	}
+
+	adjacency := make(map[string][]string)
+	for _, edge := range graph.Edges {
+		from, to := graph.Modules[edge.From], graph.Modules[edge.To]
+		follow := strings.Contains(edge.Tag, "follow:true")
+		adjacency[from.Name] = append(adjacency[from.Name], fmt.Sprintf("%s:%v", to.Name, follow))
+	}
+
+	want := map[string][]string{
+		"A": {"D:false", "B:true", "C:true"},
+		"B": {"D:true"},
+		"C": {"D:true"},
+	}
+	if !reflect.DeepEqual(adjacency, want) {
+		t.Errorf("incorrect adjacency:\nwant: %v\n got: %v", want, adjacency)
+	}
+
+	types := make(map[string]string)
+	for _, node := range graph.Modules {
+		types[node.Name] = node.Type
+	}
+	if g, w := types, (map[string]string{"A": "foo_module", "B": "bar_module", "C": "foo_module", "D": "foo_module"}); !reflect.DeepEqual(g, w) {
+		t.Errorf("incorrect module types:\nwant: %v\n got: %v", w, g)
+	}
+}
+
+type dotOrderOnlyDepsTag struct {
+	BaseDependencyTag
+}
+
+func (dotOrderOnlyDepsTag) OrderOnlyDependency() bool { return true }
+
+func TestWriteDepGraphDot(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B\"quoted"],
+			    ignored_deps: ["C"],
+			}
+
+			bar_module {
+			    name: "B\"quoted",
+			}
+
+			foo_module {
+			    name: "C",
+			}
+
+			foo_module {
+			    name: "D",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterBottomUpMutator("deps", func(mctx BottomUpMutatorContext) {
+		if m, ok := mctx.Module().(depsProvider); ok {
+			mctx.AddDependency(mctx.Module(), dotOrderOnlyDepsTag{}, m.IgnoreDeps()...)
+			mctx.AddDependency(mctx.Module(), walkerDepsTag{follow: true}, m.Deps()...)
+		}
+	})
+	ctx.RegisterBottomUpMutator("variants", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "C" {
+			mctx.CreateVariations("x", "y")
+		}
+	})
+
+	if err := ctx.WriteDepGraphDot(&bytes.Buffer{}); err != ErrDependenciesNotReady {
+		t.Errorf("expected ErrDependenciesNotReady before ResolveDependencies, got %v", err)
+	}
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	ids := make(map[string]string)
+	for i, m := range ctx.modulesSorted {
+		ids[m.Name()+"/"+m.variant.name] = fmt.Sprintf("m%d", i)
+	}
+
+	t.Run("all", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteDepGraphDot(buf); err != nil {
+			t.Fatal(err)
+		}
+		got := buf.String()
+
+		for _, w := range []string{
+			`digraph blueprint {`,
+			ids["A/"] + ` [label="A\nfoo_module"];`,
+			ids[`B"quoted/`] + ` [label="B\"quoted\nbar_module"];`,
+			ids["D/"] + ` [label="D\nfoo_module"];`,
+			`label="C";`,
+			ids["C/x"] + ` [label="C\nx\nfoo_module"];`,
+			ids["C/y"] + ` [label="C\ny\nfoo_module"];`,
+			ids["A/"] + ` -> ` + ids[`B"quoted/`] + ` [style=solid];`,
+			ids["A/"] + ` -> ` + ids["C/x"] + ` [style=dashed];`,
+		} {
+			if !strings.Contains(got, w) {
+				t.Errorf("expected output to contain %q, got:\n%s", w, got)
+			}
+		}
+
+		again := &bytes.Buffer{}
+		if err := ctx.WriteDepGraphDot(again); err != nil {
+			t.Fatal(err)
+		}
+		if again.String() != got {
+			t.Errorf("expected deterministic output, got:\n%s\nand:\n%s", got, again.String())
+		}
+	})
+
+	t.Run("from", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteDepGraphDotFrom(buf, `B"quoted`); err != nil {
+			t.Fatal(err)
+		}
+		want := "digraph blueprint {\n" +
+			"\t" + ids[`B"quoted/`] + ` [label="B\"quoted\nbar_module"];` + "\n" +
+			"}\n"
+		if g := buf.String(); g != want {
+			t.Errorf("incorrect output:\nwant: %s\n got: %s", want, g)
+		}
+
+		if err := ctx.WriteDepGraphDotFrom(&bytes.Buffer{}, "missing"); err == nil {
+			t.Errorf("expected error for missing module")
+		}
+	})
+}
+
+type verifyAcyclicTestTag struct {
+	BaseDependencyTag
+	name string
+}
+
+func (t verifyAcyclicTestTag) String() string { return t.name }
+
+type verifyAcyclicTestModule struct {
+	SimpleName
+	variant string
+}
+
+func newVerifyAcyclicTestModule() (Module, []interface{}) {
+	m := &verifyAcyclicTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *verifyAcyclicTestModule) GenerateBuildActions(ModuleContext) {}
+
+func TestVerifyAcyclic(t *testing.T) {
+	static := verifyAcyclicTestTag{name: "static"}
+	data := verifyAcyclicTestTag{name: "data"}
+
+	type dep struct {
+		tag verifyAcyclicTestTag
+		to  string
+	}
+
+	// Device variants depend on host variants, so none of these form a cycle between variants,
+	// but they do form cycles between modules.
+	deviceDeps := map[string][]dep{
+		// A and B form a cycle through static dependencies only.
+		"A": {{static, "B"}, {static, "E"}},
+		"B": {{static, "A"}},
+		// C and D form a cycle through data dependencies only.
+		"C": {{data, "D"}},
+		"D": {{data, "C"}},
+		// A and E form a cycle through a mix of static and data dependencies.
+		"E": {{data, "A"}},
+	}
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newVerifyAcyclicTestModule)
+	ctx.RegisterBottomUpMutator("variants", func(ctx BottomUpMutatorContext) {
+		variants := ctx.CreateVariations("host", "device")
+		variants[0].(*verifyAcyclicTestModule).variant = "host"
+		variants[1].(*verifyAcyclicTestModule).variant = "device"
+	})
+	ctx.RegisterBottomUpMutator("deps", func(ctx BottomUpMutatorContext) {
+		if ctx.Module().(*verifyAcyclicTestModule).variant != "device" {
+			return
+		}
+		for _, d := range deviceDeps[ctx.ModuleName()] {
+			ctx.AddVariationDependencies([]Variation{{"variants", "host"}}, d.tag, d.to)
+		}
+	})
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test {
+			    name: "A",
+			}
+
+			test {
+			    name: "B",
+			}
+
+			test {
+			    name: "C",
+			}
+
+			test {
+			    name: "D",
+			}
+
+			test {
+			    name: "E",
+			}
+		`),
+	})
+
+	if errs := ctx.VerifyAcyclic(static); len(errs) != 1 || errs[0] != ErrDependenciesNotReady {
+		t.Errorf("expected ErrDependenciesNotReady before ResolveDependencies, got %v", errs)
+	}
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected dep errors: %v", errs)
+	}
+
+	expectedErrors(t, ctx.VerifyAcyclic(static),
+		`Android.bp:2:4: encountered dependency cycle with tag static: "A" -> "B" -> "A"`)
+	expectedErrors(t, ctx.VerifyAcyclic(data),
+		`Android.bp:10:4: encountered dependency cycle with tag data: "C" -> "D" -> "C"`)
+	expectedErrors(t, ctx.VerifyAcyclic(verifyAcyclicTestTag{name: "other"}))
+}
+
+func TestDependencyCycleErrors(t *testing.T) {
+	static := verifyAcyclicTestTag{name: "static"}
+	data := verifyAcyclicTestTag{name: "data"}
+
+	type dep struct {
+		tag verifyAcyclicTestTag
+		to  string
+	}
+	deps := map[string][]dep{
+		// A, B and C form a cycle.
+		"A": {{static, "B"}},
+		"B": {{data, "C"}, {static, "C"}},
+		"C": {{static, "A"}},
+		// D and E form a second, independent cycle.
+		"D": {{static, "E"}},
+		"E": {{data, "D"}},
+	}
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newVerifyAcyclicTestModule)
+	ctx.RegisterBottomUpMutator("deps", func(ctx BottomUpMutatorContext) {
+		for _, d := range deps[ctx.ModuleName()] {
+			ctx.AddDependency(ctx.Module(), d.tag, d.to)
+		}
+	})
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test {
+			    name: "A",
+			}
+
+			test {
+			    name: "B",
+			}
+
+			test {
+			    name: "C",
+			}
+
+			test {
+			    name: "D",
+			}
+
+			test {
+			    name: "E",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+
+	// Each cycle is reported as a header followed by one error per edge, starting from whichever
+	// module of the cycle was visited first, so find the header for each cycle and rotate the
+	// expected modules to match.
+	type cycle struct {
+		modules []string
+		tags    []string
+	}
+	cycles := []cycle{
+		{[]string{"A", "B", "C"}, []string{"static", "data, static", "static"}},
+		{[]string{"D", "E"}, []string{"static", "data"}},
+	}
+	pos := map[string]string{"A": "2:4", "B": "6:4", "C": "10:4", "D": "14:4", "E": "18:4"}
+
+	var found int
+	for i, err := range errs {
+		header := err.Error()
+		start, ok := strings.CutPrefix(header, "Android.bp:")
+		if !ok || !strings.Contains(start, "encountered dependency cycle: ") {
+			continue
+		}
+		for _, c := range cycles {
+			for r := range c.modules {
+				modules := append(slices.Clone(c.modules[r:]), c.modules[:r]...)
+				tags := append(slices.Clone(c.tags[r:]), c.tags[:r]...)
+				var names []string
+				for _, m := range append(modules, modules[0]) {
+					names = append(names, strconv.Quote(m))
+				}
+				if header != fmt.Sprintf("Android.bp:%s: encountered dependency cycle: %s",
+					pos[modules[0]], strings.Join(names, " -> ")) {
+					continue
+				}
+
+				found++
+				var want []string
+				for j, m := range modules {
+					next := modules[(j+1)%len(modules)]
+					want = append(want, fmt.Sprintf("Android.bp:%s:     module %q depends on module %q with tag %s",
+						pos[m], m, next, tags[j]))
+				}
+				var got []string
+				for _, e := range errs[i+1 : min(i+1+len(want), len(errs))] {
+					got = append(got, e.Error())
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("incorrect cycle edges\nwant: %q\n got: %q", want, got)
+				}
+			}
+		}
+	}
+	if found != len(cycles) {
+		t.Errorf("expected %d cycles, found %d in errors:\n%q", len(cycles), found, errs)
+	}
+}
+
+func TestAllowDependencyCycles(t *testing.T) {
+	static := verifyAcyclicTestTag{name: "static"}
+	data := verifyAcyclicTestTag{name: "data"}
+
+	type dep struct {
+		tag verifyAcyclicTestTag
+		to  string
+	}
+
+	run := func(t *testing.T, deps map[string][]dep) (*Context, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.AllowDependencyCycles([]DependencyTag{data})
+		ctx.RegisterModuleType("test", newVerifyAcyclicTestModule)
+		ctx.RegisterBottomUpMutator("deps", func(ctx BottomUpMutatorContext) {
+			for _, d := range deps[ctx.ModuleName()] {
+				ctx.AddDependency(ctx.Module(), d.tag, d.to)
+			}
+		})
+		// A second mutator visits the modules again after the allowed cycle was found.
+		ctx.RegisterBottomUpMutator("visit", func(ctx BottomUpMutatorContext) {})
+
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				test {
+				    name: "A",
+				}
+
+				test {
+				    name: "B",
+				}
+
+				test {
+				    name: "C",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx, errs
+	}
+
+	t.Run("allowed", func(t *testing.T) {
+		ctx, errs := run(t, map[string][]dep{
+			"A": {{data, "B"}, {static, "C"}},
+			"B": {{data, "A"}, {static, "C"}},
+		})
+		expectedErrors(t, errs)
+		expectedWarnings(t, ctx.Warnings(),
+			`Android.bp:2:5: warning: encountered dependency cycle with allowed tags: "A" -> "B" -> "A" [dependency-cycle]`)
+
+		// The dependencies that form the cycle are still visible.
+		a := ctx.moduleGroupFromName("A", nil).modules.firstModule()
+		b := ctx.moduleGroupFromName("B", nil).modules.firstModule()
+		if len(a.directDeps) != 2 || a.directDeps[0].module != b {
+			t.Errorf("expected A to depend on B and C, got %v", a.directDeps)
+		}
+		if len(b.directDeps) != 2 || b.directDeps[0].module != a {
+			t.Errorf("expected B to depend on A and C, got %v", b.directDeps)
+		}
+	})
+
+	t.Run("mixed", func(t *testing.T) {
+		ctx, errs := run(t, map[string][]dep{
+			"A": {{data, "B"}},
+			"B": {{static, "A"}},
+		})
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), "encountered dependency cycle: ") {
+			t.Errorf("expected a dependency cycle error, got %q", errs)
+		}
+		expectedWarnings(t, ctx.Warnings())
+	})
+
+	t.Run("mixed through an allowed cycle", func(t *testing.T) {
+		// A and B form an allowed cycle, but A -> C -> B -> A is a cycle that isn't allowed.
+		ctx, errs := run(t, map[string][]dep{
+			"A": {{data, "B"}, {static, "C"}},
+			"B": {{data, "A"}},
+			"C": {{static, "B"}},
+		})
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), "encountered dependency cycle: ") {
+			t.Fatalf("expected a dependency cycle error, got %q", errs)
+		}
+		var edges []string
+		for _, err := range errs[1:] {
+			edges = append(edges, strings.TrimSpace(strings.SplitN(err.Error(), ": ", 2)[1]))
+		}
+		slices.Sort(edges)
+		want := []string{
+			`module "A" depends on module "C" with tag static`,
+			`module "B" depends on module "A" with tag data`,
+			`module "C" depends on module "B" with tag static`,
+		}
+		if !slices.Equal(edges, want) {
+			t.Errorf("incorrect cycle\nwant: %q\n got: %q", want, edges)
+		}
+		expectedWarnings(t, ctx.Warnings())
+	})
+}
+
+func TestMutatorMustRunAfter(t *testing.T) {
+	run := func(t *testing.T, register func(ctx *Context, mutator func(name string) BottomUpMutator)) ([]string, []error) {
+		t.Helper()
+		var order []string
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		register(ctx, func(name string) BottomUpMutator {
+			return func(BottomUpMutatorContext) {
+				order = append(order, name)
+			}
+		})
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				foo_module {
+				    name: "A",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		_, errs = ctx.ResolveDependencies(nil)
+		return order, errs
+	}
+
+	t.Run("diamond", func(t *testing.T) {
+		order, errs := run(t, func(ctx *Context, mutator func(string) BottomUpMutator) {
+			ctx.RegisterBottomUpMutator("unordered", mutator("unordered"))
+			ctx.RegisterBottomUpMutator("d", mutator("d")).MustRunAfter("b").MustRunAfter("c")
+			ctx.RegisterBottomUpMutator("c", mutator("c")).MustRunAfter("a")
+			ctx.RegisterBottomUpMutator("b", mutator("b")).MustRunAfter("a")
+			ctx.RegisterBottomUpMutator("a", mutator("a"))
+		})
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if g, w := order, []string{"unordered", "a", "c", "b", "d"}; !reflect.DeepEqual(g, w) {
+			t.Errorf("incorrect mutator order:\nwant: %q\n got: %q", w, g)
+		}
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		_, errs := run(t, func(ctx *Context, mutator func(string) BottomUpMutator) {
+			ctx.RegisterBottomUpMutator("first", mutator("first"))
+			ctx.RegisterBottomUpMutator("a", mutator("a")).MustRunAfter("b")
+			ctx.RegisterBottomUpMutator("b", mutator("b")).MustRunAfter("c")
+			ctx.RegisterBottomUpMutator("c", mutator("c")).MustRunAfter("a")
+		})
+		expectedErrors(t, errs, `mutator ordering cycle: "a" must run after "b" must run after "c" must run after "a"`)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, errs := run(t, func(ctx *Context, mutator func(string) BottomUpMutator) {
+			ctx.RegisterBottomUpMutator("a", mutator("a")).MustRunAfter("missing")
+		})
+		expectedErrors(t, errs, `mutator "a" must run after unknown mutator "missing"`)
+	})
+}
+
+// topDownDepthTestModule records the depth that parent modules propagated to it by the time the
+// top down mutator visited it.
+type topDownDepthTestModule struct {
+	fooModule
+
+	lock         sync.Mutex
+	depth        int
+	visitedDepth int
+	visited      bool
+}
+
+func newTopDownDepthTestModule() (Module, []interface{}) {
+	m := &topDownDepthTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+// topDownDepthMutator pushes the depth of each module onto its dependencies, so a dependency sees
+// its final depth only if all of its parents ran before it.
+func topDownDepthMutator(ctx TopDownMutatorContext) {
+	m := ctx.Module().(*topDownDepthTestModule)
+	m.lock.Lock()
+	depth := m.depth
+	m.visitedDepth = depth
+	m.visited = true
+	m.lock.Unlock()
+
+	ctx.VisitDirectDeps(func(dep Module) {
+		d := dep.(*topDownDepthTestModule)
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		if d.visited {
+			panic(fmt.Errorf("%s visited before its parent %s", ctx.OtherModuleName(dep), ctx.ModuleName()))
+		}
+		d.depth = max(d.depth, depth+1)
+	})
+}
+
+// topDownDepthTestBlueprint returns a Blueprints file with n modules where module i depends on
+// modules i+1 and i+2, and also on module 2*i+1 to create wide sibling subtrees.
+func topDownDepthTestBlueprint(n int) []byte {
+	buf := &bytes.Buffer{}
+	for i := 0; i < n; i++ {
+		var deps []string
+		for _, dep := range []int{i + 1, i + 2, 2*i + 1} {
+			if dep < n && !slices.Contains(deps, strconv.Quote(fmt.Sprintf("M%d", dep))) {
+				deps = append(deps, strconv.Quote(fmt.Sprintf("M%d", dep)))
+			}
+		}
+		fmt.Fprintf(buf, "depth_module {\n    name: \"M%d\",\n    deps: [%s],\n}\n\n", i, strings.Join(deps, ", "))
+	}
+	return buf.Bytes()
+}
+
+func prepareTopDownDepthTestContext(t testing.TB, n int) *Context {
+	t.Helper()
+	ctx := NewContext()
+	ctx.RegisterModuleType("depth_module", newTopDownDepthTestModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterTopDownMutator("depth", topDownDepthMutator).Parallel()
+	// Keep the module structs that recorded the visited depths.
+	ctx.SkipCloneModulesAfterMutators = true
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": topDownDepthTestBlueprint(n),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	return ctx
+}
+
+func TestParallelTopDownMutator(t *testing.T) {
+	const n = 200
+	ctx := prepareTopDownDepthTestContext(t, n)
+	_, errs := ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// Compute the expected depth of each module, which is the longest path from M0.
+	expected := make([]int, n)
+	for i := 0; i < n; i++ {
+		for _, dep := range []int{i + 1, i + 2, 2*i + 1} {
+			if dep < n {
+				expected[dep] = max(expected[dep], expected[i]+1)
+			}
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		m := ctx.moduleGroupFromName(fmt.Sprintf("M%d", i), nil).moduleByVariantName("").logicModule.(*topDownDepthTestModule)
+		if m.visitedDepth != expected[i] {
+			t.Errorf("expected M%d to see depth %d from its parents, got %d", i, expected[i], m.visitedDepth)
+		}
+	}
+}
+
+func BenchmarkParallelTopDownMutator(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		ctx := prepareTopDownDepthTestContext(b, 5000)
+		b.StartTimer()
+		if _, errs := ctx.ResolveDependencies(nil); len(errs) > 0 {
+			b.Fatalf("unexpected errors: %v", errs)
+		}
+	}
+}
+
+type ninjaSplitTestModule struct {
+	SimpleName
+	properties struct {
+		Srcs []string
+	}
+}
+
+func newNinjaSplitTestModule() (Module, []interface{}) {
+	m := &ninjaSplitTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *ninjaSplitTestModule) GenerateBuildActions(ctx ModuleContext) {
+	if len(m.properties.Srcs) == 0 {
+		return
+	}
+	link := ctx.Rule(ninjaDefsTestPctx, "link", RuleParams{
+		Command: "${cc} $in -o $out",
+	})
+	obj := ctx.ModuleName() + ".o"
+	ctx.Build(ninjaDefsTestPctx, BuildParams{
+		Rule:    ninjaDefsTestCcRule,
+		Outputs: []string{obj},
+		Inputs:  m.properties.Srcs,
+	})
+	ctx.Build(ninjaDefsTestPctx, BuildParams{
+		Rule:    link,
+		Outputs: []string{ctx.ModuleName()},
+		Inputs:  []string{obj},
+	})
+}
+
+// ninjaStatements returns the sorted statements of a ninja file with their indented variable
+// assignments, ignoring comments, blank lines and subninja statements.
+func ninjaStatements(ninja string) []string {
+	var statements []string
+	for _, line := range strings.Split(ninja, "\n") {
+		switch {
+		case strings.TrimSpace(line) == "", strings.HasPrefix(line, "#"),
+			strings.HasPrefix(line, "subninja "):
+		case strings.HasPrefix(line, " ") && len(statements) > 0:
+			statements[len(statements)-1] += "\n" + line
+		default:
+			statements = append(statements, line)
+		}
+	}
+	sort.Strings(statements)
+	return statements
+}
+
+func TestNinjaOutputPerPackage(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newNinjaSplitTestModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp":     []byte(`test { name: "root", srcs: ["root.c"] }`),
+		"a/Android.bp":   []byte(`test { name: "a1", srcs: ["a1.c"] } test { name: "a2", srcs: ["a2.c"] }`),
+		"a/b/Android.bp": []byte(`test { name: "b", srcs: ["b.c"] }`),
+		"c/Android.bp":   []byte(`test { name: "c", srcs: ["c.c"] }`),
+		"d/Android.bp":   []byte(`test { name: "d" }`),
+	})
+
+	files := []string{"Android.bp", "a/Android.bp", "a/b/Android.bp", "c/Android.bp", "d/Android.bp"}
+	if _, errs := ctx.ParseFileList(".", files, nil); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	if _, errs := ctx.ResolveDependencies(nil); len(errs) > 0 {
+		t.Fatalf("unexpected dependency errors: %v", errs)
+	}
+	if _, errs := ctx.PrepareBuildActions(nil); len(errs) > 0 {
+		t.Fatalf("unexpected build action errors: %v", errs)
+	}
+
+	monolithic := &strings.Builder{}
+	if err := ctx.WriteBuildFile(monolithic, false, "build.ninja"); err != nil {
+		t.Fatal(err)
+	}
+
+	writeSplit := func() (string, map[string]string) {
+		t.Helper()
+		srcDir := t.TempDir()
+		ctx.SetSrcDir(srcDir)
+		ctx.SetNinjaOutputSplitting(NinjaOutputPerPackage)
+		defer ctx.SetNinjaOutputSplitting(NinjaOutputMonolithic)
+
+		top := &strings.Builder{}
+		if err := ctx.WriteBuildFile(top, false, "build.ninja"); err != nil {
+			t.Fatal(err)
+		}
+
+		packages := make(map[string]string)
+		for _, line := range strings.Split(top.String(), "\n") {
+			if file, ok := strings.CutPrefix(line, "subninja "); ok {
+				contents, err := os.ReadFile(filepath.Join(srcDir, file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				packages[file] = string(contents)
+			}
+		}
+		return top.String(), packages
+	}
+
+	top, packages := writeSplit()
+
+	var subninjas []string
+	for _, line := range strings.Split(top, "\n") {
+		if strings.HasPrefix(line, "subninja ") {
+			subninjas = append(subninjas, line)
+		}
+	}
+	wantSubninjas := []string{
+		"subninja build.ninja.pkgs/package.ninja",
+		"subninja build.ninja.pkgs/a/package.ninja",
+		"subninja build.ninja.pkgs/a/b/package.ninja",
+		"subninja build.ninja.pkgs/c/package.ninja",
+	}
+	if !slices.Equal(subninjas, wantSubninjas) {
+		t.Errorf("incorrect subninja statements:\nwant: %q\n got: %q", wantSubninjas, subninjas)
+	}
+
+	if !strings.Contains(top, "rule g.ninja_defs_test.cc\n") {
+		t.Errorf("expected shared rule in the top-level file, got:\n%s", top)
+	}
+	if !strings.Contains(packages["build.ninja.pkgs/a/package.ninja"], "build a1.o:") ||
+		!strings.Contains(packages["build.ninja.pkgs/a/package.ninja"], "build a2.o:") {
+		t.Errorf("expected the modules of a in its package file, got:\n%s",
+			packages["build.ninja.pkgs/a/package.ninja"])
+	}
+
+	combined := top
+	for _, contents := range packages {
+		combined += contents
+	}
+	if want, got := ninjaStatements(monolithic.String()), ninjaStatements(combined); !slices.Equal(want, got) {
+		t.Errorf("split files don't match the monolithic file:\nwant:\n%s\n\ngot:\n%s",
+			strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	top2, packages2 := writeSplit()
+	if top != top2 || !reflect.DeepEqual(packages, packages2) {
+		t.Errorf("split output is not deterministic")
+	}
+
+	// The package files are named after the ninja file, so streaming output can't be split.
+	ctx.SetSrcDir(t.TempDir())
+	ctx.SetNinjaOutputSplitting(NinjaOutputPerPackage)
+	err := ctx.WriteBuildFileStreaming(&strings.Builder{})
+	if want := "per-package ninja output requires a ninja file name"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got %v", want, err)
+	}
+}
+
+type moduleTypeDocsTestModule struct {
+	SimpleName
+	properties struct {
+		Srcs    []string `blueprint:"required,doc:The source files."`
+		Stl     *string  `blueprint:"enum:none,libc++"`
+		Enabled *bool
+		Target  struct {
+			Host struct {
+				Cflags []string
+			}
+		}
+		Variant string `blueprint:"mutated"`
+	}
+}
+
+func newModuleTypeDocsTestModule() (Module, []interface{}) {
+	m := &moduleTypeDocsTestModule{}
+	m.properties.Enabled = proptools.BoolPtr(true)
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *moduleTypeDocsTestModule) GenerateBuildActions(ModuleContext) {}
+
+func TestModuleTypeDocs(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("docs_module", newModuleTypeDocsTestModule)
+
+	// NewContext also registers the "defaults" module type.
+	docs := ctx.ModuleTypeDocs()
+	if len(docs) != 3 || docs[0].Name != "defaults" || docs[1].Name != "docs_module" || docs[2].Name != "foo_module" {
+		t.Fatalf("expected docs for defaults, docs_module and foo_module, got %v", docs)
+	}
+
+	want := []proptools.PropertyDoc{
+		{Name: "srcs", Type: "[]string", Doc: "The source files.", Required: true},
+		{Name: "stl", Type: "*string", Enum: []string{"none", "libc++"}},
+		{Name: "enabled", Type: "*bool", Default: "true"},
+		{Name: "target.host.cflags", Type: "[]string"},
+		{Name: "name", Type: "string"},
+	}
+	if !reflect.DeepEqual(docs[1].Properties, want) {
+		t.Errorf("incorrect properties\nwant: %+v\n got: %+v", want, docs[1].Properties)
+	}
+
+	var names []string
+	for _, p := range docs[2].Properties {
+		names = append(names, p.Name+" "+p.Type)
+	}
+	if want := []string{"deps []string", "ignored_deps []string", "foo string", "name string"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("incorrect foo_module properties\nwant: %q\n got: %q", want, names)
+	}
+}
+
+func TestModuleStableID(t *testing.T) {
+	parse := func(t *testing.T) (*Context, map[string]Module) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newVerifyAcyclicTestModule)
+		ctx.RegisterBottomUpMutator("variants", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "A" {
+				ctx.CreateVariations("host", "device")
+			}
+		})
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				test {
+				    name: "A",
+				}
+
+				test {
+				    name: "B",
+				}
+			`),
+			"sub/Android.bp": []byte(`
+				test {
+				    name: "C",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseFileList(".", []string{"Android.bp", "sub/Android.bp"}, nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		_, errs = ctx.ResolveDependencies(nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected dep errors: %v", errs)
+		}
+
+		modules := make(map[string]Module)
+		ctx.VisitAllModules(func(m Module) {
+			modules[ctx.ModuleName(m)+"{"+ctx.ModuleSubDir(m)+"}"] = m
+		})
+		return ctx, modules
+	}
+
+	ctx1, modules1 := parse(t)
+	ctx2, modules2 := parse(t)
+
+	if len(modules1) != 4 {
+		t.Fatalf("expected 4 modules, got %v", modules1)
+	}
+
+	seen := make(map[string]string)
+	for key, m1 := range modules1 {
+		id := ctx1.ModuleStableID(m1)
+		if other, ok := seen[id]; ok {
+			t.Errorf("modules %s and %s have the same ID %q", key, other, id)
+		}
+		seen[id] = key
+
+		if id2 := ctx2.ModuleStableID(modules2[key]); id2 != id {
+			t.Errorf("expected the same ID for %s in both parses, got %q and %q", key, id, id2)
+		}
+	}
+}
+
+func TestGeneratorDependencies(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("io_fs_test", newIOFsTestModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			build = ["build.bp"]
+			io_fs_test {
+				name: "foo",
+				srcs: ["src/*.c"],
+			}
+		`),
+		"build.bp": []byte(`
+			io_fs_test {
+				name: "bar",
+			}
+		`),
+		"src/a.c": nil,
+		"src/b.h": nil,
+	})
+
+	if _, errs := ctx.ParseBlueprintsFiles("Android.bp", nil); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	want := []string{MockModuleListFile, "Android.bp", "build.bp"}
+	slices.Sort(want)
+	if got := ctx.GeneratorDependencies(); !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect dependencies after parsing\nwant: %q\n got: %q", want, got)
+	}
+
+	if _, errs := ctx.ResolveDependencies(nil); len(errs) > 0 {
+		t.Fatalf("unexpected dependency errors: %v", errs)
+	}
+	if _, errs := ctx.PrepareBuildActions(nil); len(errs) > 0 {
+		t.Fatalf("unexpected build action errors: %v", errs)
+	}
+
+	want = append(want, "src")
+	slices.Sort(want)
+	if got := ctx.GeneratorDependencies(); !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect dependencies after preparing build actions\nwant: %q\n got: %q", want, got)
+	}
+}
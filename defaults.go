@@ -0,0 +1,246 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/google/blueprint/parser"
+	"github.com/google/blueprint/proptools"
+)
+
+// A DefaultableModule is a Module that can inherit properties from defaults modules.  Module
+// types usually implement it by embedding SimpleDefaultable and returning its Properties from the
+// factory, which adds a `defaults` property listing the names of defaults modules.
+//
+// A defaults module is a module of the built-in `defaults` module type, registered by NewContext.
+// It accepts any property, and its own `defaults` property may list other defaults modules.
+// The blueprint_defaults mutator, which runs before every other mutator, merges the properties
+// of the listed defaults modules into the property structs of each DefaultableModule.  The
+// defaults are applied as if their properties came before the properties of the module in the
+// order they are listed, with the defaults of a defaults module coming before its own properties:
+// lists are concatenated, and scalar properties set by the module or by a later defaults module
+// take precedence.  Properties tagged `blueprint:"overwrite"` are instead replaced by the value
+// from the last defaults module that sets them, even if the module sets them, see
+// proptools.FieldOrder.  Properties of a defaults module that don't exist in a module are ignored,
+// and reported as a warning in the DiagnosticCodeIgnoredProperty category.  Properties tagged
+// `blueprint:"required"` may be set by the defaults, they are checked after the defaults are
+// applied.
+type DefaultableModule interface {
+	Module
+
+	// Defaults returns the names of the defaults modules to apply to the module.
+	Defaults() []string
+}
+
+// SimpleDefaultable is an embeddable implementation of DefaultableModule that adds a `defaults`
+// property.
+type SimpleDefaultable struct {
+	Properties struct {
+		Defaults []string
+	}
+}
+
+func (d *SimpleDefaultable) Defaults() []string {
+	return d.Properties.Defaults
+}
+
+// defaultsModule is the module type of the built-in `defaults` module type.  Its name and
+// defaults properties are unpacked as usual, the others are kept unevaluated so that they can be
+// unpacked into the property structs of any module that uses the defaults.
+type defaultsModule struct {
+	SimpleName
+	SimpleDefaultable
+
+	properties []*parser.Property
+}
+
+func newDefaultsModule() (Module, []interface{}) {
+	m := &defaultsModule{}
+	return m, []interface{}{&m.SimpleName.Properties, &m.SimpleDefaultable.Properties}
+}
+
+func (m *defaultsModule) GenerateBuildActions(ModuleContext) {}
+
+// takeProperties keeps the properties that are applied to the modules that use the defaults, and
+// returns the remaining properties of the defaults module itself.
+func (m *defaultsModule) takeProperties(properties []*parser.Property) []*parser.Property {
+	var own []*parser.Property
+	for _, property := range properties {
+		if property.Name == "name" || property.Name == "defaults" {
+			own = append(own, property)
+		} else {
+			m.properties = append(m.properties, property)
+		}
+	}
+	return own
+}
+
+// blueprintDefaultsMutator applies the defaults modules listed by each DefaultableModule to its
+// properties.  Only the unevaluated properties of the defaults modules are read, so it can run in
+// parallel.
+func blueprintDefaultsMutator(ctx BottomUpMutatorContext) {
+	m, ok := ctx.Module().(DefaultableModule)
+	if !ok || len(m.Defaults()) == 0 {
+		return
+	}
+
+	chain, ok := defaultsChain(ctx, m)
+	if !ok {
+		return
+	}
+	if _, isDefaults := m.(*defaultsModule); isDefaults {
+		return
+	}
+
+	module := ctx.moduleInfo()
+	merged := make([]interface{}, len(module.properties))
+	for i, props := range module.properties {
+		merged[i] = proptools.CloneEmptyProperties(reflect.ValueOf(props)).Interface()
+	}
+
+	for _, defaults := range chain {
+		defaultsProps := make([]interface{}, len(module.properties))
+		for i, props := range module.properties {
+			defaultsProps[i] = proptools.CloneEmptyProperties(reflect.ValueOf(props)).Interface()
+		}
+		_, warnings, errs := proptools.UnpackPropertiesWithWarnings(defaults.properties, defaultsProps...)
+		for _, warning := range warnings {
+			ctx.base().context.warn(Warning{
+				Pos:      warning.Pos,
+				Message:  fmt.Sprintf("in defaults %q: %s", defaults.Name(), warning.Err),
+				Category: DiagnosticCodeDeprecatedProperty,
+			})
+		}
+		for _, err := range errs {
+			if unpackErr, ok := err.(*proptools.UnpackError); ok {
+				if errors.Is(unpackErr.Err, proptools.ErrMissingRequiredProperty) {
+					// Defaults don't have to set required properties.
+					continue
+				}
+				if errors.Is(unpackErr.Err, proptools.ErrUnrecognizedProperty) {
+					ctx.base().context.warn(Warning{
+						Pos: unpackErr.Pos,
+						Message: fmt.Sprintf("in defaults %q: %s is ignored by module type %q",
+							defaults.Name(), unpackErr.Err, ctx.ModuleType()),
+						Category: DiagnosticCodeIgnoredProperty,
+					})
+					continue
+				}
+				err = &BlueprintError{
+					Err: fmt.Errorf("in defaults %q for module %q: %w", defaults.Name(), ctx.ModuleName(), unpackErr.Err),
+					Pos: unpackErr.Pos,
+				}
+			}
+			ctx.error(err)
+		}
+		if ctx.Failed() {
+			return
+		}
+		for i := range merged {
+			if err := proptools.AppendProperties(merged[i], defaultsProps[i], nil); err != nil {
+				ctx.ModuleErrorf("failed to apply defaults %q: %s", defaults.Name(), err)
+				return
+			}
+		}
+	}
+
+	for i, props := range module.properties {
+		if err := proptools.PrependProperties(props, merged[i], nil); err != nil {
+			ctx.ModuleErrorf("failed to apply defaults: %s", err)
+			return
+		}
+	}
+
+	for _, name := range proptools.MissingRequiredProperties(module.properties...) {
+		if _, set := module.propertyPos[name]; set {
+			continue
+		}
+		ctx.error(&BlueprintError{
+			Err: withDiagnosticCode(fmt.Errorf("%w %q", proptools.ErrMissingRequiredProperty, name),
+				DiagnosticCodeProperty),
+			Pos: module.pos,
+		})
+	}
+}
+
+// defaultsChain returns the defaults modules to apply to a module in the order they should be
+// applied, with each defaults module listed once.  Defaults that don't exist or are not defaults
+// modules are reported when they are listed directly by the module, and dependency cycles are
+// reported by the defaults modules that are part of it.  It returns false if the chain could not
+// be resolved.
+func defaultsChain(ctx BottomUpMutatorContext, root DefaultableModule) ([]*defaultsModule, bool) {
+	nameInterface := ctx.base().context.nameInterface
+	namespace := ctx.moduleInfo().namespace()
+	var chain []*defaultsModule
+	seen := make(map[*defaultsModule]bool)
+	var stack []*defaultsModule
+	ok := true
+
+	var walk func(names []string, direct bool)
+	walk = func(names []string, direct bool) {
+		for _, name := range names {
+			group, exists := nameInterface.ModuleFromName(name, namespace)
+			if !exists {
+				if direct {
+					ctx.PropertyErrorf("defaults", "defaults module %q does not exist", name)
+				}
+				ok = false
+				continue
+			}
+			defaults, isDefaults := group.moduleGroup.modules.firstModule().logicModule.(*defaultsModule)
+			if !isDefaults {
+				if direct {
+					ctx.PropertyErrorf("defaults", "module %q is not a defaults module", name)
+				}
+				ok = false
+				continue
+			}
+
+			if Module(defaults) == root {
+				names := []string{fmt.Sprintf("%q", root.Name())}
+				for _, d := range stack {
+					names = append(names, fmt.Sprintf("%q", d.Name()))
+				}
+				names = append(names, fmt.Sprintf("%q", root.Name()))
+				ctx.PropertyErrorf("defaults", "defaults cycle: %s", strings.Join(names, " -> "))
+				ok = false
+				continue
+			}
+			if seen[defaults] {
+				continue
+			}
+			if slices.Contains(stack, defaults) {
+				// A cycle that doesn't go through root, reported by the defaults modules in it.
+				ok = false
+				continue
+			}
+
+			stack = append(stack, defaults)
+			walk(defaults.Defaults(), false)
+			stack = stack[:len(stack)-1]
+
+			seen[defaults] = true
+			chain = append(chain, defaults)
+		}
+	}
+	walk(root.Defaults(), true)
+
+	return chain, ok
+}
@@ -0,0 +1,366 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"maps"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+)
+
+type defaultsTestModule struct {
+	SimpleName
+	SimpleDefaultable
+	SimpleEnabled
+	properties struct {
+		Srcs    []string
+		Cflags  []string `blueprint:"deprecated_name:c_flags"`
+		Ldflags []string `blueprint:"overwrite"`
+		Stem    *string
+		Labels  map[string]string
+	}
+}
+
+func newDefaultsTestModule() (Module, []interface{}) {
+	m := &defaultsTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleDefaultable.Properties,
+		&m.SimpleEnabled.Properties}
+}
+
+func (m *defaultsTestModule) GenerateBuildActions(ModuleContext) {}
+
+func runDefaultsTest(t *testing.T, bp string) (*Context, []error) {
+	t.Helper()
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newDefaultsTestModule)
+	ctx.MockFileSystem(map[string][]byte{"Android.bp": []byte(bp)})
+
+	if _, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	// The blueprint_defaults mutator runs in parallel, so sort the errors to make them deterministic.
+	_, errs := ctx.ResolveDependencies(nil)
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return ctx, errs
+}
+
+func defaultsTestModuleByName(ctx *Context, name string) *defaultsTestModule {
+	return ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule.(*defaultsTestModule)
+}
+
+func TestDefaults(t *testing.T) {
+	t.Run("single", func(t *testing.T) {
+		ctx, errs := runDefaultsTest(t, `
+			defaults {
+				name: "d",
+				srcs: ["d.c"],
+				stem: "d",
+				unused: true,
+			}
+
+			test {
+				name: "a",
+				defaults: ["d"],
+				srcs: ["a.c"],
+			}
+
+			test {
+				name: "b",
+				defaults: ["d"],
+				stem: "b",
+			}
+		`)
+		expectedErrors(t, errs)
+
+		expectedWarnings(t, ctx.Warnings(),
+			`Android.bp:6:11: warning: in defaults "d": unrecognized property "unused" is ignored by module type "test" [ignored-property]`)
+
+		a := defaultsTestModuleByName(ctx, "a")
+		if want := []string{"d.c", "a.c"}; !slices.Equal(a.properties.Srcs, want) {
+			t.Errorf("expected a srcs %q, got %q", want, a.properties.Srcs)
+		}
+		if got := proptools.String(a.properties.Stem); got != "d" {
+			t.Errorf("expected a stem %q, got %q", "d", got)
+		}
+
+		b := defaultsTestModuleByName(ctx, "b")
+		if want := []string{"d.c"}; !slices.Equal(b.properties.Srcs, want) {
+			t.Errorf("expected b srcs %q, got %q", want, b.properties.Srcs)
+		}
+		if got := proptools.String(b.properties.Stem); got != "b" {
+			t.Errorf("expected b stem %q, got %q", "b", got)
+		}
+	})
+
+	t.Run("chained", func(t *testing.T) {
+		ctx, errs := runDefaultsTest(t, `
+			defaults {
+				name: "base",
+				cflags: ["-base"],
+				stem: "base",
+			}
+
+			defaults {
+				name: "d1",
+				defaults: ["base"],
+				cflags: ["-d1"],
+				stem: "d1",
+			}
+
+			defaults {
+				name: "d2",
+				defaults: ["base"],
+				cflags: ["-d2"],
+				enabled: false,
+			}
+
+			test {
+				name: "a",
+				defaults: ["d1", "d2"],
+				cflags: ["-a"],
+			}
+		`)
+		expectedErrors(t, errs)
+
+		a := defaultsTestModuleByName(ctx, "a")
+		if want := []string{"-base", "-d1", "-d2", "-a"}; !slices.Equal(a.properties.Cflags, want) {
+			t.Errorf("expected cflags %q, got %q", want, a.properties.Cflags)
+		}
+		if got := proptools.String(a.properties.Stem); got != "d1" {
+			t.Errorf("expected stem %q, got %q", "d1", got)
+		}
+		if a.Enabled() {
+			t.Errorf("expected a to be disabled by d2")
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		ctx, errs := runDefaultsTest(t, `
+			defaults {
+				name: "d1",
+				ldflags: ["-d1"],
+			}
+
+			defaults {
+				name: "d2",
+				ldflags: ["-d2"],
+			}
+
+			test {
+				name: "a",
+				defaults: ["d1", "d2"],
+				ldflags: ["-a"],
+			}
+		`)
+		expectedErrors(t, errs)
+
+		a := defaultsTestModuleByName(ctx, "a")
+		if want := []string{"-d2"}; !slices.Equal(a.properties.Ldflags, want) {
+			t.Errorf("expected ldflags %q, got %q", want, a.properties.Ldflags)
+		}
+	})
+
+	t.Run("map", func(t *testing.T) {
+		ctx, errs := runDefaultsTest(t, `
+			defaults {
+				name: "d1",
+				labels: {
+					a: "d1",
+					b: "d1",
+				},
+			}
+
+			defaults {
+				name: "d2",
+				labels: {
+					b: "d2",
+					c: "d2",
+				},
+			}
+
+			test {
+				name: "a",
+				defaults: ["d1", "d2"],
+				labels: {
+					c: "a",
+				},
+			}
+		`)
+		expectedErrors(t, errs)
+
+		a := defaultsTestModuleByName(ctx, "a")
+		want := map[string]string{"a": "d1", "b": "d2", "c": "a"}
+		if !maps.Equal(a.properties.Labels, want) {
+			t.Errorf("expected labels %q, got %q", want, a.properties.Labels)
+		}
+	})
+
+	t.Run("deprecated name", func(t *testing.T) {
+		ctx, errs := runDefaultsTest(t, `
+			defaults {
+				name: "d",
+				c_flags: ["-d"],
+			}
+
+			test {
+				name: "a",
+				defaults: ["d"],
+			}
+
+			test {
+				name: "b",
+				defaults: ["d"],
+			}
+		`)
+		expectedErrors(t, errs)
+
+		a := defaultsTestModuleByName(ctx, "a")
+		if want := []string{"-d"}; !slices.Equal(a.properties.Cflags, want) {
+			t.Errorf("expected cflags %q, got %q", want, a.properties.Cflags)
+		}
+		expectedWarnings(t, ctx.Warnings(),
+			`Android.bp:4:12: warning: in defaults "d": property "c_flags" is deprecated, use "cflags" instead [deprecated-property]`)
+	})
+
+	t.Run("type mismatch", func(t *testing.T) {
+		_, errs := runDefaultsTest(t, `
+			defaults {
+				name: "d",
+				srcs: "d.c",
+			}
+
+			test {
+				name: "a",
+				defaults: ["d"],
+			}
+		`)
+		expectedErrors(t, errs,
+			`Android.bp:4:11: in defaults "d" for module "a": can't assign string value to list property "srcs"`)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, errs := runDefaultsTest(t, `
+			test {
+				name: "a",
+				defaults: ["missing"],
+			}
+
+			test {
+				name: "b",
+				defaults: ["a"],
+			}
+		`)
+		expectedErrors(t, errs,
+			`Android.bp:4:13: module "a": defaults: defaults module "missing" does not exist`,
+			`Android.bp:9:13: module "b": defaults: module "a" is not a defaults module`)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		_, errs := runDefaultsTest(t, `
+			defaults {
+				name: "d1",
+				defaults: ["d2"],
+			}
+
+			defaults {
+				name: "d2",
+				defaults: ["d1"],
+			}
+
+			test {
+				name: "a",
+				defaults: ["d1"],
+			}
+		`)
+		expectedErrors(t, errs,
+			`Android.bp:4:13: module "d1": defaults: defaults cycle: "d1" -> "d2" -> "d1"`,
+			`Android.bp:9:13: module "d2": defaults: defaults cycle: "d2" -> "d1" -> "d2"`)
+	})
+}
+
+type requiredDefaultsTestModule struct {
+	SimpleName
+	SimpleDefaultable
+	properties struct {
+		Srcs []string `blueprint:"required"`
+	}
+}
+
+func newRequiredDefaultsTestModule() (Module, []interface{}) {
+	m := &requiredDefaultsTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleDefaultable.Properties}
+}
+
+func (m *requiredDefaultsTestModule) GenerateBuildActions(ModuleContext) {}
+
+func TestDefaultsRequiredProperties(t *testing.T) {
+	run := func(t *testing.T, bp string) (*Context, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newRequiredDefaultsTestModule)
+		ctx.MockFileSystem(map[string][]byte{"Android.bp": []byte(bp)})
+		if _, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil); len(errs) > 0 {
+			return ctx, errs
+		}
+		_, errs := ctx.ResolveDependencies(nil)
+		return ctx, errs
+	}
+
+	t.Run("set by defaults", func(t *testing.T) {
+		ctx, errs := run(t, `
+			defaults {
+				name: "d",
+				srcs: ["d.c"],
+			}
+
+			test {
+				name: "a",
+				defaults: ["d"],
+			}
+		`)
+		expectedErrors(t, errs)
+
+		m := ctx.moduleGroupFromName("a", nil).modules.firstModule().logicModule.(*requiredDefaultsTestModule)
+		if want := []string{"d.c"}; !slices.Equal(m.properties.Srcs, want) {
+			t.Errorf("expected srcs %q, got %q", want, m.properties.Srcs)
+		}
+	})
+
+	t.Run("not set by defaults", func(t *testing.T) {
+		_, errs := run(t, `
+			defaults {
+				name: "d",
+			}
+
+			test {
+				name: "a",
+				defaults: ["d"],
+			}
+		`)
+		expectedErrors(t, errs, `Android.bp:6:4: missing required property "srcs"`)
+	})
+
+	t.Run("no defaults", func(t *testing.T) {
+		_, errs := run(t, `
+			test {
+				name: "a",
+			}
+		`)
+		expectedErrors(t, errs, `Android.bp:2:4: missing required property "srcs"`)
+	})
+}
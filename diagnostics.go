@@ -0,0 +1,222 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/scanner"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// DiagnosticSeverity is the severity of a Diagnostic.
+type DiagnosticSeverity int
+
+const (
+	SeverityError DiagnosticSeverity = iota
+	SeverityWarning
+)
+
+func (s DiagnosticSeverity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		panic(fmt.Errorf("unknown diagnostic severity %d", int(s)))
+	}
+}
+
+// Codes that classify the problem described by a Diagnostic.
+const (
+	DiagnosticCodeError             = "error"
+	DiagnosticCodeSyntax            = "syntax"
+	DiagnosticCodeUnknownModuleType = "unknown-module-type"
+	DiagnosticCodeUnknownProperty   = "unknown-property"
+	DiagnosticCodeProperty          = "property"
+
+	// Codes of warnings, which are also used as the Category of a Warning.
+	DiagnosticCodeDeprecatedProperty = "deprecated-property"
+	DiagnosticCodeIgnoredProperty    = "ignored-property"
+	DiagnosticCodeVisibility         = "visibility"
+	DiagnosticCodeDependencyCycle    = "dependency-cycle"
+	DiagnosticCodeEmptyGlob          = "empty-glob"
+)
+
+// A Diagnostic is a machine-readable description of a problem found in a Blueprints file.  Lines
+// and columns are 1-based, and the end position is the position immediately after the range.  A
+// Diagnostic that is not associated with a location in a file has an empty File and zero lines
+// and columns.
+type Diagnostic struct {
+	File    string
+	Line    int
+	Col     int
+	EndLine int
+	EndCol  int
+
+	Severity DiagnosticSeverity
+	Message  string
+	Code     string
+}
+
+func (d Diagnostic) String() string {
+	if d.File == "" {
+		return fmt.Sprintf("%s: %s [%s]", d.Severity, d.Message, d.Code)
+	}
+	return fmt.Sprintf("%s:%d:%d-%d:%d: %s: %s [%s]", d.File, d.Line, d.Col, d.EndLine, d.EndCol,
+		d.Severity, d.Message, d.Code)
+}
+
+// ParseBlueprintsFilesWithDiagnostics is like ParseBlueprintsFiles, but returns the problems it
+// found as Diagnostics instead of errors, followed by the Warnings of the Context.
+func (c *Context) ParseBlueprintsFilesWithDiagnostics(rootFile string,
+	config interface{}) (deps []string, diags []Diagnostic) {
+
+	deps, errs := c.ParseBlueprintsFiles(rootFile, config)
+	diags = c.diagnosticsFromErrors(errs)
+	return deps, append(diags, c.diagnosticsFromWarnings(c.Warnings())...)
+}
+
+// diagnosticCodeError attaches a diagnostic code to an error without changing its message.
+type diagnosticCodeError struct {
+	err  error
+	code string
+}
+
+func withDiagnosticCode(err error, code string) error {
+	return &diagnosticCodeError{err, code}
+}
+
+func (e *diagnosticCodeError) Error() string { return e.err.Error() }
+func (e *diagnosticCodeError) Unwrap() error { return e.err }
+
+func diagnosticCode(err error) string {
+	if errors.Is(err, proptools.ErrUnrecognizedProperty) {
+		return DiagnosticCodeUnknownProperty
+	}
+	var codeErr *diagnosticCodeError
+	if errors.As(err, &codeErr) {
+		return codeErr.code
+	}
+	return DiagnosticCodeError
+}
+
+func (c *Context) diagnosticsFromErrors(errs []error) []Diagnostic {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	sources := make(map[string][]byte)
+	diags := make([]Diagnostic, 0, len(errs))
+	for _, err := range errs {
+		var blueprintErr *BlueprintError
+		switch e := err.(type) {
+		case *BlueprintError:
+			blueprintErr = e
+		case *ModuleError:
+			blueprintErr = &e.BlueprintError
+		case *PropertyError:
+			blueprintErr = &e.BlueprintError
+		}
+
+		if blueprintErr == nil || !blueprintErr.Pos.IsValid() {
+			diags = append(diags, Diagnostic{
+				Severity: SeverityError,
+				Message:  err.Error(),
+				Code:     diagnosticCode(err),
+			})
+			continue
+		}
+
+		pos := blueprintErr.Pos
+		end := c.tokenEnd(sources, pos)
+		diags = append(diags, Diagnostic{
+			File:     pos.Filename,
+			Line:     pos.Line,
+			Col:      pos.Column,
+			EndLine:  end.Line,
+			EndCol:   end.Column,
+			Severity: SeverityError,
+			Message:  blueprintErr.Err.Error(),
+			Code:     diagnosticCode(blueprintErr.Err),
+		})
+	}
+	return diags
+}
+
+func (c *Context) diagnosticsFromWarnings(warnings []Warning) []Diagnostic {
+	if len(warnings) == 0 {
+		return nil
+	}
+
+	sources := make(map[string][]byte)
+	diags := make([]Diagnostic, 0, len(warnings))
+	for _, w := range warnings {
+		diag := Diagnostic{
+			Severity: SeverityWarning,
+			Message:  w.Message,
+			Code:     w.Category,
+		}
+		if w.Pos.IsValid() {
+			end := c.tokenEnd(sources, w.Pos)
+			diag.File, diag.Line, diag.Col = w.Pos.Filename, w.Pos.Line, w.Pos.Column
+			diag.EndLine, diag.EndCol = end.Line, end.Column
+		}
+		diags = append(diags, diag)
+	}
+	return diags
+}
+
+// tokenEnd returns the position immediately after the token that starts at pos, or pos itself
+// if the source file can't be read or there is no token at pos.
+func (c *Context) tokenEnd(sources map[string][]byte, pos scanner.Position) scanner.Position {
+	src, ok := sources[pos.Filename]
+	if !ok {
+		if f, err := c.openFile(pos.Filename); err == nil {
+			src, _ = io.ReadAll(f)
+			f.Close()
+		}
+		sources[pos.Filename] = src
+	}
+	if pos.Offset < 0 || pos.Offset >= len(src) {
+		return pos
+	}
+
+	var s scanner.Scanner
+	s.Init(strings.NewReader(string(src[pos.Offset:])))
+	s.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanStrings |
+		scanner.ScanRawStrings | scanner.ScanComments
+	s.Whitespace = 0
+	s.Error = func(*scanner.Scanner, string) {}
+	if s.Scan() == scanner.EOF {
+		return pos
+	}
+
+	// The scanner counts lines and columns from the start of the token.
+	end := pos
+	scanned := s.Pos()
+	end.Offset += scanned.Offset
+	if scanned.Line == 1 {
+		end.Column += scanned.Column - 1
+	} else {
+		end.Line += scanned.Line - 1
+		end.Column = scanned.Column
+	}
+	return end
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+type renamedPropertyModule struct {
+	SimpleName
+	properties struct {
+		Srcs []string `blueprint:"deprecated_name:files"`
+	}
+}
+
+func newRenamedPropertyModule() (Module, []interface{}) {
+	m := &renamedPropertyModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *renamedPropertyModule) GenerateBuildActions(ModuleContext) {}
+
+func TestParseBlueprintsFilesWithDiagnostics(t *testing.T) {
+	testCases := []struct {
+		name string
+		bp   string
+		want []Diagnostic
+	}{
+		{
+			name: "no errors",
+			bp: `
+foo_module {
+	name: "foo",
+}
+`,
+		},
+		{
+			name: "syntax error",
+			bp: `
+foo_module {
+	name: "foo"
+	deps: ["bar"],
+}
+`,
+			want: []Diagnostic{{
+				File:     "Android.bp",
+				Line:     4,
+				Col:      2,
+				EndLine:  4,
+				EndCol:   6,
+				Severity: SeverityError,
+				Message:  `expected "}", found Ident`,
+				Code:     DiagnosticCodeSyntax,
+			}},
+		},
+		{
+			name: "unknown property",
+			bp: `
+foo_module {
+	name: "foo",
+	srcs: ["foo.c"],
+}
+`,
+			want: []Diagnostic{{
+				File:     "Android.bp",
+				Line:     4,
+				Col:      6,
+				EndLine:  4,
+				EndCol:   7,
+				Severity: SeverityError,
+				Message:  `unrecognized property "srcs"`,
+				Code:     DiagnosticCodeUnknownProperty,
+			}},
+		},
+		{
+			name: "unknown module type",
+			bp: `
+baz_module {
+	name: "baz",
+}
+`,
+			want: []Diagnostic{{
+				File:     "Android.bp",
+				Line:     2,
+				Col:      1,
+				EndLine:  2,
+				EndCol:   11,
+				Severity: SeverityError,
+				Message:  `unrecognized module type "baz_module"`,
+				Code:     DiagnosticCodeUnknownModuleType,
+			}},
+		},
+		{
+			name: "deprecated property",
+			bp: `
+renamed_module {
+	name: "foo",
+	files: ["foo.c"],
+}
+
+renamed_module {
+	name: "bar",
+	srcs: ["bar.c"],
+}
+`,
+			want: []Diagnostic{{
+				File:     "Android.bp",
+				Line:     4,
+				Col:      7,
+				EndLine:  4,
+				EndCol:   8,
+				Severity: SeverityWarning,
+				Message:  `property "files" is deprecated, use "srcs" instead`,
+				Code:     DiagnosticCodeDeprecatedProperty,
+			}},
+		},
+		{
+			name: "deprecated and new property",
+			bp: `
+renamed_module {
+	name: "foo",
+	srcs: ["foo.c"],
+	files: ["foo.c"],
+}
+`,
+			want: []Diagnostic{{
+				File:     "Android.bp",
+				Line:     5,
+				Col:      7,
+				EndLine:  5,
+				EndCol:   8,
+				Severity: SeverityError,
+				Message:  `deprecated property "files" can't be set together with "srcs"`,
+				Code:     DiagnosticCodeProperty,
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := NewContext()
+			ctx.RegisterModuleType("foo_module", newFooModule)
+			ctx.RegisterModuleType("renamed_module", newRenamedPropertyModule)
+			ctx.MockFileSystem(map[string][]byte{
+				"Android.bp": []byte(tc.bp),
+			})
+
+			_, diags := ctx.ParseBlueprintsFilesWithDiagnostics("Android.bp", nil)
+			if !reflect.DeepEqual(diags, tc.want) {
+				t.Errorf("incorrect diagnostics:\nwant: %v\n got: %v", tc.want, diags)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// A BuildActionsSummary describes the build actions that PrepareBuildActions would generate.
+type BuildActionsSummary struct {
+	// BuildDefsByModuleType is the number of build statements generated by the modules of each
+	// module type.
+	BuildDefsByModuleType map[string]int
+
+	// BuildDefsBySingleton is the number of build statements generated by each singleton.
+	BuildDefsBySingleton map[string]int
+
+	// Rules is the number of build statements that use each rule, indexed by the name of the rule
+	// in the ninja file.  Like the ninja file, it includes the phony build statements that merge
+	// the phony targets of the modules and their common order-only dependencies, and counts the
+	// build statements of identical rules under the name of the rule they are merged into.
+	Rules map[string]int
+
+	// Pools is the sorted list of the names of the pools referenced by the rules in Rules.
+	Pools []string
+
+	// Outputs is the total number of outputs and implicit outputs of all build statements,
+	// including the phony build statements.
+	Outputs int
+}
+
+// DryRunBuildActions runs the GenerateBuildActions methods of every module and singleton and
+// returns a summary of the build actions they generated.  It must be called after
+// ResolveDependencies.  The build actions are not kept: any build actions from an earlier call to
+// PrepareBuildActions are restored afterwards, and a later call to PrepareBuildActions starts
+// from the same state as if DryRunBuildActions had not been called, which also discards the
+// warnings reported by the dry run.  The singletons are run on
+// new instances created by their factories, but GenerateBuildActions is run on the same module
+// instances that PrepareBuildActions will use.
+func (c *Context) DryRunBuildActions() (*BuildActionsSummary, error) {
+	if !c.dependenciesReady {
+		return nil, ErrDependenciesNotReady
+	}
+	config := c.liveGlobals.config
+
+	saved := c.saveBuildActions()
+	defer c.restoreBuildActions(saved)
+	c.resetBuildActions(config)
+	c.actionTrace = nil
+
+	if _, errs := c.generateModuleBuildActions(config, c.liveGlobals); len(errs) > 0 {
+		return nil, proptools.MergeErrors(errs)
+	}
+
+	singletons, errs := c.sortSingletons()
+	if len(errs) > 0 {
+		return nil, proptools.MergeErrors(errs)
+	}
+	if _, errs := c.generateSingletonBuildActions(config, singletons, c.liveGlobals); len(errs) > 0 {
+		return nil, proptools.MergeErrors(errs)
+	}
+	if errs := c.deduplicateBuildDefs(); len(errs) > 0 {
+		return nil, proptools.MergeErrors(errs)
+	}
+
+	// Follow the steps of PrepareBuildActions and WriteBuildFile that change the build
+	// statements and the names of the rules in the ninja file.
+	pkgNames, _ := c.makeUniquePackageNames(c.liveGlobals)
+	c.nameTracker = c.memoizeFullNames(c.liveGlobals, pkgNames)
+	c.globalVariables = c.liveGlobals.variables
+	c.globalPools = c.liveGlobals.pools
+	c.globalRules = c.liveGlobals.rules
+	c.deduplicateGlobalRules()
+
+	modules := make([]*moduleInfo, 0, len(c.moduleInfo))
+	for _, module := range c.moduleInfo {
+		modules = append(modules, module)
+	}
+	sort.Sort(moduleSorter{modules, c.nameInterface})
+	phonys := c.deduplicateOrderOnlyDeps(modules)
+	phonys.buildDefs = append(phonys.buildDefs, mergePhonys(modules)...)
+
+	summary := &BuildActionsSummary{
+		BuildDefsByModuleType: make(map[string]int),
+		BuildDefsBySingleton:  make(map[string]int),
+		Rules:                 make(map[string]int),
+	}
+	pools := make(map[string]bool)
+	addBuildDefs := func(defs []*buildDef) {
+		for _, def := range defs {
+			summary.Rules[c.nameTracker.Rule(def.Rule)]++
+			summary.Outputs += len(def.Outputs) + len(def.OutputStrings) +
+				len(def.ImplicitOutputs) + len(def.ImplicitOutputStrings)
+			if def.RuleDef != nil && def.RuleDef.Pool != nil {
+				pools[c.nameTracker.Pool(def.RuleDef.Pool)] = true
+			}
+		}
+	}
+
+	addBuildDefs(phonys.buildDefs)
+	for _, module := range modules {
+		if n := len(module.actionDefs.buildDefs); n > 0 {
+			summary.BuildDefsByModuleType[module.typeName] += n
+			addBuildDefs(module.actionDefs.buildDefs)
+		}
+	}
+	for _, info := range c.singletonInfo {
+		if n := len(info.actionDefs.buildDefs); n > 0 {
+			summary.BuildDefsBySingleton[info.name] += n
+			addBuildDefs(info.actionDefs.buildDefs)
+		}
+	}
+
+	for pool := range pools {
+		summary.Pools = append(summary.Pools, pool)
+	}
+	sort.Strings(summary.Pools)
+
+	return summary, nil
+}
+
+// savedBuildActions holds the state of the Context that is modified while generating build
+// actions.
+type savedBuildActions struct {
+	buildActionsReady bool
+	liveGlobals       *liveTracker
+	actionTrace       *actionTrace
+
+	nameTracker     *nameTracker
+	globalVariables map[Variable]*ninjaString
+	globalPools     map[Pool]*poolDef
+	globalRules     map[Rule]*ruleDef
+
+	warnings      []Warning
+	generatorDeps []string
+
+	modules    map[*moduleInfo]savedModuleBuildActions
+	singletons []savedSingletonBuildActions
+
+	outDir             *ninjaString
+	requiredNinjaMajor int
+	requiredNinjaMinor int
+	requiredNinjaMicro int
+	subninjas          []string
+}
+
+type savedModuleBuildActions struct {
+	actionDefs                   localBuildActions
+	ninjaFileDeps                []string
+	startedGenerateBuildActions  bool
+	finishedGenerateBuildActions bool
+	providers                    []interface{}
+	providerInitialValueHashes   []uint64
+}
+
+type savedSingletonBuildActions struct {
+	singleton  Singleton
+	actionDefs localBuildActions
+}
+
+func (c *Context) saveBuildActions() *savedBuildActions {
+	saved := &savedBuildActions{
+		buildActionsReady:  c.buildActionsReady,
+		liveGlobals:        c.liveGlobals,
+		actionTrace:        c.actionTrace,
+		nameTracker:        c.nameTracker,
+		globalVariables:    c.globalVariables,
+		globalPools:        c.globalPools,
+		globalRules:        c.globalRules,
+		generatorDeps:      slices.Clone(c.generatorDeps),
+		modules:            make(map[*moduleInfo]savedModuleBuildActions, len(c.moduleInfo)),
+		outDir:             c.outDir,
+		requiredNinjaMajor: c.requiredNinjaMajor,
+		requiredNinjaMinor: c.requiredNinjaMinor,
+		requiredNinjaMicro: c.requiredNinjaMicro,
+		subninjas:          c.subninjas,
+	}
+	c.warningsLock.Lock()
+	saved.warnings = slices.Clone(c.warnings)
+	c.warningsLock.Unlock()
+	for _, module := range c.moduleInfo {
+		saved.modules[module] = savedModuleBuildActions{
+			actionDefs:                   module.actionDefs,
+			ninjaFileDeps:                module.ninjaFileDeps,
+			startedGenerateBuildActions:  module.startedGenerateBuildActions,
+			finishedGenerateBuildActions: module.finishedGenerateBuildActions,
+			providers:                    append([]interface{}(nil), module.providers...),
+			providerInitialValueHashes:   append([]uint64(nil), module.providerInitialValueHashes...),
+		}
+	}
+	for _, info := range c.singletonInfo {
+		saved.singletons = append(saved.singletons, savedSingletonBuildActions{
+			singleton:  info.singleton,
+			actionDefs: info.actionDefs,
+		})
+	}
+	return saved
+}
+
+func (c *Context) restoreBuildActions(saved *savedBuildActions) {
+	c.buildActionsReady = saved.buildActionsReady
+	c.liveGlobals = saved.liveGlobals
+	c.actionTrace = saved.actionTrace
+	c.nameTracker = saved.nameTracker
+	c.globalVariables = saved.globalVariables
+	c.globalPools = saved.globalPools
+	c.globalRules = saved.globalRules
+	c.warningsLock.Lock()
+	c.warnings = saved.warnings
+	c.warningsLock.Unlock()
+	c.generatorDeps = saved.generatorDeps
+	for module, state := range saved.modules {
+		module.actionDefs = state.actionDefs
+		module.ninjaFileDeps = state.ninjaFileDeps
+		module.startedGenerateBuildActions = state.startedGenerateBuildActions
+		module.finishedGenerateBuildActions = state.finishedGenerateBuildActions
+		module.providers = state.providers
+		module.providerInitialValueHashes = state.providerInitialValueHashes
+	}
+	for i, info := range c.singletonInfo {
+		info.singleton = saved.singletons[i].singleton
+		info.actionDefs = saved.singletons[i].actionDefs
+	}
+	c.outDir = saved.outDir
+	c.requiredNinjaMajor = saved.requiredNinjaMajor
+	c.requiredNinjaMinor = saved.requiredNinjaMinor
+	c.requiredNinjaMicro = saved.requiredNinjaMicro
+	c.subninjas = saved.subninjas
+}
@@ -0,0 +1,201 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type dryRunTestSingleton struct{}
+
+func newDryRunTestSingleton() Singleton {
+	return &dryRunTestSingleton{}
+}
+
+func (s *dryRunTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.Build(ninjaDefsTestOtherPctx, BuildParams{
+		Rule:            ninjaDefsTestPooledCcRule,
+		Outputs:         []string{"all.o"},
+		ImplicitOutputs: []string{"all.d"},
+		Inputs:          []string{"all.c"},
+	})
+}
+
+// dryRunPhonyTestModule builds an object with a common order-only dependency and adds it to a
+// phony target, which WriteBuildFile turns into phony build statements shared by the modules.
+type dryRunPhonyTestModule struct {
+	SimpleName
+}
+
+func newDryRunPhonyTestModule() (Module, []interface{}) {
+	m := &dryRunPhonyTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *dryRunPhonyTestModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(ninjaDefsTestPctx, BuildParams{
+		Rule:      ninjaDefsTestCcRule,
+		Outputs:   []string{ctx.ModuleName() + ".o"},
+		Inputs:    []string{ctx.ModuleName() + ".c"},
+		OrderOnly: []string{"gen.h"},
+	})
+	ctx.Phony("objs", ctx.ModuleName()+".o")
+	ctx.ModuleWarningf("test", "built")
+	ctx.AddNinjaFileDeps(ctx.ModuleName() + ".dep")
+}
+
+func newDryRunTestContext(t *testing.T) *Context {
+	t.Helper()
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newNinjaSplitTestModule)
+	ctx.RegisterModuleType("phony_test", newDryRunPhonyTestModule)
+	ctx.RegisterSingletonType("dry_run", newDryRunTestSingleton, false)
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test { name: "a", srcs: ["a.c"] }
+			test { name: "b", srcs: ["b.c"] }
+			test { name: "c" }
+			phony_test { name: "p1" }
+			phony_test { name: "p2" }
+		`),
+	})
+	if _, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	if _, errs := ctx.ResolveDependencies(nil); len(errs) > 0 {
+		t.Fatalf("unexpected dependency errors: %v", errs)
+	}
+	return ctx
+}
+
+func writeDryRunTestContext(t *testing.T, ctx *Context) string {
+	t.Helper()
+	if _, errs := ctx.PrepareBuildActions(nil); len(errs) > 0 {
+		t.Fatalf("unexpected build action errors: %v", errs)
+	}
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestDryRunBuildActions(t *testing.T) {
+	ctx := newDryRunTestContext(t)
+
+	summary, err := ctx.DryRunBuildActions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &BuildActionsSummary{
+		BuildDefsByModuleType: map[string]int{"test": 4, "phony_test": 2},
+		BuildDefsBySingleton:  map[string]int{"dry_run": 1},
+		Rules: map[string]int{
+			"g.ninja_defs_test.cc":              4,
+			"m.a_.link":                         1,
+			"m.b_.link":                         1,
+			"g.ninja_defs_test_other.pooled_cc": 1,
+			"phony":                             2,
+		},
+		Pools:   []string{"g.ninja_defs_test_other.pool"},
+		Outputs: 10,
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("incorrect summary:\nwant: %+v\n got: %+v", want, summary)
+	}
+
+	// Compare the summary with the statistics of the written file.
+	ninja := writeDryRunTestContext(t, ctx)
+	rules := make(map[string]int)
+	outputs := 0
+	for _, line := range strings.Split(ninja, "\n") {
+		if build, ok := strings.CutPrefix(line, "build "); ok {
+			outs, rest, _ := strings.Cut(build, ": ")
+			rule, _, _ := strings.Cut(rest, " ")
+			rules[rule]++
+			outputs += len(strings.Fields(strings.ReplaceAll(outs, "|", "")))
+		}
+	}
+	if !reflect.DeepEqual(rules, summary.Rules) {
+		t.Errorf("rules in the summary don't match the written file:\nfile: %v\n got: %v", rules, summary.Rules)
+	}
+	if outputs != summary.Outputs {
+		t.Errorf("expected %d outputs in the written file, got %d", summary.Outputs, outputs)
+	}
+	for _, pool := range summary.Pools {
+		if !strings.Contains(ninja, "pool "+pool+"\n") {
+			t.Errorf("expected pool %q in the written file", pool)
+		}
+	}
+}
+
+func TestDryRunBuildActionsDoesNotModifyContext(t *testing.T) {
+	want := writeDryRunTestContext(t, newDryRunTestContext(t))
+
+	t.Run("before PrepareBuildActions", func(t *testing.T) {
+		ctx := newDryRunTestContext(t)
+		if _, err := ctx.DryRunBuildActions(); err != nil {
+			t.Fatal(err)
+		}
+		if got := ctx.Warnings(); len(got) > 0 {
+			t.Errorf("expected the warnings of the dry run to be discarded, got %v", got)
+		}
+		for _, module := range ctx.moduleInfo {
+			if module.ninjaFileDeps != nil {
+				t.Errorf("expected the dependencies of %s from the dry run to be discarded, got %q",
+					module, module.ninjaFileDeps)
+			}
+		}
+		if got := writeDryRunTestContext(t, ctx); got != want {
+			t.Errorf("output changed after DryRunBuildActions:\nwant:\n%s\n got:\n%s", want, got)
+		}
+	})
+
+	t.Run("after PrepareBuildActions", func(t *testing.T) {
+		ctx := newDryRunTestContext(t)
+		// WriteBuildFile replaces the common order-only dependencies of the build statements, so
+		// only prepare the build actions before the dry run.
+		if _, errs := ctx.PrepareBuildActions(nil); len(errs) > 0 {
+			t.Fatalf("unexpected build action errors: %v", errs)
+		}
+		warnings, deps := ctx.Warnings(), ctx.GeneratorDependencies()
+		if _, err := ctx.DryRunBuildActions(); err != nil {
+			t.Fatal(err)
+		}
+		if got := ctx.Warnings(); !reflect.DeepEqual(got, warnings) {
+			t.Errorf("warnings changed after DryRunBuildActions:\nwant: %v\n got: %v", warnings, got)
+		}
+		if got := ctx.GeneratorDependencies(); !reflect.DeepEqual(got, deps) {
+			t.Errorf("dependencies changed after DryRunBuildActions:\nwant: %q\n got: %q", deps, got)
+		}
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("output changed after DryRunBuildActions:\nwant:\n%s\n got:\n%s", want, got)
+		}
+	})
+
+	t.Run("dependencies not ready", func(t *testing.T) {
+		ctx := NewContext()
+		if _, err := ctx.DryRunBuildActions(); err != ErrDependenciesNotReady {
+			t.Errorf("expected ErrDependenciesNotReady, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"slices"
+)
+
+// An EnableableModule is a Module that can be disabled.  Module types usually implement it by
+// embedding SimpleEnabled and returning its Properties from the factory, which adds an `enabled`
+// property that defaults to true.
+//
+// Whether each module is enabled is read by the blueprint_disabled mutator, which runs right
+// after blueprint_deps, so the value must be set in the Blueprints file, by a load hook or by a
+// defaults module (see DefaultableModule).  The GenerateBuildActions method of a disabled module
+// is not called, and neither are the mutators registered after blueprint_disabled, other than
+// transition mutators, so a disabled module isn't split into variants by CreateVariations.  After
+// all mutators have run, dependencies on a disabled module are removed if their tag implements
+// AllowDisabledDependencyTag and returns true, and are otherwise reported as an error from
+// ResolveDependencies.  Dependencies of disabled modules on other disabled modules
+// are ignored.
+type EnableableModule interface {
+	Module
+
+	// Enabled returns false if the module is disabled.
+	Enabled() bool
+}
+
+// SimpleEnabled is an embeddable implementation of EnableableModule that adds an `enabled`
+// property.
+type SimpleEnabled struct {
+	Properties struct {
+		Enabled *bool
+	}
+}
+
+func (e *SimpleEnabled) Enabled() bool {
+	return e.Properties.Enabled == nil || *e.Properties.Enabled
+}
+
+// An AllowDisabledDependencyTag is a DependencyTag for dependencies that are silently removed when
+// the dependency is disabled.
+type AllowDisabledDependencyTag interface {
+	DependencyTag
+
+	// AllowDisabledDependency returns true if the dependency should be removed when the
+	// dependency is disabled instead of being reported as an error.
+	AllowDisabledDependency() bool
+}
+
+func allowsDisabledDependency(tag DependencyTag) bool {
+	allow, ok := tag.(AllowDisabledDependencyTag)
+	return ok && allow.AllowDisabledDependency()
+}
+
+// blueprintDisabledMutator marks the modules that are disabled.  The marks are copied to any
+// variants created by later mutators.
+func blueprintDisabledMutator(ctx BottomUpMutatorContext) {
+	if m, ok := ctx.Module().(EnableableModule); ok && !m.Enabled() {
+		ctx.moduleInfo().disabled = true
+	}
+}
+
+// pruneDisabledDependencies removes allowed dependencies on disabled modules, and reports every
+// other dependency of an enabled module on a disabled module.  It must be called after all
+// mutators have run.  Each disallowed dependency is reported once per module group, regardless of
+// how many variants are involved.
+func (c *Context) pruneDisabledDependencies() (errs []error) {
+	type groupPair struct {
+		from, to *moduleGroup
+	}
+	reported := make(map[groupPair]bool)
+	pruned := false
+
+	for _, module := range c.modulesSorted {
+		if module.disabled {
+			continue
+		}
+		for _, dep := range module.directDeps {
+			if !dep.module.disabled || allowsDisabledDependency(dep.tag) {
+				continue
+			}
+			pair := groupPair{module.group, dep.module.group}
+			if reported[pair] {
+				continue
+			}
+			reported[pair] = true
+			errs = append(errs, c.ModuleErrorf(module.logicModule,
+				"depends on disabled module %q", dep.module.Name()))
+		}
+
+		n := len(module.directDeps)
+		module.directDeps = slices.DeleteFunc(module.directDeps, func(dep depInfo) bool {
+			return dep.module.disabled && allowsDisabledDependency(dep.tag)
+		})
+		pruned = pruned || len(module.directDeps) != n
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	if pruned {
+		return c.updateDependencies()
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+)
+
+type enabledTestAllowDisabledTag struct {
+	BaseDependencyTag
+}
+
+func (enabledTestAllowDisabledTag) AllowDisabledDependency() bool { return true }
+
+type enabledTestModule struct {
+	SimpleName
+	SimpleEnabled
+	properties struct {
+		Deps          []string
+		Optional_deps []string
+		Defaults      *string
+	}
+
+	generated bool
+	deps      []string
+}
+
+// newEnabledTestModuleFactory returns a factory for modules that apply the enabled property of
+// the named entry in defaults from a load hook, unless the module sets it itself.
+func newEnabledTestModuleFactory(defaults map[string]bool) ModuleFactory {
+	return func() (Module, []interface{}) {
+		m := &enabledTestModule{}
+		AddLoadHook(m, func(ctx LoadHookContext) {
+			if m.properties.Defaults == nil {
+				return
+			}
+			enabled, ok := defaults[*m.properties.Defaults]
+			if !ok {
+				ctx.PropertyErrorf("defaults", "unknown defaults %q", *m.properties.Defaults)
+				return
+			}
+			var props SimpleEnabled
+			props.Properties.Enabled = proptools.BoolPtr(enabled)
+			if err := proptools.PrependProperties(&m.SimpleEnabled.Properties, &props.Properties, nil); err != nil {
+				ctx.ModuleErrorf("%s", err)
+			}
+		})
+		return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleEnabled.Properties}
+	}
+}
+
+func (m *enabledTestModule) DynamicDependencies(DynamicDependerModuleContext) []string {
+	return m.properties.Deps
+}
+
+func (m *enabledTestModule) GenerateBuildActions(ctx ModuleContext) {
+	m.generated = true
+	ctx.VisitDirectDeps(func(dep Module) {
+		m.deps = append(m.deps, ctx.OtherModuleName(dep))
+	})
+}
+
+func runEnabledTest(t *testing.T, bp string, defaults map[string]bool) (*Context, []error) {
+	t.Helper()
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newEnabledTestModuleFactory(defaults))
+	ctx.RegisterBottomUpMutator("optional_deps", func(ctx BottomUpMutatorContext) {
+		if m, ok := ctx.Module().(*enabledTestModule); ok {
+			ctx.AddDependency(ctx.Module(), enabledTestAllowDisabledTag{}, m.properties.Optional_deps...)
+		}
+	})
+	ctx.RegisterBottomUpMutator("variants", func(ctx BottomUpMutatorContext) {
+		ctx.CreateVariations("a", "b")
+	})
+	ctx.MockFileSystem(map[string][]byte{"Android.bp": []byte(bp)})
+
+	if _, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	if _, errs := ctx.ResolveDependencies(nil); len(errs) > 0 {
+		return ctx, errs
+	}
+	if _, errs := ctx.PrepareBuildActions(nil); len(errs) > 0 {
+		t.Fatalf("unexpected build action errors: %v", errs)
+	}
+	return ctx, nil
+}
+
+func enabledTestModuleVariant(ctx *Context, name, variant string) *enabledTestModule {
+	return ctx.moduleGroupFromName(name, nil).moduleByVariantName(variant).logicModule.(*enabledTestModule)
+}
+
+func TestDisabledModules(t *testing.T) {
+	t.Run("disabled leaf", func(t *testing.T) {
+		ctx, errs := runEnabledTest(t, `
+			test {
+				name: "lib",
+				enabled: false,
+			}
+
+			test {
+				name: "app",
+			}
+		`, nil)
+		expectedErrors(t, errs)
+
+		if lib := ctx.moduleGroupFromName("lib", nil); len(lib.modules) != 1 {
+			t.Errorf("expected the variants mutator to skip disabled lib, got %d variants", len(lib.modules))
+		}
+		if enabledTestModuleVariant(ctx, "lib", "").generated {
+			t.Errorf("expected no build actions for disabled lib")
+		}
+		for _, variant := range []string{"a", "b"} {
+			if !enabledTestModuleVariant(ctx, "app", variant).generated {
+				t.Errorf("expected build actions for app variant %q", variant)
+			}
+		}
+	})
+
+	t.Run("disabled with dependents", func(t *testing.T) {
+		_, errs := runEnabledTest(t, `
+			test {
+				name: "lib",
+				enabled: false,
+			}
+
+			test {
+				name: "app",
+				deps: ["lib"],
+			}
+
+			test {
+				name: "disabled_app",
+				deps: ["lib"],
+				enabled: false,
+			}
+		`, nil)
+		expectedErrors(t, errs,
+			`Android.bp:7:4: module "app" variant "a": depends on disabled module "lib"`)
+	})
+
+	t.Run("optional dependency", func(t *testing.T) {
+		ctx, errs := runEnabledTest(t, `
+			test {
+				name: "lib",
+				enabled: false,
+			}
+
+			test {
+				name: "other",
+			}
+
+			test {
+				name: "app",
+				optional_deps: ["lib", "other"],
+			}
+		`, nil)
+		expectedErrors(t, errs)
+
+		app := enabledTestModuleVariant(ctx, "app", "a")
+		if want := []string{"other"}; !slices.Equal(app.deps, want) {
+			t.Errorf("expected deps %q, got %q", want, app.deps)
+		}
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		ctx, errs := runEnabledTest(t, `
+			test {
+				name: "disabled_by_defaults",
+				defaults: "disabled",
+			}
+
+			test {
+				name: "reenabled",
+				defaults: "disabled",
+				enabled: true,
+			}
+
+			test {
+				name: "app",
+				deps: ["reenabled"],
+			}
+		`, map[string]bool{"disabled": false})
+		expectedErrors(t, errs)
+
+		if enabledTestModuleVariant(ctx, "disabled_by_defaults", "").generated {
+			t.Errorf("expected no build actions for module disabled by defaults")
+		}
+		if !enabledTestModuleVariant(ctx, "reenabled", "a").generated {
+			t.Errorf("expected build actions for module re-enabled over defaults")
+		}
+		if app := enabledTestModuleVariant(ctx, "app", "a"); !slices.Equal(app.deps, []string{"reenabled"}) {
+			t.Errorf("expected app to depend on reenabled, got %q", app.deps)
+		}
+	})
+}
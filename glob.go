@@ -15,7 +15,12 @@
 package blueprint
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"slices"
 	"sort"
 	"strings"
@@ -38,7 +43,42 @@ func verifyGlob(key globKey, pattern string, excludes []string, g pathtools.Glob
 	}
 }
 
+// glob returns the files and directories that match pattern and not excludes.  If a loader was set
+// by SetFileLoader the matches are checked with the loader, see SetFileLoader.
 func (c *Context) glob(pattern string, excludes []string) ([]string, error) {
+	matches, err := c.globFs(pattern, excludes)
+	if err != nil || c.fileLoader == nil {
+		return matches, err
+	}
+	return c.loaderGlobMatches(pattern, matches)
+}
+
+// loaderGlobMatches returns the matches of a glob of pattern on the filesystem that the loader set
+// by SetFileLoader can load.  Directories are kept as the loader can't list them.  A pattern
+// without wildcards that the glob didn't find on the filesystem is returned if the loader can load
+// it.
+func (c *Context) loaderGlobMatches(pattern string, matches []string) ([]string, error) {
+	if !pathtools.IsGlob(pattern) && len(matches) == 0 {
+		matches = []string{pattern}
+	}
+
+	var loaded []string
+	for _, match := range matches {
+		if !strings.HasSuffix(match, "/") {
+			if _, err := c.fileLoader(match); errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		loaded = append(loaded, match)
+	}
+	return loaded, nil
+}
+
+// globFs returns the files and directories on the filesystem set by SetFs that match pattern and
+// not excludes.  The results are cached, and recorded for Globs.
+func (c *Context) globFs(pattern string, excludes []string) ([]string, error) {
 	// Sort excludes so that two globs with the same excludes in a different order reuse the same
 	// key.  Make a copy first to avoid modifying the caller's version.
 	excludes = slices.Clone(excludes)
@@ -58,16 +98,37 @@ func (c *Context) glob(pattern string, excludes []string) ([]string, error) {
 		return slices.Clone(g.Matches), nil
 	}
 
-	// Get a globbed file list
-	result, err := c.fs.Glob(pattern, excludes, pathtools.FollowSymlinks)
-	if err != nil {
-		return nil, err
+	var result pathtools.GlobResult
+	var cacheDeps []globCacheDep
+	cached := false
+	if c.globCacheFile != "" {
+		// Reuse the result from a previous run if nothing it depends on has changed
+		result, cacheDeps, cached = c.cachedGlob(key)
+	}
+
+	if !cached {
+		// Get a globbed file list
+		var err error
+		result, err = c.fs.Glob(pattern, excludes, pathtools.FollowSymlinks)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.globCacheFile != "" {
+			cacheDeps, err = c.globCacheDeps(result.Deps)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	// Store the results
 	c.globLock.Lock()
 	if g, exists = c.globs[key]; !exists {
 		c.globs[key] = result
+		if cacheDeps != nil {
+			c.globCacheResults[key] = cacheDeps
+		}
 	}
 	c.globLock.Unlock()
 
@@ -115,3 +176,210 @@ type globKey struct {
 func globToKey(pattern string, excludes []string) globKey {
 	return globKey{pattern, strings.Join(excludes, "|")}
 }
+
+// SetGlobCacheFile sets the path of a file used to cache the results of globs between runs.  When
+// set, globs whose results were recorded in the file by a previous run are not re-evaluated unless
+// one of the directories they traversed has changed, and PrepareBuildActions writes the results
+// of all globs evaluated in this run back to the file.  A relative path is relative to SrcDir.
+// The cache file itself is always read from and written to the real disk, the filesystem set by
+// SetFs is only used to check the directories of the cached globs.
+func (c *Context) SetGlobCacheFile(path string) {
+	c.globCacheFile = path
+}
+
+// SetGlobDirectoryDeps sets whether PrepareBuildActions returns every directory searched by a
+// glob, including every directory traversed by a recursive glob, as a dependency of the output.
+// The primary builder is then rerun whenever any file is added to or removed from one of those
+// directories, even if it doesn't match the glob, so this is meant for callers that don't rerun
+// globs separately, like bootstrap does with bpglob.  The directories are always returned when a
+// glob cache file is set by SetGlobCacheFile.
+func (c *Context) SetGlobDirectoryDeps(deps bool) {
+	c.globDirectoryDeps = deps
+}
+
+// SetReportEmptyGlobs sets whether globs evaluated by VariableFunc functions through
+// VariableFuncContext report patterns that match nothing, for example to find stale patterns.  A
+// pattern with wildcards that matches nothing is reported once as a warning returned by
+// Warnings, while a pattern without wildcards names a path that must exist, so the glob returns
+// an error if it is missing.
+func (c *Context) SetReportEmptyGlobs(report bool) {
+	c.reportEmptyGlobs = report
+}
+
+// checkEmptyGlob reports the results of a glob if SetReportEmptyGlobs was set and the glob
+// matched nothing.
+func (c *Context) checkEmptyGlob(pattern string, excludes []string, matches []string) error {
+	if !c.reportEmptyGlobs || len(matches) > 0 {
+		return nil
+	}
+	if !pathtools.IsGlob(pattern) {
+		return fmt.Errorf("glob pattern %q: path does not exist", pattern)
+	}
+
+	// The warning is kept with the glob results instead of the warnings of the build actions, as
+	// cached variable functions don't evaluate the glob again when the build actions are reset.
+	key := globToKey(pattern, excludes)
+	c.globLock.Lock()
+	c.emptyGlobWarnings[key] = Warning{
+		Message:  fmt.Sprintf("glob pattern %q matched no files", pattern),
+		Category: DiagnosticCodeEmptyGlob,
+	}
+	c.globLock.Unlock()
+	return nil
+}
+
+const globCacheVersion = 1
+
+// globCacheFileContents is the format of the file set by SetGlobCacheFile.
+type globCacheFileContents struct {
+	Version int
+	Globs   []globCacheEntry
+}
+
+// globCacheEntry is the cached result of a single glob.
+type globCacheEntry struct {
+	Pattern  string
+	Excludes []string
+	Matches  []string
+	Deps     []globCacheDep
+}
+
+// globCacheDep records the state of a file or directory that a cached glob depends on.  ModTime
+// is used as a fast check that the dependency is unchanged, Hash covers the list of entries in a
+// directory and is checked when ModTime is not available or differs.
+type globCacheDep struct {
+	Path    string
+	ModTime int64 `json:",omitempty"`
+	Hash    string
+}
+
+// loadGlobCache reads the glob cache file from the real disk the first time it is called.  A
+// missing, unreadable or outdated cache file is treated as empty.
+func (c *Context) loadGlobCache() {
+	c.globCacheOnce.Do(func() {
+		c.globCache = make(map[globKey]globCacheEntry)
+
+		data, err := os.ReadFile(JoinPath(c.SrcDir(), c.globCacheFile))
+		if err != nil {
+			return
+		}
+
+		var contents globCacheFileContents
+		if err := json.Unmarshal(data, &contents); err != nil || contents.Version != globCacheVersion {
+			return
+		}
+
+		for _, entry := range contents.Globs {
+			c.globCache[globToKey(entry.Pattern, entry.Excludes)] = entry
+		}
+	})
+}
+
+// cachedGlob returns the result of the glob from the glob cache file if every file or directory
+// that it depends on is unchanged since the cache file was written.
+func (c *Context) cachedGlob(key globKey) (pathtools.GlobResult, []globCacheDep, bool) {
+	c.loadGlobCache()
+
+	entry, ok := c.globCache[key]
+	if !ok {
+		return pathtools.GlobResult{}, nil, false
+	}
+
+	deps := make([]string, 0, len(entry.Deps))
+	cacheDeps := make([]globCacheDep, 0, len(entry.Deps))
+	for i := range entry.Deps {
+		dep, err := c.globCacheDep(entry.Deps[i].Path, &entry.Deps[i])
+		if err != nil || dep.Hash != entry.Deps[i].Hash {
+			return pathtools.GlobResult{}, nil, false
+		}
+		deps = append(deps, dep.Path)
+		cacheDeps = append(cacheDeps, dep)
+	}
+
+	return pathtools.GlobResult{
+		Pattern:  entry.Pattern,
+		Excludes: slices.Clone(entry.Excludes),
+		Matches:  slices.Clone(entry.Matches),
+		Deps:     deps,
+	}, cacheDeps, true
+}
+
+// globCacheDeps records the current state of the files and directories a glob depends on.
+func (c *Context) globCacheDeps(paths []string) ([]globCacheDep, error) {
+	deps := make([]globCacheDep, 0, len(paths))
+	for _, path := range paths {
+		dep, err := c.globCacheDep(path, nil)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, dep)
+	}
+	return deps, nil
+}
+
+// globCacheDep returns the current state of a file or directory.  If previous is not nil and the
+// modification time of the directory matches the one in previous the directory is assumed to be
+// unchanged, otherwise the hash of the list of entries in the directory is recomputed.
+func (c *Context) globCacheDep(path string, previous *globCacheDep) (globCacheDep, error) {
+	dep := globCacheDep{Path: path}
+
+	exists, isDir, err := c.fs.Exists(path)
+	if err != nil {
+		return globCacheDep{}, err
+	}
+	if !exists {
+		dep.Hash = "missing"
+		return dep, nil
+	}
+	if !isDir {
+		dep.Hash = "file"
+		return dep, nil
+	}
+
+	if stat, err := c.fs.Stat(path); err == nil && !stat.ModTime().IsZero() {
+		dep.ModTime = stat.ModTime().UnixNano()
+	}
+	if previous != nil && dep.ModTime != 0 && dep.ModTime == previous.ModTime {
+		dep.Hash = previous.Hash
+		return dep, nil
+	}
+
+	names, err := c.fs.ReadDirNames(path)
+	if err != nil {
+		return globCacheDep{}, err
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+	}
+	dep.Hash = hex.EncodeToString(h.Sum(nil))
+
+	return dep, nil
+}
+
+// writeGlobCache writes the results of all globs evaluated in this run to the glob cache file.
+// The file is only rewritten if its contents changed.
+func (c *Context) writeGlobCache() error {
+	contents := globCacheFileContents{Version: globCacheVersion}
+
+	c.globLock.Lock()
+	for _, g := range c.Globs() {
+		contents.Globs = append(contents.Globs, globCacheEntry{
+			Pattern:  g.Pattern,
+			Excludes: g.Excludes,
+			Matches:  g.Matches,
+			Deps:     c.globCacheResults[globToKey(g.Pattern, g.Excludes)],
+		})
+	}
+	c.globLock.Unlock()
+
+	data, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+
+	return pathtools.WriteFileIfChanged(JoinPath(c.SrcDir(), c.globCacheFile), data, OutFilePermissions)
+}
@@ -14,7 +14,16 @@
 
 package blueprint
 
-import "testing"
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/blueprint/pathtools"
+)
 
 func TestGlobCache(t *testing.T) {
 	ctx := NewContext()
@@ -53,3 +62,362 @@ func TestGlobCache(t *testing.T) {
 		t.Error(`expected ["a/a"], got`, matches)
 	}
 }
+
+func TestGlobCacheFile(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "globs.json")
+	key := globToKey("a/*", nil)
+
+	run := func(t *testing.T, files map[string][]byte, wantCached bool, want []string) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.MockFileSystem(files)
+		ctx.SetGlobCacheFile(cacheFile)
+
+		if _, _, cached := ctx.cachedGlob(key); cached != wantCached {
+			t.Errorf("expected cached result to be used: %v, got %v", wantCached, cached)
+		}
+
+		matches, err := ctx.glob("a/*", nil)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		if !reflect.DeepEqual(matches, want) {
+			t.Errorf("expected %q, got %q", want, matches)
+		}
+
+		if err := ctx.writeGlobCache(); err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+
+	files := map[string][]byte{
+		"Android.bp": nil,
+		"a/a":        nil,
+		"a/b":        nil,
+	}
+
+	t.Run("initial", func(t *testing.T) {
+		run(t, files, false, []string{"a/a", "a/b"})
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		run(t, files, true, []string{"a/a", "a/b"})
+	})
+
+	t.Run("added", func(t *testing.T) {
+		files["a/c"] = nil
+		run(t, files, false, []string{"a/a", "a/b", "a/c"})
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		delete(files, "a/a")
+		run(t, files, false, []string{"a/b", "a/c"})
+	})
+
+	t.Run("renamed", func(t *testing.T) {
+		delete(files, "a/b")
+		files["a/d"] = nil
+		run(t, files, false, []string{"a/c", "a/d"})
+	})
+
+	t.Run("unrelated", func(t *testing.T) {
+		files["b/a"] = nil
+		run(t, files, true, []string{"a/c", "a/d"})
+	})
+}
+
+type globCacheTestModule struct {
+	SimpleName
+}
+
+func newGlobCacheTestModule() (Module, []interface{}) {
+	m := &globCacheTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *globCacheTestModule) GenerateBuildActions(ctx ModuleContext) {
+	if _, err := ctx.GlobWithDeps("a/*", nil); err != nil {
+		ctx.ModuleErrorf("%s", err)
+	}
+}
+
+func TestGlobCacheFileNinjaDeps(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "globs.json")
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("glob_cache_test", newGlobCacheTestModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			glob_cache_test {
+				name: "foo",
+			}
+		`),
+		"a/a": nil,
+	})
+	ctx.SetGlobCacheFile(cacheFile)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) > 0 {
+		t.Fatal("unexpected errors", errs)
+	}
+	deps, errs := ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Fatal("unexpected errors", errs)
+	}
+
+	for _, want := range []string{cacheFile, "a"} {
+		if !slices.Contains(deps, want) {
+			t.Errorf("expected deps to contain %q, got %q", want, deps)
+		}
+	}
+}
+
+type globDepsTestModule struct {
+	SimpleName
+}
+
+func newGlobDepsTestModule() (Module, []interface{}) {
+	m := &globDepsTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *globDepsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	for _, pattern := range []string{"src/**/*.c", "src/a/*.h"} {
+		if _, err := ctx.GlobWithDeps(pattern, []string{"src/skip/**"}); err != nil {
+			ctx.ModuleErrorf("%s", err)
+		}
+	}
+}
+
+func TestGlobNinjaDeps(t *testing.T) {
+	run := func(t *testing.T, globDirectoryDeps bool) []string {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("glob_deps_test", newGlobDepsTestModule)
+		ctx.SetGlobDirectoryDeps(globDirectoryDeps)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				glob_deps_test {
+					name: "foo",
+				}
+			`),
+			"src/a/a.c":            nil,
+			"src/a/b/b.c":          nil,
+			"src/a/b/c/empty/.dir": nil,
+			"src/d/d.h":            nil,
+			"src/skip/e/e.c":       nil,
+			"other/f.c":            nil,
+		})
+
+		if _, errs := ctx.ParseBlueprintsFiles("Android.bp", nil); len(errs) > 0 {
+			t.Fatal("unexpected errors", errs)
+		}
+		if _, errs := ctx.ResolveDependencies(nil); len(errs) > 0 {
+			t.Fatal("unexpected errors", errs)
+		}
+		deps, errs := ctx.PrepareBuildActions(nil)
+		if len(errs) > 0 {
+			t.Fatal("unexpected errors", errs)
+		}
+		return deps
+	}
+
+	t.Run("default", func(t *testing.T) {
+		// Globs are rerun separately by default, so the directories they search aren't deps.
+		if deps := run(t, false); len(deps) > 0 {
+			t.Errorf("expected no deps, got %q", deps)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		// Every directory traversed by the recursive glob is a dependency, including ones that
+		// contained no matches, but not the directories that were pruned by the exclude.
+		want := []string{"src", "src/a", "src/a/b", "src/a/b/c", "src/a/b/c/empty", "src/d"}
+		deps := run(t, true)
+		if !reflect.DeepEqual(deps, want) {
+			t.Errorf("incorrect deps\nwant: %q\n got: %q", want, deps)
+		}
+		if slices.Contains(deps, "src/skip") {
+			t.Errorf("expected pruned directory src/skip not to be a dep, got %q", deps)
+		}
+	})
+}
+
+type ioFsTestModule struct {
+	SimpleName
+	properties struct {
+		Srcs []string
+	}
+}
+
+func newIOFsTestModule() (Module, []interface{}) {
+	m := &ioFsTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties, &m.properties}
+}
+
+func (m *ioFsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	var srcs []string
+	for _, src := range m.properties.Srcs {
+		matches, err := ctx.GlobWithDeps(filepath.Join(ctx.ModuleDir(), src), nil)
+		if err != nil {
+			ctx.ModuleErrorf("%s", err)
+			return
+		}
+		srcs = append(srcs, matches...)
+	}
+	ctx.Build(ninjaDefsTestPctx, BuildParams{
+		Rule:    ninjaDefsTestCcRule,
+		Outputs: []string{ctx.ModuleName() + ".o"},
+		Inputs:  srcs,
+	})
+}
+
+func TestIOFs(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("io_fs_test", newIOFsTestModule)
+	ctx.SetFs(pathtools.NewIOFs(fstest.MapFS{
+		"Android.bp": &fstest.MapFile{Data: []byte(`
+			build = ["build.bp"]
+			io_fs_test {
+				name: "foo",
+				srcs: ["src/*.c"],
+			}
+		`)},
+		"build.bp": &fstest.MapFile{Data: []byte(`
+			io_fs_test {
+				name: "bar",
+				srcs: ["bar.c"],
+			}
+		`)},
+		"bar.c":   &fstest.MapFile{},
+		"src/a.c": &fstest.MapFile{},
+		"src/b.c": &fstest.MapFile{},
+		"src/c.h": &fstest.MapFile{},
+	}))
+
+	if _, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	if _, errs := ctx.ResolveDependencies(nil); len(errs) > 0 {
+		t.Fatalf("unexpected dependency errors: %v", errs)
+	}
+	if _, errs := ctx.PrepareBuildActions(nil); len(errs) > 0 {
+		t.Fatalf("unexpected build action errors: %v", errs)
+	}
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	statements := ninjaStatements(buf.String())
+	for _, want := range []string{
+		"build foo.o: g.ninja_defs_test.cc src/a.c src/b.c\n",
+		"build bar.o: g.ninja_defs_test.cc bar.c\n",
+	} {
+		if !slices.ContainsFunc(statements, func(s string) bool { return strings.HasPrefix(s, want) }) {
+			t.Errorf("missing build statement %q in:\n%s", want, strings.Join(statements, "\n"))
+		}
+	}
+}
+
+func TestVariableFuncGlobDirsWithDeps(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp":   nil,
+		"a/b/file":     nil,
+		"a/c/file":     nil,
+		"a/d/file":     nil,
+		"a/file":       nil,
+		"a/other.file": nil,
+	})
+	v := &variableFuncContext{ctx}
+
+	dirs, err := v.GlobDirsWithDeps("a/*", []string{"a/c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a/b", "a/d"}; !reflect.DeepEqual(dirs, want) {
+		t.Errorf("incorrect directories, want %q, got %q", want, dirs)
+	}
+
+	all, err := v.GlobWithDeps("a/*", []string{"a/c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a/b/", "a/d/", "a/file", "a/other.file"}; !reflect.DeepEqual(all, want) {
+		t.Errorf("incorrect matches, want %q, got %q", want, all)
+	}
+}
+
+func TestReportEmptyGlobs(t *testing.T) {
+	newVariableFuncContext := func(report bool) *variableFuncContext {
+		ctx := NewContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": nil,
+			"a/a.c":      nil,
+		})
+		ctx.SetReportEmptyGlobs(report)
+		return &variableFuncContext{ctx}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		v := newVariableFuncContext(false)
+		for _, pattern := range []string{"a/*.h", "a/missing.c"} {
+			if matches, err := v.GlobWithDeps(pattern, nil); err != nil || len(matches) > 0 {
+				t.Errorf("%q: expected no matches or errors, got %q, %v", pattern, matches, err)
+			}
+		}
+		expectedWarnings(t, v.context.Warnings())
+	})
+
+	t.Run("wildcard", func(t *testing.T) {
+		v := newVariableFuncContext(true)
+		for i := 0; i < 2; i++ {
+			if matches, err := v.GlobWithDeps("a/*.h", nil); err != nil || len(matches) > 0 {
+				t.Errorf("expected no matches or errors, got %q, %v", matches, err)
+			}
+		}
+		if _, err := v.GlobWithDeps("a/*.c", []string{"a/a.c"}); err != nil {
+			t.Error(err)
+		}
+		if _, err := v.GlobWithDeps("a/*.c", nil); err != nil {
+			t.Error(err)
+		}
+		expectedWarnings(t, v.context.Warnings(),
+			`warning: glob pattern "a/*.c" matched no files [empty-glob]`,
+			`warning: glob pattern "a/*.h" matched no files [empty-glob]`)
+	})
+
+	t.Run("literal", func(t *testing.T) {
+		v := newVariableFuncContext(true)
+		if _, err := v.GlobWithDeps("a/a.c", nil); err != nil {
+			t.Error(err)
+		}
+		_, err := v.GlobWithDeps("a/missing.c", nil)
+		if want := `glob pattern "a/missing.c": path does not exist`; err == nil || err.Error() != want {
+			t.Errorf("expected error %q, got %v", want, err)
+		}
+		expectedWarnings(t, v.context.Warnings())
+	})
+
+	t.Run("reparse", func(t *testing.T) {
+		v := newVariableFuncContext(true)
+		ctx := v.context
+		ctx.SetIncrementalReparse(true)
+		if _, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if _, err := v.GlobWithDeps("a/*.h", nil); err != nil {
+			t.Error(err)
+		}
+
+		// The glob results are kept when the build actions are reset, and so is the warning.
+		ctx.resetBuildActions(nil)
+		expectedWarnings(t, ctx.Warnings(), `warning: glob pattern "a/*.h" matched no files [empty-glob]`)
+
+		if _, errs := ctx.reparseAll(nil); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		expectedWarnings(t, ctx.Warnings())
+	})
+}
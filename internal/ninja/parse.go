@@ -0,0 +1,407 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ninja parses the subset of the ninja file syntax that Blueprint writes, so that tests
+// can check the structure of the generated ninja files instead of their exact bytes.
+//
+// Paths and values are returned in the form they are passed to Blueprint: the escapes of spaces,
+// colons and line breaks are removed, but "$$" and variable references are left as they are.
+// Variables are not evaluated.
+package ninja
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A File is a parsed ninja file.
+type File struct {
+	Variables []Variable // The global variables, in the order they are assigned.
+	Pools     []*Pool
+	Rules     []*Rule
+	Builds    []*Build
+	Defaults  []string // The targets of all the default statements.
+	Subninjas []string
+}
+
+// A Variable is a variable assignment.
+type Variable struct {
+	Name  string
+	Value string
+}
+
+// A Pool is a pool declaration.
+type Pool struct {
+	Name      string
+	Variables map[string]string
+}
+
+// A Rule is a rule declaration.
+type Rule struct {
+	Name      string
+	Variables map[string]string
+}
+
+// A Build is a build statement.
+type Build struct {
+	Rule            string
+	Outputs         []string
+	ImplicitOutputs []string
+	Inputs          []string
+	Implicits       []string
+	OrderOnly       []string
+	Validations     []string
+	Variables       map[string]string
+}
+
+// Rule returns the rule called name, or nil if there is none.
+func (f *File) Rule(name string) *Rule {
+	for _, r := range f.Rules {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// Pool returns the pool called name, or nil if there is none.
+func (f *File) Pool(name string) *Pool {
+	for _, p := range f.Pools {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Build returns the build statement with the explicit or implicit output, or nil if there is none.
+func (f *File) Build(output string) *Build {
+	for _, b := range f.Builds {
+		for _, o := range b.Outputs {
+			if o == output {
+				return b
+			}
+		}
+		for _, o := range b.ImplicitOutputs {
+			if o == output {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+// Variable returns the value of the global variable called name, and whether it is assigned.
+func (f *File) Variable(name string) (string, bool) {
+	for _, v := range f.Variables {
+		if v.Name == name {
+			return v.Value, true
+		}
+	}
+	return "", false
+}
+
+// Parse parses a ninja file written by Blueprint.
+func Parse(r io.Reader) (*File, error) {
+	p := &parser{
+		scanner: bufio.NewScanner(r),
+		file:    &File{},
+	}
+	p.scanner.Buffer(nil, 1024*1024*1024)
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p.file, nil
+}
+
+type parser struct {
+	scanner *bufio.Scanner
+	file    *File
+
+	lineNum   int               // the line number of the last physical line read
+	variables map[string]string // the variables of the current pool, rule or build statement
+}
+
+func (p *parser) errorf(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// nextLine returns the next logical line, joining the lines that end with a "$" line
+// continuation, and the number of its first physical line.
+func (p *parser) nextLine() (string, int, bool) {
+	var line strings.Builder
+	start := p.lineNum + 1
+	for p.scanner.Scan() {
+		p.lineNum++
+		text := p.scanner.Text()
+		if line.Len() > 0 {
+			// The indentation of a continuation line is not significant.
+			text = strings.TrimLeft(text, " ")
+		} else if strings.HasPrefix(strings.TrimLeft(text, " "), "#") {
+			// Comments can't be continued.
+			return text, start, true
+		}
+		if !endsWithContinuation(text) {
+			line.WriteString(text)
+			return line.String(), start, true
+		}
+		line.WriteString(text[:len(text)-1])
+	}
+	return line.String(), start, line.Len() > 0
+}
+
+// endsWithContinuation returns true if line ends with a "$" that is not part of a "$$" escape.
+func endsWithContinuation(line string) bool {
+	dollars := len(line) - len(strings.TrimRight(line, "$"))
+	return dollars%2 == 1
+}
+
+func (p *parser) parse() error {
+	for {
+		line, lineNum, ok := p.nextLine()
+		if !ok {
+			break
+		}
+
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if trimmed != line {
+			// An indented line assigns a variable of the preceding declaration.
+			if p.variables == nil {
+				return p.errorf(lineNum, "indented variable %q outside of a declaration", trimmed)
+			}
+			name, value, err := parseAssignment(trimmed)
+			if err != nil {
+				return p.errorf(lineNum, "%s", err)
+			}
+			p.variables[name] = value
+			continue
+		}
+		p.variables = nil
+
+		keyword, rest, _ := strings.Cut(line, " ")
+		switch keyword {
+		case "pool":
+			pool := &Pool{Name: rest, Variables: make(map[string]string)}
+			p.file.Pools = append(p.file.Pools, pool)
+			p.variables = pool.Variables
+		case "rule":
+			rule := &Rule{Name: rest, Variables: make(map[string]string)}
+			p.file.Rules = append(p.file.Rules, rule)
+			p.variables = rule.Variables
+		case "build":
+			build, err := parseBuild(rest)
+			if err != nil {
+				return p.errorf(lineNum, "%s", err)
+			}
+			p.file.Builds = append(p.file.Builds, build)
+			p.variables = build.Variables
+		case "default":
+			targets, err := parsePathList(rest)
+			if err != nil {
+				return p.errorf(lineNum, "%s", err)
+			}
+			p.file.Defaults = append(p.file.Defaults, targets...)
+		case "subninja":
+			paths, err := parsePathList(rest)
+			if err != nil {
+				return p.errorf(lineNum, "%s", err)
+			}
+			if len(paths) != 1 {
+				return p.errorf(lineNum, "expected a single path after subninja, got %q", rest)
+			}
+			p.file.Subninjas = append(p.file.Subninjas, paths[0])
+		default:
+			name, value, err := parseAssignment(line)
+			if err != nil {
+				return p.errorf(lineNum, "%s", err)
+			}
+			p.file.Variables = append(p.file.Variables, Variable{Name: name, Value: value})
+		}
+	}
+	return p.scanner.Err()
+}
+
+func parseAssignment(line string) (name, value string, err error) {
+	name, value, ok := strings.Cut(line, " = ")
+	if !ok {
+		// An empty value may have lost its trailing space.
+		name, ok = strings.CutSuffix(line, " =")
+		if !ok {
+			return "", "", fmt.Errorf("expected variable assignment, got %q", line)
+		}
+	}
+	if name == "" || strings.ContainsAny(name, " $") {
+		return "", "", fmt.Errorf("invalid variable name %q", name)
+	}
+	return name, value, nil
+}
+
+// A token is either a path or one of the ":", "|", "||" and "|@" separators of a build statement.
+type token struct {
+	text      string
+	separator bool
+}
+
+// tokenize splits the paths and separators of a build or default statement.
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == ' ':
+			i++
+		case s[i] == ':':
+			tokens = append(tokens, token{":", true})
+			i++
+		case strings.HasPrefix(s[i:], "||"), strings.HasPrefix(s[i:], "|@"):
+			tokens = append(tokens, token{s[i : i+2], true})
+			i += 2
+		case s[i] == '|':
+			tokens = append(tokens, token{"|", true})
+			i++
+		default:
+			path, n, err := scanPath(s[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{path, false})
+			i += n
+		}
+	}
+	return tokens, nil
+}
+
+// scanPath returns the path at the start of s and the number of bytes it used.  A path ends at
+// an unescaped space, colon or pipe.
+func scanPath(s string) (string, int, error) {
+	var path strings.Builder
+	i := 0
+	for i < len(s) && s[i] != ' ' && s[i] != ':' && s[i] != '|' {
+		if s[i] != '$' {
+			path.WriteByte(s[i])
+			i++
+			continue
+		}
+		if i+1 == len(s) {
+			return "", 0, fmt.Errorf("unexpected end of path after '$' in %q", s)
+		}
+		switch c := s[i+1]; {
+		case c == ' ', c == ':':
+			path.WriteByte(c)
+			i += 2
+		case c == '$':
+			path.WriteString("$$")
+			i += 2
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", 0, fmt.Errorf("unterminated variable reference in %q", s)
+			}
+			path.WriteString(s[i : i+end+1])
+			i += end + 1
+		case isVariableNameChar(c):
+			end := i + 1
+			for end < len(s) && isVariableNameChar(s[end]) {
+				end++
+			}
+			path.WriteString(s[i:end])
+			i = end
+		default:
+			return "", 0, fmt.Errorf("invalid character after '$' in %q", s)
+		}
+	}
+	return path.String(), i, nil
+}
+
+func isVariableNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func parsePathList(s string) ([]string, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		if t.separator {
+			return nil, fmt.Errorf("unexpected %q in %q", t.text, s)
+		}
+		paths = append(paths, t.text)
+	}
+	return paths, nil
+}
+
+func parseBuild(s string) (*Build, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	build := &Build{Variables: make(map[string]string)}
+
+	// Each separator switches the list that the following paths are added to, and may only appear
+	// after the separators that precede it in a build statement.
+	lists := []struct {
+		separator string
+		paths     *[]string
+	}{
+		{"", &build.Outputs},
+		{"|", &build.ImplicitOutputs},
+		{":", nil},
+		{"|", &build.Implicits},
+		{"||", &build.OrderOnly},
+		{"|@", &build.Validations},
+	}
+	current := 0
+	for _, t := range tokens {
+		if t.separator {
+			next := current + 1
+			for next < len(lists) && lists[next].separator != t.text {
+				next++
+			}
+			if next == len(lists) || (current < 2 && next > 2) {
+				return nil, fmt.Errorf("unexpected %q in %q", t.text, s)
+			}
+			current = next
+			continue
+		}
+		if lists[current].paths == nil {
+			// The first path after the ":" is the rule, the following ones are the inputs.
+			if build.Rule == "" {
+				build.Rule = t.text
+				continue
+			}
+			build.Inputs = append(build.Inputs, t.text)
+			continue
+		}
+		*lists[current].paths = append(*lists[current].paths, t.text)
+	}
+
+	if current < 2 {
+		return nil, fmt.Errorf("missing ':' in %q", s)
+	}
+	if len(build.Outputs) == 0 {
+		return nil, fmt.Errorf("no outputs in %q", s)
+	}
+	if build.Rule == "" {
+		return nil, fmt.Errorf("no rule in %q", s)
+	}
+	return build, nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ninja
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testNinjaFile = `# ******************************************************************************
+# ***            This file is generated and should not be edited             ***
+# ******************************************************************************
+
+ninja_required_version = 1.7.0
+
+g.pkg.cc = clang
+g.pkg.empty =
+
+pool g.pkg.pool
+    depth = 4
+
+# a comment that ends with a dollar $
+rule g.pkg.cc
+    command = ${g.pkg.cc} -c ${in} -o ${out}
+    description = cc ${out}
+    pool = g.pkg.pool
+
+build out$ dir/a$:b.o | a.d: g.pkg.cc in$ dir/a.c $
+        ${g.pkg.cc}/b.c | imp.h || order.stamp |@ check.stamp
+    flags = -DA=$$x $
+            -DB
+default out$ dir/a$:b.o a.d
+
+build a.stamp: phony
+default a.stamp
+
+subninja build.all.ninja
+`
+
+func TestParse(t *testing.T) {
+	f, err := Parse(strings.NewReader(testNinjaFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantVariables := []Variable{
+		{"ninja_required_version", "1.7.0"},
+		{"g.pkg.cc", "clang"},
+		{"g.pkg.empty", ""},
+	}
+	if !reflect.DeepEqual(f.Variables, wantVariables) {
+		t.Errorf("incorrect variables\nwant: %q\n got: %q", wantVariables, f.Variables)
+	}
+
+	wantPools := []*Pool{{Name: "g.pkg.pool", Variables: map[string]string{"depth": "4"}}}
+	if !reflect.DeepEqual(f.Pools, wantPools) {
+		t.Errorf("incorrect pools\nwant: %v\n got: %v", wantPools, f.Pools)
+	}
+
+	wantRules := []*Rule{{
+		Name: "g.pkg.cc",
+		Variables: map[string]string{
+			"command":     "${g.pkg.cc} -c ${in} -o ${out}",
+			"description": "cc ${out}",
+			"pool":        "g.pkg.pool",
+		},
+	}}
+	if !reflect.DeepEqual(f.Rules, wantRules) {
+		t.Errorf("incorrect rules\nwant: %v\n got: %v", wantRules, f.Rules)
+	}
+
+	wantBuilds := []*Build{
+		{
+			Rule:            "g.pkg.cc",
+			Outputs:         []string{"out dir/a:b.o"},
+			ImplicitOutputs: []string{"a.d"},
+			Inputs:          []string{"in dir/a.c", "${g.pkg.cc}/b.c"},
+			Implicits:       []string{"imp.h"},
+			OrderOnly:       []string{"order.stamp"},
+			Validations:     []string{"check.stamp"},
+			Variables:       map[string]string{"flags": "-DA=$$x -DB"},
+		},
+		{
+			Rule:      "phony",
+			Outputs:   []string{"a.stamp"},
+			Variables: map[string]string{},
+		},
+	}
+	if !reflect.DeepEqual(f.Builds, wantBuilds) {
+		t.Errorf("incorrect builds\nwant: %+v\n got: %+v", wantBuilds, f.Builds)
+	}
+
+	if want := []string{"out dir/a:b.o", "a.d", "a.stamp"}; !reflect.DeepEqual(f.Defaults, want) {
+		t.Errorf("incorrect defaults\nwant: %q\n got: %q", want, f.Defaults)
+	}
+	if want := []string{"build.all.ninja"}; !reflect.DeepEqual(f.Subninjas, want) {
+		t.Errorf("incorrect subninjas\nwant: %q\n got: %q", want, f.Subninjas)
+	}
+
+	if f.Build("a.d") != f.Builds[0] {
+		t.Errorf("expected Build to find the statement with implicit output a.d")
+	}
+	if f.Rule("g.pkg.cc") != f.Rules[0] || f.Pool("g.pkg.pool") != f.Pools[0] {
+		t.Errorf("expected Rule and Pool to find the declarations")
+	}
+	if v, ok := f.Variable("g.pkg.cc"); !ok || v != "clang" {
+		t.Errorf("expected variable g.pkg.cc to be %q, got %q", "clang", v)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "indented variable at top level",
+			input: "    foo = bar\n",
+			err:   `line 1: indented variable "foo = bar" outside of a declaration`,
+		},
+		{
+			name:  "not an assignment",
+			input: "foo bar\n",
+			err:   `line 1: expected variable assignment, got "foo bar"`,
+		},
+		{
+			name:  "missing colon",
+			input: "\nbuild out rule in\n",
+			err:   `line 2: missing ':' in "out rule in"`,
+		},
+		{
+			name:  "no outputs",
+			input: "build : rule in\n",
+			err:   `line 1: no outputs in ": rule in"`,
+		},
+		{
+			name:  "no rule",
+			input: "build out:\n",
+			err:   `line 1: no rule in "out:"`,
+		},
+		{
+			name:  "misplaced separator",
+			input: "build out || order: rule in\n",
+			err:   `line 1: unexpected "||" in "out || order: rule in"`,
+		},
+		{
+			name:  "invalid escape",
+			input: "build out: rule in$!\n",
+			err:   `line 1: invalid character after '$' in "in$!"`,
+		},
+		{
+			name:  "separator in default",
+			input: "default a | b\n",
+			err:   `line 1: unexpected "|" in "a | b"`,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.input))
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -15,103 +15,53 @@
 package blueprint
 
 import (
+	"slices"
 	"sort"
+
+	"github.com/google/blueprint/proptools"
 )
 
-func abs(a int) int {
-	if a < 0 {
-		return -a
-	}
-	return a
-}
+// maxNameGuesses is the maximum number of similar names returned by namesLike.
+const maxNameGuesses = 3
 
-// This implementation is written to be recursive, because
-// we know Soong names are short, so we shouldn't hit the stack
-// depth. Also, the buffer is indexed this way so that new
-// allocations aren't needed.
-func levenshtein(a, b string, ai, bi, max int, buf [][]int) int {
-	if max == 0 {
-		return 0
-	}
-	if ai >= len(a) {
-		return len(b) - bi
-	}
-	if bi >= len(b) {
-		return len(a) - ai
-	}
-	if buf[bi][ai] != 0 {
-		return buf[bi][ai]
-	}
-	if abs(len(a)-len(b)) >= max {
-		return max
-	}
-	var res = max
-	if a[ai] == b[bi] {
-		res = levenshtein(a, b, ai+1, bi+1, max, buf)
-	} else {
-		if c := levenshtein(a, b, ai+1, bi+1, max-1, buf); c < res {
-			res = c // replace
-		}
-		if c := levenshtein(a, b, ai+1, bi, max-1, buf); c < res {
-			res = c // delete from a
-		}
-		if c := levenshtein(a, b, ai, bi+1, max-1, buf); c < res {
-			res = c // delete from b
-		}
-		res += 1
-	}
-	buf[bi][ai] = res
-	return res
-}
-
-func stringIn(arr []string, str string) bool {
-	for _, a := range arr {
-		if a == str {
-			return true
-		}
-	}
-	return false
-}
+// namesLike returns up to maxNameGuesses names of module groups in namespace that are similar to
+// name, closest first, excluding unlike.  A name is similar if it is at most one edit per three
+// characters of name away from it, and at least one.
+func namesLike(name string, unlike string, namespace Namespace, moduleGroups []*moduleGroup) []string {
+	maxDifferences := max(len(name)/3, 1)
 
-func namesLike(name string, unlike string, moduleGroups []*moduleGroup) []string {
-	const kAllowedDifferences = 10
-	buf := make([][]int, len(name)+kAllowedDifferences)
-	for i := range buf {
-		buf[i] = make([]int, len(name))
+	type guess struct {
+		name     string
+		distance int
 	}
-
-	var best []string
-	bestVal := kAllowedDifferences + 1
+	var guesses []guess
 
 	for _, group := range moduleGroups {
 		other := group.name
 
-		if other == unlike {
+		if other == unlike || group.namespace != namespace {
 			continue
 		}
 
-		l := levenshtein(name, other, 0, 0, kAllowedDifferences, buf)
-		// fmt.Printf("levenshtein %q %q %d\n", name, other, l)
+		l := proptools.EditDistance(name, other)
 
-		// slightly better to use a min-heap
-		if l == 0 {
-			// these are the same, so it must be in a different namespace
-			// ignore...
-		} else if l < bestVal {
-			bestVal = l
-			best = []string{other}
-		} else if l == bestVal && !stringIn(best, other) {
-			best = append(best, other)
+		// A distance of 0 means the names are the same, so it must be in a different
+		// namespace; ignore it.
+		if l > 0 && l <= maxDifferences && !slices.ContainsFunc(guesses, func(g guess) bool { return g.name == other }) {
+			guesses = append(guesses, guess{other, l})
 		}
+	}
 
-		// zero buffer once used
-		for _, v := range buf {
-			for j := range v {
-				v[j] = 0
-			}
+	sort.Slice(guesses, func(i, j int) bool {
+		if guesses[i].distance != guesses[j].distance {
+			return guesses[i].distance < guesses[j].distance
 		}
-	}
+		return guesses[i].name < guesses[j].name
+	})
 
-	sort.Strings(best)
+	var best []string
+	for _, g := range guesses[:min(len(guesses), maxNameGuesses)] {
+		best = append(best, g.name)
+	}
 	return best
 }
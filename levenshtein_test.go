@@ -41,14 +41,30 @@ func assertEqual(t *testing.T, a, b []string) {
 }
 
 func TestLevenshteinWontGuessUnlike(t *testing.T) {
-	assertEqual(t, namesLike("a", "test", mods([]string{"test"})), []string{})
+	assertEqual(t, namesLike("a", "test", nil, mods([]string{"test"})), []string{})
 }
 func TestLevenshteinInsert(t *testing.T) {
-	assertEqual(t, namesLike("a", "test", mods([]string{"ab", "ac", "not_this"})), []string{"ab", "ac"})
+	assertEqual(t, namesLike("a", "test", nil, mods([]string{"ab", "ac", "not_this"})), []string{"ab", "ac"})
 }
 func TestLevenshteinDelete(t *testing.T) {
-	assertEqual(t, namesLike("ab", "test", mods([]string{"a", "b", "not_this"})), []string{"a", "b"})
+	assertEqual(t, namesLike("ab", "test", nil, mods([]string{"a", "b", "not_this"})), []string{"a", "b"})
 }
 func TestLevenshteinReplace(t *testing.T) {
-	assertEqual(t, namesLike("aa", "test", mods([]string{"ab", "ac", "not_this"})), []string{"ab", "ac"})
+	assertEqual(t, namesLike("aa", "test", nil, mods([]string{"ab", "ac", "not_this"})), []string{"ab", "ac"})
+}
+func TestLevenshteinNearMiss(t *testing.T) {
+	assertEqual(t, namesLike("libfoo", "test", nil, mods([]string{"libbar", "libfo", "libfoo_static", "libfao"})),
+		[]string{"libfao", "libfo"})
+}
+func TestLevenshteinWontGuessFarOff(t *testing.T) {
+	assertEqual(t, namesLike("missing", "test", nil, mods([]string{"taken", "single", "multi"})), []string{})
+}
+func TestLevenshteinLimitsGuesses(t *testing.T) {
+	assertEqual(t, namesLike("libfoo", "test", nil, mods([]string{"libfo", "libfoa", "libfob", "libfoc", "libfoo2"})),
+		[]string{"libfo", "libfoa", "libfob"})
+}
+func TestLevenshteinNamespace(t *testing.T) {
+	groups := mods([]string{"libfoa", "libfob"})
+	groups[0].namespace = &NamespaceMarker{}
+	assertEqual(t, namesLike("libfoo", "test", nil, groups), []string{"libfob"})
 }
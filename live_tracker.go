@@ -14,12 +14,22 @@
 
 package blueprint
 
-import "sync"
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+)
 
 // A liveTracker tracks the values of live variables, rules, and pools.  An
 // entity is made "live" when it is referenced directly or indirectly by a build
 // definition.  When an entity is made live its value is computed based on the
 // configuration.
+//
+// Each live entity is reference counted so that the dependencies added by a
+// build definition can be removed again with RemoveBuildDefDeps.  A reference is
+// counted for every time a build definition refers to an entity, and once for
+// each reference from the value of another live entity.
 type liveTracker struct {
 	sync.Mutex
 	config interface{} // Used to evaluate variable, rule, and pool values.
@@ -28,62 +38,91 @@ type liveTracker struct {
 	variables map[Variable]*ninjaString
 	pools     map[Pool]*poolDef
 	rules     map[Rule]*ruleDef
+
+	refCounts map[interface{}]int // Number of live references to each entity and build definition.
+
+	// set by Context.SetTrackLiveReferences
+	referrers map[interface{}]interface{} // The entity that first made each entity live.
 }
 
 func newLiveTracker(ctx *Context, config interface{}) *liveTracker {
-	return &liveTracker{
+	l := &liveTracker{
 		ctx:       ctx,
 		config:    config,
 		variables: make(map[Variable]*ninjaString),
 		pools:     make(map[Pool]*poolDef),
 		rules:     make(map[Rule]*ruleDef),
+		refCounts: make(map[interface{}]int),
+	}
+	if ctx.trackLiveReferences {
+		l.referrers = make(map[interface{}]interface{})
 	}
+	return l
 }
 
+// recordReferrer remembers the entity that caused entity to become live.  It does nothing unless
+// reference tracking is enabled.  It must be called with the lock held.
+func (l *liveTracker) recordReferrer(entity, referrer interface{}) {
+	if l.referrers == nil {
+		return
+	}
+	if _, exists := l.referrers[entity]; !exists {
+		l.referrers[entity] = referrer
+	}
+}
+
+// AddBuildDefDeps makes the rule, pool and variables referenced by def live.  It may be called
+// concurrently for different build definitions.  The lock is only held while looking up or
+// inserting a single entity, the values of newly live entities are computed without it so that
+// multiple goroutines can make entities live in parallel.  If two goroutines race to make the
+// same entity live the first insertion wins and the other value is discarded, which is safe
+// because values only depend on the configuration.
 func (l *liveTracker) AddBuildDefDeps(def *buildDef) error {
 	l.Lock()
-	defer l.Unlock()
+	l.refCounts[def]++
+	l.recordReferrer(def, nil)
+	l.Unlock()
 
-	ruleDef, err := l.innerAddRule(def.Rule)
+	ruleDef, err := l.innerAddRule(def.Rule, def)
 	if err != nil {
 		return err
 	}
 	def.RuleDef = ruleDef
 
-	err = l.innerAddNinjaStringListDeps(def.Outputs)
+	err = l.innerAddNinjaStringListDeps(def.Outputs, def)
 	if err != nil {
 		return err
 	}
 
-	err = l.innerAddNinjaStringListDeps(def.Inputs)
+	err = l.innerAddNinjaStringListDeps(def.Inputs, def)
 	if err != nil {
 		return err
 	}
 
-	err = l.innerAddNinjaStringListDeps(def.Implicits)
+	err = l.innerAddNinjaStringListDeps(def.Implicits, def)
 	if err != nil {
 		return err
 	}
 
-	err = l.innerAddNinjaStringListDeps(def.OrderOnly)
+	err = l.innerAddNinjaStringListDeps(def.OrderOnly, def)
 	if err != nil {
 		return err
 	}
 
-	err = l.innerAddNinjaStringListDeps(def.Validations)
+	err = l.innerAddNinjaStringListDeps(def.Validations, def)
 	if err != nil {
 		return err
 	}
 
 	for _, value := range def.Variables {
-		err = l.innerAddNinjaStringDeps(value)
+		err = l.innerAddNinjaStringDeps(value, def)
 		if err != nil {
 			return err
 		}
 	}
 
 	for _, value := range def.Args {
-		err = l.innerAddNinjaStringDeps(value)
+		err = l.innerAddNinjaStringDeps(value, def)
 		if err != nil {
 			return err
 		}
@@ -93,119 +132,186 @@ func (l *liveTracker) AddBuildDefDeps(def *buildDef) error {
 }
 
 func (l *liveTracker) addRule(r Rule) (def *ruleDef, err error) {
+	return l.innerAddRule(r, nil)
+}
+
+// lookupRule returns the definition of r and adds a reference to it if it is already live.
+func (l *liveTracker) lookupRule(r Rule) (*ruleDef, bool) {
 	l.Lock()
 	defer l.Unlock()
-	return l.innerAddRule(r)
+	def, ok := l.rules[r]
+	if ok {
+		l.refCounts[r]++
+	}
+	return def, ok
 }
 
-func (l *liveTracker) innerAddRule(r Rule) (def *ruleDef, err error) {
-	def, ok := l.rules[r]
-	if !ok {
-		def, err = r.def(l.config)
-		if err == errRuleIsBuiltin {
-			// No need to do anything for built-in rules.
-			return nil, nil
-		}
-		if err != nil {
-			return nil, err
-		}
+// insertRule makes r live with definition def unless another goroutine has already done so.  It
+// returns the live definition and whether def was inserted.
+func (l *liveTracker) insertRule(r Rule, def *ruleDef, referrer interface{}) (*ruleDef, bool) {
+	l.Lock()
+	defer l.Unlock()
+	l.refCounts[r]++
+	if existing, ok := l.rules[r]; ok {
+		return existing, false
+	}
+	l.rules[r] = def
+	l.recordReferrer(r, referrer)
+	return def, true
+}
 
-		if def.Pool != nil {
-			err = l.innerAddPool(def.Pool)
-			if err != nil {
-				return nil, err
-			}
-		}
+func (l *liveTracker) innerAddRule(r Rule, referrer interface{}) (*ruleDef, error) {
+	if def, ok := l.lookupRule(r); ok {
+		return def, nil
+	}
 
-		err = l.innerAddNinjaStringListDeps(def.CommandDeps)
+	def, err := r.def(l.config)
+	if err == errRuleIsBuiltin {
+		// No need to do anything for built-in rules.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	def, inserted := l.insertRule(r, def, referrer)
+	if !inserted {
+		return def, nil
+	}
+
+	if def.Pool != nil {
+		err = l.innerAddPool(def.Pool, r)
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	err = l.innerAddNinjaStringListDeps(def.CommandDeps, r)
+	if err != nil {
+		return nil, err
+	}
 
-		err = l.innerAddNinjaStringListDeps(def.CommandOrderOnly)
+	err = l.innerAddNinjaStringListDeps(def.CommandOrderOnly, r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range def.Variables {
+		err = l.innerAddNinjaStringDeps(value, r)
 		if err != nil {
 			return nil, err
 		}
-
-		for _, value := range def.Variables {
-			err = l.innerAddNinjaStringDeps(value)
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		l.rules[r] = def
 	}
 
-	return
+	return def, nil
 }
 
 func (l *liveTracker) addPool(p Pool) error {
+	return l.innerAddPool(p, nil)
+}
+
+// lookupPool adds a reference to p and returns true if it is already live.
+func (l *liveTracker) lookupPool(p Pool) bool {
 	l.Lock()
 	defer l.Unlock()
-	return l.addPool(p)
+	_, ok := l.pools[p]
+	if ok {
+		l.refCounts[p]++
+	}
+	return ok
 }
 
-func (l *liveTracker) innerAddPool(p Pool) error {
-	_, ok := l.pools[p]
-	if !ok {
-		def, err := p.def(l.config)
-		if err == errPoolIsBuiltin {
-			// No need to do anything for built-in rules.
-			return nil
-		}
-		if err != nil {
-			return err
-		}
+func (l *liveTracker) innerAddPool(p Pool, referrer interface{}) error {
+	if l.lookupPool(p) {
+		return nil
+	}
 
+	def, err := p.def(l.config)
+	if err == errPoolIsBuiltin {
+		// No need to do anything for built-in rules.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if def.Depth < 1 {
+		return fmt.Errorf("pool %s has depth %d, it must be at least 1", p, def.Depth)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.refCounts[p]++
+	if _, ok := l.pools[p]; !ok {
 		l.pools[p] = def
+		l.recordReferrer(p, referrer)
 	}
 
 	return nil
 }
 
 func (l *liveTracker) addVariable(v Variable) error {
+	return l.innerAddVariable(v, nil)
+}
+
+// lookupVariable adds a reference to v and returns true if it is already live.
+func (l *liveTracker) lookupVariable(v Variable) bool {
 	l.Lock()
 	defer l.Unlock()
-	return l.innerAddVariable(v)
+	_, ok := l.variables[v]
+	if ok {
+		l.refCounts[v]++
+	}
+	return ok
 }
 
-func (l *liveTracker) innerAddVariable(v Variable) error {
-	_, ok := l.variables[v]
-	if !ok {
-		ctx := &variableFuncContext{l.ctx}
+// insertVariable makes v live with the given value unless another goroutine has already done so.
+// It returns true if value was inserted.
+func (l *liveTracker) insertVariable(v Variable, value *ninjaString, referrer interface{}) bool {
+	l.Lock()
+	defer l.Unlock()
+	l.refCounts[v]++
+	if _, ok := l.variables[v]; ok {
+		return false
+	}
+	l.variables[v] = value
+	l.recordReferrer(v, referrer)
+	return true
+}
 
-		value, err := v.value(ctx, l.config)
-		if err == errVariableIsArg {
-			// This variable is a placeholder for an argument that can be passed
-			// to a rule.  It has no value and thus doesn't reference any other
-			// variables.
-			return nil
-		}
-		if err != nil {
-			return err
-		}
+func (l *liveTracker) innerAddVariable(v Variable, referrer interface{}) error {
+	if l.lookupVariable(v) {
+		return nil
+	}
 
-		l.variables[v] = value
+	ctx := &variableFuncContext{l.ctx}
 
-		err = l.innerAddNinjaStringDeps(value)
-		if err != nil {
-			return err
-		}
+	value, err := v.value(ctx, l.config)
+	if err == errVariableIsArg {
+		// This variable is a placeholder for an argument that can be passed
+		// to a rule.  It has no value and thus doesn't reference any other
+		// variables.
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
-	return nil
+	// The variable is inserted before its dependencies are added so that reference
+	// cycles between variables terminate.
+	if !l.insertVariable(v, value, referrer) {
+		return nil
+	}
+
+	return l.innerAddNinjaStringDeps(value, v)
 }
 
 func (l *liveTracker) addNinjaStringListDeps(list []*ninjaString) error {
-	l.Lock()
-	defer l.Unlock()
-	return l.innerAddNinjaStringListDeps(list)
+	return l.innerAddNinjaStringListDeps(list, nil)
 }
 
-func (l *liveTracker) innerAddNinjaStringListDeps(list []*ninjaString) error {
+func (l *liveTracker) innerAddNinjaStringListDeps(list []*ninjaString, referrer interface{}) error {
 	for _, str := range list {
-		err := l.innerAddNinjaStringDeps(str)
+		err := l.innerAddNinjaStringDeps(str, referrer)
 		if err != nil {
 			return err
 		}
@@ -214,14 +320,20 @@ func (l *liveTracker) innerAddNinjaStringListDeps(list []*ninjaString) error {
 }
 
 func (l *liveTracker) addNinjaStringDeps(str *ninjaString) error {
+	return l.innerAddNinjaStringDeps(str, nil)
+}
+
+// removeNinjaStringDeps releases the references added by a previous call to addNinjaStringDeps
+// for the same string.
+func (l *liveTracker) removeNinjaStringDeps(str *ninjaString) {
 	l.Lock()
 	defer l.Unlock()
-	return l.innerAddNinjaStringDeps(str)
+	l.innerReleaseNinjaStringDeps(str)
 }
 
-func (l *liveTracker) innerAddNinjaStringDeps(str *ninjaString) error {
+func (l *liveTracker) innerAddNinjaStringDeps(str *ninjaString, referrer interface{}) error {
 	for _, v := range str.Variables() {
-		err := l.innerAddVariable(v)
+		err := l.innerAddVariable(v, referrer)
 		if err != nil {
 			return err
 		}
@@ -229,6 +341,53 @@ func (l *liveTracker) innerAddNinjaStringDeps(str *ninjaString) error {
 	return nil
 }
 
+// resolveVariable evaluates v and the global variables it references under the config, without
+// making any of them live.  Variables that are already live use their live values.
+func (l *liveTracker) resolveVariable(v Variable) (string, error) {
+	ctx := &variableFuncContext{l.ctx}
+	values := make(map[Variable]*ninjaString)
+	visiting := make(map[Variable]bool)
+
+	var resolve func(v Variable) error
+	resolve = func(v Variable) error {
+		if _, ok := values[v]; ok {
+			return nil
+		}
+		if visiting[v] {
+			return fmt.Errorf("variable %s references itself", v)
+		}
+
+		l.Lock()
+		value, isLive := l.variables[v]
+		l.Unlock()
+		if !isLive {
+			var err error
+			value, err = v.value(ctx, l.config)
+			if err == errVariableIsArg {
+				return fmt.Errorf("variable %s is a rule argument and has no value", v)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		visiting[v] = true
+		for _, ref := range value.Variables() {
+			if err := resolve(ref); err != nil {
+				return err
+			}
+		}
+		delete(visiting, v)
+		values[v] = value
+		return nil
+	}
+
+	if err := resolve(v); err != nil {
+		return "", err
+	}
+	return values[v].Eval(values)
+}
+
 func (l *liveTracker) Eval(n *ninjaString) (string, error) {
 	l.Lock()
 	defer l.Unlock()
@@ -242,6 +401,7 @@ func (l *liveTracker) RemoveVariableIfLive(v Variable) bool {
 	_, isLive := l.variables[v]
 	if isLive {
 		delete(l.variables, v)
+		delete(l.refCounts, v)
 	}
 	return isLive
 }
@@ -253,6 +413,233 @@ func (l *liveTracker) RemoveRuleIfLive(r Rule) bool {
 	_, isLive := l.rules[r]
 	if isLive {
 		delete(l.rules, r)
+		delete(l.refCounts, r)
 	}
 	return isLive
 }
+
+// RemoveBuildDefDeps releases the references added by a previous call to AddBuildDefDeps for the
+// same build definition.  Any variable, rule or pool that is no longer referenced by another live
+// build definition or entity stops being live.  Entities removed with RemoveVariableIfLive or
+// RemoveRuleIfLive are dropped regardless of their reference count, but the references they hold
+// on other entities are kept since the removed entity is still written out as a local.
+// RemoveBuildDefDeps must not be called concurrently with AddBuildDefDeps.
+func (l *liveTracker) RemoveBuildDefDeps(def *buildDef) error {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.refCounts[def] == 0 {
+		return fmt.Errorf("build definition for %s is not live", def.Rule)
+	}
+	l.innerRelease(def)
+
+	l.innerReleaseRule(def.Rule)
+
+	l.innerReleaseNinjaStringListDeps(def.Outputs)
+	l.innerReleaseNinjaStringListDeps(def.Inputs)
+	l.innerReleaseNinjaStringListDeps(def.Implicits)
+	l.innerReleaseNinjaStringListDeps(def.OrderOnly)
+	l.innerReleaseNinjaStringListDeps(def.Validations)
+
+	for _, value := range def.Variables {
+		l.innerReleaseNinjaStringDeps(value)
+	}
+
+	for _, value := range def.Args {
+		l.innerReleaseNinjaStringDeps(value)
+	}
+
+	return nil
+}
+
+// innerRelease decrements the reference count of an entity, and returns true if the last
+// reference was released.  Entities that are not tracked, such as built-in rules and argument
+// variables, are ignored.
+func (l *liveTracker) innerRelease(entity interface{}) bool {
+	count, ok := l.refCounts[entity]
+	if !ok {
+		return false
+	}
+	if count > 1 {
+		l.refCounts[entity] = count - 1
+		return false
+	}
+	delete(l.refCounts, entity)
+	return true
+}
+
+func (l *liveTracker) innerReleaseRule(r Rule) {
+	def, ok := l.rules[r]
+	if !ok || !l.innerRelease(r) {
+		return
+	}
+	delete(l.rules, r)
+
+	if def.Pool != nil {
+		l.innerReleasePool(def.Pool)
+	}
+
+	l.innerReleaseNinjaStringListDeps(def.CommandDeps)
+	l.innerReleaseNinjaStringListDeps(def.CommandOrderOnly)
+
+	for _, value := range def.Variables {
+		l.innerReleaseNinjaStringDeps(value)
+	}
+}
+
+func (l *liveTracker) innerReleasePool(p Pool) {
+	if _, ok := l.pools[p]; ok && l.innerRelease(p) {
+		delete(l.pools, p)
+	}
+}
+
+func (l *liveTracker) innerReleaseVariable(v Variable) {
+	value, ok := l.variables[v]
+	if !ok || !l.innerRelease(v) {
+		return
+	}
+	delete(l.variables, v)
+
+	l.innerReleaseNinjaStringDeps(value)
+}
+
+func (l *liveTracker) innerReleaseNinjaStringListDeps(list []*ninjaString) {
+	for _, str := range list {
+		l.innerReleaseNinjaStringDeps(str)
+	}
+}
+
+func (l *liveTracker) innerReleaseNinjaStringDeps(str *ninjaString) {
+	for _, v := range str.Variables() {
+		l.innerReleaseVariable(v)
+	}
+}
+
+// checkRuleVariables returns an error for each variable referenced by a live rule that is neither
+// an argument of the rule, a Ninja built-in such as $in and $out, nor a live global variable.
+// Such references are normally rejected when the rule is parsed, so this is a consistency check
+// enabled by Context.SetValidateRuleVariables.
+func (l *liveTracker) checkRuleVariables() []error {
+	var errs []error
+	for _, rule := range l.liveRules() {
+		def := l.rules[rule]
+		if def == nil {
+			continue
+		}
+
+		names := make([]string, 0, len(def.Variables))
+		for name := range def.Variables {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		values := make([]*ninjaString, 0, len(names)+len(def.CommandDeps)+len(def.CommandOrderOnly))
+		for _, name := range names {
+			values = append(values, def.Variables[name])
+		}
+		values = append(values, def.CommandDeps...)
+		values = append(values, def.CommandOrderOnly...)
+
+		reported := make(map[Variable]bool)
+		for _, value := range values {
+			for _, v := range value.Variables() {
+				if reported[v] {
+					continue
+				}
+				if _, ok := v.(*argVariable); ok {
+					if rule.isArg(v.name()) || slices.Contains(builtinRuleArgs, v.name()) {
+						continue
+					}
+				} else if _, ok := l.variables[v]; ok {
+					continue
+				}
+				reported[v] = true
+				errs = append(errs, fmt.Errorf("rule %s references undefined variable %q", rule, v.name()))
+			}
+		}
+	}
+	return errs
+}
+
+// liveVariables returns a snapshot of the live variables sorted by package path and then name.
+func (l *liveTracker) liveVariables() []Variable {
+	l.Lock()
+	defer l.Unlock()
+
+	variables := make([]Variable, 0, len(l.variables))
+	for v := range l.variables {
+		variables = append(variables, v)
+	}
+	slices.SortFunc(variables, func(a, b Variable) int {
+		return compareLiveEntities(a.packageContext(), a.name(), b.packageContext(), b.name())
+	})
+	return variables
+}
+
+// liveRules returns a snapshot of the live rules sorted by package path and then name.
+func (l *liveTracker) liveRules() []Rule {
+	l.Lock()
+	defer l.Unlock()
+
+	rules := make([]Rule, 0, len(l.rules))
+	for r := range l.rules {
+		rules = append(rules, r)
+	}
+	slices.SortFunc(rules, func(a, b Rule) int {
+		return compareLiveEntities(a.packageContext(), a.name(), b.packageContext(), b.name())
+	})
+	return rules
+}
+
+// livePools returns a snapshot of the live pools sorted by package path and then name.
+func (l *liveTracker) livePools() []Pool {
+	l.Lock()
+	defer l.Unlock()
+
+	pools := make([]Pool, 0, len(l.pools))
+	for p := range l.pools {
+		pools = append(pools, p)
+	}
+	slices.SortFunc(pools, func(a, b Pool) int {
+		return compareLiveEntities(a.packageContext(), a.name(), b.packageContext(), b.name())
+	})
+	return pools
+}
+
+// compareLiveEntities orders ninja entities by the path of the package that defined them and then
+// by name.  Entities without a package, such as local variables, sort first.
+func compareLiveEntities(aPctx *packageContext, aName string, bPctx *packageContext, bName string) int {
+	var aPkgPath, bPkgPath string
+	if aPctx != nil {
+		aPkgPath = aPctx.pkgPath
+	}
+	if bPctx != nil {
+		bPkgPath = bPctx.pkgPath
+	}
+	return cmp.Or(cmp.Compare(aPkgPath, bPkgPath), cmp.Compare(aName, bName))
+}
+
+// VariableReferencePath returns the chain of entities that caused v to become live, starting with
+// the build definition (or the first rule or variable if v was made live outside of a build
+// definition) and ending with v.  Each entry is a *buildDef, Rule, Pool or Variable.  It returns
+// nil if v is not live or reference tracking was not enabled.
+func (l *liveTracker) VariableReferencePath(v Variable) []interface{} {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.referrers == nil {
+		return nil
+	}
+
+	var entity interface{} = v
+	if _, ok := l.referrers[entity]; !ok {
+		return nil
+	}
+
+	var path []interface{}
+	for entity != nil {
+		path = append(path, entity)
+		entity = l.referrers[entity]
+	}
+	slices.Reverse(path)
+	return path
+}
@@ -0,0 +1,625 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	liveTrackerTestPctx = NewPackageContext("github.com/google/blueprint/live_tracker_test")
+
+	liveTrackerTestUnusedVar = liveTrackerTestPctx.StaticVariable("unusedVar", "unused")
+	liveTrackerTestToolVar   = liveTrackerTestPctx.StaticVariable("toolVar", "tool")
+	liveTrackerTestFlagsVar  = liveTrackerTestPctx.StaticVariable("flagsVar", "-x ${toolVar}")
+	liveTrackerTestSharedVar = liveTrackerTestPctx.StaticVariable("sharedVar", "shared")
+	liveTrackerTestJobsVar   = liveTrackerTestPctx.VariableFunc("jobsVar", func(ctx VariableFuncContext, config interface{}) (string, error) {
+		return fmt.Sprintf("-j%d ${flagsVar}", config.(liveTrackerTestConfig).cpus), nil
+	})
+
+	liveTrackerTestCachedCalls, liveTrackerTestUncachedCalls atomic.Int32
+
+	liveTrackerTestCachedVar = liveTrackerTestPctx.CacheableVariableFunc("cachedVar", func(ctx VariableFuncContext, config interface{}) (string, error) {
+		liveTrackerTestCachedCalls.Add(1)
+		return fmt.Sprintf("-j%d", config.(liveTrackerTestConfig).cpus), nil
+	})
+	liveTrackerTestUncachedVar = liveTrackerTestPctx.VariableFunc("uncachedVar", func(ctx VariableFuncContext, config interface{}) (string, error) {
+		liveTrackerTestUncachedCalls.Add(1)
+		return fmt.Sprintf("-j%d", config.(liveTrackerTestConfig).cpus), nil
+	})
+
+	liveTrackerTestPool        = liveTrackerTestPctx.StaticPool("pool", PoolParams{Depth: 2})
+	liveTrackerTestInvalidPool = liveTrackerTestPctx.StaticPool("invalidPool", PoolParams{Depth: 0})
+	liveTrackerTestConfigPool  = liveTrackerTestPctx.PoolFunc("configPool", func(config interface{}) (PoolParams, error) {
+		return PoolParams{Depth: config.(liveTrackerTestConfig).cpus}, nil
+	})
+
+	liveTrackerTestRule = liveTrackerTestPctx.StaticRule("rule", RuleParams{
+		Command: "${toolVar} ${flagsVar} $in -o $out",
+		Pool:    liveTrackerTestPool,
+	})
+	liveTrackerTestArgsRule = liveTrackerTestPctx.StaticRule("argsRule", RuleParams{
+		Command: "${toolVar} $args $in -o $out",
+	}, "args")
+)
+
+// liveTrackerBenchVars is a set of variables for BenchmarkLiveTracker whose values reference each
+// other, so that making one live makes a chain of others live too.
+var liveTrackerBenchVars = func() []Variable {
+	var vars []Variable
+	for i := 0; i < 1000; i++ {
+		value := fmt.Sprintf("value%d", i)
+		if i > 0 {
+			value += fmt.Sprintf(" ${benchVar%d}", i-1)
+		}
+		vars = append(vars, liveTrackerTestPctx.StaticVariable(fmt.Sprintf("benchVar%d", i), value))
+	}
+	return vars
+}()
+
+type liveTrackerTestModule struct {
+	SimpleName
+}
+
+func newLiveTrackerTestModule() (Module, []interface{}) {
+	m := &liveTrackerTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *liveTrackerTestModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(liveTrackerTestPctx, BuildParams{
+		Rule:    liveTrackerTestRule,
+		Outputs: []string{ctx.ModuleName() + ".out"},
+		Inputs:  []string{ctx.ModuleName() + ".in"},
+	})
+}
+
+func prepareLiveTrackerTestContext(t *testing.T) *Context {
+	t.Helper()
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("live_tracker_test_module", newLiveTrackerTestModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			live_tracker_test_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors preparing build actions: %v", errs)
+	}
+
+	return ctx
+}
+
+func TestLiveEntities(t *testing.T) {
+	t.Run("not ready", func(t *testing.T) {
+		ctx := NewContext()
+		if _, err := ctx.LiveGlobalVariables(); !errors.Is(err, ErrBuildActionsNotReady) {
+			t.Errorf("LiveGlobalVariables: expected ErrBuildActionsNotReady, got %v", err)
+		}
+		if _, err := ctx.LiveRules(); !errors.Is(err, ErrBuildActionsNotReady) {
+			t.Errorf("LiveRules: expected ErrBuildActionsNotReady, got %v", err)
+		}
+		if _, err := ctx.LivePools(); !errors.Is(err, ErrBuildActionsNotReady) {
+			t.Errorf("LivePools: expected ErrBuildActionsNotReady, got %v", err)
+		}
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		ctx := prepareLiveTrackerTestContext(t)
+
+		variables, err := ctx.LiveGlobalVariables()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g, w := variables, []Variable{liveTrackerTestFlagsVar, liveTrackerTestToolVar}; !reflect.DeepEqual(g, w) {
+			t.Errorf("incorrect live variables:\nwant: %v\n got: %v", w, g)
+		}
+
+		rules, err := ctx.LiveRules()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g, w := rules, []Rule{liveTrackerTestRule}; !reflect.DeepEqual(g, w) {
+			t.Errorf("incorrect live rules:\nwant: %v\n got: %v", w, g)
+		}
+
+		pools, err := ctx.LivePools()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g, w := pools, []Pool{liveTrackerTestPool}; !reflect.DeepEqual(g, w) {
+			t.Errorf("incorrect live pools:\nwant: %v\n got: %v", w, g)
+		}
+	})
+}
+
+// runWithTimeout fails the test if f does not return within a generous timeout, which catches
+// deadlocks on the liveTracker mutex.
+func runWithTimeout(t *testing.T, name string, f func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%s did not return, possible deadlock", name)
+	}
+}
+
+func TestLiveTrackerAddPool(t *testing.T) {
+	ctx := NewContext()
+	l := newLiveTracker(ctx, nil)
+
+	def, err := parseBuildParams(liveTrackerTestPctx.getScope(), &BuildParams{
+		Rule:    liveTrackerTestRule,
+		Outputs: []string{"out"},
+		Inputs:  []string{"in"},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runWithTimeout(t, "AddBuildDefDeps", func() {
+		if err := l.AddBuildDefDeps(def); err != nil {
+			t.Error(err)
+		}
+	})
+
+	runWithTimeout(t, "addPool", func() {
+		if err := l.addPool(liveTrackerTestPool); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if _, ok := l.pools[liveTrackerTestPool]; !ok {
+		t.Errorf("expected pool %s to be live", liveTrackerTestPool)
+	}
+}
+
+type liveTrackerTestConfig struct {
+	cpus int
+}
+
+func TestLiveTrackerPoolDepth(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		l := newLiveTracker(NewContext(), liveTrackerTestConfig{})
+		err := l.addPool(liveTrackerTestInvalidPool)
+		want := "pool github.com/google/blueprint/live_tracker_test.invalidPool has depth 0, it must be at least 1"
+		if err == nil || err.Error() != want {
+			t.Errorf("expected error %q, got %v", want, err)
+		}
+	})
+
+	t.Run("from config", func(t *testing.T) {
+		l := newLiveTracker(NewContext(), liveTrackerTestConfig{cpus: 8})
+		if err := l.addPool(liveTrackerTestConfigPool); err != nil {
+			t.Fatal(err)
+		}
+		if g, w := l.pools[liveTrackerTestConfigPool].Depth, 8; g != w {
+			t.Errorf("expected depth %d, got %d", w, g)
+		}
+	})
+
+	t.Run("invalid from config", func(t *testing.T) {
+		l := newLiveTracker(NewContext(), liveTrackerTestConfig{cpus: -1})
+		err := l.addPool(liveTrackerTestConfigPool)
+		want := "pool github.com/google/blueprint/live_tracker_test.configPool has depth -1, it must be at least 1"
+		if err == nil || err.Error() != want {
+			t.Errorf("expected error %q, got %v", want, err)
+		}
+	})
+
+	t.Run("console", func(t *testing.T) {
+		l := newLiveTracker(NewContext(), liveTrackerTestConfig{})
+		if err := l.addPool(Console); err != nil {
+			t.Errorf("unexpected error for the console pool: %v", err)
+		}
+		if _, ok := l.pools[Console]; ok {
+			t.Errorf("expected the built-in console pool not to be defined")
+		}
+	})
+}
+
+func TestLiveTrackerConcurrentAdds(t *testing.T) {
+	ctx := NewContext()
+	l := newLiveTracker(ctx, nil)
+
+	runWithTimeout(t, "concurrent adds", func() {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 50; i++ {
+			wg.Add(3)
+			go func() {
+				defer wg.Done()
+				if _, err := l.addRule(liveTrackerTestRule); err != nil {
+					t.Error(err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				if err := l.addPool(liveTrackerTestPool); err != nil {
+					t.Error(err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				if err := l.addVariable(liveTrackerTestFlagsVar); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	if g, w := l.liveVariables(), []Variable{liveTrackerTestFlagsVar, liveTrackerTestToolVar}; !reflect.DeepEqual(g, w) {
+		t.Errorf("incorrect live variables:\nwant: %v\n got: %v", w, g)
+	}
+	if g, w := l.liveRules(), []Rule{liveTrackerTestRule}; !reflect.DeepEqual(g, w) {
+		t.Errorf("incorrect live rules:\nwant: %v\n got: %v", w, g)
+	}
+	if g, w := l.livePools(), []Pool{liveTrackerTestPool}; !reflect.DeepEqual(g, w) {
+		t.Errorf("incorrect live pools:\nwant: %v\n got: %v", w, g)
+	}
+}
+
+func TestLiveVariableReferencePath(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetTrackLiveReferences(true)
+	ctx.RegisterModuleType("live_tracker_test_module", newLiveTrackerTestModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			live_tracker_test_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors preparing build actions: %v", errs)
+	}
+
+	path, err := ctx.LiveVariableReferencePath(liveTrackerTestFlagsVar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`module "A"`,
+		"build A.out",
+		"rule g.live_tracker_test.rule",
+		"variable g.live_tracker_test.flagsVar",
+	}
+	if !reflect.DeepEqual(path, want) {
+		t.Errorf("incorrect reference path:\nwant: %q\n got: %q", want, path)
+	}
+
+	path, err = ctx.LiveVariableReferencePath(liveTrackerTestToolVar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// toolVar is referenced directly by the rule's command before flagsVar is evaluated.
+	want = []string{
+		`module "A"`,
+		"build A.out",
+		"rule g.live_tracker_test.rule",
+		"variable g.live_tracker_test.toolVar",
+	}
+	if !reflect.DeepEqual(path, want) {
+		t.Errorf("incorrect reference path:\nwant: %q\n got: %q", want, path)
+	}
+
+	if _, err := ctx.LiveVariableReferencePath(liveTrackerTestUnusedVar); err == nil {
+		t.Errorf("expected error for variable that is not live")
+	}
+
+	untracked := prepareLiveTrackerTestContext(t)
+	if _, err := untracked.LiveVariableReferencePath(liveTrackerTestFlagsVar); err == nil {
+		t.Errorf("expected error when reference tracking is disabled")
+	}
+}
+
+func TestLiveTrackerRemoveBuildDefDeps(t *testing.T) {
+	ctx := NewContext()
+	l := newLiveTracker(ctx, nil)
+
+	newDef := func(out string) *buildDef {
+		t.Helper()
+		def, err := parseBuildParams(liveTrackerTestPctx.getScope(), &BuildParams{
+			Rule:      liveTrackerTestRule,
+			Outputs:   []string{out},
+			Inputs:    []string{"in"},
+			Implicits: []string{"${sharedVar}"},
+		}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return def
+	}
+
+	def1 := newDef("out1")
+	def2 := newDef("out2")
+
+	if err := l.RemoveBuildDefDeps(def1); err == nil {
+		t.Errorf("expected error removing build definition that was not added")
+	}
+
+	for _, def := range []*buildDef{def1, def2} {
+		if err := l.AddBuildDefDeps(def); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	allVariables := []Variable{liveTrackerTestFlagsVar, liveTrackerTestSharedVar, liveTrackerTestToolVar}
+
+	if err := l.RemoveBuildDefDeps(def1); err != nil {
+		t.Fatal(err)
+	}
+	if g, w := l.liveVariables(), allVariables; !reflect.DeepEqual(g, w) {
+		t.Errorf("incorrect live variables after removing one build definition:\nwant: %v\n got: %v", w, g)
+	}
+	if g, w := l.liveRules(), []Rule{liveTrackerTestRule}; !reflect.DeepEqual(g, w) {
+		t.Errorf("incorrect live rules after removing one build definition:\nwant: %v\n got: %v", w, g)
+	}
+	if g, w := l.livePools(), []Pool{liveTrackerTestPool}; !reflect.DeepEqual(g, w) {
+		t.Errorf("incorrect live pools after removing one build definition:\nwant: %v\n got: %v", w, g)
+	}
+
+	if err := l.RemoveBuildDefDeps(def1); err == nil {
+		t.Errorf("expected error removing build definition twice")
+	}
+
+	if err := l.RemoveBuildDefDeps(def2); err != nil {
+		t.Fatal(err)
+	}
+	if g := l.liveVariables(); len(g) != 0 {
+		t.Errorf("expected no live variables, got %v", g)
+	}
+	if g := l.liveRules(); len(g) != 0 {
+		t.Errorf("expected no live rules, got %v", g)
+	}
+	if g := l.livePools(); len(g) != 0 {
+		t.Errorf("expected no live pools, got %v", g)
+	}
+
+	// A variable that is also referenced by another live variable stays live.
+	if err := l.AddBuildDefDeps(def1); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.addVariable(liveTrackerTestToolVar); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.RemoveBuildDefDeps(def1); err != nil {
+		t.Fatal(err)
+	}
+	if g, w := l.liveVariables(), []Variable{liveTrackerTestToolVar}; !reflect.DeepEqual(g, w) {
+		t.Errorf("incorrect live variables:\nwant: %v\n got: %v", w, g)
+	}
+}
+
+// BenchmarkLiveTracker adds the dependencies of a synthetic graph of 50k build definitions to a
+// liveTracker, either from a single goroutine or from one goroutine per CPU.
+func BenchmarkLiveTracker(b *testing.B) {
+	const numDefs = 50000
+
+	defs := make([]*buildDef, numDefs)
+	for i := range defs {
+		def, err := parseBuildParams(liveTrackerTestPctx.getScope(), &BuildParams{
+			Rule:      liveTrackerTestRule,
+			Outputs:   []string{fmt.Sprintf("out/%d", i)},
+			Inputs:    []string{fmt.Sprintf("${benchVar%d}/in", i%len(liveTrackerBenchVars))},
+			Implicits: []string{"${sharedVar}"},
+		}, nil, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defs[i] = def
+	}
+
+	run := func(b *testing.B, goroutines int) {
+		for n := 0; n < b.N; n++ {
+			l := newLiveTracker(NewContext(), nil)
+			wg := sync.WaitGroup{}
+			for g := 0; g < goroutines; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := g; i < len(defs); i += goroutines {
+						if err := l.AddBuildDefDeps(defs[i]); err != nil {
+							b.Error(err)
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+		}
+	}
+
+	b.Run("serial", func(b *testing.B) {
+		run(b, 1)
+	})
+	b.Run("parallel", func(b *testing.B) {
+		run(b, runtime.GOMAXPROCS(0))
+	})
+}
+
+func TestLiveTrackerCheckRuleVariables(t *testing.T) {
+	t.Run("defined", func(t *testing.T) {
+		ctx := NewContext()
+		ctx.SetValidateRuleVariables(true)
+		ctx.RegisterModuleType("live_tracker_test_module", newLiveTrackerTestModule)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				live_tracker_test_module {
+				    name: "A",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		expectedErrors(t, errs)
+	})
+
+	t.Run("undefined", func(t *testing.T) {
+		// A rule definition parsed in a scope with an extra argument that the rule doesn't declare.
+		scope := makeRuleScope(liveTrackerTestPctx.getScope(), map[string]bool{"args": true, "extra": true})
+		def, err := parseRuleParams(scope, &RuleParams{
+			Command:     "${toolVar} $args $extra $in -o $out",
+			Description: "${flagsVar} $extra",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l := newLiveTracker(NewContext(), nil)
+		l.rules[liveTrackerTestArgsRule] = def
+		l.variables[liveTrackerTestToolVar] = simpleNinjaString("tool")
+
+		expectedErrors(t, l.checkRuleVariables(),
+			`rule github.com/google/blueprint/live_tracker_test.argsRule references undefined variable "extra"`,
+			`rule github.com/google/blueprint/live_tracker_test.argsRule references undefined variable "flagsVar"`)
+	})
+}
+
+func TestResolveVariable(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("live_tracker_test_module", newLiveTrackerTestModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			live_tracker_test_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	if _, err := ctx.ResolveVariable(liveTrackerTestToolVar); !errors.Is(err, ErrDependenciesNotReady) {
+		t.Errorf("expected ErrDependenciesNotReady, got %v", err)
+	}
+
+	config := liveTrackerTestConfig{cpus: 4}
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", config)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(config)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	testCases := []struct {
+		name     string
+		variable Variable
+		want     string
+	}{
+		{"live static", liveTrackerTestFlagsVar, "-x tool"},
+		{"unused static", liveTrackerTestUnusedVar, "unused"},
+		{"func", liveTrackerTestJobsVar, "-j4 -x tool"},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ctx.ResolveVariable(tt.variable)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %s to be %q, got %q", tt.variable, tt.want, got)
+			}
+		})
+	}
+
+	live, err := ctx.LiveGlobalVariables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range live {
+		if v == liveTrackerTestUnusedVar || v == liveTrackerTestJobsVar {
+			t.Errorf("expected ResolveVariable to not make %s live", v)
+		}
+	}
+}
+
+func TestCacheableVariableFunc(t *testing.T) {
+	liveTrackerTestCachedCalls.Store(0)
+	liveTrackerTestUncachedCalls.Store(0)
+
+	ctx := NewContext()
+	config := liveTrackerTestConfig{cpus: 4}
+	for i := 0; i < 3; i++ {
+		l := newLiveTracker(ctx, config)
+		for _, v := range []Variable{liveTrackerTestCachedVar, liveTrackerTestUncachedVar} {
+			if err := l.addVariable(v); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := l.Eval(l.variables[v]); err != nil || got != "-j4" {
+				t.Errorf("expected %s to be %q, got %q (%v)", v, "-j4", got, err)
+			}
+		}
+	}
+
+	if got := liveTrackerTestCachedCalls.Load(); got != 1 {
+		t.Errorf("expected the cacheable function to be called once, got %d", got)
+	}
+	if got := liveTrackerTestUncachedCalls.Load(); got != 3 {
+		t.Errorf("expected the uncached function to be called 3 times, got %d", got)
+	}
+
+	// A different config has its own value.
+	l := newLiveTracker(ctx, liveTrackerTestConfig{cpus: 8})
+	if err := l.addVariable(liveTrackerTestCachedVar); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := l.Eval(l.variables[liveTrackerTestCachedVar]); err != nil || got != "-j8" {
+		t.Errorf("expected %s to be %q, got %q (%v)", liveTrackerTestCachedVar, "-j8", got, err)
+	}
+	if got := liveTrackerTestCachedCalls.Load(); got != 2 {
+		t.Errorf("expected the cacheable function to be called again for a new config, got %d calls", got)
+	}
+
+	// Each Context has its own cache.
+	l = newLiveTracker(NewContext(), config)
+	if err := l.addVariable(liveTrackerTestCachedVar); err != nil {
+		t.Fatal(err)
+	}
+	if got := liveTrackerTestCachedCalls.Load(); got != 3 {
+		t.Errorf("expected the cacheable function to be called again for a new Context, got %d calls", got)
+	}
+}
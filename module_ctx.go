@@ -17,6 +17,7 @@ package blueprint
 import (
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"text/scanner"
@@ -169,6 +170,15 @@ type EarlyModuleContext interface {
 	// OtherModulePropertyErrorf reports an error at the line number of a property in the given module definition.
 	OtherModulePropertyErrorf(logicModule Module, property string, format string, args ...interface{})
 
+	// ModuleWarningf reports a warning in the given category at the line number of the module type
+	// in the module definition.  Warnings don't fail the build, they are returned by
+	// Context.Warnings.
+	ModuleWarningf(category, fmt string, args ...interface{})
+
+	// PropertyWarningf reports a warning in the given category at the line number of a property in
+	// the module definition, see ModuleWarningf.
+	PropertyWarningf(property, category, fmt string, args ...interface{})
+
 	// Failed returns true if any errors have been reported.  In most cases the module can continue with generating
 	// build rules after an error, allowing it to report additional errors in a single run, but in cases where the error
 	// has prevented the module from creating necessary data it can return early when Failed returns true.
@@ -180,6 +190,11 @@ type EarlyModuleContext interface {
 	// dependencies to rerun the primary builder whenever a file matching
 	// the pattern as added or removed, without rerunning if a file that
 	// does not match the pattern is added to a searched directory.
+	//
+	// Module types that glob their sources conventionally expose the
+	// excludes as an "exclude_srcs" property next to "srcs".  An exclude
+	// ending in "/**" excludes everything beneath the matching directories
+	// and stops recursive globs from searching them.
 	GlobWithDeps(pattern string, excludes []string) ([]string, error)
 
 	// Fs returns a pathtools.Filesystem that can be used to interact with files.  Using the Filesystem interface allows
@@ -221,6 +236,17 @@ type BaseModuleContext interface {
 	// invalidated by future mutators.
 	VisitDirectDeps(visit func(Module))
 
+	// VisitDirectDependents calls visit for each module variant that has a direct dependency on this variant of the
+	// module, sorted by name and variant.  It will be called only once for each dependent, even if it has multiple
+	// dependencies on the module.  The dependents are only known once all mutators have run, so it panics if called
+	// from a mutator.
+	//
+	// The Module passed to the visit function should not be retained outside of the visit function.
+	VisitDirectDependents(visit func(Module))
+
+	// DirectDependents returns the module variants visited by VisitDirectDependents.
+	DirectDependents() []Module
+
 	// VisitDirectDepsIf calls pred for each direct dependency, and if pred returns true calls visit.  If there are
 	// multiple direct dependencies on the same module pred and visit will be called multiple times on that module and
 	// OtherModuleDependencyTag will return a different tag for each.
@@ -252,6 +278,9 @@ type BaseModuleContext interface {
 	// be called multiple times for the same (child, parent) pair if there are multiple direct dependencies between the
 	// child and parent with different tags.  OtherModuleDependencyTag will return the tag for the currently visited
 	// (child, parent) pair.  If visit returns false WalkDeps will not continue recursing down to child.
+	// Pruning only applies to the current path; if child is reachable through another parent it will be visited
+	// again, and recursed into if visit returns true for that path.  Each dependency is recursed into at most once,
+	// so the walk always terminates.
 	//
 	// The Modules passed to the visit function should not be retained outside of the visit function, they may be
 	// invalidated by future mutators.
@@ -301,6 +330,11 @@ type BaseModuleContext interface {
 	// dependencies on the module being visited, it returns the dependency tag used for the current dependency.
 	OtherModuleDependencyTag(m Module) DependencyTag
 
+	// DependencyTagData returns the data attached to the dependency tag used to depend on a module, as returned by
+	// OtherModuleDependencyTag, if the tag implements DataDependencyTag.  It returns nil if there is no dependency on
+	// the module or the tag does not implement DataDependencyTag.  The returned map must not be modified.
+	DependencyTagData(m Module) map[string]string
+
 	// OtherModuleExists returns true if a module with the specified name exists, as determined by the NameInterface
 	// passed to Context.SetNameInterface, or SimpleNameInterface if it was not called.
 	OtherModuleExists(name string) bool
@@ -361,6 +395,10 @@ type BaseModuleContext interface {
 
 	EarlyGetMissingDependencies() []string
 
+	// MissingOptionalDeps returns the names passed to AddOptionalDependency on this variant of the
+	// module, or on the variant it was created from, that didn't match any module.
+	MissingOptionalDeps() []string
+
 	base() *baseModuleContext
 }
 
@@ -383,6 +421,17 @@ type ModuleContext interface {
 	// Build creates a new ninja build statement.
 	Build(pctx PackageContext, params BuildParams)
 
+	// Phony creates a ninja build statement using the built-in phony rule that makes name depend
+	// on deps.  Phony targets are not scoped to the module: if multiple modules declare a phony
+	// target with the same name they are merged into a single build statement that depends on the
+	// union of their deps.
+	Phony(name string, deps ...string)
+
+	// AddDefault adds outputs to the targets that ninja builds when it is run without any targets.
+	// The outputs added by all modules are deduplicated, sorted and written in a single default
+	// statement at the end of the ninja file.
+	AddDefault(outputs ...string)
+
 	// GetMissingDependencies returns the list of dependencies that were passed to AddDependencies or related methods,
 	// but do not exist.  It can be used with Context.SetAllowMissingDependencies to allow the primary builder to
 	// handle missing dependencies on its own instead of having Blueprint treat them as an error.
@@ -474,6 +523,14 @@ func (d *baseModuleContext) OtherModulePropertyErrorf(logicModule Module, proper
 	d.error(d.context.PropertyErrorf(logicModule, property, format, args...))
 }
 
+func (d *baseModuleContext) ModuleWarningf(category, format string, args ...interface{}) {
+	d.context.warn(d.context.moduleWarning(d.module, scanner.Position{}, category, format, args...))
+}
+
+func (d *baseModuleContext) PropertyWarningf(property, category, format string, args ...interface{}) {
+	d.context.warn(d.context.moduleWarning(d.module, d.module.propertyPos[property], category, format, args...))
+}
+
 func (d *baseModuleContext) Failed() bool {
 	return len(d.errs) > 0
 }
@@ -548,6 +605,13 @@ func (m *baseModuleContext) OtherModuleDependencyTag(logicModule Module) Depende
 	return nil
 }
 
+func (m *baseModuleContext) DependencyTagData(logicModule Module) map[string]string {
+	if tag, ok := m.OtherModuleDependencyTag(logicModule).(DataDependencyTag); ok {
+		return tag.DependencyTagData()
+	}
+	return nil
+}
+
 func (m *baseModuleContext) ModuleFromName(name string) (Module, bool) {
 	moduleGroup, exists := m.context.nameInterface.ModuleFromName(name, m.module.namespace())
 	if exists {
@@ -599,6 +663,12 @@ func (m *baseModuleContext) OtherModuleReverseDependencyVariantExists(name strin
 
 func (m *baseModuleContext) OtherModuleProvider(logicModule Module, provider AnyProviderKey) (any, bool) {
 	module := m.context.moduleInfo[logicModule]
+	if module != m.module {
+		if m.module.providerReads == nil {
+			m.module.providerReads = make(map[*moduleInfo]bool)
+		}
+		m.module.providerReads[module] = true
+	}
 	return m.context.provider(module, provider.provider())
 }
 
@@ -657,6 +727,23 @@ func (m *baseModuleContext) VisitDirectDeps(visit func(Module)) {
 	m.visitingDep = depInfo{}
 }
 
+func (m *baseModuleContext) VisitDirectDependents(visit func(Module)) {
+	if !m.context.dependenciesReady {
+		panic(fmt.Errorf("VisitDirectDependents called for %s before all mutators have run", m.module))
+	}
+	for _, dependent := range m.module.directDependents {
+		visit(dependent.logicModule)
+	}
+}
+
+func (m *baseModuleContext) DirectDependents() []Module {
+	var dependents []Module
+	m.VisitDirectDependents(func(dependent Module) {
+		dependents = append(dependents, dependent)
+	})
+	return dependents
+}
+
 func (m *baseModuleContext) VisitDirectDepsIf(pred func(Module) bool, visit func(Module)) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -790,7 +877,7 @@ func (m *moduleContext) Rule(pctx PackageContext, name string,
 func (m *moduleContext) Build(pctx PackageContext, params BuildParams) {
 	m.scope.ReparentTo(pctx)
 
-	def, err := parseBuildParams(m.scope, &params, m.ModuleTags())
+	def, err := parseBuildParams(m.scope, &params, m.ModuleTags(), m.config)
 	if err != nil {
 		panic(err)
 	}
@@ -798,6 +885,22 @@ func (m *moduleContext) Build(pctx PackageContext, params BuildParams) {
 	m.actionDefs.buildDefs = append(m.actionDefs.buildDefs, def)
 }
 
+func (m *moduleContext) Phony(name string, deps ...string) {
+	if name == "" {
+		m.ModuleErrorf("phony target name must not be empty")
+		return
+	}
+
+	m.actionDefs.phonys = append(m.actionDefs.phonys, phonyDef{
+		name: name,
+		deps: slices.Clone(deps),
+	})
+}
+
+func (m *moduleContext) AddDefault(outputs ...string) {
+	m.actionDefs.defaults = append(m.actionDefs.defaults, outputs...)
+}
+
 func (m *moduleContext) GetMissingDependencies() []string {
 	m.handledMissingDeps = true
 	return m.module.missingDeps
@@ -807,6 +910,10 @@ func (m *baseModuleContext) EarlyGetMissingDependencies() []string {
 	return m.module.missingDeps
 }
 
+func (m *baseModuleContext) MissingOptionalDeps() []string {
+	return m.module.missingOptionalDeps
+}
+
 //
 // MutatorContext
 //
@@ -817,12 +924,31 @@ type mutatorContext struct {
 	reverseDeps      []reverseDep
 	rename           []rename
 	replace          []replace
+	nameAliases      []nameAlias
 	newVariations    modulesOrAliases // new variants of existing modules
 	newModules       []*moduleInfo    // brand new modules
 	defaultVariation *string
 	pauseCh          chan<- pauseSpec
 }
 
+// MissingVariantFallback selects what AddFarVariationDependenciesWithFallback does when a
+// dependency has no variant that matches the requested variations.
+type MissingVariantFallback int
+
+const (
+	// MissingVariantError reports an error listing the available variants, as
+	// AddFarVariationDependencies does.
+	MissingVariantError MissingVariantFallback = iota
+
+	// MissingVariantSkip doesn't add the dependency and doesn't report an error.
+	MissingVariantSkip
+
+	// MissingVariantNearest adds a dependency on the variant that matches the most requested
+	// variations, using the first one in variant order if there is a tie.  It reports an error
+	// if no variant matches any of them.
+	MissingVariantNearest
+)
+
 type BaseMutatorContext interface {
 	BaseModuleContext
 
@@ -840,6 +966,14 @@ type TopDownMutatorContext interface {
 	// CreateModule creates a new module by calling the factory method for the specified moduleType, and applies
 	// the specified property structs to it as if the properties were set in a blueprint file.
 	CreateModule(ModuleFactory, string, ...interface{}) Module
+
+	// CreateAlias makes dependencies on the name alias resolve to the module named target, which
+	// may itself be an alias.  The alias is only visible to modules in the namespace of this
+	// module, and the target is resolved in that namespace.  The alias is not visible to
+	// AddDependency until after this mutator pass is complete.  It is an error for the alias to
+	// have the same name as a module, including one created by a later mutator, or to form a cycle
+	// of aliases.
+	CreateAlias(alias, target string)
 }
 
 type BottomUpMutatorContext interface {
@@ -855,6 +989,21 @@ type BottomUpMutatorContext interface {
 	// be ordered correctly for all future mutator passes.
 	AddDependency(module Module, tag DependencyTag, name ...string) []Module
 
+	// AddDependencyIf adds a dependency from the current module to the module with the given name
+	// if cond returns true.  cond is called exactly once, when AddDependencyIf is called, so it may
+	// depend on state such as the config that is only available while mutators are running.  It
+	// returns the new dependency as AddDependency would, or nil if cond returned false, in which
+	// case no dependency is added.  Errors from adding the dependency are reported as they would be
+	// by AddDependency.
+	AddDependencyIf(tag DependencyTag, name string, cond func() bool) Module
+
+	// AddOptionalDependency adds a dependency from the current module to the module with the given
+	// name as AddDependency would, unless no module with that name exists, in which case no
+	// dependency is added and the name is recorded for MissingOptionalDeps instead of being reported
+	// as an error.  A module with the name that exists but has no matching variant is still an
+	// error.  It returns the new dependency, or nil if the module doesn't exist.
+	AddOptionalDependency(tag DependencyTag, name string) Module
+
 	// AddReverseDependency adds a dependency from the destination to the given module.
 	// Does not affect the ordering of the current mutator pass, but will be ordered
 	// correctly for all future mutator passes.  All reverse dependencies for a destination module are
@@ -890,6 +1039,13 @@ type BottomUpMutatorContext interface {
 
 	// SetDefaultDependencyVariation sets the default variation when a dangling reference is detected
 	// during the subsequent calls on Create*Variations* functions. To reset, set it to nil.
+	//
+	// When a dependency of the current module was split into variants by this mutator, each new
+	// variant of the current module depends on the variant of the dependency with the same
+	// variation.  If the dependency has no such variant, the variant with the default variation is
+	// used instead of reporting an error.  To make dependencies that don't specify a variation for
+	// this mutator use one variant of a module from modules that are not split, use AliasVariation
+	// on the module instead.
 	SetDefaultDependencyVariation(*string)
 
 	// AddVariationDependencies adds deps as dependencies of the current module, but uses the variations
@@ -918,6 +1074,25 @@ type BottomUpMutatorContext interface {
 	// be ordered correctly for all future mutator passes.
 	AddFarVariationDependencies([]Variation, DependencyTag, ...string) []Module
 
+	// AddFarVariationDependenciesWithFallback adds deps as dependencies of the current module like
+	// AddFarVariationDependencies, but fallback selects what happens when a dependency has no
+	// variant that matches the variations argument.  It returns the dependencies as
+	// AddFarVariationDependencies would, and whether a variant of each dependency was found and
+	// added.  A dependency on a module that doesn't exist is always an error.
+	AddFarVariationDependenciesWithFallback(variations []Variation, fallback MissingVariantFallback,
+		tag DependencyTag, deps ...string) ([]Module, []bool)
+
+	// AddSingleVariantDependency adds a dependency of the current module on the only variant of the
+	// module with the given name, ignoring the variations of both modules.  It reports an error
+	// listing the available variants if the module has more than one variant.  It returns the new
+	// dependency, or nil if it was not added.
+	//
+	// If the mutator is parallel (see MutatorHandle.Parallel), this method will pause until the
+	// new dependency has had the current mutator called on it.  If the mutator is not parallel
+	// this method does not affect the ordering of the current mutator pass, but will be ordered
+	// correctly for all future mutator passes.
+	AddSingleVariantDependency(tag DependencyTag, name string) Module
+
 	// AddInterVariantDependency adds a dependency between two variants of the same module.  Variants are always
 	// ordered in the same order as they were listed in CreateVariations, and AddInterVariantDependency does not change
 	// that ordering, but it associates a DependencyTag with the dependency and makes it visible to VisitDirectDeps,
@@ -926,7 +1101,9 @@ type BottomUpMutatorContext interface {
 
 	// ReplaceDependencies finds all the variants of the module with the specified name, then
 	// replaces all dependencies onto those variants with the current variant of this module.
-	// Replacements don't take effect until after the mutator pass is finished.
+	// Replacements don't take effect until after the mutator pass is finished, and the tags of
+	// the replaced dependencies are preserved.  It reports an error if no module with the
+	// specified name exists.
 	ReplaceDependencies(string)
 
 	// ReplaceDependenciesIf finds all the variants of the module with the specified name, then
@@ -988,6 +1165,16 @@ func (BaseDependencyTag) dependencyTag(DependencyTag) {
 
 var _ DependencyTag = BaseDependencyTag{}
 
+// DataDependencyTag can be implemented by a DependencyTag to attach structured data, for example a
+// link type or priority, to a dependency.  The data can be read back with
+// BaseModuleContext.DependencyTagData while visiting the dependency.  Tags are compared by value,
+// so DependencyTagData should usually build the map from comparable fields of the tag rather than
+// storing a map in the tag.
+type DataDependencyTag interface {
+	DependencyTag
+	DependencyTagData() map[string]string
+}
+
 func (mctx *mutatorContext) MutatorName() string {
 	return mctx.mutator.name
 }
@@ -1129,6 +1316,22 @@ func (mctx *mutatorContext) AddDependency(module Module, tag DependencyTag, deps
 	return depInfos
 }
 
+func (mctx *mutatorContext) AddDependencyIf(tag DependencyTag, name string, cond func() bool) Module {
+	if !cond() {
+		return nil
+	}
+	return mctx.AddDependency(mctx.module.logicModule, tag, name)[0]
+}
+
+func (mctx *mutatorContext) AddOptionalDependency(tag DependencyTag, name string) Module {
+	// A name that is both an alias and a module is not missing, AddDependency reports the error.
+	if group, err := mctx.context.dependencyGroupFromName(name, mctx.module.namespace()); group == nil && err == nil {
+		mctx.module.missingOptionalDeps = append(mctx.module.missingOptionalDeps, name)
+		return nil
+	}
+	return mctx.AddDependency(mctx.module.logicModule, tag, name)[0]
+}
+
 func (mctx *mutatorContext) AddReverseDependency(module Module, tag DependencyTag, destName string) {
 	if _, ok := tag.(BaseDependencyTag); ok {
 		panic("BaseDependencyTag is not allowed to be used directly!")
@@ -1151,7 +1354,7 @@ func (mctx *mutatorContext) AddVariationDependencies(variations []Variation, tag
 
 	depInfos := make([]Module, 0, len(deps))
 	for _, dep := range deps {
-		depInfo, errs := mctx.context.addVariationDependency(mctx.module, mctx.config, variations, tag, dep, false)
+		depInfo, errs := mctx.context.addVariationDependency(mctx.module, mctx.config, variations, tag, dep, false, MissingVariantError)
 		if len(errs) > 0 {
 			mctx.errs = append(mctx.errs, errs...)
 		}
@@ -1169,7 +1372,7 @@ func (mctx *mutatorContext) AddFarVariationDependencies(variations []Variation,
 
 	depInfos := make([]Module, 0, len(deps))
 	for _, dep := range deps {
-		depInfo, errs := mctx.context.addVariationDependency(mctx.module, mctx.config, variations, tag, dep, true)
+		depInfo, errs := mctx.context.addVariationDependency(mctx.module, mctx.config, variations, tag, dep, true, MissingVariantError)
 		if len(errs) > 0 {
 			mctx.errs = append(mctx.errs, errs...)
 		}
@@ -1182,6 +1385,38 @@ func (mctx *mutatorContext) AddFarVariationDependencies(variations []Variation,
 	return depInfos
 }
 
+func (mctx *mutatorContext) AddFarVariationDependenciesWithFallback(variations []Variation,
+	fallback MissingVariantFallback, tag DependencyTag, deps ...string) ([]Module, []bool) {
+
+	depInfos := make([]Module, 0, len(deps))
+	resolved := make([]bool, 0, len(deps))
+	for _, dep := range deps {
+		depInfo, errs := mctx.context.addVariationDependency(mctx.module, mctx.config, variations, tag, dep, true, fallback)
+		if len(errs) > 0 {
+			mctx.errs = append(mctx.errs, errs...)
+		}
+		resolved = append(resolved, depInfo != nil)
+		if !mctx.pause(depInfo) {
+			// Pausing not supported by this mutator, new dependencies can't be returned.
+			depInfo = nil
+		}
+		depInfos = append(depInfos, maybeLogicModule(depInfo))
+	}
+	return depInfos, resolved
+}
+
+func (mctx *mutatorContext) AddSingleVariantDependency(tag DependencyTag, name string) Module {
+	depInfo, errs := mctx.context.addSingleVariantDependency(mctx.module, tag, name)
+	if len(errs) > 0 {
+		mctx.errs = append(mctx.errs, errs...)
+	}
+	if !mctx.pause(depInfo) {
+		// Pausing not supported by this mutator, new dependencies can't be returned.
+		depInfo = nil
+	}
+	return maybeLogicModule(depInfo)
+}
+
 func (mctx *mutatorContext) AddInterVariantDependency(tag DependencyTag, from, to Module) {
 	mctx.context.addInterVariantDependency(mctx.module, tag, from, to)
 }
@@ -1193,6 +1428,11 @@ func (mctx *mutatorContext) ReplaceDependencies(name string) {
 type ReplaceDependencyPredicate func(from Module, tag DependencyTag, to Module) bool
 
 func (mctx *mutatorContext) ReplaceDependenciesIf(name string, predicate ReplaceDependencyPredicate) {
+	if mctx.context.moduleGroupFromName(name, mctx.module.namespace()) == nil {
+		mctx.ModuleErrorf("ReplaceDependencies could not find module %q", name)
+		return
+	}
+
 	targets := mctx.context.moduleVariantsThatDependOn(name, mctx.module)
 
 	if len(targets) == 0 {
@@ -1212,6 +1452,10 @@ func (mctx *mutatorContext) Rename(name string) {
 	mctx.rename = append(mctx.rename, rename{mctx.module.group, name})
 }
 
+func (mctx *mutatorContext) CreateAlias(alias, target string) {
+	mctx.nameAliases = append(mctx.nameAliases, nameAlias{alias, target, mctx.module})
+}
+
 func (mctx *mutatorContext) CreateModule(factory ModuleFactory, typeName string, props ...interface{}) Module {
 	module := newModule(factory)
 
@@ -1400,7 +1644,7 @@ func CheckBlueprintSyntax(moduleFactories map[string]ModuleFactory, filename str
 	for _, def := range file.Defs {
 		switch def := def.(type) {
 		case *parser.Module:
-			_, moduleErrs := processModuleDef(def, filename, moduleFactories, nil, false)
+			_, _, moduleErrs := processModuleDef(def, filename, moduleFactories, nil, false)
 			errs = append(errs, moduleErrs...)
 
 		default:
@@ -15,9 +15,16 @@
 package blueprint
 
 import (
+	"fmt"
 	"reflect"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+
+	"github.com/google/blueprint/proptools"
 )
 
 type moduleCtxTestModule struct {
@@ -501,7 +508,7 @@ test {
 }
 `)
 
-		expectedErrors(t, errs, `path/Blueprint:3:5: unrecognized property "nam"`)
+		expectedErrors(t, errs, `path/Blueprint:3:5: unrecognized property "nam" (did you mean "name"?)`)
 	})
 
 	t.Run("invalid property type", func(t *testing.T) {
@@ -620,3 +627,1187 @@ func TestAddNinjaFileDeps(t *testing.T) {
 	}
 
 }
+
+type replaceDependenciesTestTag struct {
+	BaseDependencyTag
+	name string
+}
+
+func TestReplaceDependencies(t *testing.T) {
+	prepare := func(t *testing.T, replaceWith string) (*Context, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newModuleCtxTestModule)
+		ctx.RegisterBottomUpMutator("variants", func(ctx BottomUpMutatorContext) {
+			ctx.CreateVariations("a", "b")
+		})
+		ctx.RegisterBottomUpMutator("deps", func(ctx BottomUpMutatorContext) {
+			switch ctx.ModuleName() {
+			case "user":
+				ctx.AddDependency(ctx.Module(), replaceDependenciesTestTag{name: "first"}, "foo")
+				ctx.AddDependency(ctx.Module(), replaceDependenciesTestTag{name: "second"}, "foo")
+			case "foo":
+				// ReplaceDependencies only replaces variants that depend on the replacement, as
+				// a source module does on its prebuilt.
+				ctx.AddDependency(ctx.Module(), replaceDependenciesTestTag{name: "prebuilt"}, "prebuilt_foo")
+			}
+		})
+		ctx.RegisterBottomUpMutator("replace", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "prebuilt_foo" {
+				ctx.ReplaceDependencies(replaceWith)
+			}
+		})
+
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				test {
+					name: "user",
+				}
+
+				test {
+					name: "foo",
+				}
+
+				test {
+					name: "prebuilt_foo",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx, errs
+	}
+
+	t.Run("multiple variants", func(t *testing.T) {
+		ctx, errs := prepare(t, "foo")
+		if len(errs) > 0 {
+			t.Fatalf("unexpected dep errors: %v", errs)
+		}
+
+		for _, variant := range []string{"a", "b"} {
+			user := ctx.moduleGroupFromName("user", nil).moduleByVariantName(variant)
+			prebuilt := ctx.moduleGroupFromName("prebuilt_foo", nil).moduleByVariantName(variant)
+
+			want := []depInfo{
+				{prebuilt, replaceDependenciesTestTag{name: "first"}},
+				{prebuilt, replaceDependenciesTestTag{name: "second"}},
+			}
+			if g := user.directDeps; !reflect.DeepEqual(g, want) {
+				t.Errorf("expected user variant %q deps to be %v, got %v", variant, want, g)
+			}
+		}
+	})
+
+	t.Run("missing module", func(t *testing.T) {
+		_, errs := prepare(t, "missing")
+		expectedErrors(t, errs,
+			`Android.bp:10:5: module "prebuilt_foo" variant "a": ReplaceDependencies could not find module "missing"`)
+	})
+}
+
+func TestCreateAlias(t *testing.T) {
+	run := func(t *testing.T, aliases [][2]string, created []string, deps func(ctx BottomUpMutatorContext)) (*Context, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newModuleCtxTestModule)
+		ctx.RegisterTopDownMutator("alias", func(ctx TopDownMutatorContext) {
+			if ctx.ModuleName() == "foo" {
+				for _, alias := range aliases {
+					ctx.CreateAlias(alias[0], alias[1])
+				}
+			}
+		})
+		ctx.RegisterTopDownMutator("create", func(ctx TopDownMutatorContext) {
+			if ctx.ModuleName() == "foo" {
+				for _, name := range created {
+					ctx.CreateModule(newModuleCtxTestModule, "test", &struct{ Name string }{name})
+				}
+			}
+		})
+		ctx.RegisterBottomUpMutator("variants", func(ctx BottomUpMutatorContext) {
+			ctx.CreateVariations("a", "b")
+		})
+		ctx.RegisterBottomUpMutator("deps", deps)
+
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				test {
+					name: "foo",
+				}
+
+				test {
+					name: "bar",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx, errs
+	}
+
+	t.Run("variants", func(t *testing.T) {
+		ctx, errs := run(t, [][2]string{{"legacy_bar", "bar"}, {"older_bar", "legacy_bar"}}, nil,
+			func(ctx BottomUpMutatorContext) {
+				if ctx.ModuleName() == "foo" {
+					ctx.AddDependency(ctx.Module(), nil, "legacy_bar")
+					ctx.AddVariationDependencies([]Variation{{"variants", "b"}}, nil, "older_bar")
+				}
+			})
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		barA := ctx.moduleGroupFromName("bar", nil).moduleByVariantName("a")
+		barB := ctx.moduleGroupFromName("bar", nil).moduleByVariantName("b")
+		for variant, want := range map[string][]depInfo{
+			"a": {{barA, nil}, {barB, nil}},
+			"b": {{barB, nil}, {barB, nil}},
+		} {
+			foo := ctx.moduleGroupFromName("foo", nil).moduleByVariantName(variant)
+			if g := foo.directDeps; !reflect.DeepEqual(g, want) {
+				t.Errorf("expected foo variant %q deps to be %v, got %v", variant, want, g)
+			}
+		}
+
+		if ctx.moduleGroupFromName("legacy_bar", nil) != nil {
+			t.Errorf("expected alias not to be a module")
+		}
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		_, errs := run(t, [][2]string{{"bar", "foo"}}, nil, func(BottomUpMutatorContext) {})
+		expectedErrors(t, errs, `Android.bp:2:5: alias "bar" collides with an existing module`)
+	})
+
+	t.Run("module created after alias", func(t *testing.T) {
+		_, errs := run(t, [][2]string{{"legacy_bar", "bar"}}, []string{"legacy_bar"},
+			func(ctx BottomUpMutatorContext) {
+				if ctx.ModuleName() == "foo" {
+					ctx.AddDependency(ctx.Module(), nil, "legacy_bar")
+				}
+			})
+		expectedErrors(t, errs, `Android.bp:2:5: alias "legacy_bar" collides with an existing module`)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		_, errs := run(t, [][2]string{{"x", "y"}, {"y", "z"}, {"z", "x"}}, nil, func(BottomUpMutatorContext) {})
+		expectedErrors(t, errs, `Android.bp:2:5: alias cycle: z -> x -> y -> z`)
+	})
+}
+
+func TestCreateAliasNamespaces(t *testing.T) {
+	run := func(t *testing.T, fs map[string]string) (*Context, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.EnableNamespaces()
+		ctx.RegisterModuleType("test", newModuleCtxTestModule)
+		ctx.RegisterTopDownMutator("alias", func(ctx TopDownMutatorContext) {
+			switch ctx.ModuleDir() {
+			case "a":
+				ctx.CreateAlias("legacy_lib", "lib")
+			case "b":
+				ctx.CreateAlias("legacy_lib", "other_lib")
+			}
+		})
+		ctx.RegisterBottomUpMutator("deps", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "app" {
+				ctx.AddDependency(ctx.Module(), nil, "legacy_lib")
+			}
+		})
+
+		fs["a/Android.bp"] = `
+			soong_namespace {}
+
+			test {
+				name: "lib",
+			}
+		`
+		fs["b/Android.bp"] = `
+			soong_namespace {}
+
+			test {
+				name: "other_lib",
+			}
+		`
+		mockFS := make(map[string][]byte)
+		var files []string
+		for file, contents := range fs {
+			mockFS[file] = []byte(contents)
+			files = append(files, file)
+		}
+		sort.Strings(files)
+		ctx.MockFileSystem(mockFS)
+
+		if _, errs := ctx.ParseFileList(".", files, nil); len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		_, errs := ctx.ResolveDependencies(nil)
+		return ctx, errs
+	}
+
+	t.Run("same alias in different namespaces", func(t *testing.T) {
+		app := `
+			test {
+				name: "app",
+			}
+		`
+		ctx, errs := run(t, map[string]string{
+			"a/app/Android.bp": app,
+			"b/app/Android.bp": app,
+		})
+		expectedErrors(t, errs)
+
+		for name, want := range map[string]string{
+			"//a:app": "a/Android.bp",
+			"//b:app": "b/Android.bp",
+		} {
+			if got := namespaceTestDeps(t, ctx, name); len(got) != 1 || got[0] != want {
+				t.Errorf("expected %s to depend on the alias target in %q, got %q", name, want, got)
+			}
+		}
+	})
+
+	t.Run("imported namespace", func(t *testing.T) {
+		_, errs := run(t, map[string]string{
+			"c/Android.bp": `
+				soong_namespace {
+					imports: ["a"],
+				}
+
+				test {
+					name: "app",
+				}
+			`,
+		})
+		expectedErrors(t, errs, `c/Android.bp:6:5: "app" depends on undefined module "legacy_lib".`)
+	})
+}
+
+type phonyTestModule struct {
+	SimpleName
+	properties struct {
+		Phony      string
+		Phony_deps []string
+	}
+}
+
+func newPhonyTestModule() (Module, []interface{}) {
+	m := &phonyTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *phonyTestModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Phony(m.properties.Phony, m.properties.Phony_deps...)
+}
+
+func TestPhony(t *testing.T) {
+	run := func(t *testing.T, bp string) (string, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("phony_test", newPhonyTestModule)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(bp),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		_, errs = ctx.ResolveDependencies(nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected dependency errors: %v", errs)
+		}
+		_, errs = ctx.PrepareBuildActions(nil)
+		if len(errs) > 0 {
+			return "", errs
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String(), nil
+	}
+
+	t.Run("merged", func(t *testing.T) {
+		out, errs := run(t, `
+			phony_test {
+				name: "foo",
+				phony: "checkbuild",
+				phony_deps: ["foo.out", "common.out"],
+			}
+
+			phony_test {
+				name: "bar",
+				phony: "checkbuild",
+				phony_deps: ["common.out", "bar.out"],
+			}
+
+			phony_test {
+				name: "baz",
+				phony: "baz_only",
+				phony_deps: ["baz.out"],
+			}
+		`)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		want := "build checkbuild: phony bar.out common.out foo.out\n"
+		if c := strings.Count(out, "build checkbuild:"); c != 1 {
+			t.Errorf("expected 1 checkbuild statement, found %d in:\n%s", c, out)
+		}
+		if !strings.Contains(out, want) {
+			t.Errorf("expected ninja output to contain %q, got:\n%s", want, out)
+		}
+		if want := "build baz_only: phony baz.out\n"; !strings.Contains(out, want) {
+			t.Errorf("expected ninja output to contain %q, got:\n%s", want, out)
+		}
+	})
+
+	t.Run("empty name", func(t *testing.T) {
+		_, errs := run(t, `
+			phony_test {
+				name: "foo",
+				phony_deps: ["foo.out"],
+			}
+		`)
+		expectedErrors(t, errs, `Android.bp:2:4: module "foo": phony target name must not be empty`)
+	})
+}
+
+type addDefaultTestModule struct {
+	SimpleName
+	properties struct {
+		Defaults []string
+	}
+}
+
+func newAddDefaultTestModule() (Module, []interface{}) {
+	m := &addDefaultTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *addDefaultTestModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.AddDefault(m.properties.Defaults...)
+}
+
+func TestAddDefault(t *testing.T) {
+	run := func(t *testing.T, bp string) string {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("add_default_test", newAddDefaultTestModule)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(bp),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.ResolveDependencies(nil)
+		}
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	t.Run("multiple modules", func(t *testing.T) {
+		out := run(t, `
+			add_default_test {
+				name: "foo",
+				defaults: ["foo.out", "common.out", "foo.out"],
+			}
+
+			add_default_test {
+				name: "bar",
+				defaults: ["common.out", "bar.out"],
+			}
+
+			add_default_test {
+				name: "baz",
+			}
+		`)
+
+		want := "default bar.out common.out foo.out\n\n"
+		if c := strings.Count(out, "default "); c != 1 {
+			t.Errorf("expected 1 default statement, found %d in:\n%s", c, out)
+		}
+		if !strings.HasSuffix(out, want) {
+			t.Errorf("expected ninja output to end with %q, got:\n%s", want, out)
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		out := run(t, `
+			add_default_test {
+				name: "foo",
+			}
+		`)
+		if strings.Contains(out, "default ") {
+			t.Errorf("expected no default statement, got:\n%s", out)
+		}
+	})
+}
+
+type sharedPropertiesTestModule struct {
+	SimpleName
+	first struct {
+		Srcs []string
+	}
+	second struct {
+		Srcs   []string
+		Cflags []string
+		Nested struct {
+			Enabled *bool
+		}
+	}
+}
+
+func newSharedPropertiesTestModule() (Module, []interface{}) {
+	m := &sharedPropertiesTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties, &m.first, &m.second}
+}
+
+func (m *sharedPropertiesTestModule) GenerateBuildActions(ModuleContext) {}
+
+// Properties that are not consumed by any of the property structs of a module type are always
+// reported, at the position of the assignment.
+func TestUnusedProperties(t *testing.T) {
+	factories := map[string]ModuleFactory{
+		"test": newSharedPropertiesTestModule,
+	}
+
+	t.Run("shared", func(t *testing.T) {
+		errs := CheckBlueprintSyntax(factories, "path/Blueprint", `
+test {
+	name: "test",
+	srcs: ["a.c"],
+	cflags: ["-Wall"],
+	nested: {
+		enabled: true,
+	},
+}
+`)
+		expectedErrors(t, errs)
+	})
+
+	t.Run("typos", func(t *testing.T) {
+		errs := CheckBlueprintSyntax(factories, "path/Blueprint", `
+test {
+	name: "test",
+	src: ["a.c"],
+	nested: {
+		enable: true,
+	},
+}
+`)
+		expectedErrors(t, errs,
+			`path/Blueprint:6:9: unrecognized property "nested.enable" (did you mean "nested.enabled"?)`,
+			`path/Blueprint:4:5: unrecognized property "src" (did you mean "srcs"?)`)
+	})
+}
+
+type requiredPropertiesTestModule struct {
+	SimpleName
+	properties struct {
+		Srcs []string `blueprint:"required"`
+	}
+}
+
+func newRequiredPropertiesTestModule() (Module, []interface{}) {
+	m := &requiredPropertiesTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties, &m.properties}
+}
+
+func (m *requiredPropertiesTestModule) GenerateBuildActions(ModuleContext) {}
+
+func TestRequiredProperties(t *testing.T) {
+	factories := map[string]ModuleFactory{
+		"test": newRequiredPropertiesTestModule,
+	}
+
+	errs := CheckBlueprintSyntax(factories, "path/Blueprint", `
+test {
+	name: "test",
+	srcs: ["a.c"],
+}
+
+test {
+	name: "missing",
+}
+`)
+	expectedErrors(t, errs, `path/Blueprint:7:1: missing required property "srcs"`)
+}
+
+type dependencyTagDataTestTag struct {
+	BaseDependencyTag
+	link     string
+	priority int
+}
+
+func (t dependencyTagDataTestTag) DependencyTagData() map[string]string {
+	return map[string]string{
+		"link":     t.link,
+		"priority": strconv.Itoa(t.priority),
+	}
+}
+
+var _ DataDependencyTag = dependencyTagDataTestTag{}
+
+type dependencyTagDataTestModule struct {
+	SimpleName
+	variant string
+}
+
+func newDependencyTagDataTestModule() (Module, []interface{}) {
+	m := &dependencyTagDataTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *dependencyTagDataTestModule) GenerateBuildActions(ModuleContext) {}
+
+func TestDependencyTagData(t *testing.T) {
+	var lock sync.Mutex
+	got := make(map[string]map[string]string)
+
+	record := func(ctx BaseModuleContext, kind string) {
+		if ctx.ModuleName() != "user" {
+			return
+		}
+		ctx.VisitDirectDeps(func(dep Module) {
+			key := kind + " " + ctx.Module().(*dependencyTagDataTestModule).variant + " " + ctx.OtherModuleName(dep)
+			lock.Lock()
+			defer lock.Unlock()
+			got[key] = ctx.DependencyTagData(dep)
+		})
+	}
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newDependencyTagDataTestModule)
+	ctx.RegisterBottomUpMutator("deps", func(ctx BottomUpMutatorContext) {
+		if ctx.ModuleName() == "user" {
+			ctx.AddDependency(ctx.Module(), dependencyTagDataTestTag{link: "static", priority: 1}, "lib")
+			ctx.AddDependency(ctx.Module(), replaceDependenciesTestTag{name: "plain"}, "other")
+		}
+	})
+	ctx.RegisterBottomUpMutator("variants", func(ctx BottomUpMutatorContext) {
+		variants := ctx.CreateVariations("a", "b")
+		variants[0].(*dependencyTagDataTestModule).variant = "a"
+		variants[1].(*dependencyTagDataTestModule).variant = "b"
+	})
+	ctx.RegisterBottomUpMutator("bottom_up", func(ctx BottomUpMutatorContext) {
+		record(ctx, "bottom_up")
+	})
+	ctx.RegisterTopDownMutator("top_down", func(ctx TopDownMutatorContext) {
+		record(ctx, "top_down")
+	})
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test {
+				name: "user",
+			}
+
+			test {
+				name: "lib",
+			}
+
+			test {
+				name: "other",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected dep errors: %v", errs)
+	}
+
+	libData := map[string]string{"link": "static", "priority": "1"}
+	want := make(map[string]map[string]string)
+	for _, kind := range []string{"bottom_up", "top_down"} {
+		for _, variant := range []string{"a", "b"} {
+			want[kind+" "+variant+" lib"] = libData
+			want[kind+" "+variant+" other"] = nil
+		}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect dependency tag data\nwant: %v\n got: %v", want, got)
+	}
+}
+
+func TestAddDependencyIf(t *testing.T) {
+	run := func(t *testing.T, conds map[string]bool) (*Context, map[string]int, []error) {
+		t.Helper()
+		calls := make(map[string]int)
+
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newModuleCtxTestModule)
+		ctx.RegisterBottomUpMutator("deps", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() != "user" {
+				return
+			}
+			for name, ret := range conds {
+				ctx.AddDependencyIf(replaceDependenciesTestTag{name: "cond"}, name, func() bool {
+					calls[name]++
+					return ret
+				})
+			}
+		})
+
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				test {
+					name: "user",
+				}
+
+				test {
+					name: "taken",
+				}
+
+				test {
+					name: "skipped",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx, calls, errs
+	}
+
+	t.Run("taken and skipped", func(t *testing.T) {
+		conds := map[string]bool{"taken": true, "skipped": false, "missing": false}
+		ctx, calls, errs := run(t, conds)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected dep errors: %v", errs)
+		}
+
+		want := map[string]int{"taken": 1, "skipped": 1, "missing": 1}
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("expected each condition to be evaluated once, got %v", calls)
+		}
+
+		user := ctx.moduleGroupFromName("user", nil).modules.firstModule()
+		var deps []string
+		for _, dep := range user.directDeps {
+			deps = append(deps, dep.module.Name())
+		}
+		if !reflect.DeepEqual(deps, []string{"taken"}) {
+			t.Errorf("expected deps [taken], got %v", deps)
+		}
+
+		skipped := ctx.moduleGroupFromName("skipped", nil).modules.firstModule()
+		if len(skipped.reverseDeps) > 0 {
+			t.Errorf("expected no reverse deps on skipped, got %v", skipped.reverseDeps)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, _, errs := run(t, map[string]bool{"missing": true})
+		expectedErrors(t, errs,
+			`Android.bp:2:5: "user" depends on undefined module "missing".`)
+	})
+}
+
+type dependentsTestModule struct {
+	SimpleName
+
+	dependents []string
+}
+
+func newDependentsTestModule() (Module, []interface{}) {
+	m := &dependentsTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *dependentsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	for _, dependent := range ctx.DirectDependents() {
+		name := ctx.OtherModuleName(dependent)
+		if variant := ctx.OtherModuleSubDir(dependent); variant != "" {
+			name += "{" + variant + "}"
+		}
+		m.dependents = append(m.dependents, name)
+	}
+}
+
+func TestDirectDependents(t *testing.T) {
+	run := func(t *testing.T, allowedCycleTags []DependencyTag, mutator func(BottomUpMutatorContext)) (*Context, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.AllowDependencyCycles(allowedCycleTags)
+		ctx.RegisterModuleType("test", newDependentsTestModule)
+		ctx.RegisterBottomUpMutator("variant", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "lib" || ctx.ModuleName() == "app" {
+				ctx.CreateVariations("a", "b")
+			}
+		})
+		ctx.RegisterBottomUpMutator("deps", mutator)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				test {
+					name: "lib",
+				}
+
+				test {
+					name: "app",
+				}
+
+				test {
+					name: "bin",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		_, errs = ctx.ResolveDependencies(nil)
+		if len(errs) > 0 {
+			return ctx, errs
+		}
+		_, errs = ctx.PrepareBuildActions(nil)
+		return ctx, errs
+	}
+
+	t.Run("variants", func(t *testing.T) {
+		ctx, errs := run(t, nil, func(ctx BottomUpMutatorContext) {
+			switch ctx.ModuleName() {
+			case "app":
+				ctx.AddVariationDependencies(nil, nil, "lib")
+			case "bin":
+				ctx.AddVariationDependencies([]Variation{{"variant", "a"}}, nil, "lib", "app")
+				ctx.AddVariationDependencies([]Variation{{"variant", "a"}}, replaceDependenciesTestTag{name: "other"}, "lib")
+			}
+		})
+		expectedErrors(t, errs)
+
+		for _, tt := range []struct {
+			variant string
+			want    []string
+		}{
+			{"lib:a", []string{"app{a}", "bin"}},
+			{"lib:b", []string{"app{b}"}},
+			{"app:a", []string{"bin"}},
+			{"app:b", nil},
+			{"bin:", nil},
+		} {
+			name, variant, _ := strings.Cut(tt.variant, ":")
+			m := ctx.moduleGroupFromName(name, nil).moduleByVariantName(variant).logicModule.(*dependentsTestModule)
+			if !reflect.DeepEqual(m.dependents, tt.want) {
+				t.Errorf("expected dependents of %s to be %q, got %q", tt.variant, tt.want, m.dependents)
+			}
+		}
+	})
+
+	t.Run("allowed cycle", func(t *testing.T) {
+		data := replaceDependenciesTestTag{name: "data"}
+		ctx, errs := run(t, []DependencyTag{data}, func(ctx BottomUpMutatorContext) {
+			switch ctx.ModuleName() {
+			case "lib":
+				ctx.AddDependency(ctx.Module(), data, "bin")
+			case "bin":
+				ctx.AddVariationDependencies([]Variation{{"variant", "a"}}, data, "lib")
+			}
+		})
+		expectedErrors(t, errs)
+
+		for _, tt := range []struct {
+			variant string
+			want    []string
+		}{
+			{"lib:a", []string{"bin"}},
+			{"lib:b", nil},
+			{"bin:", []string{"lib{a}", "lib{b}"}},
+		} {
+			name, variant, _ := strings.Cut(tt.variant, ":")
+			m := ctx.moduleGroupFromName(name, nil).moduleByVariantName(variant).logicModule.(*dependentsTestModule)
+			if !reflect.DeepEqual(m.dependents, tt.want) {
+				t.Errorf("expected dependents of %s to be %q, got %q", tt.variant, tt.want, m.dependents)
+			}
+		}
+	})
+
+	t.Run("mutator", func(t *testing.T) {
+		_, errs := run(t, nil, func(ctx BottomUpMutatorContext) {
+			ctx.DirectDependents()
+		})
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), "before all mutators have run") {
+			t.Errorf("expected DirectDependents to fail in a mutator, got %v", errs)
+		}
+	})
+}
+
+type optionalDepsTestModule struct {
+	SimpleName
+	properties struct {
+		Optional_deps []string
+	}
+
+	missing []string
+}
+
+func newOptionalDepsTestModule() (Module, []interface{}) {
+	m := &optionalDepsTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *optionalDepsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	m.missing = ctx.MissingOptionalDeps()
+}
+
+func TestAddOptionalDependency(t *testing.T) {
+	const bp = `
+		test {
+			name: "lib",
+		}
+
+		test {
+			name: "app",
+			optional_deps: ["lib", "migrating"],
+		}
+	`
+
+	ctx := NewContext()
+	ctx.SetIncrementalReparse(true)
+	ctx.RegisterModuleType("test", newOptionalDepsTestModule)
+	ctx.RegisterBottomUpMutator("variants", func(ctx BottomUpMutatorContext) {
+		ctx.CreateVariations("a", "b")
+	})
+	ctx.RegisterBottomUpMutator("optional_deps", func(ctx BottomUpMutatorContext) {
+		for _, name := range ctx.Module().(*optionalDepsTestModule).properties.Optional_deps {
+			ctx.AddOptionalDependency(nil, name)
+		}
+	}).Parallel()
+	ctx.MockFileSystem(map[string][]byte{"Android.bp": []byte(bp)})
+
+	check := func(t *testing.T, wantDeps, wantMissing []string) {
+		t.Helper()
+		if _, errs := ctx.ResolveDependencies(nil); len(errs) > 0 {
+			t.Fatalf("unexpected dep errors: %v", errs)
+		}
+		if _, errs := ctx.PrepareBuildActions(nil); len(errs) > 0 {
+			t.Fatalf("unexpected build action errors: %v", errs)
+		}
+		for _, variant := range []string{"a", "b"} {
+			app := ctx.moduleGroupFromName("app", nil).moduleByVariantName(variant)
+			var deps, wantVariantDeps []string
+			for _, dep := range app.directDeps {
+				deps = append(deps, dep.module.Name()+"{"+dep.module.variant.name+"}")
+			}
+			for _, dep := range wantDeps {
+				wantVariantDeps = append(wantVariantDeps, dep+"{"+variant+"}")
+			}
+			if !reflect.DeepEqual(deps, wantVariantDeps) {
+				t.Errorf("expected app{%s} deps %q, got %q", variant, wantVariantDeps, deps)
+			}
+			missing := app.logicModule.(*optionalDepsTestModule).missing
+			if !reflect.DeepEqual(missing, wantMissing) {
+				t.Errorf("expected app{%s} missing optional deps %q, got %q", variant, wantMissing, missing)
+			}
+		}
+	}
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	check(t, []string{"lib"}, []string{"migrating"})
+
+	ctx.MockFileSystem(map[string][]byte{"Android.bp": []byte(bp + `
+		test {
+			name: "migrating",
+		}
+	`)})
+	if _, errs := ctx.ReparseFiles(nil, []string{"Android.bp"}); len(errs) > 0 {
+		t.Fatalf("unexpected reparse errors: %v", errs)
+	}
+	check(t, []string{"lib", "migrating"}, nil)
+}
+
+func TestAddSingleVariantDependency(t *testing.T) {
+	run := func(t *testing.T, dep string) (*Context, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newModuleCtxTestModule)
+		ctx.RegisterBottomUpMutator("variant", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "app" || ctx.ModuleName() == "multi" {
+				ctx.CreateVariations("a", "b")
+			}
+		})
+		ctx.RegisterBottomUpMutator("other", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "single" {
+				ctx.CreateVariations("only")
+			}
+		})
+		ctx.RegisterBottomUpMutator("deps", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "app" {
+				ctx.AddSingleVariantDependency(nil, dep)
+			}
+		}).Parallel()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				test {
+					name: "app",
+				}
+
+				test {
+					name: "single",
+				}
+
+				test {
+					name: "multi",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx, errs
+	}
+
+	t.Run("single variant", func(t *testing.T) {
+		ctx, errs := run(t, "single")
+		expectedErrors(t, errs)
+
+		single := ctx.moduleGroupFromName("single", nil).moduleByVariantName("only")
+		for _, variant := range []string{"a", "b"} {
+			app := ctx.moduleGroupFromName("app", nil).moduleByVariantName(variant)
+			if len(app.directDeps) != 1 || app.directDeps[0].module != single {
+				t.Errorf("expected app{%s} to depend on single{only}, got %v", variant, app.directDeps)
+			}
+		}
+	})
+
+	t.Run("multiple variants", func(t *testing.T) {
+		_, errs := run(t, "multi")
+		expectedErrors(t, errs,
+			"Android.bp:2:5: dependency \"multi\" of \"app\" has multiple variants:\n  variant:a\n  variant:b")
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, errs := run(t, "missing")
+		expectedErrors(t, errs,
+			`Android.bp:2:5: "app" depends on undefined module "missing".`)
+	})
+}
+
+func TestAddFarVariationDependenciesWithFallback(t *testing.T) {
+	run := func(t *testing.T, variations []Variation, fallback MissingVariantFallback) (*Context, []bool, []error) {
+		t.Helper()
+		var resolved []bool
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newVerifyAcyclicTestModule)
+		ctx.RegisterBottomUpMutator("os", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "lib" {
+				variants := ctx.CreateVariations("android", "linux")
+				variants[0].(*verifyAcyclicTestModule).variant = "android"
+				variants[1].(*verifyAcyclicTestModule).variant = "linux"
+			}
+		})
+		ctx.RegisterBottomUpMutator("arch", func(ctx BottomUpMutatorContext) {
+			switch ctx.Module().(*verifyAcyclicTestModule).variant {
+			case "android":
+				ctx.CreateVariations("arm64")
+			case "linux":
+				ctx.CreateVariations("x86_64")
+			}
+		})
+		ctx.RegisterBottomUpMutator("deps", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "app" {
+				_, resolved = ctx.AddFarVariationDependenciesWithFallback(variations, fallback, nil, "lib")
+			}
+		})
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				test {
+					name: "app",
+				}
+
+				test {
+					name: "lib",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx, resolved, errs
+	}
+
+	expectDep := func(t *testing.T, ctx *Context, want string) {
+		t.Helper()
+		app := ctx.moduleGroupFromName("app", nil).modules.firstModule()
+		var got []string
+		for _, dep := range app.directDeps {
+			got = append(got, dep.module.variant.name)
+		}
+		if want == "" && len(got) > 0 || want != "" && !slices.Equal(got, []string{want}) {
+			t.Errorf("expected app to depend on lib variant %q, got %q", want, got)
+		}
+	}
+
+	t.Run("exact match", func(t *testing.T) {
+		for _, fallback := range []MissingVariantFallback{MissingVariantError, MissingVariantSkip, MissingVariantNearest} {
+			ctx, resolved, errs := run(t, []Variation{{"os", "linux"}, {"arch", "x86_64"}}, fallback)
+			expectedErrors(t, errs)
+			if !slices.Equal(resolved, []bool{true}) {
+				t.Errorf("expected lib to be resolved, got %v", resolved)
+			}
+			expectDep(t, ctx, "linux_x86_64")
+		}
+	})
+
+	t.Run("nearest match", func(t *testing.T) {
+		ctx, resolved, errs := run(t, []Variation{{"os", "android"}, {"arch", "riscv64"}}, MissingVariantNearest)
+		expectedErrors(t, errs)
+		if !slices.Equal(resolved, []bool{true}) {
+			t.Errorf("expected lib to be resolved, got %v", resolved)
+		}
+		expectDep(t, ctx, "android_arm64")
+	})
+
+	t.Run("no nearest match", func(t *testing.T) {
+		_, resolved, errs := run(t, []Variation{{"os", "darwin"}, {"arch", "riscv64"}}, MissingVariantNearest)
+		expectedErrors(t, errs,
+			"Android.bp:2:5: dependency \"lib\" of \"app\" missing variant:\n  os:darwin,arch:riscv64\n"+
+				"available variants:\n  os:android,arch:arm64\n  os:linux,arch:x86_64")
+		if !slices.Equal(resolved, []bool{false}) {
+			t.Errorf("expected lib not to be resolved, got %v", resolved)
+		}
+	})
+
+	t.Run("skip on missing", func(t *testing.T) {
+		ctx, resolved, errs := run(t, []Variation{{"os", "darwin"}}, MissingVariantSkip)
+		expectedErrors(t, errs)
+		if !slices.Equal(resolved, []bool{false}) {
+			t.Errorf("expected lib not to be resolved, got %v", resolved)
+		}
+		expectDep(t, ctx, "")
+	})
+
+	t.Run("error on missing", func(t *testing.T) {
+		_, resolved, errs := run(t, []Variation{{"os", "darwin"}}, MissingVariantError)
+		expectedErrors(t, errs,
+			"Android.bp:2:5: dependency \"lib\" of \"app\" missing variant:\n  os:darwin\n"+
+				"available variants:\n  os:android,arch:arm64\n  os:linux,arch:x86_64")
+		if !slices.Equal(resolved, []bool{false}) {
+			t.Errorf("expected lib not to be resolved, got %v", resolved)
+		}
+	})
+}
+
+func TestSetDefaultDependencyVariation(t *testing.T) {
+	run := func(t *testing.T, variantMutator func(BottomUpMutatorContext)) (*Context, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newModuleCtxTestModule)
+		ctx.RegisterBottomUpMutator("deps", addVariantDepsMutator(nil, nil, "app", "lib"))
+		ctx.RegisterBottomUpMutator("variant", variantMutator)
+		ctx.RegisterBottomUpMutator("late_deps", addVariantDepsMutator(nil, nil, "tool", "lib"))
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				test {
+					name: "lib",
+				}
+
+				test {
+					name: "app",
+				}
+
+				test {
+					name: "tool",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx, errs
+	}
+
+	depVariant := func(ctx *Context, name, variant string) string {
+		module := ctx.moduleGroupFromName(name, nil).moduleByVariantName(variant)
+		if len(module.directDeps) != 1 {
+			return fmt.Sprintf("%d deps", len(module.directDeps))
+		}
+		return module.directDeps[0].module.Name() + "{" + module.directDeps[0].module.variant.name + "}"
+	}
+
+	t.Run("default", func(t *testing.T) {
+		ctx, errs := run(t, func(ctx BottomUpMutatorContext) {
+			switch ctx.ModuleName() {
+			case "lib":
+				ctx.CreateVariations("a", "b")
+				ctx.AliasVariation("b")
+			case "app":
+				ctx.SetDefaultDependencyVariation(proptools.StringPtr("b"))
+				ctx.CreateVariations("a", "c")
+			}
+		})
+		expectedErrors(t, errs)
+
+		for _, tt := range []struct{ module, variant, want string }{
+			{"app", "a", "lib{a}"},
+			{"app", "c", "lib{b}"},
+			// A dependency added without the variation after lib was split lands on the alias.
+			{"tool", "", "lib{b}"},
+		} {
+			if got := depVariant(ctx, tt.module, tt.variant); got != tt.want {
+				t.Errorf("expected %s{%s} to depend on %s, got %s", tt.module, tt.variant, tt.want, got)
+			}
+		}
+	})
+
+	t.Run("no default", func(t *testing.T) {
+		_, errs := run(t, func(ctx BottomUpMutatorContext) {
+			switch ctx.ModuleName() {
+			case "lib":
+				ctx.CreateVariations("a", "b")
+				ctx.AliasVariation("b")
+			case "app":
+				ctx.CreateVariations("a", "c")
+			}
+		})
+		expectedErrors(t, errs,
+			`Android.bp:6:5: failed to find variation "c" for module "lib" needed by "app"`)
+	})
+}
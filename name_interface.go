@@ -108,6 +108,21 @@ type SkippedModuleInfo struct {
 	reason   string
 }
 
+// A ModuleAlreadyDefinedError is returned by NameInterface.NewModule when a module with the same
+// name is already visible from the namespace of the new module.  When returned for a module
+// defined in a Blueprints file, the Context keeps parsing and reports all the definitions of the
+// name together in a single parse error.
+type ModuleAlreadyDefinedError struct {
+	Name     string
+	Previous ModuleGroup // the group of the first definition of the name
+}
+
+func (e *ModuleAlreadyDefinedError) Error() string {
+	// seven characters at the start of the second line to align with the string "error: "
+	return fmt.Sprintf("module %q already defined\n"+
+		"       %s <-- previous definition here", e.Name, e.Previous.modules.firstModule().pos)
+}
+
 // a SimpleNameInterface just stores all modules in a map based on name
 type SimpleNameInterface struct {
 	modules        map[string]ModuleGroup
@@ -124,11 +139,7 @@ func NewSimpleNameInterface() *SimpleNameInterface {
 func (s *SimpleNameInterface) NewModule(ctx NamespaceContext, group ModuleGroup, module Module) (namespace Namespace, err []error) {
 	name := group.name
 	if group, present := s.modules[name]; present {
-		return nil, []error{
-			// seven characters at the start of the second line to align with the string "error: "
-			fmt.Errorf("module %q already defined\n"+
-				"       %s <-- previous definition here", name, group.modules.firstModule().pos),
-		}
+		return nil, []error{&ModuleAlreadyDefinedError{Name: name, Previous: group}}
 	}
 
 	s.modules[name] = group
@@ -198,8 +209,14 @@ func (s *SimpleNameInterface) AllModules() []ModuleGroup {
 }
 
 func (s *SimpleNameInterface) MissingDependencyError(depender string, dependerNamespace Namespace, dependency string, guess []string) (err error) {
-	skipInfos, skipped := s.SkippedModuleFromName(dependency, dependerNamespace)
-	if skipped {
+	skipInfos, _ := s.SkippedModuleFromName(dependency, dependerNamespace)
+	return missingDependencyError(depender, dependency, guess, skipInfos)
+}
+
+// missingDependencyError returns the error for a dependency on a module that doesn't exist, or
+// that was skipped if skipInfos is not empty.
+func missingDependencyError(depender, dependency string, guess []string, skipInfos []SkippedModuleInfo) error {
+	if len(skipInfos) > 0 {
 		filesFound := make([]string, 0, len(skipInfos))
 		reasons := make([]string, 0, len(skipInfos))
 		for _, info := range skipInfos {
@@ -0,0 +1,346 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/scanner"
+)
+
+// EnableNamespaces registers the `soong_namespace` module type and replaces the NameInterface of
+// the Context with one that scopes module names by namespace.  It must be called before any
+// Blueprints file is parsed.
+//
+// A soong_namespace module must be the first module in its directory, and establishes a
+// namespace for the modules in its directory and in its subdirectories, except those in the
+// subdirectories of another namespace.  Modules outside of any namespace are in the root
+// namespace.  Modules in different namespaces may have the same name.
+//
+// A bare module name is looked up in the namespace of the module that refers to it, then in the
+// namespaces listed in the `imports` property of its soong_namespace module, and finally in the
+// root namespace.  A name that is found in more than one imported namespace is ambiguous and is
+// reported as an error.  A name of the form //some/dir:name always refers to the module called
+// name in the namespace established in some/dir, and //:name to the one in the root namespace.
+func (c *Context) EnableNamespaces() {
+	c.SetNameInterface(newNamespaceNameInterface())
+	c.RegisterModuleType("soong_namespace", newNamespaceModule)
+}
+
+const namespaceModuleName = "soong_namespace"
+
+// namespaceModule is the module type of the soong_namespace modules.
+type namespaceModule struct {
+	properties struct {
+		Imports []string
+	}
+}
+
+func newNamespaceModule() (Module, []interface{}) {
+	m := &namespaceModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *namespaceModule) Name() string {
+	return namespaceModuleName
+}
+
+func (m *namespaceModule) GenerateBuildActions(ModuleContext) {}
+
+// moduleNamespace is the Namespace used by the namespaceNameInterface.
+type moduleNamespace struct {
+	NamespaceMarker
+
+	dir     string             // the directory of the soong_namespace module, "." for the root namespace
+	module  *namespaceModule   // the soong_namespace module, nil for the root namespace
+	pos     scanner.Position   // the position of the soong_namespace module
+	imports []*moduleNamespace // resolved by resolveImports
+	modules map[string]ModuleGroup
+}
+
+func newModuleNamespace(dir string) *moduleNamespace {
+	return &moduleNamespace{
+		dir:     dir,
+		modules: make(map[string]ModuleGroup),
+	}
+}
+
+func (n *moduleNamespace) String() string {
+	if n.dir == "." {
+		return "//"
+	}
+	return "//" + n.dir
+}
+
+// namespaceNameInterface is the NameInterface installed by EnableNamespaces.
+type namespaceNameInterface struct {
+	root           *moduleNamespace
+	namespaces     map[string]*moduleNamespace // by directory, excluding the root namespace
+	dirsWithModule map[string]bool
+	skippedModules map[string][]SkippedModuleInfo
+}
+
+func newNamespaceNameInterface() *namespaceNameInterface {
+	return &namespaceNameInterface{
+		root:           newModuleNamespace("."),
+		namespaces:     make(map[string]*moduleNamespace),
+		dirsWithModule: make(map[string]bool),
+		skippedModules: make(map[string][]SkippedModuleInfo),
+	}
+}
+
+// namespaceForDir returns the namespace of the modules defined in dir.
+func (s *namespaceNameInterface) namespaceForDir(dir string) *moduleNamespace {
+	for dir != "." && dir != "/" {
+		if namespace, ok := s.namespaces[dir]; ok {
+			return namespace
+		}
+		dir = filepath.Dir(dir)
+	}
+	return s.root
+}
+
+func (s *namespaceNameInterface) namespaceFromCtx(ctx NamespaceContext) *moduleNamespace {
+	return s.namespaceForDir(filepath.Dir(ctx.ModulePath()))
+}
+
+// toModuleNamespace converts a Namespace returned by this NameInterface, treating nil as the
+// root namespace.
+func (s *namespaceNameInterface) toModuleNamespace(namespace Namespace) *moduleNamespace {
+	if namespace, ok := namespace.(*moduleNamespace); ok && namespace != nil {
+		return namespace
+	}
+	return s.root
+}
+
+func (s *namespaceNameInterface) NewModule(ctx NamespaceContext, group ModuleGroup, module Module) (Namespace, []error) {
+	dir := filepath.Dir(ctx.ModulePath())
+
+	if m, ok := module.(*namespaceModule); ok {
+		if dir == "." {
+			return nil, []error{fmt.Errorf("soong_namespace is not allowed in the root directory")}
+		}
+		if existing, ok := s.namespaces[dir]; ok {
+			return nil, []error{&ModuleAlreadyDefinedError{
+				Name:     namespaceModuleName,
+				Previous: existing.modules[namespaceModuleName],
+			}}
+		}
+		if s.dirsWithModule[dir] {
+			return nil, []error{fmt.Errorf("soong_namespace must be the first module in the directory")}
+		}
+		namespace := newModuleNamespace(dir)
+		namespace.module = m
+		namespace.pos = group.modules.firstModule().pos
+		namespace.modules[namespaceModuleName] = group
+		s.namespaces[dir] = namespace
+		return namespace, nil
+	}
+
+	s.dirsWithModule[dir] = true
+	namespace := s.namespaceForDir(dir)
+	if existing, present := namespace.modules[group.name]; present {
+		return nil, []error{&ModuleAlreadyDefinedError{Name: group.name, Previous: existing}}
+	}
+	namespace.modules[group.name] = group
+	return namespace, nil
+}
+
+// resolveImports resolves the imports of each namespace once all the Blueprints files have been
+// parsed.
+func (s *namespaceNameInterface) resolveImports() []error {
+	var errs []error
+	for _, namespace := range s.sortedNamespaces() {
+		if namespace.module == nil {
+			continue
+		}
+		namespace.imports = nil
+		for _, path := range namespace.module.properties.Imports {
+			imported, ok := s.namespaces[filepath.Clean(strings.TrimPrefix(path, "//"))]
+			if !ok {
+				errs = append(errs, &BlueprintError{
+					Err: fmt.Errorf("namespace %s imports undefined namespace %q", namespace, path),
+					Pos: namespace.pos,
+				})
+				continue
+			}
+			namespace.imports = append(namespace.imports, imported)
+		}
+	}
+	return errs
+}
+
+// sortedNamespaces returns the root namespace followed by the other namespaces sorted by
+// directory.
+func (s *namespaceNameInterface) sortedNamespaces() []*moduleNamespace {
+	dirs := make([]string, 0, len(s.namespaces))
+	for dir := range s.namespaces {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	namespaces := []*moduleNamespace{s.root}
+	for _, dir := range dirs {
+		namespaces = append(namespaces, s.namespaces[dir])
+	}
+	return namespaces
+}
+
+// parseQualifiedName splits a name of the form //some/dir:name.
+func parseQualifiedName(name string) (dir, moduleName string, qualified bool) {
+	if !strings.HasPrefix(name, "//") {
+		return "", name, false
+	}
+	dir, moduleName, qualified = strings.Cut(strings.TrimPrefix(name, "//"), ":")
+	if !qualified {
+		return "", name, false
+	}
+	if dir == "" {
+		dir = "."
+	}
+	return dir, moduleName, true
+}
+
+// lookup returns the module called name that is visible from namespace, and the imported
+// namespaces that define it if it is ambiguous.
+func (s *namespaceNameInterface) lookup(name string, namespace *moduleNamespace) (ModuleGroup, []*moduleNamespace) {
+	if dir, moduleName, qualified := parseQualifiedName(name); qualified {
+		target := s.root
+		if dir != "." {
+			target = s.namespaces[dir]
+		}
+		if target == nil {
+			return ModuleGroup{}, nil
+		}
+		return target.modules[moduleName], nil
+	}
+
+	if group, ok := namespace.modules[name]; ok {
+		return group, nil
+	}
+
+	var found ModuleGroup
+	var definedIn []*moduleNamespace
+	for _, imported := range namespace.imports {
+		if group, ok := imported.modules[name]; ok {
+			found = group
+			definedIn = append(definedIn, imported)
+		}
+	}
+	if len(definedIn) > 1 {
+		return ModuleGroup{}, definedIn
+	} else if len(definedIn) == 1 {
+		return found, nil
+	}
+
+	return s.root.modules[name], nil
+}
+
+func (s *namespaceNameInterface) NewSkippedModule(ctx NamespaceContext, name string, info SkippedModuleInfo) {
+	if name == "" {
+		return
+	}
+	s.skippedModules[name] = append(s.skippedModules[name], info)
+}
+
+func (s *namespaceNameInterface) ModuleFromName(moduleName string, namespace Namespace) (ModuleGroup, bool) {
+	group, _ := s.lookup(moduleName, s.toModuleNamespace(namespace))
+	return group, group.moduleGroup != nil
+}
+
+func (s *namespaceNameInterface) SkippedModuleFromName(moduleName string, namespace Namespace) ([]SkippedModuleInfo, bool) {
+	skipInfos, skipped := s.skippedModules[moduleName]
+	return skipInfos, skipped
+}
+
+func (s *namespaceNameInterface) MissingDependencyError(depender string, dependerNamespace Namespace, dependency string, guess []string) error {
+	_, ambiguous := s.lookup(dependency, s.toModuleNamespace(dependerNamespace))
+	if len(ambiguous) > 0 {
+		definedIn := make([]string, len(ambiguous))
+		for i, namespace := range ambiguous {
+			definedIn[i] = namespace.String()
+		}
+		return fmt.Errorf("%q depends on ambiguous module %q, which is defined in imported namespaces %s",
+			depender, dependency, strings.Join(definedIn, ", "))
+	}
+
+	var definedIn []string
+	for _, namespace := range s.sortedNamespaces() {
+		if _, ok := namespace.modules[dependency]; ok {
+			definedIn = append(definedIn, namespace.String())
+		}
+	}
+	if len(definedIn) == 0 {
+		return missingDependencyError(depender, dependency, guess, s.skippedModules[dependency])
+	}
+	// The module exists, so guesses of similar names are not useful.
+	return fmt.Errorf("%w Module %q is defined in namespaces %s, which are not visible from %s.",
+		missingDependencyError(depender, dependency, nil, s.skippedModules[dependency]),
+		dependency, strings.Join(definedIn, ", "), s.toModuleNamespace(dependerNamespace))
+}
+
+func (s *namespaceNameInterface) Rename(oldName string, newName string, namespace Namespace) []error {
+	moduleNamespace := s.toModuleNamespace(namespace)
+	if existingGroup, exists := moduleNamespace.modules[newName]; exists {
+		return []error{
+			// seven characters at the start of the second line to align with the string "error: "
+			fmt.Errorf("renaming module %q to %q conflicts with existing module\n"+
+				"       %s <-- existing module defined here",
+				oldName, newName, existingGroup.modules.firstModule().pos),
+		}
+	}
+
+	group, exists := moduleNamespace.modules[oldName]
+	if !exists {
+		return []error{fmt.Errorf("module %q to renamed to %q doesn't exist", oldName, newName)}
+	}
+	moduleNamespace.modules[newName] = group
+	delete(moduleNamespace.modules, group.name)
+	group.name = newName
+	return nil
+}
+
+// AllModules returns the modules of the root namespace followed by those of the other namespaces
+// sorted by directory, each sorted by name.
+func (s *namespaceNameInterface) AllModules() []ModuleGroup {
+	var groups []ModuleGroup
+	for _, namespace := range s.sortedNamespaces() {
+		names := make([]string, 0, len(namespace.modules))
+		for name := range namespace.modules {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			groups = append(groups, namespace.modules[name])
+		}
+	}
+	return groups
+}
+
+func (s *namespaceNameInterface) GetNamespace(ctx NamespaceContext) Namespace {
+	return s.namespaceFromCtx(ctx)
+}
+
+// UniqueName returns the name of modules in the root namespace unchanged, and the fully qualified
+// name of the others.
+func (s *namespaceNameInterface) UniqueName(ctx NamespaceContext, name string) string {
+	namespace := s.namespaceFromCtx(ctx)
+	if namespace == s.root {
+		return name
+	}
+	return namespace.String() + ":" + name
+}
@@ -0,0 +1,235 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"sort"
+	"testing"
+)
+
+type namespaceTestModule struct {
+	SimpleName
+	properties struct {
+		Deps []string
+	}
+}
+
+func newNamespaceTestModule() (Module, []interface{}) {
+	m := &namespaceTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *namespaceTestModule) DynamicDependencies(DynamicDependerModuleContext) []string {
+	return m.properties.Deps
+}
+
+func (m *namespaceTestModule) GenerateBuildActions(ModuleContext) {}
+
+func runNamespaceTest(t *testing.T, fs map[string]string) (*Context, []error) {
+	t.Helper()
+	ctx := NewContext()
+	ctx.EnableNamespaces()
+	ctx.RegisterModuleType("test", newNamespaceTestModule)
+
+	mockFS := make(map[string][]byte)
+	var files []string
+	for file, contents := range fs {
+		mockFS[file] = []byte(contents)
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	ctx.MockFileSystem(mockFS)
+
+	if _, errs := ctx.ParseFileList(".", files, nil); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	_, errs := ctx.ResolveDependencies(nil)
+	return ctx, errs
+}
+
+// namespaceTestDeps returns the files defining the direct dependencies of a module.
+func namespaceTestDeps(t *testing.T, ctx *Context, name string) []string {
+	t.Helper()
+	group := ctx.moduleGroupFromName(name, nil)
+	if group == nil {
+		t.Fatalf("module %q not found", name)
+	}
+	var files []string
+	for _, dep := range group.modules.firstModule().directDeps {
+		files = append(files, dep.module.pos.Filename)
+	}
+	return files
+}
+
+func TestNamespaces(t *testing.T) {
+	libs := map[string]string{
+		"a/Android.bp": `
+			soong_namespace {}
+
+			test {
+				name: "lib",
+			}
+
+			test {
+				name: "app",
+				deps: ["lib"],
+			}
+		`,
+		"b/Android.bp": `
+			soong_namespace {}
+
+			test {
+				name: "lib",
+			}
+
+			test {
+				name: "app",
+				deps: ["lib"],
+			}
+		`,
+	}
+	withLibs := func(fs map[string]string) map[string]string {
+		for file, contents := range libs {
+			fs[file] = contents
+		}
+		return fs
+	}
+
+	t.Run("same name in different namespaces", func(t *testing.T) {
+		ctx, errs := runNamespaceTest(t, withLibs(map[string]string{
+			"Android.bp": `
+				test {
+					name: "lib",
+				}
+
+				test {
+					name: "root_app",
+					deps: ["lib"],
+				}
+			`,
+		}))
+		expectedErrors(t, errs)
+
+		for name, want := range map[string]string{
+			"//a:app":     "a/Android.bp",
+			"//b:app":     "b/Android.bp",
+			"//:root_app": "Android.bp",
+		} {
+			if got := namespaceTestDeps(t, ctx, name); len(got) != 1 || got[0] != want {
+				t.Errorf("expected %s to depend on the lib in %q, got %q", name, want, got)
+			}
+		}
+	})
+
+	t.Run("import", func(t *testing.T) {
+		ctx, errs := runNamespaceTest(t, withLibs(map[string]string{
+			"c/Android.bp": `
+				soong_namespace {
+					imports: ["a"],
+				}
+
+				test {
+					name: "app",
+					deps: ["lib", "//b:lib"],
+				}
+			`,
+		}))
+		expectedErrors(t, errs)
+
+		got := namespaceTestDeps(t, ctx, "//c:app")
+		if len(got) != 2 || got[0] != "a/Android.bp" || got[1] != "b/Android.bp" {
+			t.Errorf("expected //c:app to depend on //a:lib and //b:lib, got %q", got)
+		}
+	})
+
+	t.Run("nested directory", func(t *testing.T) {
+		ctx, errs := runNamespaceTest(t, withLibs(map[string]string{
+			"a/sub/Android.bp": `
+				test {
+					name: "sub_app",
+					deps: ["lib"],
+				}
+			`,
+		}))
+		expectedErrors(t, errs)
+
+		if got := namespaceTestDeps(t, ctx, "//a:sub_app"); len(got) != 1 || got[0] != "a/Android.bp" {
+			t.Errorf("expected //a:sub_app to depend on //a:lib, got %q", got)
+		}
+	})
+
+	t.Run("ambiguous", func(t *testing.T) {
+		_, errs := runNamespaceTest(t, withLibs(map[string]string{
+			"c/Android.bp": `
+				soong_namespace {
+					imports: ["a", "//b"],
+				}
+
+				test {
+					name: "app",
+					deps: ["lib"],
+				}
+			`,
+		}))
+		expectedErrors(t, errs,
+			`c/Android.bp:6:5: "app" depends on ambiguous module "lib", which is defined in imported namespaces //a, //b`)
+	})
+
+	t.Run("not imported", func(t *testing.T) {
+		_, errs := runNamespaceTest(t, withLibs(map[string]string{
+			"c/Android.bp": `
+				soong_namespace {}
+
+				test {
+					name: "app",
+					deps: ["lib"],
+				}
+			`,
+		}))
+		expectedErrors(t, errs,
+			`c/Android.bp:4:5: "app" depends on undefined module "lib". `+
+				`Module "lib" is defined in namespaces //a, //b, which are not visible from //c.`)
+	})
+
+	t.Run("undefined import", func(t *testing.T) {
+		_, errs := runNamespaceTest(t, map[string]string{
+			"c/Android.bp": `
+				soong_namespace {
+					imports: ["missing"],
+				}
+			`,
+		})
+		expectedErrors(t, errs,
+			`c/Android.bp:2:5: namespace //c imports undefined namespace "missing"`)
+	})
+
+	t.Run("not first", func(t *testing.T) {
+		ctx := NewContext()
+		ctx.EnableNamespaces()
+		ctx.RegisterModuleType("test", newNamespaceTestModule)
+		ctx.MockFileSystem(map[string][]byte{
+			"c/Android.bp": []byte(`
+				test {
+					name: "lib",
+				}
+
+				soong_namespace {}
+			`),
+		})
+		_, errs := ctx.ParseFileList(".", []string{"c/Android.bp"}, nil)
+		expectedErrors(t, errs,
+			`c/Android.bp:6:5: soong_namespace must be the first module in the directory`)
+	})
+}
@@ -17,6 +17,8 @@ package blueprint
 import (
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -49,7 +51,7 @@ func (d Deps) String() string {
 // definition.
 type PoolParams struct {
 	Comment string // The comment that will appear above the definition.
-	Depth   int    // The Ninja pool depth.
+	Depth   int    // The Ninja pool depth, which must be at least 1.
 }
 
 // A RuleParams object contains the set of parameters that make up a Ninja rule
@@ -57,12 +59,14 @@ type PoolParams struct {
 type RuleParams struct {
 	// These fields correspond to a Ninja variable of the same name.
 	Command        string // The command that Ninja will run for the rule.
-	Depfile        string // The dependency file name.
+	Depfile        string // The dependency file name, used for DepsGCC unless set by the build statement.
 	Deps           Deps   // The format of the dependency file.
+	MSVCDepsPrefix string // The prefix of the lines printed by cl.exe /showIncludes, only for DepsMSVC.
 	Description    string // The description that Ninja will print for the rule.
-	Generator      bool   // Whether the rule generates the Ninja manifest file.
+	Generator      bool   // Whether the rule generates the Ninja manifest file, so ninja -t clean keeps its outputs.
 	Pool           Pool   // The Ninja pool to which the rule belongs.
-	Restat         bool   // Whether Ninja should re-stat the rule's outputs.
+	MaxConcurrency int    // If > 0, the rule belongs to a pool of that depth shared by the rules with the same MaxConcurrency.
+	Restat         bool   // Whether Ninja should re-stat the rule's outputs, to skip dependents if they didn't change.
 	Rspfile        string // The response file.
 	RspfileContent string // The response file content.
 
@@ -70,6 +74,7 @@ type RuleParams struct {
 	CommandDeps      []string // Command-specific implicit dependencies to prepend to builds
 	CommandOrderOnly []string // Command-specific order-only dependencies to prepend to builds
 	Comment          string   // The comment that will appear above the definition.
+	Console          bool     // Whether the rule belongs to the built-in console pool, see Console.
 }
 
 // A BuildParams object contains the set of parameters that make up a Ninja
@@ -88,8 +93,39 @@ type BuildParams struct {
 	Implicits       []string          // The list of implicit input dependencies.
 	OrderOnly       []string          // The list of order-only dependencies.
 	Validations     []string          // The list of validations to run when this rule runs.
+	Dyndep          string            // The dyndep file, which must also be an input, implicit or order-only dependency.
 	Args            map[string]string // The variable/value pairs to set.
 	Optional        bool              // Skip outputting a default statement
+	Sandbox         *Sandbox          // The files the command may access, for an external sandbox runner.
+}
+
+// A Sandbox declares the complete set of files that the command of a build statement may access,
+// so that a wrapper that runs the commands in a sandbox can verify that they don't access any
+// other file.  It is metadata only and doesn't change the ninja file, except for the comments
+// written when Context.SetNinjaSandboxComments is enabled.  It is included in the output of
+// Context.ModuleGraphJSON.  The paths are not parsed for ninja variables.
+type Sandbox struct {
+	Inputs  []string // The files the command may read, including those that are not dependencies.
+	Outputs []string // The files the command may write.
+	Tools   []string // The tools the command runs, and the files they read transitively.
+}
+
+// comment returns the ninja comment that lists the declared files.
+func (s *Sandbox) comment() string {
+	var lines []string
+	for _, list := range []struct {
+		name  string
+		paths []string
+	}{
+		{"inputs", s.Inputs},
+		{"outputs", s.Outputs},
+		{"tools", s.Tools},
+	} {
+		if len(list.paths) > 0 {
+			lines = append(lines, "sandbox "+list.name+": "+strings.Join(list.paths, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
 }
 
 // A poolDef describes a pool definition.  It does not include the name of the
@@ -150,10 +186,34 @@ func parseRuleParams(scope scope, params *RuleParams) (*ruleDef,
 			"specified")
 	}
 
+	if params.Rspfile != "" && params.RspfileContent == "" {
+		return nil, fmt.Errorf("encountered rule params with Rspfile but no " +
+			"RspfileContent specified")
+	}
+
+	if params.Console {
+		if r.Pool != nil && r.Pool != Console {
+			return nil, fmt.Errorf("encountered rule params with Console and " +
+				"another Pool specified")
+		}
+		r.Pool = Console
+	}
+
 	if r.Pool != nil && !scope.IsPoolVisible(r.Pool) {
 		return nil, fmt.Errorf("Pool %s is not visible in this scope", r.Pool)
 	}
 
+	if params.MaxConcurrency < 0 {
+		return nil, fmt.Errorf("encountered rule params with negative MaxConcurrency %d",
+			params.MaxConcurrency)
+	} else if params.MaxConcurrency > 0 {
+		if r.Pool != nil {
+			return nil, fmt.Errorf("encountered rule params with MaxConcurrency and " +
+				"a Pool specified")
+		}
+		r.Pool = maxConcurrencyPool(params.MaxConcurrency)
+	}
+
 	value, err := parseNinjaString(scope, params.Command)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing Command param: %s", err)
@@ -172,6 +232,18 @@ func parseRuleParams(scope scope, params *RuleParams) (*ruleDef,
 		r.Variables["deps"] = simpleNinjaString(params.Deps.String())
 	}
 
+	if params.MSVCDepsPrefix != "" {
+		if params.Deps != DepsMSVC {
+			return nil, fmt.Errorf("encountered rule params with MSVCDepsPrefix but " +
+				"Deps is not msvc")
+		}
+		value, err = parseNinjaString(scope, params.MSVCDepsPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing MSVCDepsPrefix param: %s", err)
+		}
+		r.Variables["msvc_deps_prefix"] = value
+	}
+
 	if params.Description != "" {
 		value, err = parseNinjaString(scope, params.Description)
 		if err != nil {
@@ -218,7 +290,10 @@ func parseRuleParams(scope scope, params *RuleParams) (*ruleDef,
 	return r, nil
 }
 
-func (r *ruleDef) WriteTo(nw *ninjaWriter, name string, nameTracker *nameTracker) error {
+// WriteTo writes the rule called name.  If commandTransform is not nil, the command is replaced by
+// the result of calling it with the name and the command, see Context.SetCommandTransform.
+func (r *ruleDef) WriteTo(nw *ninjaWriter, name string, nameTracker *nameTracker,
+	commandTransform func(ruleName, command string) string) error {
 
 	if r.Comment != "" {
 		err := nw.Comment(r.Comment)
@@ -239,6 +314,30 @@ func (r *ruleDef) WriteTo(nw *ninjaWriter, name string, nameTracker *nameTracker
 		}
 	}
 
+	if command, ok := r.Variables["command"]; ok && commandTransform != nil {
+		// Write the variables in the same order as writeVariables, with the transformed command
+		// escaped after the transform.
+		var keys []string
+		for k := range r.Variables {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			value := r.Variables[k].Value(nameTracker)
+			if k == "command" {
+				unescaped := &strings.Builder{}
+				command.ValueWithEscaper(unescaped, nameTracker, noopEscaper)
+				value = defaultEscaper.Replace(commandTransform(name, unescaped.String()))
+			}
+			err = nw.ScopedAssign(k, value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	err = writeVariables(nw, r.Variables, nameTracker)
 	if err != nil {
 		return err
@@ -247,6 +346,42 @@ func (r *ruleDef) WriteTo(nw *ninjaWriter, name string, nameTracker *nameTracker
 	return nil
 }
 
+// dedupKey returns a string that is identical for two rule definitions that would produce the same
+// ninja rule once the global variables they reference are evaluated.  The comment is not part of
+// the key.
+func (r *ruleDef) dedupKey(globals map[Variable]*ninjaString, nameTracker *nameTracker) string {
+	key := &strings.Builder{}
+
+	if r.Pool != nil {
+		key.WriteString("pool=")
+		key.WriteString(nameTracker.Pool(r.Pool))
+	}
+	key.WriteString("\n")
+
+	names := make([]string, 0, len(r.Variables))
+	for name := range r.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key.WriteString(name)
+		key.WriteString("=")
+		r.Variables[name].evalGlobals(key, globals, nameTracker)
+		key.WriteString("\n")
+	}
+
+	for _, list := range [][]*ninjaString{r.CommandDeps, r.CommandOrderOnly} {
+		for _, str := range list {
+			str.evalGlobals(key, globals, nameTracker)
+			key.WriteString(" ")
+		}
+		key.WriteString("\n")
+	}
+
+	return key.String()
+}
+
 // A buildDef describes a build target definition.
 type buildDef struct {
 	Comment               string
@@ -267,6 +402,85 @@ type buildDef struct {
 	Args                  map[Variable]*ninjaString
 	Variables             map[string]*ninjaString
 	Optional              bool
+	Sandbox               *Sandbox
+
+	// the order-only dependencies that deduplicateOrderOnlyDeps replaced with a phony, or nil
+	dedupedOrderOnly *dedupedOrderOnly
+}
+
+// dedupedOrderOnly holds the original order-only dependencies of a buildDef whose order-only
+// dependencies were replaced with a phony by deduplicateOrderOnlyDeps.
+type dedupedOrderOnly struct {
+	orderOnly        []*ninjaString
+	orderOnlyStrings []string
+}
+
+// replaceOrderOnly replaces the order-only dependencies of the build definition with the outputs
+// of a phony, keeping the original dependencies so that restoreOrderOnly can put them back.
+func (b *buildDef) replaceOrderOnly(phonyOutputs []string) {
+	if b.dedupedOrderOnly == nil {
+		b.dedupedOrderOnly = &dedupedOrderOnly{b.OrderOnly, b.OrderOnlyStrings}
+	}
+	b.OrderOnly = nil
+	b.OrderOnlyStrings = phonyOutputs
+}
+
+// restoreOrderOnly undoes replaceOrderOnly, so that the order-only dependencies can be
+// deduplicated again, for example when the phonys are recomputed for a different set of modules.
+func (b *buildDef) restoreOrderOnly() {
+	if b.dedupedOrderOnly != nil {
+		b.OrderOnly = b.dedupedOrderOnly.orderOnly
+		b.OrderOnlyStrings = b.dedupedOrderOnly.orderOnlyStrings
+		b.dedupedOrderOnly = nil
+	}
+}
+
+// buildDefsEqual returns true if a and b write the same build statement, ignoring their comments
+// and the tags that describe the module that created them.
+func buildDefsEqual(a, b *buildDef) bool {
+	return a.Rule == b.Rule &&
+		a.Optional == b.Optional &&
+		slices.EqualFunc(a.Outputs, b.Outputs, ninjaStringsEqual) &&
+		slices.Equal(a.OutputStrings, b.OutputStrings) &&
+		slices.EqualFunc(a.ImplicitOutputs, b.ImplicitOutputs, ninjaStringsEqual) &&
+		slices.Equal(a.ImplicitOutputStrings, b.ImplicitOutputStrings) &&
+		slices.EqualFunc(a.Inputs, b.Inputs, ninjaStringsEqual) &&
+		slices.Equal(a.InputStrings, b.InputStrings) &&
+		slices.EqualFunc(a.Implicits, b.Implicits, ninjaStringsEqual) &&
+		slices.Equal(a.ImplicitStrings, b.ImplicitStrings) &&
+		slices.EqualFunc(a.OrderOnly, b.OrderOnly, ninjaStringsEqual) &&
+		slices.Equal(a.OrderOnlyStrings, b.OrderOnlyStrings) &&
+		slices.EqualFunc(a.Validations, b.Validations, ninjaStringsEqual) &&
+		slices.Equal(a.ValidationStrings, b.ValidationStrings) &&
+		maps.EqualFunc(a.Args, b.Args, ninjaStringsEqual) &&
+		buildVariablesEqual(a.Variables, b.Variables) &&
+		sandboxesEqual(a.Sandbox, b.Sandbox)
+}
+
+func sandboxesEqual(a, b *Sandbox) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return slices.Equal(a.Inputs, b.Inputs) &&
+		slices.Equal(a.Outputs, b.Outputs) &&
+		slices.Equal(a.Tools, b.Tools)
+}
+
+func buildVariablesEqual(a, b map[string]*ninjaString) bool {
+	for name, value := range a {
+		if name == "tags" {
+			continue
+		}
+		if other, ok := b[name]; !ok || !ninjaStringsEqual(value, other) {
+			return false
+		}
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok && name != "tags" {
+			return false
+		}
+	}
+	return true
 }
 
 func formatTags(tags map[string]string, rule Rule) string {
@@ -286,8 +500,32 @@ func formatTags(tags map[string]string, rule Rule) string {
 	return strings.Join(pairs, ";")
 }
 
+// checkRuleDepfile returns an error if a build statement that doesn't set a depfile uses gcc style
+// dependencies, either from deps or from its rule, and the rule doesn't set a depfile either.
+func checkRuleDepfile(rule Rule, deps Deps, config interface{}) error {
+	def, err := rule.def(config)
+	if err != nil {
+		// Builtin rules have no deps, and errors from rule functions are reported when the rule is
+		// written.
+		return nil
+	}
+	if _, ok := def.Variables["depfile"]; ok {
+		return nil
+	}
+	if deps == DepsNone {
+		if v, ok := def.Variables["deps"]; ok && v.Value(nil) == DepsGCC.String() {
+			deps = DepsGCC
+		}
+	}
+	if deps == DepsGCC {
+		return fmt.Errorf("encountered build params with Deps gcc but no Depfile specified by "+
+			"the build statement or rule %s", rule)
+	}
+	return nil
+}
+
 func parseBuildParams(scope scope, params *BuildParams,
-	tags map[string]string) (*buildDef, error) {
+	tags map[string]string, config interface{}) (*buildDef, error) {
 
 	comment := params.Comment
 	rule := params.Rule
@@ -297,6 +535,14 @@ func parseBuildParams(scope scope, params *BuildParams,
 		Rule:    rule,
 	}
 
+	if params.Sandbox != nil {
+		b.Sandbox = &Sandbox{
+			Inputs:  slices.Clone(params.Sandbox.Inputs),
+			Outputs: slices.Clone(params.Sandbox.Outputs),
+			Tools:   slices.Clone(params.Sandbox.Tools),
+		}
+	}
+
 	setVariable := func(name string, value *ninjaString) {
 		if b.Variables == nil {
 			b.Variables = make(map[string]*ninjaString)
@@ -357,6 +603,26 @@ func parseBuildParams(scope scope, params *BuildParams,
 		setVariable("deps", simpleNinjaString(params.Deps.String()))
 	}
 
+	if params.Depfile == "" && params.Args["depfile"] == "" {
+		if err := checkRuleDepfile(rule, params.Deps, config); err != nil {
+			return nil, err
+		}
+	}
+
+	if params.Dyndep != "" {
+		// Ninja only loads the dyndep file once it has been built, so it must be a dependency of
+		// the build statement.
+		if !slices.Contains(params.Inputs, params.Dyndep) && !slices.Contains(params.Implicits, params.Dyndep) &&
+			!slices.Contains(params.OrderOnly, params.Dyndep) {
+			return nil, fmt.Errorf("Dyndep %q is not listed in Inputs, Implicits or OrderOnly", params.Dyndep)
+		}
+		value, err := parseNinjaString(scope, params.Dyndep)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing Dyndep param: %s", err)
+		}
+		setVariable("dyndep", value)
+	}
+
 	if params.Description != "" {
 		value, err := parseNinjaString(scope, params.Description)
 		if err != nil {
@@ -0,0 +1,1427 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/blueprint/internal/ninja"
+)
+
+var (
+	ninjaDefsTestPctx = NewPackageContext("github.com/google/blueprint/ninja_defs_test")
+
+	ninjaDefsTestRspFlagsVar = ninjaDefsTestPctx.StaticVariable("rspFlags", "--rsp-flag")
+
+	ninjaDefsTestRspRule = ninjaDefsTestPctx.StaticRule("rsp", RuleParams{
+		Command:        "tool @$out.rsp -o $out",
+		Rspfile:        "$out.rsp",
+		RspfileContent: "${rspFlags} $in",
+	})
+)
+
+var (
+	ninjaDefsTestOtherPctx = NewPackageContext("github.com/google/blueprint/ninja_defs_test_other")
+
+	ninjaDefsTestCcVar      = ninjaDefsTestPctx.StaticVariable("cc", "clang")
+	ninjaDefsTestOtherCcVar = ninjaDefsTestOtherPctx.StaticVariable("compiler", "clang")
+
+	ninjaDefsTestPool = ninjaDefsTestOtherPctx.StaticPool("pool", PoolParams{Depth: 1})
+
+	ninjaDefsTestCcRule = ninjaDefsTestPctx.StaticRule("cc", RuleParams{
+		Command:     "${cc} -c $in -o $out",
+		Description: "cc $out",
+	})
+	ninjaDefsTestOtherCcRule = ninjaDefsTestOtherPctx.StaticRule("cc", RuleParams{
+		Command:     "${compiler} -c $in -o $out",
+		Description: "cc $out",
+		Comment:     "The comment is not significant.",
+	})
+	ninjaDefsTestPooledCcRule = ninjaDefsTestOtherPctx.StaticRule("pooled_cc", RuleParams{
+		Command:     "${compiler} -c $in -o $out",
+		Description: "cc $out",
+		Pool:        ninjaDefsTestPool,
+	})
+	ninjaDefsTestDepDirVar = ninjaDefsTestPctx.StaticVariable("depDir", "deps")
+
+	ninjaDefsTestGccRule = ninjaDefsTestPctx.StaticRule("gcc", RuleParams{
+		Command: "gcc -MD -MF ${depDir}/$out.d -c $in -o $out",
+		Depfile: "${depDir}/$out.d",
+		Deps:    DepsGCC,
+	})
+	ninjaDefsTestGccBuildDepfileRule = ninjaDefsTestPctx.StaticRule("gcc_build_depfile", RuleParams{
+		Command: "gcc -MD -MF $out.d -c $in -o $out",
+		Deps:    DepsGCC,
+	})
+	ninjaDefsTestMsvcRule = ninjaDefsTestPctx.StaticRule("msvc", RuleParams{
+		Command:        "cl /showIncludes /c $in /Fo$out",
+		Deps:           DepsMSVC,
+		MSVCDepsPrefix: "Note: including file:",
+	})
+	ninjaDefsTestPlainRule   = ninjaDefsTestPctx.StaticRule("plain", RuleParams{Command: "gen $out"})
+	ninjaDefsTestRestatRule  = ninjaDefsTestPctx.StaticRule("restat", RuleParams{Command: "gen $out", Restat: true})
+	ninjaDefsTestRegenRule   = ninjaDefsTestPctx.StaticRule("regen", RuleParams{Command: "gen $out", Generator: true})
+	ninjaDefsTestRestatRegen = ninjaDefsTestPctx.StaticRule("restat_regen", RuleParams{
+		Command:   "gen $out",
+		Generator: true,
+		Restat:    true,
+	})
+	ninjaDefsTestDyndepDirVar = ninjaDefsTestPctx.StaticVariable("dyndepDir", "dyndeps")
+	ninjaDefsTestArgsRule     = ninjaDefsTestPctx.StaticRule("args", RuleParams{
+		Command:     "tool $flags $in -o $out",
+		Description: "tool $out",
+	}, "flags")
+	ninjaDefsTestConsoleRule = ninjaDefsTestPctx.StaticRule("console", RuleParams{
+		Command: "interactive_tool $in $out",
+		Console: true,
+	})
+	ninjaDefsTestToolRule = ninjaDefsTestPctx.StaticRule("tool", RuleParams{
+		Command:     "prebuilts/tools/cc ${rspFlags} --home=$$HOME/.cache $in -o $out",
+		Description: "cc $out",
+	})
+	ninjaDefsTestGenDirVar = ninjaDefsTestPctx.StaticVariable("genDir", "out/gen")
+	ninjaDefsTestYaccRule  = ninjaDefsTestPctx.StaticRule("yacc", RuleParams{
+		Command:     "prebuilts/bison $in -o $out",
+		CommandDeps: []string{"prebuilts/bison"},
+	})
+	ninjaDefsTestLinkRule = ninjaDefsTestPctx.StaticRule("link", RuleParams{
+		Command:        "ld $in -o $out",
+		MaxConcurrency: 2,
+	})
+	ninjaDefsTestArchiveRule = ninjaDefsTestPctx.StaticRule("archive", RuleParams{
+		Command:        "ar $out $in",
+		MaxConcurrency: 2,
+	})
+	ninjaDefsTestPackageRule = ninjaDefsTestPctx.StaticRule("package", RuleParams{
+		Command:        "zip $out $in",
+		MaxConcurrency: 4,
+	})
+)
+
+// ninjaDefsBenchRules contains the same set of rules defined by each of many package contexts,
+// simulating rules generated from a common template.
+var ninjaDefsBenchRules = func() []Rule {
+	var rules []Rule
+	for i := 0; i < 20; i++ {
+		pctx := NewPackageContext(fmt.Sprintf("github.com/google/blueprint/ninja_defs_bench%d", i))
+		pctx.StaticVariable("flags", "-O2 -Wall")
+		for j := 0; j < 25; j++ {
+			rules = append(rules, pctx.StaticRule(fmt.Sprintf("rule%d", j), RuleParams{
+				Command:     fmt.Sprintf("tool%d ${flags} $in -o $out", j),
+				Description: fmt.Sprintf("tool%d $out", j),
+			}))
+		}
+	}
+	return rules
+}()
+
+type ninjaDefsTestModule struct {
+	SimpleName
+}
+
+func (m *ninjaDefsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(ninjaDefsTestPctx, BuildParams{
+		Rule:    ninjaDefsTestRspRule,
+		Outputs: []string{ctx.ModuleName() + ".out"},
+		Inputs:  []string{ctx.ModuleName() + ".in"},
+	})
+}
+
+func TestParseRuleParamsRspfileWithoutContent(t *testing.T) {
+	_, err := parseRuleParams(ninjaDefsTestPctx.getScope(), &RuleParams{
+		Command: "tool @$out.rsp",
+		Rspfile: "$out.rsp",
+	})
+	if err == nil {
+		t.Fatal("expected error for Rspfile without RspfileContent")
+	}
+	if !strings.Contains(err.Error(), "RspfileContent") {
+		t.Errorf("expected error to mention RspfileContent, got %q", err)
+	}
+}
+
+func TestRuleDeps(t *testing.T) {
+	t.Run("output", func(t *testing.T) {
+		rules := []Rule{ninjaDefsTestGccRule, ninjaDefsTestMsvcRule}
+		ctx := prepareNinjaDefsRulesTestContext(t, rules, false)
+
+		variables, err := ctx.LiveGlobalVariables()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Contains(variables, ninjaDefsTestDepDirVar) {
+			t.Errorf("expected %s referenced by Depfile to be live, got %v", ninjaDefsTestDepDirVar, variables)
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		out := buf.String()
+		for _, w := range []string{
+			"rule g.ninja_defs_test.gcc\n",
+			"    depfile = ${g.ninja_defs_test.depDir}/${out}.d\n    deps = gcc\n",
+			"rule g.ninja_defs_test.msvc\n",
+			"    deps = msvc\n    msvc_deps_prefix = Note: including file:\n",
+		} {
+			if !strings.Contains(out, w) {
+				t.Errorf("expected ninja output to contain %q, got:\n%s", w, out)
+			}
+		}
+	})
+
+	for _, tt := range []struct {
+		name   string
+		params RuleParams
+		err    string
+	}{
+		{
+			name:   "msvc prefix without msvc",
+			params: RuleParams{Command: "gcc", Depfile: "deps.d", Deps: DepsGCC, MSVCDepsPrefix: "Note:"},
+			err:    "MSVCDepsPrefix but Deps is not msvc",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRuleParams(ninjaDefsTestPctx.getScope(), &tt.params)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRuleRestatAndGenerator(t *testing.T) {
+	out := writeNinjaDefsRulesTestBuildFile(t, []Rule{ninjaDefsTestPlainRule, ninjaDefsTestRestatRule,
+		ninjaDefsTestRegenRule, ninjaDefsTestRestatRegen}, false)
+
+	for _, tt := range []struct {
+		rule      string
+		restat    bool
+		generator bool
+	}{
+		{"plain", false, false},
+		{"restat", true, false},
+		{"regen", false, true},
+		{"restat_regen", true, true},
+	} {
+		header := "rule g.ninja_defs_test." + tt.rule + "\n"
+		start := strings.Index(out, header)
+		if start < 0 {
+			t.Errorf("expected ninja output to contain %q, got:\n%s", header, out)
+			continue
+		}
+		rule, _, _ := strings.Cut(out[start+len(header):], "\n\n")
+		if got := strings.Contains(rule, "    restat = true"); got != tt.restat {
+			t.Errorf("expected restat %t for rule %s, got:\n%s", tt.restat, tt.rule, rule)
+		}
+		if got := strings.Contains(rule, "    generator = true"); got != tt.generator {
+			t.Errorf("expected generator %t for rule %s, got:\n%s", tt.generator, tt.rule, rule)
+		}
+	}
+}
+
+func TestRuleDepsGCCBuildDepfile(t *testing.T) {
+	// The depfile of a gcc rule may be set by each build statement instead of the rule.
+	r, err := parseRuleParams(ninjaDefsTestPctx.getScope(), &RuleParams{Command: "gcc", Deps: DepsGCC})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := r.Variables["depfile"]; ok {
+		t.Errorf("expected no rule depfile, got %v", r.Variables["depfile"])
+	}
+}
+
+func TestBuildDepsGCCDepfile(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		params BuildParams
+		err    string
+	}{
+		{
+			name:   "rule depfile",
+			params: BuildParams{Rule: ninjaDefsTestGccRule, Outputs: []string{"A.o"}, Inputs: []string{"A.c"}},
+		},
+		{
+			name: "build depfile",
+			params: BuildParams{Rule: ninjaDefsTestGccBuildDepfileRule, Outputs: []string{"A.o"},
+				Inputs: []string{"A.c"}, Depfile: "A.o.d"},
+		},
+		{
+			name:   "gcc rule without depfile",
+			params: BuildParams{Rule: ninjaDefsTestGccBuildDepfileRule, Outputs: []string{"A.o"}, Inputs: []string{"A.c"}},
+			err:    "Deps gcc but no Depfile",
+		},
+		{
+			name: "gcc build without depfile",
+			params: BuildParams{Rule: ninjaDefsTestPlainRule, Outputs: []string{"A.o"}, Inputs: []string{"A.c"},
+				Deps: DepsGCC},
+			err: "Deps gcc but no Depfile",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseBuildParams(ninjaDefsTestPctx.getScope(), &tt.params, nil, nil)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRuleConsole(t *testing.T) {
+	t.Run("output", func(t *testing.T) {
+		out := writeNinjaDefsRulesTestBuildFile(t, []Rule{ninjaDefsTestConsoleRule}, false)
+		for _, w := range []string{
+			"rule g.ninja_defs_test.console\n    pool = console\n    command = interactive_tool ${in} ${out}\n",
+			"build A.0.o: g.ninja_defs_test.console A.0.c\n",
+		} {
+			if !strings.Contains(out, w) {
+				t.Errorf("expected ninja output to contain %q, got:\n%s", w, out)
+			}
+		}
+		if strings.Contains(out, "pool console") {
+			t.Errorf("expected the built-in console pool not to be defined, got:\n%s", out)
+		}
+	})
+
+	t.Run("with pool", func(t *testing.T) {
+		_, err := parseRuleParams(ninjaDefsTestOtherPctx.getScope(), &RuleParams{
+			Command: "tool",
+			Console: true,
+			Pool:    ninjaDefsTestPool,
+		})
+		if err == nil || !strings.Contains(err.Error(), "Console and another Pool") {
+			t.Errorf("expected error for Console with another Pool, got %v", err)
+		}
+	})
+}
+
+func TestSetCommandTransform(t *testing.T) {
+	ctx := prepareNinjaDefsRulesTestContext(t, []Rule{ninjaDefsTestToolRule}, false)
+
+	commands := make(map[string]string)
+	ctx.SetCommandTransform(func(ruleName, command string) string {
+		commands[ruleName] = command
+		return strings.ReplaceAll(command, "/", `\`)
+	})
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	// The transform sees the references to variables and the "$$" escape intact.
+	want := map[string]string{
+		"g.ninja_defs_test.tool": "prebuilts/tools/cc ${g.ninja_defs_test.rspFlags} --home=$$HOME/.cache ${in} -o ${out}",
+	}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("incorrect commands passed to the transform\nwant: %q\n got: %q", want, commands)
+	}
+
+	for _, w := range []string{
+		`    command = prebuilts\tools\cc ${g.ninja_defs_test.rspFlags} --home=$$HOME\.cache ${in} -o ${out}` + "\n",
+		"    description = cc ${out}\n",
+		"build A.0.o: g.ninja_defs_test.tool A.0.c\n",
+	} {
+		if !strings.Contains(out, w) {
+			t.Errorf("expected ninja output to contain %q, got:\n%s", w, out)
+		}
+	}
+}
+
+func TestRuleMaxConcurrency(t *testing.T) {
+	t.Run("output", func(t *testing.T) {
+		out := writeNinjaDefsRulesTestBuildFile(t,
+			[]Rule{ninjaDefsTestLinkRule, ninjaDefsTestArchiveRule, ninjaDefsTestPackageRule}, false)
+		for _, w := range []string{
+			"pool max_concurrency_2\n    depth = 2\n",
+			"pool max_concurrency_4\n    depth = 4\n",
+			"rule g.ninja_defs_test.link\n    pool = max_concurrency_2\n",
+			"rule g.ninja_defs_test.archive\n    pool = max_concurrency_2\n",
+			"rule g.ninja_defs_test.package\n    pool = max_concurrency_4\n",
+		} {
+			if !strings.Contains(out, w) {
+				t.Errorf("expected ninja output to contain %q, got:\n%s", w, out)
+			}
+		}
+		if n := strings.Count(out, "pool max_concurrency_2\n"); n != 1 {
+			t.Errorf("expected the rules with the same MaxConcurrency to share a pool, got %d pools:\n%s", n, out)
+		}
+	})
+
+	t.Run("with pool", func(t *testing.T) {
+		_, err := parseRuleParams(ninjaDefsTestOtherPctx.getScope(), &RuleParams{
+			Command:        "tool",
+			MaxConcurrency: 2,
+			Pool:           ninjaDefsTestPool,
+		})
+		if err == nil || !strings.Contains(err.Error(), "MaxConcurrency and a Pool") {
+			t.Errorf("expected error for MaxConcurrency with a Pool, got %v", err)
+		}
+	})
+
+	t.Run("with console", func(t *testing.T) {
+		_, err := parseRuleParams(ninjaDefsTestOtherPctx.getScope(), &RuleParams{
+			Command:        "tool",
+			MaxConcurrency: 2,
+			Console:        true,
+		})
+		if err == nil || !strings.Contains(err.Error(), "MaxConcurrency and a Pool") {
+			t.Errorf("expected error for MaxConcurrency with Console, got %v", err)
+		}
+	})
+}
+
+type ninjaDefsDyndepTestModule struct {
+	SimpleName
+}
+
+func (m *ninjaDefsDyndepTestModule) GenerateBuildActions(ctx ModuleContext) {
+	dyndep := "${dyndepDir}/" + ctx.ModuleName() + ".dd"
+	ctx.Build(ninjaDefsTestPctx, BuildParams{
+		Rule:    ninjaDefsTestPlainRule,
+		Outputs: []string{dyndep},
+		Inputs:  []string{ctx.ModuleName() + ".in"},
+	})
+	ctx.Build(ninjaDefsTestPctx, BuildParams{
+		Rule:      ninjaDefsTestPlainRule,
+		Outputs:   []string{ctx.ModuleName() + ".out"},
+		Inputs:    []string{ctx.ModuleName() + ".in"},
+		OrderOnly: []string{dyndep},
+		Dyndep:    dyndep,
+	})
+}
+
+func TestBuildDyndep(t *testing.T) {
+	t.Run("output", func(t *testing.T) {
+		ctx := NewContext()
+		ctx.RegisterModuleType("ninja_defs_dyndep_test_module", func() (Module, []interface{}) {
+			m := &ninjaDefsDyndepTestModule{}
+			return m, []interface{}{&m.SimpleName.Properties}
+		})
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				ninja_defs_dyndep_test_module {
+				    name: "A",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		variables, err := ctx.LiveGlobalVariables()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Contains(variables, ninjaDefsTestDyndepDirVar) {
+			t.Errorf("expected %s referenced by Dyndep to be live, got %v", ninjaDefsTestDyndepDirVar, variables)
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		out := buf.String()
+
+		for _, w := range []string{
+			"build ${g.ninja_defs_test.dyndepDir}/A.dd: g.ninja_defs_test.plain A.in\n",
+			"build A.out: g.ninja_defs_test.plain A.in || $\n" +
+				"        ${g.ninja_defs_test.dyndepDir}/A.dd\n" +
+				"    dyndep = ${g.ninja_defs_test.dyndepDir}/A.dd\n",
+		} {
+			if !strings.Contains(out, w) {
+				t.Errorf("expected ninja output to contain %q, got:\n%s", w, out)
+			}
+		}
+	})
+
+	t.Run("not a dependency", func(t *testing.T) {
+		_, err := parseBuildParams(ninjaDefsTestPctx.getScope(), &BuildParams{
+			Rule:    ninjaDefsTestPlainRule,
+			Outputs: []string{"A.out"},
+			Inputs:  []string{"A.in"},
+			Dyndep:  "A.dd",
+		}, nil, nil)
+		if g, w := fmt.Sprint(err), `Dyndep "A.dd" is not listed in Inputs, Implicits or OrderOnly`; g != w {
+			t.Errorf("expected error %q, got %q", w, g)
+		}
+	})
+}
+
+func TestRuleRspfile(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("ninja_defs_test_module", func() (Module, []interface{}) {
+		m := &ninjaDefsTestModule{}
+		return m, []interface{}{&m.SimpleName.Properties}
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			ninja_defs_test_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	variables, err := ctx.LiveGlobalVariables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(variables, ninjaDefsTestRspFlagsVar) {
+		t.Errorf("expected %s referenced by RspfileContent to be live, got %v", ninjaDefsTestRspFlagsVar, variables)
+	}
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, w := range []string{
+		"rule g.ninja_defs_test.rsp\n",
+		"    rspfile = ${out}.rsp\n",
+		"    rspfile_content = ${g.ninja_defs_test.rspFlags} ${in}\n",
+		"build A.out: g.ninja_defs_test.rsp A.in\n",
+	} {
+		if !strings.Contains(out, w) {
+			t.Errorf("expected ninja output to contain %q, got:\n%s", w, out)
+		}
+	}
+}
+
+type ninjaDefsRulesTestModule struct {
+	SimpleName
+	rules []Rule
+}
+
+func (m *ninjaDefsRulesTestModule) GenerateBuildActions(ctx ModuleContext) {
+	for i, rule := range m.rules {
+		// Build in the rule's own package context so that the rule is visible.
+		ctx.Build(rule.packageContext(), BuildParams{
+			Rule:    rule,
+			Outputs: []string{fmt.Sprintf("%s.%d.o", ctx.ModuleName(), i)},
+			Inputs:  []string{fmt.Sprintf("%s.%d.c", ctx.ModuleName(), i)},
+		})
+	}
+}
+
+func prepareNinjaDefsRulesTestContext(t testing.TB, rules []Rule, dedup bool) *Context {
+	t.Helper()
+
+	ctx := NewContext()
+	ctx.SetDeduplicateRules(dedup)
+	ctx.RegisterModuleType("ninja_defs_rules_test_module", func() (Module, []interface{}) {
+		m := &ninjaDefsRulesTestModule{rules: rules}
+		return m, []interface{}{&m.SimpleName.Properties}
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			ninja_defs_rules_test_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	return ctx
+}
+
+func writeNinjaDefsRulesTestBuildFile(t testing.TB, rules []Rule, dedup bool) string {
+	t.Helper()
+
+	ctx := prepareNinjaDefsRulesTestContext(t, rules, dedup)
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestDeduplicateRules(t *testing.T) {
+	rules := []Rule{ninjaDefsTestOtherCcRule, ninjaDefsTestPooledCcRule, ninjaDefsTestCcRule}
+
+	out := writeNinjaDefsRulesTestBuildFile(t, rules, true)
+
+	for _, w := range []string{
+		"rule g.ninja_defs_test.cc\n",
+		"rule g.ninja_defs_test_other.pooled_cc\n",
+		"build A.0.o: g.ninja_defs_test.cc A.0.c\n",
+		"build A.1.o: g.ninja_defs_test_other.pooled_cc A.1.c\n",
+		"build A.2.o: g.ninja_defs_test.cc A.2.c\n",
+	} {
+		if !strings.Contains(out, w) {
+			t.Errorf("expected ninja output to contain %q, got:\n%s", w, out)
+		}
+	}
+	if strings.Contains(out, "rule g.ninja_defs_test_other.cc\n") {
+		t.Errorf("expected duplicate rule g.ninja_defs_test_other.cc to be removed, got:\n%s", out)
+	}
+
+	// The output must not depend on the order the rules were used in.
+	reversed := slices.Clone(rules)
+	slices.Reverse(reversed)
+	reversedOut := writeNinjaDefsRulesTestBuildFile(t, reversed, true)
+	if g, w := strings.Count(reversedOut, "\nrule "), strings.Count(out, "\nrule "); g != w {
+		t.Errorf("expected %d rules with reversed module order, got %d", w, g)
+	}
+	if !strings.Contains(reversedOut, "build A.0.o: g.ninja_defs_test.cc A.0.c\n") {
+		t.Errorf("expected canonical rule g.ninja_defs_test.cc with reversed module order, got:\n%s", reversedOut)
+	}
+
+	undeduplicated := writeNinjaDefsRulesTestBuildFile(t, rules, false)
+	if !strings.Contains(undeduplicated, "build A.0.o: g.ninja_defs_test_other.cc A.0.c\n") {
+		t.Errorf("expected rules to be kept when deduplication is disabled, got:\n%s", undeduplicated)
+	}
+}
+
+// BenchmarkDeduplicateRules writes a build file that uses the same 25 rules from 20 package
+// contexts and reports the size of the output with and without rule deduplication.
+func BenchmarkDeduplicateRules(b *testing.B) {
+	for _, dedup := range []bool{false, true} {
+		b.Run(fmt.Sprintf("dedup=%v", dedup), func(b *testing.B) {
+			var size int
+			for n := 0; n < b.N; n++ {
+				size = len(writeNinjaDefsRulesTestBuildFile(b, ninjaDefsBenchRules, dedup))
+			}
+			b.ReportMetric(float64(size), "bytes")
+		})
+	}
+}
+
+// countingWriter is an io.Writer that doesn't implement io.StringWriter and records the number of
+// calls to Write.
+type countingWriter struct {
+	buf    bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.buf.Write(p)
+}
+
+// ninjaDefsRoundTripTestParams are built by ninjaDefsRoundTripTestModule.
+var ninjaDefsRoundTripTestParams = []BuildParams{
+	{
+		Rule:    ninjaDefsTestPlainRule,
+		Outputs: []string{"plain.out"},
+		Inputs:  []string{"plain.in"},
+	},
+	{
+		Rule:            ninjaDefsTestArgsRule,
+		Outputs:         []string{"out dir/a:b.o", "${depDir}/a.o"},
+		ImplicitOutputs: []string{"a.d"},
+		Inputs:          []string{"in dir/a.c", " leading.c", "dollar$$.c"},
+		Implicits:       []string{"${dyndepDir}/implicit.h"},
+		OrderOnly:       []string{"order.stamp"},
+		Validations:     []string{"check.stamp"},
+		Description:     "compile ${depDir}/a.o",
+		Args:            map[string]string{"flags": "-DA=$$x -I${depDir}"},
+	},
+	{
+		Rule:     Phony,
+		Outputs:  []string{"all"},
+		Inputs:   []string{"plain.out", "a.d"},
+		Optional: true,
+	},
+}
+
+type ninjaDefsRoundTripTestModule struct {
+	SimpleName
+}
+
+func (m *ninjaDefsRoundTripTestModule) GenerateBuildActions(ctx ModuleContext) {
+	for _, params := range ninjaDefsRoundTripTestParams {
+		ctx.Build(ninjaDefsTestPctx, params)
+	}
+}
+
+func TestWriteBuildFileRoundTrip(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("ninja_defs_round_trip_test_module", func() (Module, []interface{}) {
+		m := &ninjaDefsRoundTripTestModule{}
+		return m, []interface{}{&m.SimpleName.Properties}
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			ninja_defs_round_trip_test_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ninja.Parse(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("error parsing ninja output: %s\n%s", err, buf.String())
+	}
+
+	// The parsed file refers to the global variables by their full names.
+	qualify := strings.NewReplacer(
+		"${depDir}", "${g.ninja_defs_test.depDir}",
+		"${dyndepDir}", "${g.ninja_defs_test.dyndepDir}").Replace
+	qualifyAll := func(list []string) []string {
+		var ret []string
+		for _, s := range list {
+			ret = append(ret, qualify(s))
+		}
+		return ret
+	}
+
+	for _, v := range []struct{ name, value string }{
+		{"g.ninja_defs_test.depDir", "deps"},
+		{"g.ninja_defs_test.dyndepDir", "dyndeps"},
+	} {
+		if g, ok := f.Variable(v.name); !ok || g != v.value {
+			t.Errorf("expected global variable %s = %q, got %q", v.name, v.value, g)
+		}
+	}
+
+	wantRules := map[string]map[string]string{
+		"g.ninja_defs_test.plain": {"command": "gen ${out}"},
+		"g.ninja_defs_test.args":  {"command": "tool ${flags} ${in} -o ${out}", "description": "tool ${out}"},
+	}
+	if g := len(f.Rules); g != len(wantRules) {
+		t.Errorf("expected %d rules, got %d", len(wantRules), g)
+	}
+	for name, want := range wantRules {
+		if rule := f.Rule(name); rule == nil {
+			t.Errorf("missing rule %s", name)
+		} else if !reflect.DeepEqual(rule.Variables, want) {
+			t.Errorf("incorrect variables of rule %s\nwant: %q\n got: %q", name, want, rule.Variables)
+		}
+	}
+
+	if g, w := len(f.Builds), len(ninjaDefsRoundTripTestParams); g != w {
+		t.Errorf("expected %d build statements, got %d", w, g)
+	}
+	var wantDefaults []string
+	for _, params := range ninjaDefsRoundTripTestParams {
+		outputs := qualifyAll(params.Outputs)
+		b := f.Build(outputs[0])
+		if b == nil {
+			t.Errorf("missing build statement for %q", outputs[0])
+			continue
+		}
+		want := &ninja.Build{
+			Rule:            ctx.nameTracker.Rule(params.Rule),
+			Outputs:         outputs,
+			ImplicitOutputs: qualifyAll(params.ImplicitOutputs),
+			Inputs:          qualifyAll(params.Inputs),
+			Implicits:       qualifyAll(params.Implicits),
+			OrderOnly:       qualifyAll(params.OrderOnly),
+			Validations:     qualifyAll(params.Validations),
+			Variables:       map[string]string{},
+		}
+		if params.Description != "" {
+			want.Variables["description"] = qualify(params.Description)
+		}
+		for name, value := range params.Args {
+			want.Variables[name] = qualify(value)
+		}
+		if _, ok := b.Variables["tags"]; ok {
+			want.Variables["tags"] = b.Variables["tags"]
+		}
+		if !reflect.DeepEqual(b, want) {
+			t.Errorf("incorrect build statement for %q\nwant: %+v\n got: %+v", outputs[0], want, b)
+		}
+		if !params.Optional {
+			wantDefaults = append(wantDefaults, outputs...)
+		}
+	}
+	// The build statements are sorted by primary output, so compare the defaults regardless of order.
+	slices.Sort(wantDefaults)
+	gotDefaults := slices.Clone(f.Defaults)
+	slices.Sort(gotDefaults)
+	if !reflect.DeepEqual(gotDefaults, wantDefaults) {
+		t.Errorf("incorrect defaults\nwant: %q\n got: %q", wantDefaults, gotDefaults)
+	}
+}
+
+func TestSetNinjaLineWidth(t *testing.T) {
+	ctx := prepareNinjaDefsRulesTestContext(t, []Rule{ninjaDefsTestCcRule}, false)
+
+	for _, tt := range []struct {
+		width int
+		want  string
+	}{
+		{0, "build A.0.o: g.ninja_defs_test.cc A.0.c\n"},
+		{20, "build A.0.o: $\n        g.ninja_defs_test.cc $\n        A.0.c\n"},
+	} {
+		ctx.SetNinjaLineWidth(tt.width)
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), tt.want) {
+			t.Errorf("expected ninja output at width %d to contain %q, got:\n%s", tt.width, tt.want, buf.String())
+		}
+	}
+}
+
+func TestSetNinjaModuleComments(t *testing.T) {
+	ctx := prepareNinjaDefsRulesTestContext(t, []Rule{ninjaDefsTestCcRule}, false)
+
+	const header = "# Module:  A\n" +
+		"# Variant:\n" +
+		"# Type:    ninja_defs_rules_test_module\n" +
+		"# Factory: github.com/google/blueprint.prepareNinjaDefsRulesTestContext.func1\n" +
+		"# Defined: Android.bp:2:4\n" +
+		"\n"
+	const build = "build A.0.o: g.ninja_defs_test.cc A.0.c\n"
+
+	for _, comments := range []bool{true, false} {
+		ctx.SetNinjaModuleComments(comments)
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		out := buf.String()
+
+		if !strings.Contains(out, build) {
+			t.Errorf("expected ninja output to contain %q, got:\n%s", build, out)
+		}
+		if g := strings.Contains(out, header+build); g != comments {
+			t.Errorf("expected module comment before the build statement to be %t, got:\n%s", comments, out)
+		}
+		if g := strings.Contains(out, "# Module:"); g != comments {
+			t.Errorf("expected module comment to be %t, got:\n%s", comments, out)
+		}
+	}
+}
+
+type ninjaDefsDuplicateTestModule struct {
+	SimpleName
+	properties struct {
+		Out            string
+		Flags          []string
+		Sandbox_inputs []string
+	}
+}
+
+func (m *ninjaDefsDuplicateTestModule) GenerateBuildActions(ctx ModuleContext) {
+	var sandbox *Sandbox
+	if m.properties.Sandbox_inputs != nil {
+		sandbox = &Sandbox{Inputs: m.properties.Sandbox_inputs}
+	}
+	// Declare the output once for each of the flags.
+	for _, flags := range m.properties.Flags {
+		ctx.Build(ninjaDefsTestPctx, BuildParams{
+			Rule:    ninjaDefsTestArgsRule,
+			Outputs: []string{m.properties.Out},
+			Inputs:  []string{"in.c"},
+			Args:    map[string]string{"flags": flags},
+			Sandbox: sandbox,
+		})
+	}
+}
+
+func TestDuplicateBuildStatements(t *testing.T) {
+	prepare := func(t *testing.T, bp string) (*Context, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("ninja_defs_duplicate_test_module", func() (Module, []interface{}) {
+			m := &ninjaDefsDuplicateTestModule{}
+			return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+		})
+		ctx.MockFileSystem(map[string][]byte{"Android.bp": []byte(bp)})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		_, errs = ctx.PrepareBuildActions(nil)
+		return ctx, errs
+	}
+
+	t.Run("identical", func(t *testing.T) {
+		ctx, errs := prepare(t, `
+			ninja_defs_duplicate_test_module {
+			    name: "A",
+			    out: "out.o",
+			    flags: ["-O2"],
+			}
+			ninja_defs_duplicate_test_module {
+			    name: "B",
+			    out: "out.o",
+			    flags: ["-O2"],
+			}
+		`)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(buf.String(), "build out.o:"); n != 1 {
+			t.Errorf("expected a single build statement for out.o, got %d:\n%s", n, buf.String())
+		}
+	})
+
+	t.Run("conflicting", func(t *testing.T) {
+		_, errs := prepare(t, `
+			ninja_defs_duplicate_test_module {
+			    name: "A",
+			    out: "out.o",
+			    flags: ["-O2"],
+			}
+			ninja_defs_duplicate_test_module {
+			    name: "B",
+			    out: "out.o",
+			    flags: ["-O0"],
+			}
+		`)
+		expectedErrors(t, errs,
+			`output "out.o" is built by different build statements in:
+       Android.bp:2:4: module "A"
+       Android.bp:7:4: module "B"`)
+	})
+
+	t.Run("same path through a variable", func(t *testing.T) {
+		_, errs := prepare(t, `
+			ninja_defs_duplicate_test_module {
+			    name: "A",
+			    out: "${depDir}/out.o",
+			    flags: ["-O2"],
+			}
+			ninja_defs_duplicate_test_module {
+			    name: "B",
+			    out: "deps/out.o",
+			    flags: ["-O0"],
+			}
+		`)
+		expectedErrors(t, errs,
+			`output "deps/out.o" is built by different build statements in:
+       Android.bp:2:4: module "A"
+       Android.bp:7:4: module "B"`)
+	})
+
+	t.Run("different sandboxes", func(t *testing.T) {
+		_, errs := prepare(t, `
+			ninja_defs_duplicate_test_module {
+			    name: "A",
+			    out: "out.o",
+			    flags: ["-O2"],
+			    sandbox_inputs: ["in.h"],
+			}
+			ninja_defs_duplicate_test_module {
+			    name: "B",
+			    out: "out.o",
+			    flags: ["-O2"],
+			}
+		`)
+		expectedErrors(t, errs,
+			`output "out.o" is built by different build statements in:
+       Android.bp:2:4: module "A"
+       Android.bp:8:4: module "B"`)
+	})
+
+	t.Run("conflicting across several modules", func(t *testing.T) {
+		_, errs := prepare(t, `
+			ninja_defs_duplicate_test_module {
+			    name: "A",
+			    out: "out.o",
+			    flags: ["-O2"],
+			}
+			ninja_defs_duplicate_test_module {
+			    name: "B",
+			    out: "out.o",
+			    flags: ["-O2"],
+			}
+			ninja_defs_duplicate_test_module {
+			    name: "C",
+			    out: "out.o",
+			    flags: ["-O0"],
+			}
+			ninja_defs_duplicate_test_module {
+			    name: "D",
+			    out: "out.o",
+			    flags: ["-O1"],
+			}
+		`)
+		expectedErrors(t, errs,
+			`output "out.o" is built by different build statements in:
+       Android.bp:2:4: module "A"
+       Android.bp:12:4: module "C"
+       Android.bp:17:4: module "D"`)
+	})
+
+	t.Run("redeclared by the same module", func(t *testing.T) {
+		ctx, errs := prepare(t, `
+			ninja_defs_duplicate_test_module {
+			    name: "A",
+			    out: "out.o",
+			    flags: ["-O2", "-O2"],
+			}
+		`)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(buf.String(), "build out.o:"); n != 1 {
+			t.Errorf("expected a single build statement for out.o, got %d:\n%s", n, buf.String())
+		}
+	})
+}
+
+type ninjaDefsSandboxTestModule struct {
+	SimpleName
+	sandbox *Sandbox
+}
+
+func (m *ninjaDefsSandboxTestModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(ninjaDefsTestPctx, BuildParams{
+		Rule:    ninjaDefsTestPlainRule,
+		Outputs: []string{"gen/a.h"},
+		Inputs:  []string{"a.proto"},
+		Sandbox: m.sandbox,
+	})
+	ctx.Build(ninjaDefsTestPctx, BuildParams{
+		Rule:    ninjaDefsTestPlainRule,
+		Outputs: []string{"gen/b.h"},
+	})
+}
+
+func TestBuildSandbox(t *testing.T) {
+	sandbox := &Sandbox{
+		Inputs:  []string{"a.proto", "include/common.proto"},
+		Outputs: []string{"gen/a.h"},
+		Tools:   []string{"bin/protoc", "lib/libprotobuf.so"},
+	}
+	want := &Sandbox{
+		Inputs:  []string{"a.proto", "include/common.proto"},
+		Outputs: []string{"gen/a.h"},
+		Tools:   []string{"bin/protoc", "lib/libprotobuf.so"},
+	}
+
+	prepare := func(t *testing.T) *Context {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("ninja_defs_sandbox_test_module", func() (Module, []interface{}) {
+			m := &ninjaDefsSandboxTestModule{sandbox: sandbox}
+			return m, []interface{}{&m.SimpleName.Properties}
+		})
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				ninja_defs_sandbox_test_module {
+				    name: "A",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		return ctx
+	}
+
+	t.Run("captured", func(t *testing.T) {
+		ctx := prepare(t)
+		defs := ctx.moduleGroupFromName("A", nil).modules.firstModule().actionDefs.buildDefs
+		if len(defs) != 2 || defs[1].Sandbox != nil {
+			t.Fatalf("expected a sandbox only for the first of 2 build definitions, got %v", defs)
+		}
+		if !reflect.DeepEqual(defs[0].Sandbox, want) {
+			t.Errorf("incorrect sandbox\nwant: %+v\n got: %+v", want, defs[0].Sandbox)
+		}
+		if defs[0].Sandbox == sandbox || &defs[0].Sandbox.Inputs[0] == &sandbox.Inputs[0] {
+			t.Errorf("expected the sandbox to be copied from the BuildParams")
+		}
+	})
+
+	t.Run("module graph JSON", func(t *testing.T) {
+		ctx := prepare(t)
+		buf := &bytes.Buffer{}
+		if err := ctx.ModuleGraphJSON(buf); err != nil {
+			t.Fatal(err)
+		}
+
+		var graph JSONModuleGraph
+		if err := json.Unmarshal(buf.Bytes(), &graph); err != nil {
+			t.Fatalf("failed to decode module graph: %s\n%s", err, buf.String())
+		}
+		if len(graph.Modules) != 1 || !reflect.DeepEqual(graph.Modules[0].Sandboxes, []*Sandbox{want}) {
+			t.Errorf("expected the sandbox of module A in the module graph, got:\n%s", buf.String())
+		}
+	})
+
+	t.Run("ninja comments", func(t *testing.T) {
+		ctx := prepare(t)
+		write := func() string {
+			buf := &strings.Builder{}
+			if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+				t.Fatal(err)
+			}
+			return buf.String()
+		}
+
+		if out := write(); strings.Contains(out, "# sandbox") {
+			t.Errorf("expected no sandbox comments by default, got:\n%s", out)
+		}
+
+		ctx.SetNinjaSandboxComments(true)
+		out := write()
+		w := "# sandbox inputs: a.proto include/common.proto\n" +
+			"# sandbox outputs: gen/a.h\n" +
+			"# sandbox tools: bin/protoc lib/libprotobuf.so\n" +
+			"build gen/a.h: g.ninja_defs_test.plain a.proto\n"
+		if !strings.Contains(out, w) {
+			t.Errorf("expected ninja output to contain %q, got:\n%s", w, out)
+		}
+		if n := strings.Count(out, "# sandbox inputs"); n != 1 {
+			t.Errorf("expected a single sandbox comment, got %d:\n%s", n, out)
+		}
+	})
+}
+
+type ninjaDefsInputsTestModule struct {
+	SimpleName
+	properties struct {
+		Srcs    []string
+		Deps    []string
+		Src_dir string
+	}
+}
+
+func (m *ninjaDefsInputsTestModule) DynamicDependencies(DynamicDependerModuleContext) []string {
+	return m.properties.Deps
+}
+
+// GenerateBuildActions compiles the sources, after generating a source from each .y file, and
+// links them with the outputs of the dependencies.  The sources are relative to src_dir if it is
+// set, which is referenced through a local variable.
+func (m *ninjaDefsInputsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	if m.properties.Src_dir != "" {
+		ctx.Variable(ninjaDefsTestPctx, "srcDir", m.properties.Src_dir)
+	}
+	var srcs []string
+	for _, src := range m.properties.Srcs {
+		if m.properties.Src_dir != "" {
+			src = "${srcDir}/" + src
+		}
+		if strings.HasSuffix(src, ".y") {
+			gen := "${genDir}/" + strings.TrimSuffix(src, ".y") + ".c"
+			ctx.Build(ninjaDefsTestPctx, BuildParams{
+				Rule:    ninjaDefsTestYaccRule,
+				Outputs: []string{gen},
+				Inputs:  []string{src},
+			})
+			src = gen
+		}
+		srcs = append(srcs, src)
+	}
+
+	var implicits []string
+	ctx.VisitDirectDeps(func(dep Module) {
+		implicits = append(implicits, dep.Name()+".a")
+	})
+	ctx.Build(ninjaDefsTestPctx, BuildParams{
+		Rule:      ninjaDefsTestPlainRule,
+		Outputs:   []string{ctx.ModuleName() + ".a"},
+		Inputs:    srcs,
+		Implicits: implicits,
+	})
+}
+
+func prepareNinjaDefsInputsTestContext(t *testing.T) *Context {
+	t.Helper()
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("ninja_defs_inputs_test_module", func() (Module, []interface{}) {
+		m := &ninjaDefsInputsTestModule{}
+		return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			ninja_defs_inputs_test_module {
+			    name: "A",
+			    srcs: ["a.c"],
+			    deps: ["B"],
+			}
+			ninja_defs_inputs_test_module {
+			    name: "B",
+			    srcs: ["b.c", "parser.y"],
+			    deps: ["C"],
+			}
+			ninja_defs_inputs_test_module {
+			    name: "C",
+			    srcs: ["c.c", "common.c"],
+			}
+			ninja_defs_inputs_test_module {
+			    name: "loop",
+			    srcs: ["loop.a", "loop.c"],
+			}
+			ninja_defs_inputs_test_module {
+			    name: "E",
+			    srcs: ["e.c"],
+			    src_dir: "e",
+			}
+		`),
+		"d/Android.bp": []byte(`
+			ninja_defs_inputs_test_module {
+			    name: "D",
+			    srcs: ["d.c", "common.c"],
+			}
+		`),
+	})
+
+	if _, err := ctx.TransitiveInputs(&ninjaDefsInputsTestModule{}); !errors.Is(err, ErrBuildActionsNotReady) {
+		t.Errorf("expected ErrBuildActionsNotReady from TransitiveInputs, got %v", err)
+	}
+	if _, err := ctx.AffectedModules(nil); !errors.Is(err, ErrBuildActionsNotReady) {
+		t.Errorf("expected ErrBuildActionsNotReady from AffectedModules, got %v", err)
+	}
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp", "d/Android.bp"}, nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	return ctx
+}
+
+func TestTransitiveInputs(t *testing.T) {
+	ctx := prepareNinjaDefsInputsTestContext(t)
+
+	testCases := []struct {
+		module string
+		want   []string
+	}{
+		{"C", []string{"c.c", "common.c"}},
+		{"B", []string{"b.c", "c.c", "common.c", "parser.y", "prebuilts/bison"}},
+		{"A", []string{"a.c", "b.c", "c.c", "common.c", "parser.y", "prebuilts/bison"}},
+		{"D", []string{"common.c", "d.c"}},
+		// The output of loop is one of its inputs.
+		{"loop", []string{"loop.c"}},
+		{"E", []string{"e/e.c"}},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.module, func(t *testing.T) {
+			module := ctx.moduleGroupFromName(tt.module, nil).modules.firstModule().logicModule
+			got, err := ctx.TransitiveInputs(module)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("incorrect transitive inputs\nwant: %q\n got: %q", tt.want, got)
+			}
+		})
+	}
+}
+
+type ninjaDefsSortTestModule struct {
+	SimpleName
+	properties struct {
+		Outs []string
+	}
+}
+
+func (m *ninjaDefsSortTestModule) GenerateBuildActions(ctx ModuleContext) {
+	rules := make(map[string]Rule)
+	for _, out := range m.properties.Outs {
+		rules[out] = ctx.Rule(ninjaDefsTestPctx, out, RuleParams{Command: "gen $out"})
+	}
+	for _, out := range m.properties.Outs {
+		ctx.Build(ninjaDefsTestPctx, BuildParams{
+			Rule:    rules[out],
+			Outputs: []string{ctx.ModuleName() + "/" + out},
+		})
+	}
+}
+
+func TestSetSortBuildStatements(t *testing.T) {
+	write := func(t *testing.T, sortBuildStatements bool, bp string) string {
+		t.Helper()
+		ctx := NewContext()
+		ctx.SetSortBuildStatements(sortBuildStatements)
+		// The module headers include the positions of the modules, which differ between the files.
+		ctx.SetNinjaModuleComments(false)
+		ctx.RegisterModuleType("ninja_defs_sort_test_module", func() (Module, []interface{}) {
+			m := &ninjaDefsSortTestModule{}
+			return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+		})
+		ctx.MockFileSystem(map[string][]byte{"Android.bp": []byte(bp)})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	// The same modules, defined in a different order and creating their rules and build statements
+	// in a different order.
+	const bp1 = `
+		ninja_defs_sort_test_module {
+		    name: "A",
+		    outs: ["b", "a", "c"],
+		}
+		ninja_defs_sort_test_module {
+		    name: "B",
+		    outs: ["x", "y"],
+		}
+	`
+	const bp2 = `
+		ninja_defs_sort_test_module {
+		    name: "B",
+		    outs: ["y", "x"],
+		}
+		ninja_defs_sort_test_module {
+		    name: "A",
+		    outs: ["c", "b", "a"],
+		}
+	`
+
+	t.Run("sorted", func(t *testing.T) {
+		out1, out2 := write(t, true, bp1), write(t, true, bp2)
+		if out1 != out2 {
+			t.Errorf("expected identical ninja output\nfirst:\n%s\nsecond:\n%s", out1, out2)
+		}
+		a, b, c := strings.Index(out1, "build A/a:"), strings.Index(out1, "build A/b:"), strings.Index(out1, "build A/c:")
+		if a < 0 || !(a < b && b < c) {
+			t.Errorf("expected build statements sorted by output, got:\n%s", out1)
+		}
+	})
+
+	t.Run("unsorted", func(t *testing.T) {
+		out := write(t, false, bp1)
+		b, a := strings.Index(out, "build A/b:"), strings.Index(out, "build A/a:")
+		if b < 0 || !(b < a) {
+			t.Errorf("expected build statements in creation order, got:\n%s", out)
+		}
+	})
+}
+
+func TestWriteBuildFileStreaming(t *testing.T) {
+	ctx := prepareNinjaDefsRulesTestContext(t, ninjaDefsBenchRules, false)
+
+	buffered := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buffered, false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	streamed := &countingWriter{}
+	if err := ctx.WriteBuildFileStreaming(streamed); err != nil {
+		t.Fatal(err)
+	}
+
+	if streamed.buf.String() != buffered.String() {
+		t.Errorf("streamed output differs from WriteBuildFile output")
+	}
+
+	if g, w := streamed.writes, buffered.Len()/streamingWriteBufferSize; g < w {
+		t.Errorf("expected output of %d bytes to be written in at least %d writes, got %d",
+			buffered.Len(), w, g)
+	}
+}
+
+func TestAffectedModules(t *testing.T) {
+	ctx := prepareNinjaDefsInputsTestContext(t)
+
+	testCases := []struct {
+		name    string
+		changed []string
+		want    []string
+	}{
+		{"leaf source", []string{"c.c"}, []string{"A", "B", "C"}},
+		{"uncleaned path", []string{"./c.c"}, []string{"A", "B", "C"}},
+		{"generated source", []string{"parser.y"}, []string{"A", "B"}},
+		{"command dependency", []string{"prebuilts/bison"}, []string{"A", "B"}},
+		{"shared source", []string{"common.c"}, []string{"A", "B", "C", "D"}},
+		{"top source", []string{"a.c"}, []string{"A"}},
+		{"blueprints file", []string{"d/Android.bp"}, []string{"D"}},
+		{"cycle", []string{"loop.c"}, []string{"loop"}},
+		{"local variable", []string{"e/e.c"}, []string{"E"}},
+		{"unrelated", []string{"unrelated.c"}, nil},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			modules, err := ctx.AffectedModules(tt.changed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, m := range modules {
+				got = append(got, m.Name())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("incorrect affected modules\nwant: %q\n got: %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -27,13 +27,17 @@ const eof = -1
 var (
 	defaultEscaper = strings.NewReplacer(
 		"\n", "$\n")
+	// A ':' terminates any path in a build statement, not just the outputs, so it is escaped in
+	// the inputs too.  Ninja has no escape for '|'.
 	inputEscaper = strings.NewReplacer(
 		"\n", "$\n",
-		" ", "$ ")
+		" ", "$ ",
+		":", "$:")
 	outputEscaper = strings.NewReplacer(
 		"\n", "$\n",
 		" ", "$ ",
 		":", "$:")
+	noopEscaper = strings.NewReplacer()
 )
 
 // ninjaString contains the parsed result of a string that can contain references to variables (e.g. $cflags) that will
@@ -98,6 +102,9 @@ func parseNinjaString(scope scope, str string) (*ninjaString, error) {
 	if ninjaString != nil {
 		return ninjaString, nil
 	}
+	if len(str) > 0 && str[0] == ' ' {
+		str = "$" + str
+	}
 	return simpleNinjaString(str), nil
 }
 
@@ -109,9 +116,8 @@ func parseNinjaOrSimpleString(scope scope, str string) (*ninjaString, string, er
 	// naively pre-allocate slice by counting $ signs
 	n := strings.Count(str, "$")
 	if n == 0 {
-		if len(str) > 0 && str[0] == ' ' {
-			str = "$" + str
-		}
+		// A leading space in a path is escaped by inputEscaper or outputEscaper when it is
+		// written, see parseNinjaString for other strings.
 		return nil, str, nil
 	}
 	variableReferences := make([]variableReference, 0, n)
@@ -384,6 +390,34 @@ func (n *ninjaString) Eval(variables map[Variable]*ninjaString) (string, error)
 	return w.String(), nil
 }
 
+// evalGlobals writes the value of the string to w, replacing references to the given global
+// variables with their evaluated values.  References to any other variables, such as rule
+// arguments, are written by name.
+func (n *ninjaString) evalGlobals(w *strings.Builder, variables map[Variable]*ninjaString,
+	nameTracker *nameTracker) {
+
+	if n.variables == nil || len(*n.variables) == 0 {
+		w.WriteString(defaultEscaper.Replace(n.str))
+		return
+	}
+
+	i := 0
+	for _, v := range *n.variables {
+		w.WriteString(defaultEscaper.Replace(n.str[i:v.start]))
+		if v.variable == nil {
+			w.WriteString("$ ")
+		} else if value, ok := variables[v.variable]; ok {
+			value.evalGlobals(w, variables, nameTracker)
+		} else {
+			w.WriteString("${")
+			w.WriteString(nameTracker.Variable(v.variable))
+			w.WriteString("}")
+		}
+		i = int(v.end)
+	}
+	w.WriteString(defaultEscaper.Replace(n.str[i:len(n.str)]))
+}
+
 func (n *ninjaString) Variables() []Variable {
 	if n.variables == nil || len(*n.variables) == 0 {
 		return nil
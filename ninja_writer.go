@@ -16,6 +16,7 @@ package blueprint
 
 import (
 	"io"
+	"math"
 	"strings"
 	"unicode"
 )
@@ -23,7 +24,7 @@ import (
 const (
 	indentWidth    = 4
 	maxIndentDepth = 2
-	lineWidth      = 80
+	lineWidth      = 80 // the default line width, see Context.SetNinjaLineWidth
 )
 
 var indentString = strings.Repeat(" ", indentWidth*maxIndentDepth)
@@ -36,20 +37,32 @@ type StringWriterWriter interface {
 type ninjaWriter struct {
 	writer StringWriterWriter
 
+	lineWidth int // the width at which lines are wrapped, or 0 to never wrap them
+
 	justDidBlankLine bool // true if the last operation was a BlankLine
 }
 
 func newNinjaWriter(writer StringWriterWriter) *ninjaWriter {
 	return &ninjaWriter{
-		writer: writer,
+		writer:    writer,
+		lineWidth: lineWidth,
+	}
+}
+
+// maxLineLen returns the maximum length of the part of a line that follows a header of
+// headerLen bytes.
+func (n *ninjaWriter) maxLineLen(headerLen int) int {
+	if n.lineWidth <= 0 {
+		return math.MaxInt
 	}
+	return n.lineWidth - headerLen
 }
 
 func (n *ninjaWriter) Comment(comment string) error {
 	n.justDidBlankLine = false
 
 	const lineHeaderLen = len("# ")
-	const maxLineLen = lineWidth - lineHeaderLen
+	maxLineLen := n.maxLineLen(lineHeaderLen)
 
 	var lineStart, lastSplitPoint int
 	for i, r := range comment {
@@ -122,11 +135,10 @@ func (n *ninjaWriter) Build(comment string, rule string, outputs, implicitOuts,
 	n.justDidBlankLine = false
 
 	const lineWrapLen = len(" $")
-	const maxLineLen = lineWidth - lineWrapLen
 
 	wrapper := &ninjaWriterWithWrap{
 		ninjaWriter: n,
-		maxLineLen:  maxLineLen,
+		maxLineLen:  n.maxLineLen(lineWrapLen),
 	}
 
 	if comment != "" {
@@ -265,11 +277,10 @@ func (n *ninjaWriter) Default(nameTracker *nameTracker, targets []*ninjaString,
 	n.justDidBlankLine = false
 
 	const lineWrapLen = len(" $")
-	const maxLineLen = lineWidth - lineWrapLen
 
 	wrapper := &ninjaWriterWithWrap{
 		ninjaWriter: n,
-		maxLineLen:  maxLineLen,
+		maxLineLen:  n.maxLineLen(lineWrapLen),
 	}
 
 	wrapper.WriteString("default")
@@ -16,6 +16,7 @@ package blueprint
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -143,6 +144,124 @@ func TestNinjaWriter(t *testing.T) {
 	}
 }
 
+func TestNinjaWriterPathEscaping(t *testing.T) {
+	testCases := []struct {
+		name   string
+		path   string
+		output string
+		input  string
+	}{
+		{name: "plain", path: "a/b.c", output: "a/b.c", input: "a/b.c"},
+		{name: "space", path: "a b.c", output: "a$ b.c", input: "a$ b.c"},
+		{name: "spaces", path: "a  b c", output: "a$ $ b$ c", input: "a$ $ b$ c"},
+		{name: "leading space", path: " a.c", output: "$ a.c", input: "$ a.c"},
+		{name: "trailing space", path: "a.c ", output: "a.c$ ", input: "a.c$ "},
+		{name: "colon", path: "a:b.c", output: "a$:b.c", input: "a$:b.c"},
+		{name: "drive letter", path: "c:/a b/c.c", output: "c$:/a$ b/c.c", input: "c$:/a$ b/c.c"},
+		{name: "dollar", path: "a$$b.c", output: "a$$b.c", input: "a$$b.c"},
+		{name: "dollar space", path: "a$$ b.c", output: "a$$$ b.c", input: "a$$$ b.c"},
+		{name: "dollar colon", path: "$$:a", output: "$$$:a", input: "$$$:a"},
+		{name: "variable", path: "${dir}/a b:c", output: "${namespace.dir}/a$ b$:c", input: "${namespace.dir}/a$ b$:c"},
+		{name: "variable leading space", path: " ${dir}", output: "$ ${namespace.dir}", input: "$ ${namespace.dir}"},
+		{name: "tab", path: "a\tb.c", output: "a\tb.c", input: "a\tb.c"},
+	}
+
+	scope := newLocalScope(nil, "namespace.")
+	if _, err := scope.AddLocalVariable("dir", "dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ninjaStrs, strs, err := parseNinjaOrSimpleStrings(scope, []string{tt.path})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buf := &strings.Builder{}
+			w := newNinjaWriter(buf)
+			o := []string{"o"}
+			// Use the path in each list of a build statement in turn to stay within the line width.
+			ck(w.Build("", "r", ninjaStrs, nil, ninjaStrs, nil, nil, nil,
+				strs, nil, strs, nil, nil, nil, &nameTracker{}))
+			ck(w.Build("", "r", nil, ninjaStrs, nil, ninjaStrs, nil, nil,
+				o, strs, nil, strs, nil, nil, &nameTracker{}))
+			ck(w.Build("", "r", nil, nil, nil, nil, ninjaStrs, ninjaStrs,
+				o, nil, nil, nil, strs, strs, &nameTracker{}))
+			ck(w.Default(&nameTracker{}, ninjaStrs, strs))
+
+			want := "build " + tt.output + ": r " + tt.input + "\n" +
+				"build o | " + tt.output + ": r | " + tt.input + "\n" +
+				"build o: r || " + tt.input + " |@ " + tt.input + "\n" +
+				"default " + tt.output + "\n"
+			if g := buf.String(); g != want {
+				t.Errorf("incorrect output for %q\nwant: %q\n got: %q", tt.path, want, g)
+			}
+		})
+	}
+}
+
+func TestNinjaWriterLineWidth(t *testing.T) {
+	var paths []string
+	for i := 0; i < 20; i++ {
+		paths = append(paths, fmt.Sprintf("dir/file%d.c", i))
+	}
+	longPath := strings.Repeat("long/", 10) + "file.c"
+
+	write := func(width int) string {
+		buf := &strings.Builder{}
+		w := newNinjaWriter(buf)
+		w.lineWidth = width
+		ck(w.Build("", "cc", nil, nil, nil, nil, nil, nil,
+			[]string{"out.o"}, nil, append([]string{longPath}, paths...), paths, paths, nil, nil))
+		ck(w.Default(nil, nil, paths))
+		return buf.String()
+	}
+
+	unwrapped := write(0)
+	if strings.Contains(unwrapped, "$\n") {
+		t.Errorf("expected no line continuations with wrapping disabled, got:\n%s", unwrapped)
+	}
+	if g := strings.Count(unwrapped, "\n"); g != 2 {
+		t.Errorf("expected 2 lines with wrapping disabled, got %d:\n%s", g, unwrapped)
+	}
+
+	for _, width := range []int{20, lineWidth, 200} {
+		t.Run(fmt.Sprint(width), func(t *testing.T) {
+			out := write(width)
+			for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+				// A line may only exceed the width if it holds a single path that doesn't fit.
+				if fields := strings.Fields(strings.TrimSuffix(line, " $")); len(line) > width && len(fields) > 1 {
+					t.Errorf("line of %d bytes exceeds width %d: %q", len(line), width, line)
+				}
+			}
+			// Joining the continuation lines must give back the unwrapped statements.
+			if g := strings.ReplaceAll(out, " $\n"+indentString[:indentWidth*2], " "); g != unwrapped {
+				t.Errorf("incorrect output after joining continuation lines\nwant: %q\n got: %q", unwrapped, g)
+			}
+		})
+	}
+
+	t.Run("comment", func(t *testing.T) {
+		comment := strings.Repeat("word ", 40)
+		for _, tt := range []struct {
+			width int
+			lines int
+		}{
+			{0, 1},
+			{lineWidth, 3},
+		} {
+			buf := &strings.Builder{}
+			w := newNinjaWriter(buf)
+			w.lineWidth = tt.width
+			ck(w.Comment(comment))
+			if g := strings.Count(buf.String(), "\n"); g != tt.lines {
+				t.Errorf("expected %d comment lines at width %d, got %d:\n%s", tt.lines, tt.width, g, buf.String())
+			}
+		}
+	})
+}
+
 func testNinjaStrings(s ...string) []*ninjaString {
 	ret, _ := parseNinjaStrings(nil, s)
 	return ret
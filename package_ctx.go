@@ -20,8 +20,11 @@ import (
 	"reflect"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/google/blueprint/pathtools"
 )
 
 // A PackageContext provides a way to create package-scoped Ninja pools,
@@ -60,6 +63,7 @@ type PackageContext interface {
 
 	StaticVariable(name, value string) Variable
 	VariableFunc(name string, f func(ctx VariableFuncContext, config interface{}) (string, error)) Variable
+	CacheableVariableFunc(name string, f func(ctx VariableFuncContext, config interface{}) (string, error)) Variable
 	VariableConfigMethod(name string, method interface{}) Variable
 
 	StaticPool(name string, params PoolParams) Pool
@@ -123,6 +127,8 @@ func NewPackageContext(pkgPath string) PackageContext {
 
 var Phony Rule = NewBuiltinRule("phony")
 
+// Console is Ninja's built-in console pool, whose jobs run one at a time with direct access to
+// the terminal.  Rules can set RuleParams.Console instead of setting it as their Pool.
 var Console Pool = NewBuiltinPool("console")
 
 var errRuleIsBuiltin = errors.New("the rule is a built-in")
@@ -313,6 +319,23 @@ type variableFunc struct {
 	pctx   *packageContext
 	name_  string
 	value_ func(VariableFuncContext, interface{}) (string, error)
+
+	// set by CacheableVariableFunc
+	cacheable bool
+}
+
+// variableFuncCacheKey identifies the variable and config that a CacheableVariableFunc was
+// evaluated for in Context.variableFuncCache.  The cache is kept by the Context so that the globs
+// of the function are recorded by each Context that uses it.
+type variableFuncCacheKey struct {
+	variable *variableFunc
+	config   interface{}
+}
+
+type variableFuncCacheEntry struct {
+	once  sync.Once
+	value *ninjaString
+	err   error
 }
 
 // VariableFuncContext is passed to VariableFunc functions.
@@ -322,8 +345,13 @@ type VariableFuncContext interface {
 	// Any directories will have a '/' suffix.  It also adds efficient
 	// dependencies to rerun the primary builder whenever a file matching
 	// the pattern as added or removed, without rerunning if a file that
-	// does not match the pattern is added to a searched directory.
+	// does not match the pattern is added to a searched directory.  See
+	// Context.SetReportEmptyGlobs for reporting patterns that match nothing.
 	GlobWithDeps(globPattern string, excludes []string) ([]string, error)
+
+	// GlobDirsWithDeps is like GlobWithDeps, but only returns the directories that match the
+	// pattern, without the '/' suffix, for example to use them as order-only dependencies.
+	GlobDirsWithDeps(globPattern string, excludes []string) ([]string, error)
 }
 
 type variableFuncContext struct {
@@ -332,7 +360,27 @@ type variableFuncContext struct {
 
 func (v *variableFuncContext) GlobWithDeps(pattern string,
 	excludes []string) ([]string, error) {
-	return v.context.glob(pattern, excludes)
+	matches, err := v.context.glob(pattern, excludes)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.context.checkEmptyGlob(pattern, excludes, matches); err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+func (v *variableFuncContext) GlobDirsWithDeps(pattern string,
+	excludes []string) ([]string, error) {
+	matches, err := v.GlobWithDeps(pattern, excludes)
+	if err != nil {
+		return nil, err
+	}
+	dirs := pathtools.FilterGlobMatches(matches, pathtools.GlobDirs)
+	for i, dir := range dirs {
+		dirs[i] = strings.TrimSuffix(dir, "/")
+	}
+	return dirs, nil
 }
 
 // VariableFunc returns a Variable whose value is determined by a function that
@@ -369,6 +417,35 @@ func (p *packageContext) VariableFunc(name string,
 	return v
 }
 
+// CacheableVariableFunc is like VariableFunc, but f must be a pure function of the config: it is
+// called at most once for each config by each Context, and the result is reused every time the
+// variable is evaluated, even if the variable is made live by several modules or singletons.  It is intended
+// for expensive functions, such as those that run a subprocess to find a tool.  The config is
+// compared with ==, so the result is not cached if the config is not comparable.
+func (p *packageContext) CacheableVariableFunc(name string,
+	f func(ctx VariableFuncContext, config interface{}) (string, error)) Variable {
+
+	checkCalledFromInit()
+
+	err := validateNinjaName(name)
+	if err != nil {
+		panic(err)
+	}
+
+	v := &variableFunc{
+		pctx:      p,
+		name_:     name,
+		value_:    f,
+		cacheable: true,
+	}
+	err = p.scope.AddVariable(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
 // VariableConfigMethod returns a Variable whose value is determined by calling
 // a method on the config object.  The method must take no arguments and return
 // a single string that will be the variable's value.  It may only be called
@@ -425,6 +502,28 @@ func (v *variableFunc) fullName(pkgNames map[*packageContext]string) string {
 }
 
 func (v *variableFunc) value(ctx VariableFuncContext, config interface{}) (*ninjaString, error) {
+	funcCtx, ok := ctx.(*variableFuncContext)
+	if !ok || !v.cacheable || (config != nil && !reflect.ValueOf(config).Comparable()) {
+		return v.evaluate(ctx, config)
+	}
+
+	c := funcCtx.context
+	key := variableFuncCacheKey{variable: v, config: config}
+	c.variableFuncCacheLock.Lock()
+	entry, ok := c.variableFuncCache[key]
+	if !ok {
+		entry = &variableFuncCacheEntry{}
+		c.variableFuncCache[key] = entry
+	}
+	c.variableFuncCacheLock.Unlock()
+
+	entry.once.Do(func() {
+		entry.value, entry.err = v.evaluate(ctx, config)
+	})
+	return entry.value, entry.err
+}
+
+func (v *variableFunc) evaluate(ctx VariableFuncContext, config interface{}) (*ninjaString, error) {
 	value, err := v.value_(ctx, config)
 	if err != nil {
 		return nil, err
@@ -567,7 +666,8 @@ type poolFunc struct {
 // represents a Ninja pool that will be output.  The name argument should
 // exactly match the Go variable name, and the string fields of the PoolParams
 // returned by f may reference other Ninja variables that are visible within the
-// calling Go package.
+// calling Go package.  The depth may be computed from the config, for example from
+// the number of CPUs it makes available to the build.
 func (p *packageContext) PoolFunc(name string, f func(interface{}) (PoolParams,
 	error)) Pool {
 
@@ -650,6 +750,50 @@ func (p *builtinPool) String() string {
 	return "<builtin>:" + p.name_
 }
 
+// concurrencyPool is the pool created for the rules with RuleParams.MaxConcurrency set.  There
+// is a single pool for each depth, which is not part of any package.
+type concurrencyPool struct {
+	depth int
+}
+
+var (
+	maxConcurrencyPoolsLock sync.Mutex
+	maxConcurrencyPools     = make(map[int]Pool)
+)
+
+// maxConcurrencyPool returns the pool shared by the rules with RuleParams.MaxConcurrency set to
+// depth.
+func maxConcurrencyPool(depth int) Pool {
+	maxConcurrencyPoolsLock.Lock()
+	defer maxConcurrencyPoolsLock.Unlock()
+	pool, ok := maxConcurrencyPools[depth]
+	if !ok {
+		pool = &concurrencyPool{depth: depth}
+		maxConcurrencyPools[depth] = pool
+	}
+	return pool
+}
+
+func (p *concurrencyPool) packageContext() *packageContext {
+	return nil
+}
+
+func (p *concurrencyPool) name() string {
+	return "max_concurrency_" + strconv.Itoa(p.depth)
+}
+
+func (p *concurrencyPool) fullName(pkgNames map[*packageContext]string) string {
+	return p.name()
+}
+
+func (p *concurrencyPool) def(config interface{}) (*poolDef, error) {
+	return &poolDef{Depth: p.depth}, nil
+}
+
+func (p *concurrencyPool) String() string {
+	return "<max_concurrency>:" + strconv.Itoa(p.depth)
+}
+
 type staticRule struct {
 	pctx       *packageContext
 	name_      string
@@ -201,6 +201,7 @@ const (
 	BoolType
 	StringType
 	Int64Type
+	Float64Type
 	ListType
 	MapType
 	UnsetType
@@ -216,6 +217,8 @@ func (t Type) String() string {
 		return "string"
 	case Int64Type:
 		return "int64"
+	case Float64Type:
+		return "float64"
 	case ListType:
 		return "list"
 	case MapType:
@@ -292,7 +295,18 @@ func evaluateOperator(scope *Scope, operator rune, left, right Expression) (Expr
 		}
 		v.Value += r.(*String).Value
 	case *Int64:
-		v.Value += r.(*Int64).Value
+		ri, ok := r.(*Int64)
+		if !ok {
+			return nil, fmt.Errorf("can't add %s value to int64 value", r.Type())
+		}
+		v.Value += ri.Value
+		v.Token = ""
+	case *Float64:
+		rf, ok := r.(*Float64)
+		if !ok {
+			return nil, fmt.Errorf("can't add %s value to float64 value", r.Type())
+		}
+		v.Value += rf.Value
 		v.Token = ""
 	case *List:
 		v.Values = append(v.Values, r.(*List).Values...)
@@ -414,6 +428,58 @@ func (x *Variable) Type() Type {
 	return x.Type_
 }
 
+// An EnvRef is a soong_env("NAME") or soong_env("NAME", "default") expression, which evaluates to
+// the value of an environment variable in the Environment of the scope, or to the default if the
+// variable is not defined.
+type EnvRef struct {
+	KeywordPos scanner.Position
+	Name       String
+	Default    *String
+	RParenPos  scanner.Position
+}
+
+func (x *EnvRef) Pos() scanner.Position { return x.KeywordPos }
+func (x *EnvRef) End() scanner.Position { return endPos(x.RParenPos, 1) }
+
+func (x *EnvRef) Copy() Expression {
+	ret := *x
+	if x.Default != nil {
+		def := *x.Default
+		ret.Default = &def
+	}
+	return &ret
+}
+
+func (x *EnvRef) Eval(scope *Scope) (Expression, error) {
+	if value, ok := scope.lookupEnv(x.Name.Value); ok {
+		return &String{LiteralPos: x.KeywordPos, Value: value}, nil
+	}
+	if x.Default != nil {
+		return &String{LiteralPos: x.KeywordPos, Value: x.Default.Value}, nil
+	}
+	return nil, &ParseError{
+		Err: fmt.Errorf("environment variable %q is not defined and soong_env has no default", x.Name.Value),
+		Pos: x.Name.LiteralPos,
+	}
+}
+
+func (x *EnvRef) PrintfInto(value string) error {
+	return nil
+}
+
+func (x *EnvRef) MarkReferencedVariables(scope *Scope) {}
+
+func (x *EnvRef) String() string {
+	if x.Default != nil {
+		return fmt.Sprintf("soong_env(%q, %q)@%s", x.Name.Value, x.Default.Value, x.KeywordPos)
+	}
+	return fmt.Sprintf("soong_env(%q)@%s", x.Name.Value, x.KeywordPos)
+}
+
+func (x *EnvRef) Type() Type {
+	return StringType
+}
+
 type Map struct {
 	LBracePos  scanner.Position
 	RBracePos  scanner.Position
@@ -675,6 +741,41 @@ func (x *Int64) Type() Type {
 	return Int64Type
 }
 
+// A Float64 is a floating point literal.  Token holds the literal as written, or is empty if the
+// value was computed by an operator.
+type Float64 struct {
+	LiteralPos scanner.Position
+	Value      float64
+	Token      string
+}
+
+func (x *Float64) Pos() scanner.Position { return x.LiteralPos }
+func (x *Float64) End() scanner.Position { return endPos(x.LiteralPos, len(x.Token)) }
+
+func (x *Float64) Copy() Expression {
+	ret := *x
+	return &ret
+}
+
+func (x *Float64) Eval(scope *Scope) (Expression, error) {
+	return x, nil
+}
+
+func (x *Float64) PrintfInto(value string) error {
+	return nil
+}
+
+func (x *Float64) MarkReferencedVariables(scope *Scope) {
+}
+
+func (x *Float64) String() string {
+	return fmt.Sprintf("%g@%s", x.Value, x.LiteralPos)
+}
+
+func (x *Float64) Type() Type {
+	return Float64Type
+}
+
 type Bool struct {
 	LiteralPos scanner.Position
 	Value      bool
@@ -18,7 +18,10 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"slices"
 	"sort"
+	"strings"
+	"text/scanner"
 )
 
 func AddStringToList(list *List, s string) (modified bool) {
@@ -69,6 +72,278 @@ func ReplaceStringsInList(list *List, replacements map[string]string) (replaced
 	return modified
 }
 
+// AddRemoveSet is the operation that ModifyProperty applies to a list property.
+type AddRemoveSet int
+
+const (
+	// ModifyAdd appends the values that are not already in the list.
+	ModifyAdd AddRemoveSet = iota
+	// ModifyRemove removes the values from the list.
+	ModifyRemove
+	// ModifySet replaces the contents of the list with the values.
+	ModifySet
+)
+
+func (op AddRemoveSet) String() string {
+	switch op {
+	case ModifyAdd:
+		return "add"
+	case ModifyRemove:
+		return "remove"
+	case ModifySet:
+		return "set"
+	default:
+		return fmt.Sprintf("AddRemoveSet(%d)", int(op))
+	}
+}
+
+// ModifyProperty adds, removes or sets the values of the list of strings property of the modules
+// in file whose name is moduleName.  The property may be the dotted name of a property nested in
+// maps, like "arch.arm.srcs".  The property, and any map above it, is created if it doesn't exist
+// and values are added or set, and the property is removed if it becomes empty.  Added values are
+// sorted into lists that were sorted before, and set values are kept in the order they are given.
+// The rest of the file is left untouched, so that Print keeps its formatting and comments.
+func ModifyProperty(file *File, moduleName, property string, op AddRemoveSet, values []string) error {
+	found := false
+	for _, def := range file.Defs {
+		module, ok := def.(*Module)
+		if !ok {
+			continue
+		}
+		if name, ok := module.GetProperty("name"); !ok {
+			continue
+		} else if s, ok := name.Value.(*String); !ok || s.Value != moduleName {
+			continue
+		}
+		found = true
+
+		if err := modifyModuleProperty(file, module, property, op, values); err != nil {
+			return fmt.Errorf("module %q: %w", moduleName, err)
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("module %q not found", moduleName)
+	}
+	return nil
+}
+
+func modifyModuleProperty(file *File, module *Module, property string, op AddRemoveSet, values []string) error {
+	create := op != ModifyRemove && len(values) > 0
+	names := strings.Split(property, ".")
+
+	m := &module.Map
+	for i, name := range names[:len(names)-1] {
+		if prop, ok := m.GetProperty(name); ok {
+			mm, ok := prop.Value.(*Map)
+			if !ok {
+				return fmt.Errorf("expected property %q to be a map, found %s",
+					strings.Join(names[:i+1], "."), prop.Value.Type())
+			}
+			m = mm
+		} else if create {
+			mm := &Map{}
+			m.Properties = append(m.Properties, &Property{Name: name, Value: mm})
+			m = mm
+		} else {
+			return nil
+		}
+	}
+
+	name := names[len(names)-1]
+	prop, ok := m.GetProperty(name)
+	if !ok {
+		if !create {
+			return nil
+		}
+		prop = &Property{Name: name, Value: &List{}}
+		m.Properties = append(m.Properties, prop)
+	}
+
+	list, ok := prop.Value.(*List)
+	if !ok {
+		return fmt.Errorf("expected property %q to be a list, found %s", property, prop.Value.Type())
+	}
+	for _, v := range list.Values {
+		if _, ok := v.(*String); !ok {
+			return fmt.Errorf("expected property %q to be a list of strings, found %s in list",
+				property, v.Type())
+		}
+	}
+
+	wasSorted := ListIsSorted(list)
+	modified := false
+	switch op {
+	case ModifyAdd:
+		for _, v := range values {
+			modified = AddStringToList(list, v) || modified
+		}
+	case ModifyRemove:
+		for _, v := range values {
+			i := slices.IndexFunc(list.Values, func(e Expression) bool { return e.(*String).Value == v })
+			if i >= 0 {
+				removeListValue(file, list, i)
+				modified = true
+			}
+		}
+	case ModifySet:
+		for i := len(list.Values) - 1; i >= 0; i-- {
+			removeListValue(file, list, i)
+		}
+		for _, v := range values {
+			AddStringToList(list, v)
+		}
+		modified = true
+	default:
+		return fmt.Errorf("unknown operation %s", op)
+	}
+
+	if modified && len(list.Values) == 0 {
+		removeMapProperty(file, m, slices.Index(m.Properties, prop))
+	} else if modified && wasSorted && op == ModifyAdd {
+		SortList(file, list)
+	}
+	return nil
+}
+
+// removeListValue removes the value at index i from list, see removeNode.
+func removeListValue(file *File, list *List, i int) {
+	prev, next := list.LBracePos, list.RBracePos
+	if i > 0 {
+		prev = list.Values[i-1].End()
+	}
+	if i < len(list.Values)-1 {
+		next = list.Values[i+1].Pos()
+	}
+	removeNode(file, prev, list.Values[i].Pos(), list.Values[i].End(), next)
+	list.Values = slices.Delete(list.Values, i, i+1)
+}
+
+// removeMapProperty removes the property at index i from m, see removeNode.
+func removeMapProperty(file *File, m *Map, i int) {
+	prev, next := m.LBracePos, m.RBracePos
+	if i > 0 {
+		prev = m.Properties[i-1].End()
+	}
+	if i < len(m.Properties)-1 {
+		next = m.Properties[i+1].Pos()
+	}
+	removeNode(file, prev, m.Properties[i].Pos(), m.Properties[i].End(), next)
+	m.Properties = slices.Delete(m.Properties, i, i+1)
+}
+
+// removeNode removes the comments that start on the last line of a node that is being removed
+// from pos to end, after it and before next, the start of the next token.  If the node was on
+// lines of its own, between prev, the end of the previous token, and next, the lines of
+// everything after it are moved up so that Print doesn't leave a blank line in its place.
+func removeNode(file *File, prev, pos, end, next scanner.Position) {
+	file.Comments = slices.DeleteFunc(file.Comments, func(c *CommentGroup) bool {
+		return c.Pos().Offset >= pos.Offset && c.Pos().Offset < next.Offset && c.Pos().Line == end.Line
+	})
+
+	if !pos.IsValid() || prev.Line >= pos.Line || next.Line <= end.Line {
+		return
+	}
+	lines := end.Line - pos.Line + 1
+	shift := func(p *scanner.Position) {
+		if p.IsValid() && p.Offset > end.Offset {
+			p.Line -= lines
+		}
+	}
+	walkPositions(file, shift)
+}
+
+// walkPositions calls f with a pointer to each position in file.
+func walkPositions(file *File, f func(*scanner.Position)) {
+	var expression func(e Expression)
+	var property func(p *Property)
+	mapPositions := func(m *Map) {
+		f(&m.LBracePos)
+		f(&m.RBracePos)
+		for _, p := range m.Properties {
+			property(p)
+		}
+	}
+	property = func(p *Property) {
+		f(&p.NamePos)
+		f(&p.ColonPos)
+		expression(p.Value)
+	}
+	expression = func(e Expression) {
+		switch e := e.(type) {
+		case *Variable:
+			f(&e.NamePos)
+		case *Operator:
+			f(&e.OperatorPos)
+			expression(e.Args[0])
+			expression(e.Args[1])
+		case *Bool:
+			f(&e.LiteralPos)
+		case *Int64:
+			f(&e.LiteralPos)
+		case *Float64:
+			f(&e.LiteralPos)
+		case *String:
+			f(&e.LiteralPos)
+		case *List:
+			f(&e.LBracePos)
+			f(&e.RBracePos)
+			for _, v := range e.Values {
+				expression(v)
+			}
+		case *Map:
+			mapPositions(e)
+		case *Select:
+			f(&e.KeywordPos)
+			for i := range e.Conditions {
+				f(&e.Conditions[i].position)
+				for j := range e.Conditions[i].Args {
+					f(&e.Conditions[i].Args[j].LiteralPos)
+				}
+			}
+			f(&e.LBracePos)
+			f(&e.RBracePos)
+			for _, c := range e.Cases {
+				for i := range c.Patterns {
+					expression(c.Patterns[i].Value)
+					f(&c.Patterns[i].Binding.NamePos)
+				}
+				f(&c.ColonPos)
+				expression(c.Value)
+			}
+			if e.Append != nil {
+				expression(e.Append)
+			}
+		case *EnvRef:
+			f(&e.KeywordPos)
+			f(&e.Name.LiteralPos)
+			if e.Default != nil {
+				f(&e.Default.LiteralPos)
+			}
+			f(&e.RParenPos)
+		case *UnsetProperty:
+			f(&e.Position)
+		}
+	}
+
+	for _, def := range file.Defs {
+		switch def := def.(type) {
+		case *Assignment:
+			f(&def.NamePos)
+			f(&def.EqualsPos)
+			expression(def.Value)
+		case *Module:
+			f(&def.TypePos)
+			mapPositions(&def.Map)
+		}
+	}
+	for _, cg := range file.Comments {
+		for _, c := range cg.Comments {
+			f(&c.Slash)
+		}
+	}
+}
+
 // A Patch represents a region of a text buffer to be replaced [Start, End) and its Replacement
 type Patch struct {
 	Start, End  int
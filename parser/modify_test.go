@@ -63,3 +63,219 @@ func TestPatchList(t *testing.T) {
 		t.Errorf("expected %q, got %q", expected, got)
 	}
 }
+
+func TestModifyProperty(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		module   string
+		property string
+		op       AddRemoveSet
+		values   []string
+		output   string
+		err      string
+	}{
+		{
+			name: "add to existing",
+			input: `
+foo {
+    name: "foo",
+    // Comment on srcs
+    srcs: [
+        "a.c", // Trailing comment on a.c
+        "c.c",
+    ],
+}
+`,
+			module:   "foo",
+			property: "srcs",
+			op:       ModifyAdd,
+			values:   []string{"b.c", "a.c"},
+			output: `
+foo {
+    name: "foo",
+    // Comment on srcs
+    srcs: [
+        "a.c", // Trailing comment on a.c
+        "b.c",
+        "c.c",
+    ],
+}
+`,
+		},
+		{
+			name: "add creating",
+			input: `
+foo {
+    name: "foo",
+}
+
+foo {
+    name: "bar",
+}
+`,
+			module:   "bar",
+			property: "arch.arm.srcs",
+			op:       ModifyAdd,
+			values:   []string{"arm.c"},
+			output: `
+foo {
+    name: "foo",
+}
+
+foo {
+    name: "bar",
+    arch: {
+        arm: {
+            srcs: ["arm.c"],
+        },
+    },
+}
+`,
+		},
+		{
+			name: "remove last",
+			input: `
+foo {
+    name: "foo",
+    srcs: ["a.c"],
+    cflags: ["-Wall"], // Trailing comment on cflags
+}
+`,
+			module:   "foo",
+			property: "srcs",
+			op:       ModifyRemove,
+			values:   []string{"a.c"},
+			output: `
+foo {
+    name: "foo",
+    cflags: ["-Wall"], // Trailing comment on cflags
+}
+`,
+		},
+		{
+			name: "remove from middle",
+			input: `
+foo {
+    name: "foo",
+    srcs: [
+        "a.c",
+        "b.c", // Trailing comment on b.c
+        "c.c", // Trailing comment on c.c
+    ],
+}
+`,
+			module:   "foo",
+			property: "srcs",
+			op:       ModifyRemove,
+			values:   []string{"b.c"},
+			output: `
+foo {
+    name: "foo",
+    srcs: [
+        "a.c",
+        "c.c", // Trailing comment on c.c
+    ],
+}
+`,
+		},
+		{
+			name: "remove missing",
+			input: `
+foo {
+    name: "foo",
+}
+`,
+			module:   "foo",
+			property: "arch.arm.srcs",
+			op:       ModifyRemove,
+			values:   []string{"a.c"},
+			output: `
+foo {
+    name: "foo",
+}
+`,
+		},
+		{
+			name: "set overwrite",
+			input: `
+foo {
+    name: "foo",
+    srcs: [
+        "a.c",
+        "b.c",
+    ],
+}
+`,
+			module:   "foo",
+			property: "srcs",
+			op:       ModifySet,
+			values:   []string{"d.c", "c.c"},
+			output: `
+foo {
+    name: "foo",
+    srcs: [
+        "d.c",
+        "c.c",
+    ],
+}
+`,
+		},
+		{
+			name: "missing module",
+			input: `
+foo {
+    name: "foo",
+}
+`,
+			module:   "bar",
+			property: "srcs",
+			op:       ModifyAdd,
+			values:   []string{"a.c"},
+			err:      `module "bar" not found`,
+		},
+		{
+			name: "not a list",
+			input: `
+foo {
+    name: "foo",
+    stem: "bar",
+}
+`,
+			module:   "foo",
+			property: "stem",
+			op:       ModifyAdd,
+			values:   []string{"a.c"},
+			err:      `module "foo": expected property "stem" to be a list, found string`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			file, errs := Parse("", bytes.NewBufferString(testCase.input[1:]))
+			if len(errs) != 0 {
+				t.Fatalf("unexpected errors: %q", errs)
+			}
+
+			err := ModifyProperty(file, testCase.module, testCase.property, testCase.op, testCase.values)
+			if testCase.err != "" {
+				if err == nil || err.Error() != testCase.err {
+					t.Fatalf("expected error %q, got %v", testCase.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got, err := Print(file)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if expected := testCase.output[1:]; string(got) != expected {
+				t.Errorf("  expected: %s", expected)
+				t.Errorf("       got: %s", got)
+			}
+		})
+	}
+}
@@ -18,9 +18,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/scanner"
 )
 
@@ -88,7 +90,7 @@ func ParseAndEval(filename string, r io.Reader, scope *Scope) (file *File, errs
 					return nil, []error{err}
 				}
 				switch newval.(type) {
-				case *String, *Bool, *Int64, *Select, *Map, *List:
+				case *String, *Bool, *Int64, *Float64, *Select, *Map, *List:
 					// ok
 				default:
 					panic(fmt.Sprintf("Evaled but got %#v\n", newval))
@@ -140,8 +142,8 @@ func newParser(r io.Reader) *parser {
 	p.scanner.Error = func(sc *scanner.Scanner, msg string) {
 		p.errorf(msg)
 	}
-	p.scanner.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanStrings |
-		scanner.ScanRawStrings | scanner.ScanComments
+	p.scanner.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanFloats |
+		scanner.ScanStrings | scanner.ScanRawStrings | scanner.ScanComments
 	return p
 }
 
@@ -150,6 +152,11 @@ func (p *parser) error(err error) {
 	if !pos.IsValid() {
 		pos = p.scanner.Pos()
 	}
+	p.errorAt(pos, err)
+}
+
+// errorAt reports an error at pos instead of at the current token.
+func (p *parser) errorAt(pos scanner.Position, err error) {
 	err = &ParseError{
 		Err: err,
 		Pos: pos,
@@ -368,11 +375,13 @@ func (p *parser) parseValue() (value Expression) {
 			return p.parseBoolean()
 		case "select":
 			return p.parseSelect()
+		case "soong_env":
+			return p.parseEnvRef()
 		default:
 			return p.parseVariable()
 		}
-	case '-', scanner.Int: // Integer might have '-' sign ahead ('+' is only treated as operator now)
-		return p.parseIntValue()
+	case '-', scanner.Int, scanner.Float: // Numbers might have '-' sign ahead ('+' is only treated as operator now)
+		return p.parseNumberValue()
 	case scanner.String, scanner.RawString:
 		return p.parseStringValue()
 	case '[':
@@ -415,6 +424,44 @@ func (p *parser) parseVariable() Expression {
 	return value
 }
 
+func (p *parser) parseEnvRef() Expression {
+	result := &EnvRef{
+		KeywordPos: p.scanner.Position,
+	}
+	// Read the "soong_env("
+	p.accept(scanner.Ident)
+	if !p.accept('(') {
+		return nil
+	}
+
+	if p.tok != scanner.String && p.tok != scanner.RawString {
+		p.errorf("expected environment variable name string, found %s", scanner.TokenString(p.tok))
+		return nil
+	}
+	if s := p.parseStringValue(); s != nil {
+		result.Name = *s
+	} else {
+		return nil
+	}
+
+	if p.tok == ',' {
+		p.accept(',')
+		if p.tok != scanner.String && p.tok != scanner.RawString {
+			p.errorf("expected default value string, found %s", scanner.TokenString(p.tok))
+			return nil
+		}
+		if result.Default = p.parseStringValue(); result.Default == nil {
+			return nil
+		}
+	}
+
+	result.RParenPos = p.scanner.Position
+	if !p.accept(')') {
+		return nil
+	}
+	return result
+}
+
 func (p *parser) parseSelect() Expression {
 	result := &Select{
 		KeywordPos: p.scanner.Position,
@@ -713,21 +760,34 @@ func (p *parser) parseStringValue() *String {
 	return value
 }
 
-func (p *parser) parseIntValue() *Int64 {
+// parseNumberValue parses an integer or floating point literal with an optional '-' sign.
+// Literals that don't fit in an int64 or a float64 are reported at the start of the literal.
+func (p *parser) parseNumberValue() Expression {
 	var str string
 	literalPos := p.scanner.Position
 	if p.tok == '-' {
 		str += string(p.tok)
 		p.accept(p.tok)
-		if p.tok != scanner.Int {
-			p.errorf("expected int; found %s", scanner.TokenString(p.tok))
-			return nil
+		if p.tok != scanner.Int && p.tok != scanner.Float {
+			p.errorf("expected int or float; found %s", scanner.TokenString(p.tok))
+			return (*Int64)(nil)
 		}
 	}
 	str += p.scanner.TokenText()
+
+	if p.tok == scanner.Float {
+		return p.parseFloatValue(str, literalPos)
+	}
+	return p.parseIntValue(str, literalPos)
+}
+
+func (p *parser) parseIntValue(str string, literalPos scanner.Position) *Int64 {
 	i, err := strconv.ParseInt(str, 10, 64)
-	if err != nil {
-		p.errorf("couldn't parse int: %s", err)
+	if errors.Is(err, strconv.ErrRange) {
+		p.errorAt(literalPos, fmt.Errorf("integer %s is out of range for int64", str))
+		return nil
+	} else if err != nil {
+		p.errorAt(literalPos, fmt.Errorf("couldn't parse int: %s", err))
 		return nil
 	}
 
@@ -740,6 +800,25 @@ func (p *parser) parseIntValue() *Int64 {
 	return value
 }
 
+func (p *parser) parseFloatValue(str string, literalPos scanner.Position) *Float64 {
+	f, err := strconv.ParseFloat(str, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		p.errorAt(literalPos, fmt.Errorf("float %s is out of range for float64", str))
+		return nil
+	} else if err != nil {
+		p.errorAt(literalPos, fmt.Errorf("couldn't parse float: %s", err))
+		return nil
+	}
+
+	value := &Float64{
+		LiteralPos: literalPos,
+		Value:      f,
+		Token:      str,
+	}
+	p.accept(scanner.Float)
+	return value
+}
+
 func (p *parser) parseListValue() *List {
 	lBracePos := p.scanner.Position
 	if !p.accept('[') {
@@ -791,6 +870,9 @@ type Scope struct {
 	vars              map[string]*Assignment
 	preventInheriting map[string]bool
 	parentScope       *Scope
+
+	// set by SetEnvironment
+	env *Environment
 }
 
 func NewScope(s *Scope) *Scope {
@@ -862,6 +944,57 @@ func (s *Scope) GetLocal(name string) *Assignment {
 	return nil
 }
 
+// SetEnvironment sets the environment variables that soong_env expressions evaluated in this scope
+// and the scopes that inherit from it can read.
+func (s *Scope) SetEnvironment(env *Environment) {
+	s.env = env
+}
+
+// lookupEnv returns the value of an environment variable in the closest Environment of the scope or
+// its parents, and records that it was read.  Without an Environment, no variables are defined.
+func (s *Scope) lookupEnv(name string) (string, bool) {
+	for ; s != nil; s = s.parentScope {
+		if s.env != nil {
+			return s.env.lookup(name)
+		}
+	}
+	return "", false
+}
+
+// An Environment holds the environment variables that can be read by soong_env expressions, and
+// records the variables that were read so that the output can be regenerated when they change.
+// It can be shared by scopes that are evaluated concurrently.
+type Environment struct {
+	vars map[string]string
+
+	lock sync.Mutex
+	deps map[string]string
+}
+
+// NewEnvironment returns an Environment that defines the variables in vars.
+func NewEnvironment(vars map[string]string) *Environment {
+	return &Environment{
+		vars: vars,
+		deps: make(map[string]string),
+	}
+}
+
+func (e *Environment) lookup(name string) (string, bool) {
+	value, ok := e.vars[name]
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.deps[name] = value
+	return value, ok
+}
+
+// Deps returns the variables that were read by soong_env expressions and their values, with an
+// empty value for variables that were not defined.
+func (e *Environment) Deps() map[string]string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return maps.Clone(e.deps)
+}
+
 // DontInherit prevents this scope from inheriting the given variable from its
 // parent scope.
 func (s *Scope) DontInherit(name string) {
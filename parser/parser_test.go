@@ -871,6 +871,36 @@ func TestParserError(t *testing.T) {
 			`,
 			err: "Found duplicate select pattern binding: bar",
 		},
+		{
+			name:  "int64 overflow",
+			input: `m { foo: 9223372036854775808 }`,
+			err:   "<input>:1:10: integer 9223372036854775808 is out of range for int64",
+		},
+		{
+			name:  "int64 underflow",
+			input: `m { foo: -9223372036854775809 }`,
+			err:   "<input>:1:10: integer -9223372036854775809 is out of range for int64",
+		},
+		{
+			name:  "float64 overflow",
+			input: `m { foo: -1e400 }`,
+			err:   "<input>:1:10: float -1e400 is out of range for float64",
+		},
+		{
+			name:  "malformed exponent",
+			input: `m { foo: 1e }`,
+			err:   "exponent has no digits",
+		},
+		{
+			name:  "malformed hex",
+			input: `m { foo: 0x1 }`,
+			err:   `<input>:1:10: couldn't parse int: strconv.ParseInt: parsing "0x1": invalid syntax`,
+		},
+		{
+			name:  "negative string",
+			input: `m { foo: -"1" }`,
+			err:   "expected int or float; found String",
+		},
 		// TODO: test more parser errors
 	}
 
@@ -970,3 +1000,190 @@ func TestParserNotEvaluated(t *testing.T) {
 		t.Errorf("Attempt to print FOO returned %s", assignment.Value.String())
 	}
 }
+
+func TestParseEnvRef(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		value string
+		err   string
+		deps  map[string]string
+	}{
+		{
+			name:  "defined",
+			input: `m { foo: "prefix-" + soong_env("DEFINED") }`,
+			value: "prefix-value",
+			deps:  map[string]string{"DEFINED": "value"},
+		},
+		{
+			name:  "defined with default",
+			input: `m { foo: soong_env("DEFINED", "default") }`,
+			value: "value",
+			deps:  map[string]string{"DEFINED": "value"},
+		},
+		{
+			name:  "defaulted",
+			input: `m { foo: soong_env("UNDEFINED", "default") }`,
+			value: "default",
+			deps:  map[string]string{"UNDEFINED": ""},
+		},
+		{
+			name: "in assignment",
+			input: `
+				bar = soong_env("EMPTY", "default")
+				m { foo: bar }
+			`,
+			value: "",
+			deps:  map[string]string{"EMPTY": ""},
+		},
+		{
+			name:  "undefined",
+			input: `m { foo: soong_env("UNDEFINED") }`,
+			err:   `<input>:1:20: environment variable "UNDEFINED" is not defined and soong_env has no default`,
+		},
+		{
+			name:  "not a string",
+			input: `m { foo: soong_env(UNDEFINED) }`,
+			err:   `<input>:1:20: expected environment variable name string, found Ident`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			env := NewEnvironment(map[string]string{
+				"DEFINED": "value",
+				"EMPTY":   "",
+			})
+			scope := NewScope(nil)
+			scope.SetEnvironment(env)
+
+			file, errs := ParseAndEval("", bytes.NewBufferString(testCase.input), NewScope(scope))
+			if testCase.err != "" {
+				if len(errs) != 1 || errs[0].Error() != testCase.err {
+					t.Fatalf("expected error %q, got %q", testCase.err, errs)
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %q", errs)
+			}
+
+			foo, _ := file.Defs[0].(*Module).GetProperty("foo")
+			if s, ok := foo.Value.(*String); !ok || s.Value != testCase.value {
+				t.Errorf("expected foo to be %q, got %s", testCase.value, foo.Value)
+			}
+			if got := env.Deps(); !reflect.DeepEqual(got, testCase.deps) {
+				t.Errorf("expected env deps %q, got %q", testCase.deps, got)
+			}
+		})
+	}
+}
+
+// flattenMap returns the string values of a map literal and of the map literals nested in it,
+// keyed by their dotted property names.
+func flattenMap(prefix string, m *Map, values map[string]string) {
+	for _, prop := range m.Properties {
+		switch v := prop.Value.(type) {
+		case *Map:
+			flattenMap(prefix+prop.Name+".", v, values)
+		case *String:
+			values[prefix+prop.Name] = v.Value
+		}
+	}
+}
+
+func TestParseMapLiteral(t *testing.T) {
+	input := `
+		base = {
+			a: "1",
+			nested: {
+				b: "2",
+			},
+		}
+
+		m {
+			labels: base + {
+				c: "3",
+				nested: {
+					d: "4",
+				},
+			},
+		}
+	`
+	file, errs := ParseAndEval("", bytes.NewBufferString(input), NewScope(nil))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	var module *Module
+	for _, def := range file.Defs {
+		if m, ok := def.(*Module); ok {
+			module = m
+		}
+	}
+	if module == nil {
+		t.Fatalf("missing module")
+	}
+	prop, ok := module.GetProperty("labels")
+	if !ok {
+		t.Fatalf("missing labels property")
+	}
+	m, ok := prop.Value.(*Map)
+	if !ok {
+		t.Fatalf("expected map value, got %s", prop.Value.Type())
+	}
+
+	got := make(map[string]string)
+	flattenMap("", m, got)
+	want := map[string]string{"a": "1", "nested.b": "2", "nested.d": "4", "c": "3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestParseNumbers(t *testing.T) {
+	testCases := []struct {
+		input string
+		value Expression
+	}{
+		{"0", &Int64{Value: 0, Token: "0"}},
+		{"-12", &Int64{Value: -12, Token: "-12"}},
+		{"9223372036854775807", &Int64{Value: 9223372036854775807, Token: "9223372036854775807"}},
+		{"-9223372036854775808", &Int64{Value: -9223372036854775808, Token: "-9223372036854775808"}},
+		{"1.5", &Float64{Value: 1.5, Token: "1.5"}},
+		{"-0.25", &Float64{Value: -0.25, Token: "-0.25"}},
+		{".5", &Float64{Value: 0.5, Token: ".5"}},
+		{"1e3", &Float64{Value: 1000, Token: "1e3"}},
+		{"1.5 + 2", nil},
+		{"2 + 1.5", nil},
+		{"1.25 + 2.5", &Float64{Value: 3.75}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			input := "m { foo: " + tc.input + " }"
+			file, errs := ParseAndEval("", bytes.NewBufferString(input), NewScope(nil))
+			if tc.value == nil {
+				if len(errs) == 0 {
+					t.Fatalf("missing expected error")
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+
+			prop, _ := file.Defs[0].(*Module).GetProperty("foo")
+			got := prop.Value.Copy()
+			switch v := got.(type) {
+			case *Int64:
+				v.LiteralPos = scanner.Position{}
+			case *Float64:
+				v.LiteralPos = scanner.Position{}
+			}
+			if !reflect.DeepEqual(got, tc.value) {
+				t.Errorf("expected %#v, got %#v", tc.value, got)
+			}
+		})
+	}
+}
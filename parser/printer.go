@@ -125,6 +125,8 @@ func (p *printer) printExpression(value Expression) {
 		p.printToken(s, v.LiteralPos)
 	case *Int64:
 		p.printToken(strconv.FormatInt(v.Value, 10), v.LiteralPos)
+	case *Float64:
+		p.printToken(formatFloat(v.Value), v.LiteralPos)
 	case *String:
 		p.printToken(strconv.Quote(v.Value), v.LiteralPos)
 	case *List:
@@ -133,11 +135,24 @@ func (p *printer) printExpression(value Expression) {
 		p.printMap(v)
 	case *Select:
 		p.printSelect(v)
+	case *EnvRef:
+		p.printEnvRef(v)
 	default:
 		panic(fmt.Errorf("bad property type: %v", value))
 	}
 }
 
+func (p *printer) printEnvRef(e *EnvRef) {
+	p.printToken("soong_env(", e.KeywordPos)
+	p.printToken(strconv.Quote(e.Name.Value), e.Name.LiteralPos)
+	if e.Default != nil {
+		p.printToken(",", e.Default.LiteralPos)
+		p.requestSpace()
+		p.printToken(strconv.Quote(e.Default.Value), e.Default.LiteralPos)
+	}
+	p.printToken(")", e.RParenPos)
+}
+
 func (p *printer) printSelect(s *Select) {
 	if len(s.Cases) == 0 {
 		return
@@ -256,12 +271,20 @@ func (p *printer) printList(list []Expression, pos, endPos scanner.Position) {
 	p.requestSpace()
 	p.printToken("[", pos)
 	if len(list) > 1 || pos.Line != endPos.Line || listHasMap(list) {
-		p.requestNewline()
+		if len(list) > 0 {
+			p.requestNewlineBefore(list[0].Pos())
+		} else {
+			p.requestNewline()
+		}
 		p.indent(p.curIndent() + 4)
-		for _, value := range list {
+		for i, value := range list {
 			p.printExpression(value)
 			p.printToken(",", noPos)
-			p.requestNewline()
+			if i < len(list)-1 {
+				p.requestNewlineBefore(list[i+1].Pos())
+			} else {
+				p.requestNewlineBefore(endPos)
+			}
 		}
 		p.unindent(endPos)
 	} else {
@@ -276,12 +299,20 @@ func (p *printer) printMap(m *Map) {
 	p.requestSpace()
 	p.printToken("{", m.LBracePos)
 	if len(m.Properties) > 0 || m.LBracePos.Line != m.RBracePos.Line {
-		p.requestNewline()
+		if len(m.Properties) > 0 {
+			p.requestNewlineBefore(m.Properties[0].Pos())
+		} else {
+			p.requestNewline()
+		}
 		p.indent(p.curIndent() + 4)
-		for _, prop := range m.Properties {
+		for i, prop := range m.Properties {
 			p.printProperty(prop)
 			p.printToken(",", noPos)
-			p.requestNewline()
+			if i < len(m.Properties)-1 {
+				p.requestNewlineBefore(m.Properties[i+1].Pos())
+			} else {
+				p.requestNewlineBefore(m.RBracePos)
+			}
 		}
 		p.unindent(m.RBracePos)
 	}
@@ -416,6 +447,21 @@ func (p *printer) requestNewline() {
 	p._requestNewline()
 }
 
+// Ask for a newline to be inserted before the next token, which is at pos.  If the next token is
+// on the current line, only the comments that come before it are inserted, the end of line
+// comments that follow it are printed after it.
+func (p *printer) requestNewlineBefore(pos scanner.Position) {
+	if pos == noPos || pos.Line > p.pos.Line {
+		p.requestNewline()
+		return
+	}
+	for p.curComment < len(p.comments) && p.comments[p.curComment].End().Offset <= pos.Offset {
+		p.printComment(p.comments[p.curComment])
+		p.curComment++
+	}
+	p._requestNewline()
+}
+
 // Ask for two newlines to be inserted before the next token.  Also inserts any end-of line comments
 // for the current line
 func (p *printer) requestDoubleNewline() {
@@ -519,3 +565,13 @@ func listHasMap(list []Expression) bool {
 	}
 	return false
 }
+
+// formatFloat returns the shortest literal that parses back to f.  It always contains a decimal
+// point or an exponent so that it isn't parsed back as an integer.
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
@@ -16,6 +16,9 @@ package parser
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -24,6 +27,40 @@ var validPrinterTestCases = []struct {
 	input  string
 	output string
 }{
+	{
+		name: "floats",
+		input: `
+foo {
+    scale: 1.50,
+    offset: -.25,
+    big: 1e21,
+    whole: 2e3,
+}
+`,
+		output: `
+foo {
+    scale: 1.5,
+    offset: -0.25,
+    big: 1e+21,
+    whole: 2000.0,
+}
+`,
+	},
+	{
+		name: "soong_env",
+		input: `
+foo {
+    stem: soong_env( "STEM" ),
+    suffix: "-" + soong_env("SUFFIX","default"),
+}
+`,
+		output: `
+foo {
+    stem: soong_env("STEM"),
+    suffix: "-" + soong_env("SUFFIX", "default"),
+}
+`,
+	},
 	{
 		input: `
 foo {}
@@ -791,3 +828,51 @@ func TestPrinter(t *testing.T) {
 		})
 	}
 }
+
+// TestPrinterComments checks that the .bp files in testdata, which have comments in every position
+// that a comment can be attached to, are printed as the matching .golden files, and that the
+// .golden files are printed unchanged.
+func TestPrinterComments(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.bp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no .bp files in testdata")
+	}
+
+	print := func(t *testing.T, file string, in []byte) string {
+		t.Helper()
+		parsed, errs := Parse(file, bytes.NewReader(in))
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %q", errs)
+		}
+		got, err := Print(parsed)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return string(got)
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			in, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			goldenFile := strings.TrimSuffix(file, ".bp") + ".golden"
+			expected, err := os.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := print(t, file, in); got != string(expected) {
+				t.Errorf("  expected: %s", expected)
+				t.Errorf("       got: %s", got)
+			}
+			if got := print(t, goldenFile, expected); got != string(expected) {
+				t.Errorf("%s is not printed unchanged, got: %s", goldenFile, got)
+			}
+		})
+	}
+}
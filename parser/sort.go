@@ -114,24 +114,53 @@ func numericStringLess(a, b string) bool {
 	return aByte < bByte
 }
 
+// SortOptions controls how SortListsWithOptions and SortListWithOptions sort lists.
+type SortOptions struct {
+	// KeepFirst keeps the first element of each list in place and only sorts the elements after
+	// it, for lists where the first element is special, like the main source file of a binary.
+	KeepFirst bool
+}
+
+// SortLists sorts the elements of the lists of strings in the assignments and module properties
+// of file, see SortListWithOptions.
 func SortLists(file *File) {
+	SortListsWithOptions(file, SortOptions{})
+}
+
+// SortListsWithOptions sorts the elements of the lists of strings in the assignments and module
+// properties of file according to options, see SortListWithOptions.
+func SortListsWithOptions(file *File, options SortOptions) {
 	for _, def := range file.Defs {
 		if assignment, ok := def.(*Assignment); ok {
-			sortListsInValue(assignment.Value, file)
+			sortListsInValue(assignment.Value, file, options)
 		} else if module, ok := def.(*Module); ok {
 			for _, prop := range module.Properties {
-				sortListsInValue(prop.Value, file)
+				sortListsInValue(prop.Value, file, options)
 			}
 		}
 	}
 	sort.Sort(commentsByOffset(file.Comments))
 }
 
+// SortList sorts the elements of list, see SortListWithOptions.
 func SortList(file *File, list *List) {
-	if !isListOfPrimitives(list.Values) {
+	SortListWithOptions(file, list, SortOptions{})
+}
+
+// SortListWithOptions sorts the elements of list according to options if they are all string
+// literals, and leaves other lists untouched.  Each set of elements on contiguous lines is sorted
+// separately, so blank lines and comments on their own line separate the list into sections that
+// stay in place.  Comments on the same line as an element move with it.  The sort is stable, and
+// compares numbers in the strings numerically.
+func SortListWithOptions(file *File, list *List, options SortOptions) {
+	if !isListOfStrings(list.Values) {
 		return
 	}
-	for i := 0; i < len(list.Values); i++ {
+	start := 0
+	if options.KeepFirst {
+		start = 1
+	}
+	for i := start; i < len(list.Values); i++ {
 		// Find a set of values on contiguous lines
 		line := list.Values[i].Pos().Line
 		var j int
@@ -172,32 +201,33 @@ func ListIsSorted(list *List) bool {
 	return true
 }
 
-func sortListsInValue(value Expression, file *File) {
+func sortListsInValue(value Expression, file *File, options SortOptions) {
 	switch v := value.(type) {
 	case *Variable:
 		// Nothing
 	case *Operator:
-		sortListsInValue(v.Args[0], file)
-		sortListsInValue(v.Args[1], file)
+		sortListsInValue(v.Args[0], file, options)
+		sortListsInValue(v.Args[1], file, options)
 	case *Map:
 		for _, p := range v.Properties {
-			sortListsInValue(p.Value, file)
+			sortListsInValue(p.Value, file, options)
 		}
 	case *List:
-		SortList(file, v)
+		SortListWithOptions(file, v, options)
 	}
 }
 
+// sortSubList sorts a set of values on contiguous lines.  The comments that start on the same line
+// as a value and before nextPos, the position of the token after the values, move with it.  The
+// sorted values take the lines of the values they replace, and are given increasing offsets that
+// stay within the original values so that the order of the values and comments is unchanged.
 func sortSubList(values []Expression, nextPos scanner.Position, file *File) {
-	if !isListOfPrimitives(values) {
+	if !isListOfStrings(values) {
 		return
 	}
 	l := make([]elem, len(values))
 	for i, v := range values {
-		s, ok := v.(*String)
-		if !ok {
-			panic("list contains non-string element")
-		}
+		s := v.(*String)
 		n := nextPos
 		if i < len(values)-1 {
 			n = values[i+1].Pos()
@@ -210,6 +240,10 @@ func sortSubList(values []Expression, nextPos scanner.Position, file *File) {
 	})
 
 	copyValues := append([]Expression{}, values...)
+	positions := make([]scanner.Position, len(values))
+	for i, v := range values {
+		positions[i] = v.Pos()
+	}
 	copyComments := make([]*CommentGroup, len(file.Comments))
 	for i := range file.Comments {
 		cg := *file.Comments[i]
@@ -221,25 +255,30 @@ func sortSubList(values []Expression, nextPos scanner.Position, file *File) {
 		copyComments[i] = &cg
 	}
 
-	curPos := values[0].Pos()
+	offset := values[0].Pos().Offset
 	for i, e := range l {
+		pos := positions[i]
+		pos.Offset = offset
 		values[i] = copyValues[e.i]
-		values[i].(*String).LiteralPos = curPos
+		values[i].(*String).LiteralPos = pos
+
+		end := values[i].End().Offset
 		for j, c := range copyComments {
-			if c.Pos().Offset > e.pos.Offset && c.Pos().Offset < e.nextPos.Offset {
-				file.Comments[j].Comments[0].Slash.Line = curPos.Line + c.Pos().Line - e.pos.Line
-				curPos.Line += c.Pos().Line - e.pos.Line
-				file.Comments[j].Comments[0].Slash.Offset += values[i].Pos().Offset - e.pos.Offset
+			if c.Pos().Offset > e.pos.Offset && c.Pos().Offset < e.nextPos.Offset &&
+				c.Pos().Line == e.pos.Line {
+				slash := &file.Comments[j].Comments[0].Slash
+				slash.Line = pos.Line
+				slash.Offset = pos.Offset + c.Pos().Offset - e.pos.Offset
+				end = max(end, pos.Offset+c.End().Offset-e.pos.Offset)
 			}
 		}
 
-		curPos.Offset += e.nextPos.Offset - e.pos.Offset
-		curPos.Line++
+		offset = end + 1
 	}
 }
 
 func subListIsSorted(values []Expression) bool {
-	if !isListOfPrimitives(values) {
+	if !isListOfStrings(values) {
 		return true
 	}
 	prev := ""
@@ -278,14 +317,12 @@ func (l commentsByOffset) Swap(i, j int) {
 	l[i], l[j] = l[j], l[i]
 }
 
-func isListOfPrimitives(values []Expression) bool {
-	if len(values) == 0 {
-		return true
-	}
-	switch values[0].(type) {
-	case *Bool, *String, *Int64:
-		return true
-	default:
-		return false
+// isListOfStrings returns true if all the values are string literals.
+func isListOfStrings(values []Expression) bool {
+	for _, value := range values {
+		if _, ok := value.(*String); !ok {
+			return false
+		}
 	}
+	return true
 }
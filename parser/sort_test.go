@@ -14,7 +14,11 @@
 
 package parser
 
-import "testing"
+import (
+	"bytes"
+	"os"
+	"testing"
+)
 
 func Test_numericStringLess(t *testing.T) {
 	type args struct {
@@ -94,3 +98,52 @@ func Test_numericStringLess(t *testing.T) {
 		})
 	}
 }
+
+func TestSortListsGolden(t *testing.T) {
+	testCases := []struct {
+		name    string
+		options SortOptions
+		golden  string
+	}{
+		{
+			name:   "default",
+			golden: "testdata/sort/lists.golden",
+		},
+		{
+			name:    "keep first",
+			options: SortOptions{KeepFirst: true},
+			golden:  "testdata/sort/lists.keep_first.golden",
+		},
+	}
+
+	in, err := os.ReadFile("testdata/sort/lists.bp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			expected, err := os.ReadFile(testCase.golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			file, errs := Parse("lists.bp", bytes.NewReader(in))
+			if len(errs) != 0 {
+				t.Fatalf("unexpected errors: %q", errs)
+			}
+
+			SortListsWithOptions(file, testCase.options)
+
+			got, err := Print(file)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if string(got) != string(expected) {
+				t.Errorf("  expected: %s", expected)
+				t.Errorf("       got: %s", got)
+			}
+		})
+	}
+}
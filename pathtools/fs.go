@@ -16,11 +16,13 @@ package pathtools
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"syscall"
@@ -29,13 +31,45 @@ import (
 
 // Based on Andrew Gerrand's "10 things you (probably) dont' know about Go"
 
+// ShouldFollowSymlinks controls whether recursive globs and ListDirsRecursive descend into
+// symlinks to directories.
 type ShouldFollowSymlinks bool
 
 const (
-	FollowSymlinks     = ShouldFollowSymlinks(true)
+	// FollowSymlinks descends into symlinks to directories.  A symlink that points to one of the
+	// directories containing it is listed, but not descended into, so that symlink cycles don't
+	// cause infinite recursion.  Use ErrorOnSymlinkCycles to report them as errors instead.
+	FollowSymlinks = ShouldFollowSymlinks(true)
+
+	// DontFollowSymlinks doesn't descend into symlinks to directories.
 	DontFollowSymlinks = ShouldFollowSymlinks(false)
 )
 
+var SymlinkCycleErr = errors.New("symlink cycle")
+
+// ErrorOnSymlinkCycles returns a FileSystem that reads from fs, but whose recursive globs and
+// ListDirsRecursive return an error wrapping SymlinkCycleErr when they follow a symlink that points
+// to one of the directories containing it, instead of listing it without descending into it.
+func ErrorOnSymlinkCycles(fs FileSystem) FileSystem {
+	return &symlinkCycleErrorFs{fs}
+}
+
+type symlinkCycleErrorFs struct {
+	FileSystem
+}
+
+func (fs *symlinkCycleErrorFs) Glob(pattern string, excludes []string, follow ShouldFollowSymlinks) (GlobResult, error) {
+	return startGlob(fs, pattern, excludes, follow)
+}
+
+func (fs *symlinkCycleErrorFs) ListDirsRecursive(name string, follow ShouldFollowSymlinks) ([]string, error) {
+	return listDirsRecursive(fs, name, follow, nil, true)
+}
+
+func (fs *symlinkCycleErrorFs) ListDirsRecursivePruned(name string, follow ShouldFollowSymlinks, prune []string) ([]string, error) {
+	return listDirsRecursive(fs, name, follow, prune, true)
+}
+
 var OsFs FileSystem = &osFs{}
 
 func MockFs(files map[string][]byte) FileSystem {
@@ -122,6 +156,15 @@ type FileSystem interface {
 	Readlink(name string) (string, error)
 }
 
+// dirsRecursivePruner is an optional interface implemented by a FileSystem whose recursive listing
+// can skip directories.  ListDirsRecursivePruned is like ListDirsRecursive, but leaves out the
+// directories that match one of the filepath.Match patterns in prune and everything beneath them.
+// Recursive globs with excludes use it when it is implemented, and otherwise list the directories
+// with ReadDirNames.
+type dirsRecursivePruner interface {
+	ListDirsRecursivePruned(name string, follow ShouldFollowSymlinks, prune []string) (dirs []string, err error)
+}
+
 // osFs implements FileSystem using the local disk.
 type osFs struct {
 	srcDir        string
@@ -252,7 +295,7 @@ func (fs *osFs) Stat(path string) (stats os.FileInfo, err error) {
 
 // Returns a list of all directories under dir
 func (fs *osFs) ListDirsRecursive(name string, follow ShouldFollowSymlinks) (dirs []string, err error) {
-	return listDirsRecursive(fs, name, follow)
+	return listDirsRecursive(fs, name, follow, nil, false)
 }
 
 func (fs *osFs) ReadDirNames(name string) ([]string, error) {
@@ -432,6 +475,9 @@ type mockStat struct {
 	name string
 	size int64
 	mode os.FileMode
+
+	// path is the path to the file after following symlinks, which identifies it in sameFile.
+	path string
 }
 
 func (ms *mockStat) Name() string       { return ms.name }
@@ -473,6 +519,7 @@ func (m *mockFs) Stat(name string) (os.FileInfo, error) {
 	ms := mockStat{
 		name: filepath.Base(origName),
 		size: int64(len(m.files[name])),
+		path: name,
 	}
 
 	if _, isDir := m.dirs[name]; isDir {
@@ -513,7 +560,7 @@ func (m *mockFs) ReadDirNames(name string) ([]string, error) {
 }
 
 func (m *mockFs) ListDirsRecursive(name string, follow ShouldFollowSymlinks) ([]string, error) {
-	return listDirsRecursive(m, name, follow)
+	return listDirsRecursive(m, name, follow, nil, false)
 }
 
 func (m *mockFs) Readlink(name string) (string, error) {
@@ -536,7 +583,11 @@ func (m *mockFs) Readlink(name string) (string, error) {
 	}
 }
 
-func listDirsRecursive(fs FileSystem, name string, follow ShouldFollowSymlinks) ([]string, error) {
+// listDirsRecursive returns name and all the directories beneath it, leaving out the directories
+// that match any of the patterns in prune and everything beneath them.  If errorOnCycle is true following a symlink
+// to one of the directories containing it returns an error wrapping SymlinkCycleErr.
+func listDirsRecursive(fs FileSystem, name string, follow ShouldFollowSymlinks, prune []string,
+	errorOnCycle bool) ([]string, error) {
 	name = filepath.Clean(name)
 
 	isDir, err := fs.IsDir(name)
@@ -548,9 +599,21 @@ func listDirsRecursive(fs FileSystem, name string, follow ShouldFollowSymlinks)
 		return nil, nil
 	}
 
+	if prunedDir(prune, name) {
+		return nil, nil
+	}
 	dirs := []string{name}
 
-	subDirs, err := listDirsRecursiveRelative(fs, name, follow, 0)
+	var ancestors []os.FileInfo
+	if follow != DontFollowSymlinks {
+		info, err := fs.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		ancestors = []os.FileInfo{info}
+	}
+
+	subDirs, err := listDirsRecursiveRelative(fs, name, follow, prune, errorOnCycle, 0, ancestors)
 	if err != nil {
 		return nil, err
 	}
@@ -562,7 +625,12 @@ func listDirsRecursive(fs FileSystem, name string, follow ShouldFollowSymlinks)
 	return dirs, nil
 }
 
-func listDirsRecursiveRelative(fs FileSystem, name string, follow ShouldFollowSymlinks, depth int) ([]string, error) {
+// listDirsRecursiveRelative returns the directories beneath name relative to name.  When following
+// symlinks ancestors contains the FileInfos of name and the directories containing it up to the
+// one passed to listDirsRecursive, which are used to detect symlink cycles.
+func listDirsRecursiveRelative(fs FileSystem, name string, follow ShouldFollowSymlinks, prune []string,
+	errorOnCycle bool, depth int, ancestors []os.FileInfo) ([]string, error) {
+
 	depth++
 	if depth > 255 {
 		return nil, fmt.Errorf("too many symlinks")
@@ -594,8 +662,20 @@ func listDirsRecursiveRelative(fs FileSystem, name string, follow ShouldFollowSy
 			}
 		}
 		if info.IsDir() {
+			if prunedDir(prune, f) {
+				continue
+			}
 			dirs = append(dirs, f)
-			subDirs, err := listDirsRecursiveRelative(fs, f, follow, depth)
+			if follow != DontFollowSymlinks {
+				if slices.ContainsFunc(ancestors, func(a os.FileInfo) bool { return sameFile(a, info) }) {
+					if errorOnCycle {
+						return nil, fmt.Errorf("%w: %s", SymlinkCycleErr, f)
+					}
+					continue
+				}
+			}
+			subDirs, err := listDirsRecursiveRelative(fs, f, follow, prune, errorOnCycle, depth,
+				append(slices.Clip(ancestors), info))
 			if err != nil {
 				return nil, err
 			}
@@ -615,3 +695,13 @@ func listDirsRecursiveRelative(fs FileSystem, name string, follow ShouldFollowSy
 
 	return dirs, nil
 }
+
+// sameFile returns true if a and b describe the same file, like os.SameFile but also supporting
+// the FileInfos returned by a MockFs.
+func sameFile(a, b os.FileInfo) bool {
+	if ma, ok := a.(*mockStat); ok {
+		mb, ok := b.(*mockStat)
+		return ok && ma.path == mb.path
+	}
+	return os.SameFile(a, b)
+}
@@ -80,6 +80,19 @@ func (results MultipleGlobResults) Deps() []string {
 // more complete path entries) is supported. Any directories in the matches
 // list will have a '/' suffix.
 //
+// follow controls whether recursive globs descend into symlinks to directories,
+// and what happens when a symlink points back to a directory that contains it;
+// see ShouldFollowSymlinks.
+//
+// Names are matched case sensitively; pass the pattern and excludes through
+// CaseInsensitivePattern to ignore case.
+//
+// An exclude pattern ending in "/**" excludes everything beneath the
+// directories matched by the rest of the pattern, for example "gen/**" or
+// "*/testdata/**".  Recursive globs do not descend into directories excluded
+// this way, so excluding large generated or test-only directories also avoids
+// traversing them.
+//
 // In general ModuleContext.GlobWithDeps or SingletonContext.GlobWithDeps
 // should be used instead, as they will automatically set up dependencies
 // to rerun the primary builder when the list of matching files changes.
@@ -87,6 +100,47 @@ func Glob(pattern string, excludes []string, follow ShouldFollowSymlinks) (GlobR
 	return startGlob(OsFs, pattern, excludes, follow)
 }
 
+// GlobMode selects whether GlobWithMode returns files, directories, or both.
+type GlobMode int
+
+const (
+	// GlobFilesAndDirs returns both files and directories, like Glob.
+	GlobFilesAndDirs GlobMode = iota
+	// GlobFiles returns only files.
+	GlobFiles
+	// GlobDirs returns only directories.
+	GlobDirs
+)
+
+// GlobWithMode is like Glob, but only returns the kinds of matches selected by mode.  Directories
+// still have a '/' suffix.  The dependencies are the same as the dependencies of Glob, so the
+// glob is regenerated when a file or directory of either kind is added or removed.
+func GlobWithMode(pattern string, excludes []string, follow ShouldFollowSymlinks,
+	mode GlobMode) (GlobResult, error) {
+
+	result, err := Glob(pattern, excludes, follow)
+	if err != nil {
+		return GlobResult{}, err
+	}
+	result.Matches = FilterGlobMatches(result.Matches, mode)
+	return result, nil
+}
+
+// FilterGlobMatches returns the matches of a glob that are of the kinds selected by mode, in the
+// same order.  Directories are recognized by the '/' suffix added by Glob.
+func FilterGlobMatches(matches []string, mode GlobMode) []string {
+	if mode == GlobFilesAndDirs {
+		return matches
+	}
+	var ret []string
+	for _, match := range matches {
+		if strings.HasSuffix(match, "/") == (mode == GlobDirs) {
+			ret = append(ret, match)
+		}
+	}
+	return ret
+}
+
 func startGlob(fs FileSystem, pattern string, excludes []string,
 	follow ShouldFollowSymlinks) (GlobResult, error) {
 
@@ -94,7 +148,12 @@ func startGlob(fs FileSystem, pattern string, excludes []string,
 		return GlobResult{}, GlobLastRecursiveErr
 	}
 
-	matches, deps, err := glob(fs, pattern, false, follow)
+	prune, err := recursiveExcludeDirs(excludes)
+	if err != nil {
+		return GlobResult{}, err
+	}
+
+	matches, deps, err := glob(fs, pattern, false, follow, prune)
 
 	if err != nil {
 		return GlobResult{}, err
@@ -148,8 +207,10 @@ func startGlob(fs FileSystem, pattern string, excludes []string,
 
 // glob is a recursive helper function to handle globbing each level of the pattern individually,
 // allowing searched directories to be tracked.  Also handles the recursive glob pattern, **.
+// Directories beneath a directory matching one of the patterns in prune are not searched by
+// recursive globs.
 func glob(fs FileSystem, pattern string, hasRecursive bool,
-	follow ShouldFollowSymlinks) (matches, dirs []string, err error) {
+	follow ShouldFollowSymlinks, prune []string) (matches, dirs []string, err error) {
 
 	if !isWild(pattern) {
 		// If there are no wilds in the pattern, check whether the file exists or not.
@@ -187,7 +248,7 @@ func glob(fs FileSystem, pattern string, hasRecursive bool,
 		return matches, dirs, GlobInvalidRecursiveErr
 	}
 
-	dirMatches, dirs, err := glob(fs, dir, hasRecursive, follow)
+	dirMatches, dirs, err := glob(fs, dir, hasRecursive, follow, prune)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -205,7 +266,7 @@ func glob(fs FileSystem, pattern string, hasRecursive bool,
 
 		if isDir {
 			if file == "**" {
-				recurseDirs, err := fs.ListDirsRecursive(m, follow)
+				recurseDirs, err := globListDirsRecursive(fs, m, follow, prune)
 				if err != nil {
 					return nil, nil, err
 				}
@@ -227,6 +288,21 @@ func glob(fs FileSystem, pattern string, hasRecursive bool,
 	return matches, dirs, nil
 }
 
+// globListDirsRecursive lists the directories under name for a recursive glob, without descending
+// into the directories that match prune.  Without prune it uses fs.ListDirsRecursive, and with
+// prune it uses ListDirsRecursivePruned if fs implements it.
+func globListDirsRecursive(fs FileSystem, name string, follow ShouldFollowSymlinks,
+	prune []string) ([]string, error) {
+
+	if pruner, ok := fs.(dirsRecursivePruner); ok {
+		return pruner.ListDirsRecursivePruned(name, follow, prune)
+	}
+	if len(prune) == 0 {
+		return fs.ListDirsRecursive(name, follow)
+	}
+	return listDirsRecursive(fs, name, follow, prune, false)
+}
+
 // Faster version of dir, file := filepath.Dir(path), filepath.File(path) with no allocations
 // Similar to filepath.Split, but returns "." if dir is empty and trims trailing slash if dir is
 // not "/".  Returns ".", "" if path is "."
@@ -261,7 +337,13 @@ func filterExcludes(matches []string, excludes []string) ([]string, error) {
 matchLoop:
 	for _, m := range matches {
 		for _, e := range excludes {
-			exclude, err := Match(e, m)
+			var exclude bool
+			var err error
+			if dirPattern, ok := strings.CutSuffix(e, "/**"); ok {
+				exclude, err = underMatchingDir(dirPattern, m)
+			} else {
+				exclude, err = Match(e, m)
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -275,6 +357,50 @@ matchLoop:
 	return ret, nil
 }
 
+// recursiveExcludeDirs returns the directory patterns of the exclude patterns that end in "/**".
+func recursiveExcludeDirs(excludes []string) ([]string, error) {
+	var dirs []string
+	for _, e := range excludes {
+		if dirPattern, ok := strings.CutSuffix(e, "/**"); ok {
+			// A trailing ** is only supported as the only recursive element of an exclude.
+			if strings.Contains(dirPattern, "**") {
+				return nil, GlobLastRecursiveErr
+			}
+			dirs = append(dirs, dirPattern)
+		}
+	}
+	return dirs, nil
+}
+
+// underMatchingDir returns true if any parent directory of name matches dirPattern.
+func underMatchingDir(dirPattern, name string) (bool, error) {
+	if strings.Contains(dirPattern, "**") {
+		return false, GlobLastRecursiveErr
+	}
+
+	name = filepath.Clean(name)
+	for {
+		name = filepath.Dir(name)
+		if name == "." || name == "/" {
+			return false, nil
+		}
+		if match, err := filepath.Match(dirPattern, name); err != nil || match {
+			return match, err
+		}
+	}
+}
+
+// prunedDir returns true if everything beneath dir is excluded by one of the directory patterns
+// in prune.
+func prunedDir(prune []string, dir string) bool {
+	for _, p := range prune {
+		if match, _ := filepath.Match(p, dir); match {
+			return true
+		}
+	}
+	return false
+}
+
 // filterDotFiles filters out files that start with '.'
 func filterDotFiles(matches []string) []string {
 	ret := make([]string, 0, len(matches))
@@ -430,3 +556,107 @@ var matchEscaper = strings.NewReplacer(
 func MatchEscape(s string) string {
 	return matchEscaper.Replace(s)
 }
+
+// CaseInsensitivePattern returns a pattern for Glob, Match or an exclude that matches the same
+// names as pattern, but ignoring the case of ASCII letters, for example "*.C" becomes "*.[cC]".
+// Every letter becomes a wildcard, so globs of the returned pattern depend on the directories
+// they search even if pattern had no wildcards, and are regenerated when a file whose name only
+// differs in case is added.  Recursive globs (**) are unaffected.  A malformed character class
+// is left as is so that the glob reports it.
+func CaseInsensitivePattern(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			if other, ok := swapCase(pattern[i]); ok {
+				b.WriteString(string([]byte{'[', pattern[i], other, ']'}))
+			} else {
+				b.WriteString(pattern[i-1 : i+1])
+			}
+		case c == '[':
+			class, n := caseInsensitiveClass(pattern[i:])
+			if n == 0 {
+				b.WriteString(pattern[i:])
+				return b.String()
+			}
+			b.WriteString(class)
+			i += n - 1
+		default:
+			if other, ok := swapCase(c); ok {
+				b.WriteString(string([]byte{'[', c, other, ']'}))
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+// caseInsensitiveClass returns the character class at the start of pattern with the other case of
+// each of its letters and ranges of letters added, and the length of the class in pattern, or 0 if
+// the class is not terminated.
+func caseInsensitiveClass(pattern string) (string, int) {
+	i := 1
+	if i < len(pattern) && pattern[i] == '^' {
+		i++
+	}
+	classChar := func() (byte, bool) {
+		if i < len(pattern) && pattern[i] == '\\' {
+			i++
+		}
+		if i >= len(pattern) {
+			return 0, false
+		}
+		i++
+		return pattern[i-1], true
+	}
+
+	var extra []byte
+	for first := true; ; first = false {
+		if i >= len(pattern) {
+			return "", 0
+		}
+		if pattern[i] == ']' && !first {
+			break
+		}
+		lo, ok := classChar()
+		if !ok {
+			return "", 0
+		}
+		hi := lo
+		if i+1 < len(pattern) && pattern[i] == '-' && pattern[i+1] != ']' {
+			i++
+			if hi, ok = classChar(); !ok {
+				return "", 0
+			}
+		}
+		otherLo, loOk := swapCase(lo)
+		otherHi, hiOk := swapCase(hi)
+		if loOk && hiOk && isUpper(lo) == isUpper(hi) {
+			if lo == hi {
+				extra = append(extra, otherLo)
+			} else {
+				extra = append(extra, otherLo, '-', otherHi)
+			}
+		}
+	}
+	i++
+	return pattern[:i-1] + string(extra) + "]", i
+}
+
+func isUpper(c byte) bool {
+	return 'A' <= c && c <= 'Z'
+}
+
+// swapCase returns the other case of an ASCII letter, or false if c is not a letter.
+func swapCase(c byte) (byte, bool) {
+	switch {
+	case 'a' <= c && c <= 'z':
+		return c - 'a' + 'A', true
+	case isUpper(c):
+		return c - 'A' + 'a', true
+	}
+	return 0, false
+}
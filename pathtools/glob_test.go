@@ -15,11 +15,13 @@
 package pathtools
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 var pwd, _ = os.Getwd()
@@ -329,6 +331,32 @@ var globTestCases = []globTestCase{
 		deps:     []string{"c", "c/f", "c/g", "c/h"},
 	},
 
+	// directory excludes, which prune recursive globs and aren't deps
+	{
+		pattern:  "**/*",
+		excludes: []string{"a/**"},
+		matches:  []string{"a/", "b/", "c/", "d.ext", "e.ext", "b/a", "c/c", "c/f/", "c/g/", "c/h/", "c/f/f.ext", "c/g/g.ext", "c/h/h"},
+		deps:     []string{".", "b", "c", "c/f", "c/g", "c/h"},
+	},
+	{
+		pattern:  "**/*.ext",
+		excludes: []string{"c/*/**"},
+		matches:  []string{"d.ext", "e.ext"},
+		deps:     []string{".", "a", "a/a", "a/b", "b", "c"},
+	},
+	{
+		pattern:  "c/**/*",
+		excludes: []string{"c/f/**", "c/**/g.ext", "c/h/**"},
+		matches:  []string{"c/c", "c/f/", "c/g/", "c/h/"},
+		deps:     []string{"c", "c/g"},
+	},
+	{
+		pattern:  "**/a",
+		excludes: []string{"a/**", "**/a"},
+		matches:  nil,
+		deps:     []string{".", "b", "c", "c/f", "c/g", "c/h"},
+	},
+
 	// absoulte recursive exclude tests
 	{
 		pattern:  filepath.Join(pwd, "testdata/glob/c/*/*.ext"),
@@ -437,11 +465,6 @@ var globTestCases = []globTestCase{
 		excludes: []string{"**/**/a/*"},
 		err:      GlobMultipleRecursiveErr,
 	},
-	{
-		pattern:  "**/*",
-		excludes: []string{"a/**"},
-		err:      GlobLastRecursiveErr,
-	},
 	{
 		pattern:  "**/*",
 		excludes: []string{"**/**"},
@@ -513,6 +536,226 @@ func TestGlob(t *testing.T) {
 	}
 }
 
+func TestGlobWithMode(t *testing.T) {
+	os.Chdir("testdata/glob")
+	defer os.Chdir("../..")
+
+	testCases := []struct {
+		pattern  string
+		excludes []string
+		mode     GlobMode
+		matches  []string
+	}{
+		{
+			pattern: "*",
+			mode:    GlobDirs,
+			matches: []string{"a/", "b/", "c/"},
+		},
+		{
+			pattern: "*",
+			mode:    GlobFiles,
+			matches: []string{"d.ext", "e.ext"},
+		},
+		{
+			pattern: "*",
+			mode:    GlobFilesAndDirs,
+			matches: []string{"a/", "b/", "c/", "d.ext", "e.ext"},
+		},
+		{
+			pattern:  "c/*",
+			excludes: []string{"c/g"},
+			mode:     GlobFilesAndDirs,
+			matches:  []string{"c/c", "c/f/", "c/h/"},
+		},
+		{
+			pattern:  "c/*",
+			excludes: []string{"c/g"},
+			mode:     GlobDirs,
+			matches:  []string{"c/f/", "c/h/"},
+		},
+		{
+			pattern:  "**/*",
+			excludes: []string{"a/**", "c/f"},
+			mode:     GlobDirs,
+			matches:  []string{"a/", "b/", "c/", "c/g/", "c/h/"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.pattern, func(t *testing.T) {
+			result, err := GlobWithMode(testCase.pattern, testCase.excludes, FollowSymlinks, testCase.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Matches, testCase.matches) {
+				t.Errorf("incorrect matches for %q with excludes %q and mode %d\n     got: %q\nexpected: %q",
+					testCase.pattern, testCase.excludes, testCase.mode, result.Matches, testCase.matches)
+			}
+
+			all, err := Glob(testCase.pattern, testCase.excludes, FollowSymlinks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Deps, all.Deps) {
+				t.Errorf("expected the same deps as Glob, got %q and %q", result.Deps, all.Deps)
+			}
+		})
+	}
+}
+
+func TestCaseInsensitivePattern(t *testing.T) {
+	testCases := []struct {
+		pattern, want string
+	}{
+		{`*.C`, `*.[Cc]`},
+		{`a/**/B.c`, `[aA]/**/[Bb].[cC]`},
+		{`1_?.*`, `1_?.*`},
+		{`\a\*`, `[aA]\*`},
+		{`[a-cX_]`, `[a-cX_A-Cx]`},
+		{`[^\b]`, `[^\bB]`},
+		{`[A-z]`, `[A-z]`},
+		{`[]]`, `[]]`},
+		{`[a`, `[a`},
+	}
+	for _, testCase := range testCases {
+		if got := CaseInsensitivePattern(testCase.pattern); got != testCase.want {
+			t.Errorf("CaseInsensitivePattern(%q): want %q, got %q", testCase.pattern, testCase.want, got)
+		}
+	}
+}
+
+func TestGlobCaseInsensitive(t *testing.T) {
+	mock := MockFs(map[string][]byte{
+		"foo.c":         nil,
+		"bar.h":         nil,
+		"dir/SUB/baz.c": nil,
+		"dir/SUB/BAZ.h": nil,
+	})
+
+	testCases := []struct {
+		pattern  string
+		excludes []string
+		matches  []string
+	}{
+		{
+			pattern: "*.C",
+			matches: []string{"foo.c"},
+		},
+		{
+			pattern: "**/*.C",
+			matches: []string{"foo.c", "dir/SUB/baz.c"},
+		},
+		{
+			pattern: "DIR/sub/baz.*",
+			matches: []string{"dir/SUB/BAZ.h", "dir/SUB/baz.c"},
+		},
+		{
+			pattern:  "**/*",
+			excludes: []string{"*.H", "dir/sub/**"},
+			matches:  []string{"dir/", "foo.c", "dir/SUB/"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.pattern, func(t *testing.T) {
+			result, err := mock.Glob(testCase.pattern, testCase.excludes, FollowSymlinks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if testCase.excludes == nil && len(result.Matches) != 0 {
+				t.Errorf("expected no case sensitive matches, got %q", result.Matches)
+			}
+
+			var excludes []string
+			for _, exclude := range testCase.excludes {
+				excludes = append(excludes, CaseInsensitivePattern(exclude))
+			}
+			result, err = mock.Glob(CaseInsensitivePattern(testCase.pattern), excludes, FollowSymlinks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Matches, testCase.matches) {
+				t.Errorf("incorrect case insensitive matches\n     got: %q\nexpected: %q",
+					result.Matches, testCase.matches)
+			}
+		})
+	}
+}
+
+func TestIOFsGlob(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for _, f := range []string{
+		"a/a/a",
+		"a/b/b",
+		"b/a",
+		"c/c",
+		"c/f/f.ext",
+		"c/g/g.ext",
+		"c/h/h",
+		"d.ext",
+		"e.ext",
+		".test/a",
+		".testing",
+		".test/.ing",
+	} {
+		fsys[f] = &fstest.MapFile{}
+	}
+
+	for _, testCase := range globTestCases {
+		if filepath.IsAbs(testCase.pattern) {
+			// Absolute paths can't refer to files in an fs.FS.
+			continue
+		}
+		t.Run(testCase.pattern, func(t *testing.T) {
+			testGlob(t, NewIOFs(fsys), testCase, FollowSymlinks)
+		})
+	}
+}
+
+// readDirCountingFs records the directories listed by ReadDirNames and ListDirsRecursivePruned.
+type readDirCountingFs struct {
+	FileSystem
+	read      []string
+	recursive []string
+}
+
+func (fs *readDirCountingFs) ListDirsRecursivePruned(name string, follow ShouldFollowSymlinks, prune []string) ([]string, error) {
+	fs.recursive = append(fs.recursive, name)
+	return listDirsRecursive(fs, name, follow, prune, false)
+}
+
+func (fs *readDirCountingFs) ReadDirNames(name string) ([]string, error) {
+	fs.read = append(fs.read, name)
+	return fs.FileSystem.ReadDirNames(name)
+}
+
+func TestGlobExcludePrunesRecursion(t *testing.T) {
+	fs := &readDirCountingFs{FileSystem: MockFs(map[string][]byte{
+		"src/a.c":             nil,
+		"src/gen/b.c":         nil,
+		"src/gen/deep/c.c":    nil,
+		"src/gen/deep/er/d.c": nil,
+		"src/lib/e.c":         nil,
+	})}
+
+	result, err := startGlob(fs, "src/**/*.c", []string{"src/gen/**"}, FollowSymlinks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"src/a.c", "src/lib/e.c"}; !reflect.DeepEqual(result.Matches, want) {
+		t.Errorf("expected matches %q, got %q", want, result.Matches)
+	}
+	if want := []string{"src"}; !reflect.DeepEqual(fs.recursive, want) {
+		t.Errorf("expected ListDirsRecursivePruned to be called for %q, got %q", want, fs.recursive)
+	}
+	for _, dir := range fs.read {
+		if dir == "src/gen" || strings.HasPrefix(dir, "src/gen/") {
+			t.Errorf("expected excluded directory src/gen not to be traversed, but read %q", dir)
+		}
+	}
+}
+
 var globEscapeTestCases = []globTestCase{
 	{
 		pattern: `**/*`,
@@ -622,6 +865,86 @@ func TestGlobSymlinks(t *testing.T) {
 	}
 }
 
+var globSymlinkCycleTestCases = []globTestCase{
+	{
+		pattern: `**/*`,
+		matches: []string{"a/", "b/", "a/f", "a/loop/", "a/loop/a/", "a/loop/b/", "b/f", "b/loop/", "b/loop/a/", "b/loop/b/"},
+		deps:    []string{".", "a", "a/loop", "b", "b/loop"},
+	},
+	{
+		// a/loop points to the parent of a, which is only a cycle once it reaches a again.
+		pattern: `a/**/f`,
+		matches: []string{"a/f", "a/loop/a/f", "a/loop/b/f"},
+		deps:    []string{"a", "a/loop", "a/loop/a", "a/loop/b"},
+	},
+}
+
+var globSymlinkCycleFiles = []string{
+	"a/f",
+	"a/loop -> ..",
+	"b -> a",
+}
+
+func TestMockGlobSymlinkCycles(t *testing.T) {
+	mockFiles := make(map[string][]byte)
+	for _, f := range globSymlinkCycleFiles {
+		mockFiles[f] = nil
+	}
+	testGlobSymlinkCycles(t, MockFs(mockFiles))
+}
+
+func TestGlobSymlinkCycles(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range globSymlinkCycleFiles {
+		if from, to, ok := strings.Cut(f, " -> "); ok {
+			if err := os.Symlink(to, filepath.Join(dir, from)); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, f)), 0777)
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+	testGlobSymlinkCycles(t, OsFs)
+}
+
+func testGlobSymlinkCycles(t *testing.T, fs FileSystem) {
+	for _, testCase := range globSymlinkCycleTestCases {
+		t.Run(testCase.pattern, func(t *testing.T) {
+			testGlob(t, fs, testCase, FollowSymlinks)
+		})
+	}
+
+	t.Run("error on cycle", func(t *testing.T) {
+		_, err := ErrorOnSymlinkCycles(fs).Glob("**/*", nil, FollowSymlinks)
+		if !errors.Is(err, SymlinkCycleErr) {
+			t.Fatalf("expected a symlink cycle error, got %v", err)
+		}
+		if want := "symlink cycle: a/loop"; err.Error() != want {
+			t.Errorf("want error %q, got %q", want, err)
+		}
+
+		// Symlinks that don't point to a containing directory aren't cycles.
+		if _, err := ErrorOnSymlinkCycles(fs).Glob("a/f", nil, FollowSymlinks); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+
+	t.Run("don't follow", func(t *testing.T) {
+		testGlob(t, fs, globTestCase{
+			pattern: `**/*`,
+			matches: []string{"a/", "b", "a/f", "a/loop"},
+			deps:    []string{".", "a"},
+		}, DontFollowSymlinks)
+	})
+}
+
 var globDontFollowSymlinkTestCases = []globTestCase{
 	{
 		pattern: `**/*`,
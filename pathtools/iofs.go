@@ -0,0 +1,145 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pathtools
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// NewIOFs returns a FileSystem that reads files from an fs.FS, for example an fstest.MapFS, an
+// embed.FS or a zip.Reader, so that Blueprint can run without access to the real disk.  Paths are
+// relative to the root of fsys, and absolute paths or paths outside of it don't exist.  fs.FS has
+// no symlinks, so IsSymlink always returns false for paths that exist.
+func NewIOFs(fsys fs.FS) FileSystem {
+	return &ioFs{fsys: fsys}
+}
+
+// ioFs implements FileSystem using an fs.FS.
+type ioFs struct {
+	fsys fs.FS
+}
+
+// toFsPath converts a path to the slash separated form used by fs.FS, or returns false if it
+// can't refer to a file in the fs.FS.
+func toFsPath(name string) (string, bool) {
+	name = filepath.ToSlash(filepath.Clean(name))
+	return name, fs.ValidPath(name)
+}
+
+func (f *ioFs) Open(name string) (ReaderAtSeekerCloser, error) {
+	p, ok := toFsPath(name)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	contents, err := fs.ReadFile(f.fsys, p)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Closer
+		*bytes.Reader
+	}{
+		io.NopCloser(nil),
+		bytes.NewReader(contents),
+	}, nil
+}
+
+func (f *ioFs) Exists(name string) (bool, bool, error) {
+	info, err := f.Stat(name)
+	if err == nil {
+		return true, info.IsDir(), nil
+	} else if os.IsNotExist(err) {
+		return false, false, nil
+	} else {
+		return false, false, err
+	}
+}
+
+func (f *ioFs) IsDir(name string) (bool, error) {
+	info, err := f.Stat(name)
+	if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+func (f *ioFs) IsSymlink(name string) (bool, error) {
+	if _, err := f.Stat(name); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (f *ioFs) Glob(pattern string, excludes []string, follow ShouldFollowSymlinks) (GlobResult, error) {
+	return startGlob(f, pattern, excludes, follow)
+}
+
+func (f *ioFs) glob(pattern string) ([]string, error) {
+	p, ok := toFsPath(pattern)
+	if !ok {
+		return nil, nil
+	}
+	matches, err := fs.Glob(f.fsys, p)
+	for i, match := range matches {
+		matches[i] = filepath.FromSlash(match)
+	}
+	return matches, err
+}
+
+func (f *ioFs) Lstat(name string) (os.FileInfo, error) {
+	return f.Stat(name)
+}
+
+func (f *ioFs) Stat(name string) (os.FileInfo, error) {
+	p, ok := toFsPath(name)
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return fs.Stat(f.fsys, p)
+}
+
+func (f *ioFs) ListDirsRecursive(name string, follow ShouldFollowSymlinks) ([]string, error) {
+	return listDirsRecursive(f, name, follow, nil, false)
+}
+
+func (f *ioFs) ReadDirNames(name string) ([]string, error) {
+	p, ok := toFsPath(name)
+	if !ok {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+	entries, err := fs.ReadDir(f.fsys, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// fs.ReadDir returns the entries sorted by name.
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names, nil
+}
+
+func (f *ioFs) Readlink(name string) (string, error) {
+	if _, err := f.Stat(name); err != nil {
+		return "", err
+	}
+	return "", os.NewSyscallError("readlink: "+name, syscall.EINVAL)
+}
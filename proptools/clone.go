@@ -63,7 +63,7 @@ func copyProperties(dstValue, srcValue reflect.Value) {
 		origDstFieldValue := dstFieldValue
 
 		switch srcFieldValue.Kind() {
-		case reflect.Bool, reflect.String, reflect.Int, reflect.Uint:
+		case reflect.Bool, reflect.String, reflect.Int, reflect.Uint, reflect.Int64, reflect.Float64:
 			dstFieldValue.Set(srcFieldValue)
 		case reflect.Struct:
 			if isConfigurable(srcFieldValue.Type()) {
@@ -138,7 +138,7 @@ func copyProperties(dstValue, srcValue reflect.Value) {
 						origDstFieldValue.Set(newValue)
 					}
 				}
-			case reflect.Bool, reflect.Int64, reflect.String:
+			case reflect.Bool, reflect.Int64, reflect.Float64, reflect.String:
 				newValue := reflect.New(srcFieldValue.Elem().Type())
 				newValue.Elem().Set(srcFieldValue.Elem())
 				origDstFieldValue.Set(newValue)
@@ -153,6 +153,74 @@ func copyProperties(dstValue, srcValue reflect.Value) {
 	}
 }
 
+// CloneMatchingProperties copies the fields of the property struct src for which keep returns true
+// into dst, and sets all other fields of dst to their zero values.  dst and src must be pointers to
+// structs of the same type.  keep is called for every field, including struct fields, pointers to
+// structs and interfaces containing pointers to structs; returning true for those recursively
+// applies keep to their fields, returning false zeroes the whole field.  Kept slices and maps are
+// copied rather than aliased.
+func CloneMatchingProperties(dst, src interface{}, keep func(field reflect.StructField) bool) {
+	dstValue, srcValue := reflect.ValueOf(dst), reflect.ValueOf(src)
+	if !isStructPtr(dstValue.Type()) {
+		panic(fmt.Errorf("CloneMatchingProperties expected dst *struct, got %s", dstValue.Type()))
+	}
+	if dstValue.Type() != srcValue.Type() {
+		panic(fmt.Errorf("CloneMatchingProperties expected matching types, got %s and %s",
+			dstValue.Type(), srcValue.Type()))
+	}
+	copyMatchingProperties(dstValue.Elem(), srcValue.Elem(), keep)
+}
+
+func copyMatchingProperties(dstValue, srcValue reflect.Value, keep func(field reflect.StructField) bool) {
+	for i, field := range typeFields(srcValue.Type()) {
+		if field.PkgPath != "" {
+			panic(fmt.Errorf("can't copy a private field %q", field.Name))
+		}
+
+		srcFieldValue := srcValue.Field(i)
+		dstFieldValue := dstValue.Field(i)
+
+		if !keep(field) {
+			dstFieldValue.Set(reflect.Zero(field.Type))
+			continue
+		}
+
+		switch {
+		case srcFieldValue.Kind() == reflect.Struct && !isConfigurable(field.Type):
+			copyMatchingProperties(dstFieldValue, srcFieldValue, keep)
+		case srcFieldValue.Kind() == reflect.Interface:
+			if srcFieldValue.IsNil() {
+				dstFieldValue.Set(srcFieldValue)
+				break
+			}
+			if !isStructPtr(srcFieldValue.Elem().Type()) {
+				panic(fmt.Errorf("can't clone field %q: expected interface to contain *struct, found %s",
+					field.Name, srcFieldValue.Elem().Type()))
+			}
+			newValue := reflect.New(srcFieldValue.Elem().Type().Elem())
+			copyMatchingProperties(newValue.Elem(), srcFieldValue.Elem().Elem(), keep)
+			dstFieldValue.Set(newValue)
+		case isStructPtr(field.Type):
+			if srcFieldValue.IsNil() {
+				dstFieldValue.Set(srcFieldValue)
+				break
+			}
+			newValue := reflect.New(field.Type.Elem())
+			copyMatchingProperties(newValue.Elem(), srcFieldValue.Elem(), keep)
+			dstFieldValue.Set(newValue)
+		default:
+			// Copy a single basic, slice, map, configurable or pointer field by copying a
+			// struct containing only that field.
+			fieldType := reflect.StructOf([]reflect.StructField{{Name: field.Name, Type: field.Type}})
+			srcFieldStruct := reflect.New(fieldType).Elem()
+			srcFieldStruct.Field(0).Set(srcFieldValue)
+			dstFieldStruct := reflect.New(fieldType).Elem()
+			copyProperties(dstFieldStruct, srcFieldStruct)
+			dstFieldValue.Set(dstFieldStruct.Field(0))
+		}
+	}
+}
+
 // ZeroProperties takes a reflect.Value of a pointer to a struct and replaces all of its fields
 // with zero values, recursing into struct, pointer to struct and interface fields.
 func ZeroProperties(structValue reflect.Value) {
@@ -174,7 +242,8 @@ func zeroProperties(structValue reflect.Value) {
 		fieldValue := structValue.Field(i)
 
 		switch fieldValue.Kind() {
-		case reflect.Bool, reflect.String, reflect.Slice, reflect.Int, reflect.Uint, reflect.Map:
+		case reflect.Bool, reflect.String, reflect.Slice, reflect.Int, reflect.Uint, reflect.Int64,
+			reflect.Float64, reflect.Map:
 			fieldValue.Set(reflect.Zero(fieldValue.Type()))
 		case reflect.Interface:
 			if fieldValue.IsNil() {
@@ -196,7 +265,7 @@ func zeroProperties(structValue reflect.Value) {
 					break
 				}
 				zeroProperties(fieldValue.Elem())
-			case reflect.Bool, reflect.Int64, reflect.String:
+			case reflect.Bool, reflect.Int64, reflect.Float64, reflect.String:
 				fieldValue.Set(reflect.Zero(fieldValue.Type()))
 			default:
 				panic(fmt.Errorf("can't zero field %q: points to a %s",
@@ -236,7 +305,8 @@ func cloneEmptyProperties(dstValue, srcValue reflect.Value) {
 		dstFieldInterfaceValue := reflect.Value{}
 
 		switch srcFieldValue.Kind() {
-		case reflect.Bool, reflect.String, reflect.Slice, reflect.Map, reflect.Int, reflect.Uint:
+		case reflect.Bool, reflect.String, reflect.Slice, reflect.Map, reflect.Int, reflect.Uint,
+			reflect.Int64, reflect.Float64:
 			// Nothing
 		case reflect.Struct:
 			cloneEmptyProperties(dstFieldValue, srcFieldValue)
@@ -268,7 +338,7 @@ func cloneEmptyProperties(dstValue, srcValue reflect.Value) {
 				} else {
 					dstFieldValue.Set(newValue)
 				}
-			case reflect.Bool, reflect.Int64, reflect.String:
+			case reflect.Bool, reflect.Int64, reflect.Float64, reflect.String:
 				// Nothing
 			default:
 				panic(fmt.Errorf("can't clone empty field %q: points to a %s",
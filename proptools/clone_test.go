@@ -17,6 +17,7 @@ package proptools
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -569,3 +570,108 @@ func TestZeroProperties(t *testing.T) {
 		}
 	}
 }
+
+type cloneMatchingNested struct {
+	Keep_s  *string
+	Drop_s  *string
+	Keep_ss []string
+}
+
+type cloneMatchingProps struct {
+	Keep_b   *bool
+	Drop_b   *bool
+	Keep_ss  []string
+	Drop_ss  []string
+	Keep_map map[string]string
+	Nested   cloneMatchingNested
+	Ptr      *cloneMatchingNested
+	Drop_ptr *cloneMatchingNested
+	Iface    interface{}
+}
+
+func TestCloneMatchingProperties(t *testing.T) {
+	keep := func(field reflect.StructField) bool {
+		return !strings.HasPrefix(field.Name, "Drop")
+	}
+
+	src := &cloneMatchingProps{
+		Keep_b:   BoolPtr(true),
+		Drop_b:   BoolPtr(true),
+		Keep_ss:  []string{"a", "b"},
+		Drop_ss:  []string{"c"},
+		Keep_map: map[string]string{"k": "v"},
+		Nested: cloneMatchingNested{
+			Keep_s:  StringPtr("keep"),
+			Drop_s:  StringPtr("drop"),
+			Keep_ss: []string{"d"},
+		},
+		Ptr: &cloneMatchingNested{
+			Keep_s: StringPtr("ptr keep"),
+			Drop_s: StringPtr("ptr drop"),
+		},
+		Drop_ptr: &cloneMatchingNested{Keep_s: StringPtr("dropped")},
+		Iface: &cloneMatchingNested{
+			Keep_s: StringPtr("iface keep"),
+			Drop_s: StringPtr("iface drop"),
+		},
+	}
+
+	dst := &cloneMatchingProps{
+		Drop_b:  BoolPtr(false),
+		Drop_ss: []string{"stale"},
+	}
+
+	CloneMatchingProperties(dst, src, keep)
+
+	want := &cloneMatchingProps{
+		Keep_b:   BoolPtr(true),
+		Keep_ss:  []string{"a", "b"},
+		Keep_map: map[string]string{"k": "v"},
+		Nested: cloneMatchingNested{
+			Keep_s:  StringPtr("keep"),
+			Keep_ss: []string{"d"},
+		},
+		Ptr: &cloneMatchingNested{
+			Keep_s: StringPtr("ptr keep"),
+		},
+		Iface: &cloneMatchingNested{
+			Keep_s: StringPtr("iface keep"),
+		},
+	}
+
+	if !reflect.DeepEqual(want, dst) {
+		t.Errorf("incorrect output")
+		t.Errorf("  expected: %#v", want)
+		t.Errorf("       got: %#v", dst)
+	}
+
+	// Modifying the clone must not modify the source.
+	dst.Keep_ss[0] = "x"
+	dst.Nested.Keep_ss[0] = "y"
+	dst.Keep_map["k"] = "z"
+	*dst.Keep_b = false
+	*dst.Ptr.Keep_s = "modified"
+	if src.Keep_ss[0] != "a" || src.Nested.Keep_ss[0] != "d" || src.Keep_map["k"] != "v" ||
+		!*src.Keep_b || *src.Ptr.Keep_s != "ptr keep" {
+		t.Errorf("clone aliases source: %#v", src)
+	}
+	if dst.Ptr == src.Ptr || dst.Iface == src.Iface {
+		t.Errorf("clone aliases source struct pointers")
+	}
+}
+
+func TestCloneMatchingPropertiesDropNested(t *testing.T) {
+	src := &cloneMatchingProps{
+		Nested: cloneMatchingNested{Keep_s: StringPtr("a")},
+		Ptr:    &cloneMatchingNested{Keep_s: StringPtr("b")},
+	}
+	dst := &cloneMatchingProps{}
+
+	CloneMatchingProperties(dst, src, func(field reflect.StructField) bool {
+		return field.Name != "Nested" && field.Name != "Ptr"
+	})
+
+	if !reflect.DeepEqual(&cloneMatchingProps{}, dst) {
+		t.Errorf("expected nested structs to be zeroed, got %#v", dst)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// PropertyDelta describes a single difference between two property structs.
+type PropertyDelta struct {
+	// Path is the property name of the field that differs, with nested struct fields separated
+	// by "." and slice elements indexed with "[i]", for example "nested.srcs[1]".
+	Path string
+
+	// Old and New are the values from the first and second struct.  Pointers to basic types are
+	// dereferenced, and Old or New is nil if the pointer was nil or the slice element did not
+	// exist.
+	Old, New interface{}
+}
+
+func (d PropertyDelta) String() string {
+	return fmt.Sprintf("%s: %v -> %v", d.Path, d.Old, d.New)
+}
+
+// Diff returns the differences between two property structs, which must be structs or pointers
+// to structs of the same type.  Nested structs, pointers to structs and interfaces containing
+// pointers to structs of the same type are compared field by field, and slices are compared
+// element by element.  Maps and configurable properties are reported as a single delta if they
+// differ.  The deltas are returned in field order.
+func Diff(a, b interface{}) ([]PropertyDelta, error) {
+	aValue, bValue := reflect.ValueOf(a), reflect.ValueOf(b)
+	if aValue.Type() != bValue.Type() {
+		return nil, fmt.Errorf("expected matching types, got %s and %s", aValue.Type(), bValue.Type())
+	}
+	if isStructPtr(aValue.Type()) {
+		if aValue.IsNil() || bValue.IsNil() {
+			return nil, fmt.Errorf("expected non-nil *struct")
+		}
+		aValue, bValue = aValue.Elem(), bValue.Elem()
+	} else if !isStruct(aValue.Type()) {
+		return nil, fmt.Errorf("expected struct or *struct, got %s", aValue.Type())
+	}
+
+	var deltas []PropertyDelta
+	diffStructs(&deltas, "", aValue, bValue)
+	return deltas, nil
+}
+
+func diffStructs(deltas *[]PropertyDelta, prefix string, aValue, bValue reflect.Value) {
+	for i, field := range typeFields(aValue.Type()) {
+		if field.PkgPath != "" {
+			// Skip unexported fields, they are not properties.
+			continue
+		}
+
+		path := prefix
+		if !field.Anonymous {
+			path += PropertyNameForField(field.Name)
+		}
+		diffValues(deltas, path, aValue.Field(i), bValue.Field(i), field.Anonymous)
+	}
+}
+
+func diffValues(deltas *[]PropertyDelta, path string, aValue, bValue reflect.Value, embedded bool) {
+	nested := func() string {
+		if embedded || path == "" {
+			return path
+		}
+		return path + "."
+	}
+
+	switch {
+	case aValue.Kind() == reflect.Struct && !isConfigurable(aValue.Type()):
+		diffStructs(deltas, nested(), aValue, bValue)
+	case aValue.Kind() == reflect.Interface:
+		if !aValue.IsNil() && !bValue.IsNil() && aValue.Elem().Type() == bValue.Elem().Type() &&
+			isStructPtr(aValue.Elem().Type()) {
+			diffValues(deltas, path, aValue.Elem(), bValue.Elem(), embedded)
+		} else if !reflect.DeepEqual(aValue.Interface(), bValue.Interface()) {
+			*deltas = append(*deltas, PropertyDelta{path, diffInterface(aValue), diffInterface(bValue)})
+		}
+	case aValue.Kind() == reflect.Pointer:
+		if aValue.IsNil() || bValue.IsNil() {
+			if aValue.IsNil() != bValue.IsNil() {
+				*deltas = append(*deltas, PropertyDelta{path, diffInterface(aValue), diffInterface(bValue)})
+			}
+		} else if aValue.Elem().Kind() == reflect.Struct && !isConfigurable(aValue.Elem().Type()) {
+			diffStructs(deltas, nested(), aValue.Elem(), bValue.Elem())
+		} else if !reflect.DeepEqual(aValue.Elem().Interface(), bValue.Elem().Interface()) {
+			*deltas = append(*deltas, PropertyDelta{path, diffInterface(aValue), diffInterface(bValue)})
+		}
+	case aValue.Kind() == reflect.Slice:
+		for j := 0; j < max(aValue.Len(), bValue.Len()); j++ {
+			elemPath := fmt.Sprintf("%s[%d]", path, j)
+			switch {
+			case j >= aValue.Len():
+				*deltas = append(*deltas, PropertyDelta{elemPath, nil, diffInterface(bValue.Index(j))})
+			case j >= bValue.Len():
+				*deltas = append(*deltas, PropertyDelta{elemPath, diffInterface(aValue.Index(j)), nil})
+			default:
+				diffValues(deltas, elemPath, aValue.Index(j), bValue.Index(j), false)
+			}
+		}
+	default:
+		if !reflect.DeepEqual(aValue.Interface(), bValue.Interface()) {
+			*deltas = append(*deltas, PropertyDelta{path, diffInterface(aValue), diffInterface(bValue)})
+		}
+	}
+}
+
+// diffInterface returns the value to report in a PropertyDelta, dereferencing pointers and
+// returning nil for nil pointers and interfaces.
+func diffInterface(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Pointer && !isStruct(v.Type().Elem()) {
+			return v.Elem().Interface()
+		}
+	}
+	return v.Interface()
+}
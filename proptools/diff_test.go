@@ -0,0 +1,162 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"reflect"
+	"testing"
+)
+
+type diffTestNested struct {
+	Name *string
+	Tags []string
+}
+
+type diffTestProps struct {
+	Enabled  *bool
+	Count    int
+	Srcs     []string
+	Nested   diffTestNested
+	Ptr      *diffTestNested
+	Iface    interface{}
+	Map      map[string]string
+	Embedded struct {
+		DiffTestEmbedded
+	}
+}
+
+type DiffTestEmbedded struct {
+	Name *string
+}
+
+func TestDiff(t *testing.T) {
+	testCases := []struct {
+		name   string
+		a, b   *diffTestProps
+		deltas []PropertyDelta
+	}{
+		{
+			name: "equal",
+			a: &diffTestProps{
+				Enabled: BoolPtr(true),
+				Srcs:    []string{"a"},
+				Ptr:     &diffTestNested{Name: StringPtr("x")},
+			},
+			b: &diffTestProps{
+				Enabled: BoolPtr(true),
+				Srcs:    []string{"a"},
+				Ptr:     &diffTestNested{Name: StringPtr("x")},
+			},
+		},
+		{
+			name: "basic",
+			a:    &diffTestProps{Enabled: BoolPtr(true), Count: 1},
+			b:    &diffTestProps{Enabled: BoolPtr(false), Count: 2},
+			deltas: []PropertyDelta{
+				{"enabled", true, false},
+				{"count", 1, 2},
+			},
+		},
+		{
+			name: "pointer nil vs set",
+			a:    &diffTestProps{},
+			b:    &diffTestProps{Enabled: BoolPtr(false), Ptr: &diffTestNested{}},
+			deltas: []PropertyDelta{
+				{"enabled", nil, false},
+				{"ptr", nil, &diffTestNested{}},
+			},
+		},
+		{
+			name: "nested structs",
+			a: &diffTestProps{
+				Nested: diffTestNested{Name: StringPtr("a"), Tags: []string{"t"}},
+				Ptr:    &diffTestNested{Name: StringPtr("b")},
+				Iface:  &diffTestNested{Name: StringPtr("c")},
+			},
+			b: &diffTestProps{
+				Nested: diffTestNested{Name: StringPtr("A"), Tags: []string{"t"}},
+				Ptr:    &diffTestNested{Name: StringPtr("B")},
+				Iface:  &diffTestNested{Name: StringPtr("C")},
+			},
+			deltas: []PropertyDelta{
+				{"nested.name", "a", "A"},
+				{"ptr.name", "b", "B"},
+				{"iface.name", "c", "C"},
+			},
+		},
+		{
+			name: "embedded struct",
+			a:    &diffTestProps{},
+			b: &diffTestProps{
+				Embedded: struct{ DiffTestEmbedded }{DiffTestEmbedded{Name: StringPtr("e")}},
+			},
+			deltas: []PropertyDelta{
+				{"embedded.name", nil, "e"},
+			},
+		},
+		{
+			name: "slices",
+			a: &diffTestProps{
+				Srcs:   []string{"a", "b"},
+				Nested: diffTestNested{Tags: []string{"x", "y", "z"}},
+			},
+			b: &diffTestProps{
+				Srcs:   []string{"a", "c", "d", "e"},
+				Nested: diffTestNested{Tags: []string{"x"}},
+			},
+			deltas: []PropertyDelta{
+				{"srcs[1]", "b", "c"},
+				{"srcs[2]", nil, "d"},
+				{"srcs[3]", nil, "e"},
+				{"nested.tags[1]", "y", nil},
+				{"nested.tags[2]", "z", nil},
+			},
+		},
+		{
+			name: "maps",
+			a:    &diffTestProps{Map: map[string]string{"a": "b"}},
+			b:    &diffTestProps{Map: map[string]string{"a": "c"}},
+			deltas: []PropertyDelta{
+				{"map", map[string]string{"a": "b"}, map[string]string{"a": "c"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			deltas, err := Diff(tc.a, tc.b)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(tc.deltas, deltas) {
+				t.Errorf("incorrect deltas")
+				t.Errorf("  expected: %v", tc.deltas)
+				t.Errorf("       got: %v", deltas)
+			}
+		})
+	}
+}
+
+func TestDiffErrors(t *testing.T) {
+	if _, err := Diff(&diffTestProps{}, &diffTestNested{}); err == nil {
+		t.Errorf("expected error for mismatched types")
+	}
+	if _, err := Diff("a", "b"); err == nil {
+		t.Errorf("expected error for non-struct values")
+	}
+	if _, err := Diff(diffTestNested{}, diffTestNested{Name: StringPtr("a")}); err != nil {
+		t.Errorf("unexpected error for struct values: %s", err)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// PropertyDoc describes a property that can be set in a Blueprints file, as found by reflecting
+// over a property struct.
+type PropertyDoc struct {
+	// Name is the name of the property, with the names of nested properties separated by ".".
+	Name string
+
+	// Type is the Go type of the field.
+	Type string
+
+	// Default is the value of the field in the property struct formatted with %v, or "" if it is
+	// unset.  Pointers are dereferenced.
+	Default string
+
+	// Doc is the description of the property from a `blueprint:"doc:Description"` tag.
+	Doc string
+
+	// Required is true if the field is tagged `blueprint:"required"`.
+	Required bool
+
+	// DeprecatedName is the old name accepted for the property by a
+	// `blueprint:"deprecated_name:old_name"` tag.
+	DeprecatedName string
+
+	// MutuallyExclusive is the group of a `blueprint:"mutually_exclusive:group"` tag.
+	MutuallyExclusive string
+
+	// Enum lists the values allowed by a `blueprint:"enum:a,b"` or `blueprint:"enum_ci:a,b"`
+	// tag, and EnumCaseInsensitive is true for the latter.
+	Enum                []string
+	EnumCaseInsensitive bool
+}
+
+// PropertyDocs returns a PropertyDoc for each property of the property structs, which must be
+// pointers to structs as returned by a module factory, in field order.  Nested structs and
+// pointers to structs are described by their properties, and the properties of embedded structs
+// are listed as properties of the struct that embeds them.  Unexported fields and fields tagged
+// `blueprint:"mutated"` are skipped, as they can't be set in a Blueprints file.
+func PropertyDocs(props ...interface{}) []PropertyDoc {
+	var docs []PropertyDoc
+	for _, p := range props {
+		v := reflect.ValueOf(p)
+		if !isStructPtr(v.Type()) {
+			panic(fmt.Errorf("type %s is not a pointer to a struct", v.Type()))
+		}
+		docs = appendPropertyDocs(docs, "", v)
+	}
+	return docs
+}
+
+func appendPropertyDocs(docs []PropertyDoc, prefix string, v reflect.Value) []PropertyDoc {
+	// Describe the fields of nil pointers to structs with their zero values.
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			if v.Kind() == reflect.Interface {
+				return docs
+			}
+			v = reflect.Zero(v.Type().Elem())
+		} else {
+			v = v.Elem()
+		}
+	}
+
+	for i, field := range typeFields(v.Type()) {
+		if field.Name == "BlueprintEmbed" {
+			field.Anonymous = true
+		}
+		if field.PkgPath != "" || HasTag(field, "blueprint", "mutated") {
+			continue
+		}
+
+		fieldValue := v.Field(i)
+		if field.Anonymous {
+			docs = appendPropertyDocs(docs, prefix, fieldValue)
+			continue
+		}
+
+		name := fieldPath(prefix, PropertyNameForField(field.Name))
+		if isPropertyStruct(fieldValue) {
+			docs = appendPropertyDocs(docs, name, fieldValue)
+			continue
+		}
+
+		doc := PropertyDoc{
+			Name:     name,
+			Type:     field.Type.String(),
+			Doc:      FieldDoc(field),
+			Required: HasTag(field, "blueprint", "required"),
+		}
+		if !fieldValue.IsZero() {
+			if fieldValue.Kind() == reflect.Pointer {
+				doc.Default = fmt.Sprintf("%v", fieldValue.Elem().Interface())
+			} else {
+				doc.Default = fmt.Sprintf("%v", fieldValue.Interface())
+			}
+		}
+		doc.DeprecatedName, _ = tagValueWithPrefix(field, "blueprint", "deprecated_name:")
+		doc.MutuallyExclusive, _ = tagValueWithPrefix(field, "blueprint", "mutually_exclusive:")
+		doc.Enum, doc.EnumCaseInsensitive, _ = enumTagValues(field)
+
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// isPropertyStruct returns true if v is a struct of nested properties, a pointer to one, or an
+// interface holding a pointer to one.
+func isPropertyStruct(v reflect.Value) bool {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	t := v.Type()
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return isStruct(t) && !isConfigurable(t)
+}
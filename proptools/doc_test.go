@@ -0,0 +1,64 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"reflect"
+	"testing"
+)
+
+type DocTestEmbedded struct {
+	Cflags []string `blueprint:"doc:Flags passed to the compiler, in order."`
+}
+
+type docTestProps struct {
+	Name    *string  `blueprint:"required"`
+	Old     []string `blueprint:"deprecated_name:older"`
+	Stl     *string  `blueprint:"enum_ci:none, libc++,doc:The C++ library."`
+	Static  *bool    `blueprint:"mutually_exclusive:linkage"`
+	Shared  *bool    `blueprint:"mutually_exclusive:linkage"`
+	Count   int64
+	Variant string `blueprint:"mutated"`
+	Arch    struct {
+		Arm *struct {
+			Srcs []string
+		}
+	}
+	Select Configurable[string]
+
+	DocTestEmbedded
+	unexported string
+}
+
+func TestPropertyDocs(t *testing.T) {
+	props := &docTestProps{Count: 2}
+
+	want := []PropertyDoc{
+		{Name: "name", Type: "*string", Required: true},
+		{Name: "old", Type: "[]string", DeprecatedName: "older"},
+		{Name: "stl", Type: "*string", Enum: []string{"none", "libc++"}, EnumCaseInsensitive: true, Doc: "The C++ library."},
+		{Name: "static", Type: "*bool", MutuallyExclusive: "linkage"},
+		{Name: "shared", Type: "*bool", MutuallyExclusive: "linkage"},
+		{Name: "count", Type: "int64", Default: "2"},
+		{Name: "arch.arm.srcs", Type: "[]string"},
+		{Name: "select", Type: "proptools.Configurable[string]"},
+		{Name: "cflags", Type: "[]string", Doc: "Flags passed to the compiler, in order."},
+	}
+
+	got := PropertyDocs(props)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect property docs\nwant: %+v\n got: %+v", want, got)
+	}
+}
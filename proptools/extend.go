@@ -77,7 +77,7 @@ func PrependProperties(dst interface{}, src interface{}, filter ExtendPropertyFi
 // pointers to structs.  Appending the zero value of a property will always be a no-op.
 func AppendMatchingProperties(dst []interface{}, src interface{},
 	filter ExtendPropertyFilterFunc) error {
-	return extendMatchingProperties(dst, src, filter, OrderAppend)
+	return extendMatchingProperties(dst, src, filter, false, OrderAppend)
 }
 
 // PrependMatchingProperties prepends the values of properties in the property struct src to the
@@ -98,7 +98,26 @@ func AppendMatchingProperties(dst []interface{}, src interface{},
 // pointers to structs.  Prepending the zero value of a property will always be a no-op.
 func PrependMatchingProperties(dst []interface{}, src interface{},
 	filter ExtendPropertyFilterFunc) error {
-	return extendMatchingProperties(dst, src, filter, OrderPrepend)
+	return extendMatchingProperties(dst, src, filter, false, OrderPrepend)
+}
+
+// AppendOverlappingProperties appends the values of properties in the property struct src to the
+// property structs in dst, like AppendMatchingProperties, except that properties in src that are
+// not found in any of the property structs in dst are ignored instead of returning an error.  This
+// allows a property struct to be applied to module types that only support a subset of its
+// properties.  Properties that are found but whose types do not match are still an error.
+func AppendOverlappingProperties(dst []interface{}, src interface{},
+	filter ExtendPropertyFilterFunc) error {
+	return extendMatchingProperties(dst, src, filter, true, OrderAppend)
+}
+
+// PrependOverlappingProperties prepends the values of properties in the property struct src to the
+// property structs in dst, like PrependMatchingProperties, except that properties in src that are
+// not found in any of the property structs in dst are ignored instead of returning an error.
+// Properties that are found but whose types do not match are still an error.
+func PrependOverlappingProperties(dst []interface{}, src interface{},
+	filter ExtendPropertyFilterFunc) error {
+	return extendMatchingProperties(dst, src, filter, true, OrderPrepend)
 }
 
 // ExtendProperties appends or prepends the values of properties in the property struct src to the
@@ -110,7 +129,7 @@ func PrependMatchingProperties(dst []interface{}, src interface{},
 // filter will append or prepend all properties.
 //
 // The order function is called on each non-filtered property to determine if it should be appended
-// or prepended.
+// or prepended.  The struct field tags of the property can then change the order, see FieldOrder.
 //
 // An error returned by ExtendProperties that applies to a specific property will be an
 // *ExtendPropertyError, and can have the property name and error extracted from it.
@@ -148,7 +167,7 @@ func ExtendProperties(dst interface{}, src interface{}, filter ExtendPropertyFil
 // no-op.
 func ExtendMatchingProperties(dst []interface{}, src interface{},
 	filter ExtendPropertyFilterFunc, order ExtendPropertyOrderFunc) error {
-	return extendMatchingProperties(dst, src, filter, order)
+	return extendMatchingProperties(dst, src, filter, false, order)
 }
 
 type Order int
@@ -168,6 +187,29 @@ const (
 	Prepend_replace
 )
 
+// FieldOrder returns the order used to extend the property for field when order is requested by
+// the ExtendPropertyOrderFunc, which implements the per-field policy selected by struct field tags.
+// Without a tag, lists are concatenated and scalar pointer properties set in dst are kept when
+// prepending.  Properties tagged `blueprint:"overwrite"` are replaced by the value in src when it
+// is set, whether it is appended or prepended, so that defaults can override the value of a
+// module.  A non-pointer string or number is overwritten when the src value is not empty or zero,
+// and a non-pointer bool is set when it is true.  Properties tagged
+// `android:"replace_instead_of_append"` are replaced by the value in src when appending, and by the
+// value in src only if they are unset when prepending.
+func FieldOrder(field reflect.StructField, order Order) Order {
+	if HasTag(field, "blueprint", "overwrite") {
+		return Replace
+	}
+	if HasTag(field, "android", "replace_instead_of_append") {
+		if order == Append {
+			return Replace
+		} else if order == Prepend {
+			return Prepend_replace
+		}
+	}
+	return order
+}
+
 type ExtendPropertyFilterFunc func(dstField, srcField reflect.StructField) (bool, error)
 
 type ExtendPropertyOrderFunc func(dstField, srcField reflect.StructField) (Order, error)
@@ -222,11 +264,11 @@ func extendProperties(dst interface{}, src interface{}, filter ExtendPropertyFil
 
 	dstValues := []reflect.Value{dstValue}
 
-	return extendPropertiesRecursive(dstValues, srcValue, make([]string, 0, 8), filter, true, order)
+	return extendPropertiesRecursive(dstValues, srcValue, make([]string, 0, 8), filter, true, false, order)
 }
 
 func extendMatchingProperties(dst []interface{}, src interface{}, filter ExtendPropertyFilterFunc,
-	order ExtendPropertyOrderFunc) error {
+	ignoreMissing bool, order ExtendPropertyOrderFunc) error {
 
 	srcValue, err := getStruct(src)
 	if err != nil {
@@ -245,11 +287,11 @@ func extendMatchingProperties(dst []interface{}, src interface{}, filter ExtendP
 		}
 	}
 
-	return extendPropertiesRecursive(dstValues, srcValue, make([]string, 0, 8), filter, false, order)
+	return extendPropertiesRecursive(dstValues, srcValue, make([]string, 0, 8), filter, false, ignoreMissing, order)
 }
 
 func extendPropertiesRecursive(dstValues []reflect.Value, srcValue reflect.Value,
-	prefix []string, filter ExtendPropertyFilterFunc, sameTypes bool,
+	prefix []string, filter ExtendPropertyFilterFunc, sameTypes, ignoreMissing bool,
 	orderFunc ExtendPropertyOrderFunc) error {
 
 	dstValuesCopied := false
@@ -383,7 +425,7 @@ func extendPropertiesRecursive(dstValues []reflect.Value, srcValue reflect.Value
 					recurse = append(recurse, dstFieldValue)
 					continue
 				}
-			case reflect.Bool, reflect.String, reflect.Slice, reflect.Map:
+			case reflect.Bool, reflect.String, reflect.Int64, reflect.Float64, reflect.Slice, reflect.Map:
 				// If the types don't match or srcFieldValue cannot be converted to a Configurable type, it's an error
 				ct, err := configurableType(srcFieldValue.Type())
 				if srcFieldValue.Type() != dstFieldValue.Type() && (err != nil || dstFieldValue.Type() != ct) {
@@ -398,7 +440,7 @@ func extendPropertiesRecursive(dstValues []reflect.Value, srcValue reflect.Value
 						dstFieldValue.Type(), srcFieldValue.Type())
 				}
 				switch ptrKind := srcFieldValue.Type().Elem().Kind(); ptrKind {
-				case reflect.Bool, reflect.Int64, reflect.String, reflect.Struct:
+				case reflect.Bool, reflect.Int64, reflect.Float64, reflect.String, reflect.Struct:
 				// Nothing
 				default:
 					return extendPropertyErrorf(propertyName(srcField), "pointer is a %s", ptrKind)
@@ -433,12 +475,16 @@ func extendPropertiesRecursive(dstValues []reflect.Value, srcValue reflect.Value
 				}
 			}
 
-			if HasTag(dstField, "android", "replace_instead_of_append") {
-				if order == Append {
-					order = Replace
-				} else if order == Prepend {
-					order = Prepend_replace
+			order = FieldOrder(dstField, order)
+
+			if HasTag(dstField, "blueprint", "overwrite") && dstFieldValue.Kind() == reflect.String {
+				// Replace concatenates non-pointer strings, but overwrite replaces them.  An unset
+				// non-pointer string can't be told apart from "", so only overwrite with a non-empty
+				// string.
+				if s := srcFieldValue.String(); s != "" {
+					dstFieldValue.SetString(s)
 				}
+				continue
 			}
 
 			ExtendBasicType(dstFieldValue, srcFieldValue, order)
@@ -446,11 +492,11 @@ func extendPropertiesRecursive(dstValues []reflect.Value, srcValue reflect.Value
 
 		if len(recurse) > 0 {
 			err := extendPropertiesRecursive(recurse, srcFieldValue,
-				append(prefix, srcField.Name), filter, sameTypes, orderFunc)
+				append(prefix, srcField.Name), filter, sameTypes, ignoreMissing, orderFunc)
 			if err != nil {
 				return err
 			}
-		} else if !found {
+		} else if !found && !ignoreMissing {
 			return extendPropertyErrorf(propertyName(srcField), "failed to find property to extend")
 		}
 	}
@@ -492,8 +538,15 @@ func ExtendBasicType(dstFieldValue, srcFieldValue reflect.Value, order Order) {
 			unpackedDst.setAppend(srcFieldValue.Interface(), replace, prepend)
 		}
 	case reflect.Bool:
-		// Boolean OR
+		// Boolean OR.  An unset non-pointer bool can't be told apart from false, so Replace only
+		// replaces with true, which is the same.
 		dstFieldValue.Set(reflect.ValueOf(srcFieldValue.Bool() || dstFieldValue.Bool()))
+	case reflect.Int64, reflect.Float64:
+		// A zero value is treated as unset, use a pointer to distinguish an explicit zero.  An unset
+		// src value never replaces dst, and when prepending a set dst value is kept.
+		if !srcFieldValue.IsZero() && (!prepend || dstFieldValue.IsZero()) {
+			dstFieldValue.Set(srcFieldValue)
+		}
 	case reflect.String:
 		if prepend {
 			dstFieldValue.SetString(srcFieldValue.String() +
@@ -574,6 +627,15 @@ func ExtendBasicType(dstFieldValue, srcFieldValue reflect.Value, order Order) {
 				// Int() returns Int64
 				dstFieldValue.Set(reflect.ValueOf(Int64Ptr(srcFieldValue.Elem().Int())))
 			}
+		case reflect.Float64:
+			if prepend {
+				if dstFieldValue.IsNil() {
+					dstFieldValue.Set(reflect.ValueOf(Float64Ptr(srcFieldValue.Elem().Float())))
+				}
+			} else {
+				// For append, replace the original value.
+				dstFieldValue.Set(reflect.ValueOf(Float64Ptr(srcFieldValue.Elem().Float())))
+			}
 		case reflect.String:
 			if prepend {
 				if dstFieldValue.IsNil() {
@@ -34,6 +34,14 @@ type appendPropertyTestCase struct {
 	err    error
 }
 
+type overwriteTestProperties struct {
+	Overwrite []string `blueprint:"overwrite"`
+	Append    []string
+	Keep      *string
+	Stem      string `blueprint:"overwrite"`
+	Enabled   bool   `blueprint:"overwrite"`
+}
+
 func appendPropertiesTestCases() []appendPropertyTestCase {
 	return []appendPropertyTestCase{
 		// Valid inputs
@@ -106,6 +114,157 @@ func appendPropertiesTestCases() []appendPropertyTestCase {
 			},
 			order: Prepend,
 		},
+		{
+			name: "Append numbers",
+			dst: &struct {
+				I1, I2 int64
+				F1, F2 float64
+			}{
+				I1: 1,
+				I2: 1,
+				F1: 1.5,
+				F2: 1.5,
+			},
+			src: &struct {
+				I1, I2 int64
+				F1, F2 float64
+			}{
+				I1: 2,
+				F1: 2.5,
+			},
+			out: &struct {
+				I1, I2 int64
+				F1, F2 float64
+			}{
+				I1: 2,
+				I2: 1,
+				F1: 2.5,
+				F2: 1.5,
+			},
+		},
+		{
+			name: "Prepend numbers",
+			dst: &struct {
+				I1, I2 int64
+				F1, F2 float64
+			}{
+				I1: 1,
+				F1: 1.5,
+			},
+			src: &struct {
+				I1, I2 int64
+				F1, F2 float64
+			}{
+				I1: 2,
+				I2: 2,
+				F1: 2.5,
+				F2: 2.5,
+			},
+			out: &struct {
+				I1, I2 int64
+				F1, F2 float64
+			}{
+				I1: 1,
+				I2: 2,
+				F1: 1.5,
+				F2: 2.5,
+			},
+			order: Prepend,
+		},
+		{
+			name: "Replace numbers",
+			dst: &struct {
+				I1, I2 int64
+				F1, F2 float64
+			}{
+				I1: 1,
+				I2: 1,
+				F1: 1.5,
+				F2: 1.5,
+			},
+			src: &struct {
+				I1, I2 int64
+				F1, F2 float64
+			}{
+				I1: 2,
+				F1: 2.5,
+			},
+			out: &struct {
+				I1, I2 int64
+				F1, F2 float64
+			}{
+				I1: 2,
+				I2: 1,
+				F1: 2.5,
+				F2: 1.5,
+			},
+			order: Replace,
+		},
+		{
+			name: "Replace concatenates strings and ORs bools",
+			dst: &struct {
+				S1, S2 string
+				B1, B2 bool
+			}{
+				S1: "string1",
+				S2: "string2",
+				B1: true,
+			},
+			src: &struct {
+				S1, S2 string
+				B1, B2 bool
+			}{
+				S1: "string3",
+				B2: true,
+			},
+			out: &struct {
+				S1, S2 string
+				B1, B2 bool
+			}{
+				S1: "string1string3",
+				S2: "string2",
+				B1: true,
+				B2: true,
+			},
+			order: Replace,
+		},
+		{
+			name: "Append pointer to float64",
+			dst: &struct{ F1, F2, F3 *float64 }{
+				F1: Float64Ptr(1),
+				F2: nil,
+				F3: Float64Ptr(1),
+			},
+			src: &struct{ F1, F2, F3 *float64 }{
+				F1: Float64Ptr(0),
+				F2: Float64Ptr(2),
+				F3: nil,
+			},
+			out: &struct{ F1, F2, F3 *float64 }{
+				F1: Float64Ptr(0),
+				F2: Float64Ptr(2),
+				F3: Float64Ptr(1),
+			},
+		},
+		{
+			name: "Prepend pointer to float64",
+			dst: &struct{ F1, F2, F3 *float64 }{
+				F1: Float64Ptr(1),
+				F2: nil,
+				F3: Float64Ptr(1),
+			},
+			src: &struct{ F1, F2, F3 *float64 }{
+				F1: Float64Ptr(0),
+				F2: Float64Ptr(2),
+				F3: nil,
+			},
+			out: &struct{ F1, F2, F3 *float64 }{
+				F1: Float64Ptr(1),
+				F2: Float64Ptr(2),
+				F3: Float64Ptr(1),
+			},
+			order: Prepend,
+		},
 		{
 			name: "Append pointer to bool",
 			dst: &struct{ B1, B2, B3, B4, B5, B6, B7, B8, B9 *bool }{
@@ -414,6 +573,225 @@ func appendPropertiesTestCases() []appendPropertyTestCase {
 			},
 			order: Replace,
 		},
+		{
+			name: "Append overwrite slice",
+			dst: &overwriteTestProperties{
+				Overwrite: []string{"string1"},
+				Append:    []string{"string1"},
+				Keep:      StringPtr("string1"),
+			},
+			src: &overwriteTestProperties{
+				Overwrite: []string{"string2"},
+				Append:    []string{"string2"},
+				Keep:      StringPtr("string2"),
+			},
+			out: &overwriteTestProperties{
+				Overwrite: []string{"string2"},
+				Append:    []string{"string1", "string2"},
+				Keep:      StringPtr("string2"),
+			},
+		},
+		{
+			name: "Prepend overwrite slice",
+			dst: &overwriteTestProperties{
+				Overwrite: []string{"string1"},
+				Append:    []string{"string1"},
+				Keep:      StringPtr("string1"),
+			},
+			src: &overwriteTestProperties{
+				Overwrite: []string{"string2"},
+				Append:    []string{"string2"},
+				Keep:      StringPtr("string2"),
+			},
+			out: &overwriteTestProperties{
+				Overwrite: []string{"string2"},
+				Append:    []string{"string2", "string1"},
+				Keep:      StringPtr("string1"),
+			},
+			order: Prepend,
+		},
+		{
+			name: "Prepend overwrite string and bool",
+			dst: &overwriteTestProperties{
+				Stem: "string1",
+			},
+			src: &overwriteTestProperties{
+				Stem:    "string2",
+				Enabled: true,
+			},
+			out: &overwriteTestProperties{
+				Stem:    "string2",
+				Enabled: true,
+			},
+			order: Prepend,
+		},
+		{
+			name: "Prepend overwrite unset string and bool",
+			dst: &overwriteTestProperties{
+				Stem:    "module",
+				Enabled: true,
+			},
+			src: &overwriteTestProperties{},
+			out: &overwriteTestProperties{
+				Stem:    "module",
+				Enabled: true,
+			},
+			order: Prepend,
+		},
+		{
+			name: "Append replace_instead_of_append string",
+			dst: &struct {
+				R string `android:"replace_instead_of_append"`
+			}{
+				R: "string1",
+			},
+			src: &struct {
+				R string `android:"replace_instead_of_append"`
+			}{
+				R: "string2",
+			},
+			out: &struct {
+				R string `android:"replace_instead_of_append"`
+			}{
+				R: "string1string2",
+			},
+			order: Append,
+		},
+		{
+			name: "Prepend replace_instead_of_append string",
+			dst: &struct {
+				R string `android:"replace_instead_of_append"`
+			}{
+				R: "string1",
+			},
+			src: &struct {
+				R string `android:"replace_instead_of_append"`
+			}{
+				R: "string2",
+			},
+			out: &struct {
+				R string `android:"replace_instead_of_append"`
+			}{
+				R: "string2string1",
+			},
+			order: Prepend,
+		},
+		{
+			name: "Prepend overwrite unset numbers",
+			dst: &struct {
+				I int64   `blueprint:"overwrite"`
+				F float64 `blueprint:"overwrite"`
+			}{
+				I: 5,
+				F: 1.5,
+			},
+			src: &struct {
+				I int64   `blueprint:"overwrite"`
+				F float64 `blueprint:"overwrite"`
+			}{},
+			out: &struct {
+				I int64   `blueprint:"overwrite"`
+				F float64 `blueprint:"overwrite"`
+			}{
+				I: 5,
+				F: 1.5,
+			},
+			order: Prepend,
+		},
+		{
+			name: "Append overwrite unset numbers",
+			dst: &struct {
+				I int64   `blueprint:"overwrite"`
+				F float64 `blueprint:"overwrite"`
+			}{
+				I: 5,
+				F: 1.5,
+			},
+			src: &struct {
+				I int64   `blueprint:"overwrite"`
+				F float64 `blueprint:"overwrite"`
+			}{},
+			out: &struct {
+				I int64   `blueprint:"overwrite"`
+				F float64 `blueprint:"overwrite"`
+			}{
+				I: 5,
+				F: 1.5,
+			},
+			order: Append,
+		},
+		{
+			name: "Prepend overwrite numbers",
+			dst: &struct {
+				I int64 `blueprint:"overwrite"`
+			}{
+				I: 5,
+			},
+			src: &struct {
+				I int64 `blueprint:"overwrite"`
+			}{
+				I: 6,
+			},
+			out: &struct {
+				I int64 `blueprint:"overwrite"`
+			}{
+				I: 6,
+			},
+			order: Prepend,
+		},
+		{
+			name: "Append replace_instead_of_append unset numbers",
+			dst: &struct {
+				K int64   `android:"replace_instead_of_append"`
+				F float64 `android:"replace_instead_of_append"`
+			}{
+				K: 7,
+				F: 1.5,
+			},
+			src: &struct {
+				K int64   `android:"replace_instead_of_append"`
+				F float64 `android:"replace_instead_of_append"`
+			}{},
+			out: &struct {
+				K int64   `android:"replace_instead_of_append"`
+				F float64 `android:"replace_instead_of_append"`
+			}{
+				K: 7,
+				F: 1.5,
+			},
+			order: Append,
+		},
+		{
+			name: "Append replace_instead_of_append unset bool",
+			dst: &struct {
+				R bool `android:"replace_instead_of_append"`
+			}{
+				R: true,
+			},
+			src: &struct {
+				R bool `android:"replace_instead_of_append"`
+			}{},
+			out: &struct {
+				R bool `android:"replace_instead_of_append"`
+			}{
+				R: true,
+			},
+			order: Append,
+		},
+		{
+			name: "Prepend overwrite unset",
+			dst: &overwriteTestProperties{
+				Overwrite: []string{"string1"},
+			},
+			src: &overwriteTestProperties{
+				Keep: StringPtr("string2"),
+			},
+			out: &overwriteTestProperties{
+				Overwrite: []string{"string1"},
+				Keep:      StringPtr("string2"),
+			},
+			order: Prepend,
+		},
 		{
 			name: "Append slice of structs",
 			dst: &struct{ S []struct{ F string } }{
@@ -1060,16 +1438,16 @@ func appendPropertiesTestCases() []appendPropertyTestCase {
 		},
 		{
 			name: "Unsupported kind",
-			dst: &struct{ I int64 }{
-				I: 1,
+			dst: &struct{ F float32 }{
+				F: 1,
 			},
-			src: &struct{ I int64 }{
-				I: 2,
+			src: &struct{ F float32 }{
+				F: 2,
 			},
-			out: &struct{ I int64 }{
-				I: 1,
+			out: &struct{ F float32 }{
+				F: 1,
 			},
-			err: extendPropertyErrorf("i", "unsupported kind int64"),
+			err: extendPropertyErrorf("f", "unsupported kind float32"),
 		},
 		{
 			name: "Interface nilitude mismatch",
@@ -2028,6 +2406,131 @@ func TestAppendMatchingProperties(t *testing.T) {
 	}
 }
 
+func TestAppendOverlappingProperties(t *testing.T) {
+	testCases := []struct {
+		name  string
+		order Order
+		dst   []interface{}
+		src   interface{}
+		out   []interface{}
+		err   error
+	}{
+		{
+			name: "Append skips extra properties",
+			dst: []interface{}{
+				&struct{ S string }{
+					S: "string1",
+				},
+				&struct {
+					Nested struct{ B []string }
+				}{},
+			},
+			src: &struct {
+				S      string
+				Extra  *bool
+				Nested struct {
+					B     []string
+					Other string
+				}
+			}{
+				S:     "string2",
+				Extra: BoolPtr(true),
+				Nested: struct {
+					B     []string
+					Other string
+				}{
+					B:     []string{"b"},
+					Other: "other",
+				},
+			},
+			out: []interface{}{
+				&struct{ S string }{
+					S: "string1string2",
+				},
+				&struct {
+					Nested struct{ B []string }
+				}{
+					Nested: struct{ B []string }{
+						B: []string{"b"},
+					},
+				},
+			},
+		},
+		{
+			name:  "Prepend skips extra properties",
+			order: Prepend,
+			dst: []interface{}{
+				&struct{ S []string }{
+					S: []string{"string1"},
+				},
+			},
+			src: &struct {
+				S     []string
+				Extra []string
+			}{
+				S:     []string{"string2"},
+				Extra: []string{"extra"},
+			},
+			out: []interface{}{
+				&struct{ S []string }{
+					S: []string{"string2", "string1"},
+				},
+			},
+		},
+		{
+			name: "Append none",
+			dst: []interface{}{
+				&struct{ A string }{},
+			},
+			src: &struct{ S string }{
+				S: "string1",
+			},
+			out: []interface{}{
+				&struct{ A string }{},
+			},
+		},
+		{
+			name: "Append mismatched types",
+			dst: []interface{}{
+				&struct{ S string }{
+					S: "string1",
+				},
+			},
+			src: &struct {
+				S     []string
+				Extra string
+			}{
+				S: []string{"string2"},
+			},
+			out: []interface{}{
+				&struct{ S string }{
+					S: "string1",
+				},
+			},
+			err: extendPropertyErrorf("s", "mismatched types string and []string"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := testCase.dst
+			var err error
+			var testType string
+
+			switch testCase.order {
+			case Append:
+				testType = "append overlapping"
+				err = AppendOverlappingProperties(got, testCase.src, nil)
+			case Prepend:
+				testType = "prepend overlapping"
+				err = PrependOverlappingProperties(got, testCase.src, nil)
+			}
+
+			check(t, testType, testCase.name, got, err, testCase.out, testCase.err)
+		})
+	}
+}
+
 func TestExtendMatchingProperties(t *testing.T) {
 	for _, testCase := range appendMatchingPropertiesTestCases() {
 		t.Run(testCase.name, func(t *testing.T) {
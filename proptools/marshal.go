@@ -0,0 +1,117 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// MarshalProperties returns a map from property name to value for a property struct or pointer to
+// a property struct, for example to print the properties of a module or compare them with a golden
+// file.  Nested structs and pointers to structs, including those held by interfaces, become nested
+// maps, slices of structs become slices of maps, and the fields of embedded structs are added to
+// the map of the struct that embeds them.  Pointers to other types are dereferenced.
+//
+// Unexported fields and fields tagged `blueprint:"no_serialize"` are skipped.  Properties that are
+// unset are omitted: nil pointers, fields with zero values, and nested structs whose properties are
+// all omitted.  A pointer to a zero value is kept, as it was set explicitly.
+func MarshalProperties(m interface{}) (map[string]interface{}, error) {
+	v := reflect.ValueOf(m)
+	if v.IsValid() && isStructPtr(v.Type()) {
+		if v.IsNil() {
+			return nil, fmt.Errorf("expected non-nil *struct")
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || !isStruct(v.Type()) {
+		return nil, fmt.Errorf("expected struct or *struct, got %s", typeString(v))
+	}
+
+	ret := make(map[string]interface{})
+	marshalStruct(ret, v)
+	return ret, nil
+}
+
+func marshalStruct(ret map[string]interface{}, v reflect.Value) {
+	for i, field := range typeFields(v.Type()) {
+		if !field.IsExported() {
+			continue
+		}
+		if HasTag(field, "blueprint", "no_serialize") {
+			continue
+		}
+
+		fieldValue := v.Field(i)
+		if field.Anonymous {
+			if fieldValue.Kind() == reflect.Pointer {
+				if fieldValue.IsNil() {
+					continue
+				}
+				fieldValue = fieldValue.Elem()
+			}
+			if fieldValue.Kind() == reflect.Struct {
+				marshalStruct(ret, fieldValue)
+			}
+			continue
+		}
+
+		if value, ok := marshalValue(fieldValue); ok {
+			ret[PropertyNameForField(field.Name)] = value
+		}
+	}
+}
+
+// marshalValue returns the value to add to the map for a property, or false if it is unset.
+func marshalValue(v reflect.Value) (interface{}, bool) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil, false
+		}
+		return marshalValue(v.Elem())
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil, false
+		}
+		if isStruct(v.Type().Elem()) {
+			return marshalValue(v.Elem())
+		}
+		return v.Elem().Interface(), true
+	case reflect.Struct:
+		if isConfigurable(v.Type()) {
+			return v.Interface(), !v.IsZero()
+		}
+		nested := make(map[string]interface{})
+		marshalStruct(nested, v)
+		return nested, len(nested) > 0
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil, false
+		}
+		if isSliceOfStruct(v.Type()) {
+			elems := make([]interface{}, v.Len())
+			for i := range elems {
+				nested := make(map[string]interface{})
+				marshalStruct(nested, v.Index(i))
+				elems[i] = nested
+			}
+			return elems, true
+		}
+		return v.Interface(), true
+	default:
+		return v.Interface(), !v.IsZero()
+	}
+}
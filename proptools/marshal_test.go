@@ -0,0 +1,108 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"reflect"
+	"testing"
+)
+
+type marshalTestNested struct {
+	Srcs    []string
+	Enabled *bool
+}
+
+type MarshalTestEmbedded struct {
+	Cflags []string
+}
+
+type marshalTestProps struct {
+	Name     *string
+	Count    int64
+	Enabled  *bool
+	Internal string `blueprint:"no_serialize"`
+	Nested   marshalTestNested
+	Ptr      *marshalTestNested
+	Unset    *marshalTestNested
+	Empty    marshalTestNested
+	Iface    interface{}
+	List     []marshalTestNested
+	Map      map[string]string
+
+	MarshalTestEmbedded
+	unexported string
+}
+
+func TestMarshalProperties(t *testing.T) {
+	props := &marshalTestProps{
+		Name:     StringPtr("foo"),
+		Enabled:  BoolPtr(false),
+		Internal: "hidden",
+		Nested:   marshalTestNested{Srcs: []string{"a.c"}},
+		Ptr:      &marshalTestNested{Enabled: BoolPtr(true)},
+		Iface:    &marshalTestNested{Srcs: []string{"i.c"}},
+		List:     []marshalTestNested{{Srcs: []string{"l.c"}}, {}},
+		MarshalTestEmbedded: MarshalTestEmbedded{
+			Cflags: []string{"-O2"},
+		},
+		unexported: "hidden",
+	}
+
+	got, err := MarshalProperties(props)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"name":    "foo",
+		"enabled": false,
+		"nested":  map[string]interface{}{"srcs": []string{"a.c"}},
+		"ptr":     map[string]interface{}{"enabled": true},
+		"iface":   map[string]interface{}{"srcs": []string{"i.c"}},
+		"list": []interface{}{
+			map[string]interface{}{"srcs": []string{"l.c"}},
+			map[string]interface{}{},
+		},
+		"cflags": []string{"-O2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect properties\nwant: %#v\n got: %#v", want, got)
+	}
+
+	got, err = MarshalProperties(*props)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect properties for a struct value\nwant: %#v\n got: %#v", want, got)
+	}
+}
+
+func TestMarshalPropertiesUnset(t *testing.T) {
+	got, err := MarshalProperties(&marshalTestProps{Internal: "hidden"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("expected no properties, got %#v", got)
+	}
+}
+
+func TestMarshalPropertiesErrors(t *testing.T) {
+	for _, m := range []interface{}{nil, (*marshalTestProps)(nil), "string"} {
+		if _, err := MarshalProperties(m); err == nil {
+			t.Errorf("expected an error for %#v", m)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SetPropertyByPath sets the property at path in the property struct pointed to by ptr to value.
+// The path is a list of field names separated by ".", for example "Arch.Arm.Srcs", and each name
+// may also be written as a property name, for example "arch.arm.srcs".  Fields of embedded
+// structs can be set without naming the embedded struct.  Nil pointers to structs along the path
+// are replaced with pointers to new zero structs.
+//
+// The value must be assignable to the property, with two exceptions: a value of a basic type can
+// set a pointer to that type, and a slice property is appended to instead of being replaced,
+// either with a single element or with all the elements of a slice of the same type.
+func SetPropertyByPath(ptr interface{}, path string, value interface{}) error {
+	field, err := propertyByPath(ptr, path, true)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(value)
+	t := field.Type()
+	switch {
+	case !v.IsValid():
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+			field.SetZero()
+			return nil
+		}
+	case t.Kind() == reflect.Slice && v.Type().AssignableTo(t):
+		field.Set(reflect.AppendSlice(field, v))
+		return nil
+	case t.Kind() == reflect.Slice && v.Type().AssignableTo(t.Elem()):
+		field.Set(reflect.Append(field, v))
+		return nil
+	case v.Type().AssignableTo(t):
+		field.Set(v)
+		return nil
+	case t.Kind() == reflect.Pointer && v.Type().AssignableTo(t.Elem()):
+		p := reflect.New(t.Elem())
+		p.Elem().Set(v)
+		field.Set(p)
+		return nil
+	}
+
+	return fmt.Errorf("cannot set property %q of type %s to a value of type %s", path, t, typeString(v))
+}
+
+// GetPropertyByPath returns the value of the property at path in the property struct pointed to
+// by ptr, using the same paths as SetPropertyByPath.  It returns nil if a pointer to a struct
+// along the path is nil.
+func GetPropertyByPath(ptr interface{}, path string) (interface{}, error) {
+	field, err := propertyByPath(ptr, path, false)
+	if err != nil || !field.IsValid() {
+		return nil, err
+	}
+	return field.Interface(), nil
+}
+
+// propertyByPath returns the settable field at path in the struct pointed to by ptr.  If create
+// is true nil pointers to structs along the path are replaced with pointers to new structs,
+// otherwise an invalid Value is returned when one is found.
+func propertyByPath(ptr interface{}, path string, create bool) (reflect.Value, error) {
+	v := reflect.ValueOf(ptr)
+	if !v.IsValid() || !isStructPtr(v.Type()) || v.IsNil() {
+		return reflect.Value{}, fmt.Errorf("expected non-nil *struct, got %s", typeString(v))
+	}
+	if path == "" {
+		return reflect.Value{}, fmt.Errorf("empty property path")
+	}
+
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		// Descend into pointers and interfaces that hold pointers to structs.
+		for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+			if v.Kind() == reflect.Interface {
+				if v.IsNil() || !isStructPtr(v.Elem().Type()) {
+					break
+				}
+				v = v.Elem()
+				continue
+			}
+			if v.IsNil() {
+				if !create {
+					return reflect.Value{}, nil
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("property %q is a %s, not a struct",
+				strings.Join(names[:i], "."), v.Type())
+		}
+
+		field, ok := v.Type().FieldByName(FieldNameForProperty(name))
+		if !ok || !field.IsExported() {
+			return reflect.Value{}, fmt.Errorf("property %q not found in %s",
+				strings.Join(names[:i+1], "."), v.Type())
+		}
+
+		// Walk the index of promoted fields to handle nil pointers to embedded structs.
+		for j, index := range field.Index {
+			if j > 0 {
+				if v.Kind() == reflect.Pointer {
+					if v.IsNil() {
+						if !create {
+							return reflect.Value{}, nil
+						}
+						v.Set(reflect.New(v.Type().Elem()))
+					}
+					v = v.Elem()
+				}
+			}
+			v = v.Field(index)
+		}
+	}
+
+	return v, nil
+}
+
+func typeString(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	return v.Type().String()
+}
@@ -0,0 +1,171 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"reflect"
+	"testing"
+)
+
+type pathTestArch struct {
+	Srcs    []string
+	Enabled *bool
+}
+
+type PathTestEmbedded struct {
+	Cflags []string
+}
+
+type pathTestProps struct {
+	Name *string
+	Arch struct {
+		Arm *pathTestArch
+		X86 pathTestArch
+	}
+	Iface interface{}
+	Count int64
+
+	*PathTestEmbedded
+	unexported string
+}
+
+func TestSetPropertyByPath(t *testing.T) {
+	props := &pathTestProps{Iface: &pathTestArch{}}
+
+	for _, set := range []struct {
+		path  string
+		value interface{}
+	}{
+		{"Name", "foo"},
+		{"Arch.Arm.Srcs", []string{"a.c"}},
+		{"arch.arm.srcs", "b.c"},
+		{"Arch.Arm.Enabled", true},
+		{"Arch.X86.Srcs", []string{"x.c", "y.c"}},
+		{"Iface.Srcs", "i.c"},
+		{"Count", int64(3)},
+		{"Cflags", "-O2"},
+	} {
+		if err := SetPropertyByPath(props, set.path, set.value); err != nil {
+			t.Errorf("unexpected error setting %q: %s", set.path, err)
+		}
+	}
+
+	want := &pathTestProps{
+		Name:             StringPtr("foo"),
+		Iface:            &pathTestArch{Srcs: []string{"i.c"}},
+		Count:            3,
+		PathTestEmbedded: &PathTestEmbedded{Cflags: []string{"-O2"}},
+	}
+	want.Arch.Arm = &pathTestArch{Srcs: []string{"a.c", "b.c"}, Enabled: BoolPtr(true)}
+	want.Arch.X86.Srcs = []string{"x.c", "y.c"}
+	if !reflect.DeepEqual(props, want) {
+		t.Errorf("incorrect properties\nwant: %#v\n got: %#v", want, props)
+	}
+
+	for _, get := range []struct {
+		path string
+		want interface{}
+	}{
+		{"Arch.Arm.Srcs", []string{"a.c", "b.c"}},
+		{"arch.x86.srcs", []string{"x.c", "y.c"}},
+		{"Name", StringPtr("foo")},
+		{"Cflags", []string{"-O2"}},
+	} {
+		got, err := GetPropertyByPath(props, get.path)
+		if err != nil {
+			t.Errorf("unexpected error getting %q: %s", get.path, err)
+		} else if !reflect.DeepEqual(got, get.want) {
+			t.Errorf("incorrect value for %q\nwant: %#v\n got: %#v", get.path, get.want, got)
+		}
+	}
+}
+
+func TestGetPropertyByPathNilPointer(t *testing.T) {
+	props := &pathTestProps{}
+	got, err := GetPropertyByPath(props, "Arch.Arm.Srcs")
+	if err != nil || got != nil {
+		t.Errorf("expected nil for a property under a nil pointer, got %#v, %v", got, err)
+	}
+	if props.Arch.Arm != nil {
+		t.Errorf("expected GetPropertyByPath not to create pointers")
+	}
+}
+
+func TestSetPropertyByPathErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		ptr   interface{}
+		path  string
+		value interface{}
+		err   string
+	}{
+		{
+			name: "not a pointer",
+			ptr:  pathTestProps{},
+			path: "Name",
+			err:  `expected non-nil *struct, got proptools.pathTestProps`,
+		},
+		{
+			name:  "unknown field",
+			ptr:   &pathTestProps{},
+			path:  "Arch.Mips.Srcs",
+			value: "a.c",
+			err:   `property "Arch.Mips" not found in struct { Arm *proptools.pathTestArch; X86 proptools.pathTestArch }`,
+		},
+		{
+			name:  "unexported field",
+			ptr:   &pathTestProps{},
+			path:  "unexported",
+			value: "a",
+			err:   `property "unexported" not found in proptools.pathTestProps`,
+		},
+		{
+			name:  "not a struct",
+			ptr:   &pathTestProps{},
+			path:  "Name.Value",
+			value: "a",
+			err:   `property "Name" is a string, not a struct`,
+		},
+		{
+			name:  "type mismatch",
+			ptr:   &pathTestProps{},
+			path:  "Arch.X86.Srcs",
+			value: 1,
+			err:   `cannot set property "Arch.X86.Srcs" of type []string to a value of type int`,
+		},
+		{
+			name:  "pointer type mismatch",
+			ptr:   &pathTestProps{},
+			path:  "Arch.Arm.Enabled",
+			value: "true",
+			err:   `cannot set property "Arch.Arm.Enabled" of type *bool to a value of type string`,
+		},
+		{
+			name: "nil for a basic type",
+			ptr:  &pathTestProps{},
+			path: "Count",
+			err:  `cannot set property "Count" of type int64 to a value of type nil`,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SetPropertyByPath(tt.ptr, tt.path, tt.value)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
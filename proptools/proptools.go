@@ -16,6 +16,7 @@ package proptools
 
 import (
 	"reflect"
+	"sort"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -70,11 +71,27 @@ func Int64Ptr(i int64) *int64 {
 	return &(b)
 }
 
+// Float64Ptr returns a pointer to a new float64 containing the given value.
+func Float64Ptr(f float64) *float64 {
+	return &f
+}
+
 // StringPtr returns a pointer to a new string containing the given value.
 func StringPtr(s string) *string {
 	return &s
 }
 
+// SortedKeys returns the keys of a map property in sorted order, so that output generated from
+// the map is deterministic.
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // BoolDefault takes a pointer to a bool and returns the value pointed to by the pointer if it is non-nil,
 // or def if the pointer is nil.
 func BoolDefault(b *bool, def bool) bool {
@@ -129,6 +146,21 @@ func Int(i *int64) int {
 	return IntDefault(i, 0)
 }
 
+// Float64Default takes a pointer to a float64 and returns the value pointed to by the pointer if it
+// is non-nil, or def if the pointer is nil.
+func Float64Default(f *float64, def float64) float64 {
+	if f != nil {
+		return *f
+	}
+	return def
+}
+
+// Float64 takes a pointer to a float64 and returns the value pointed to by the pointer if it is
+// non-nil, or 0 if the pointer is nil.
+func Float64(f *float64) float64 {
+	return Float64Default(f, 0)
+}
+
 func isStruct(t reflect.Type) bool {
 	return t.Kind() == reflect.Struct
 }
@@ -196,3 +196,34 @@ func TestIsConfigurable(t *testing.T) {
 		})
 	}
 }
+
+func TestSortedKeys(t *testing.T) {
+	got := SortedKeys(map[string]*string{"b": nil, "c": nil, "a": nil})
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got := SortedKeys(map[string]string(nil)); len(got) != 0 {
+		t.Errorf("expected no keys, got %q", got)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"foo", "foo", 0},
+		{"", "foo", 3},
+		{"foo", "", 3},
+		{"foo", "fooo", 1},
+		{"foo", "fo", 1},
+		{"foo", "fao", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		if got := EditDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("EditDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -22,7 +22,7 @@ import (
 
 // HasTag returns true if a StructField has a tag in the form `name:"foo,value"`.
 func HasTag(field reflect.StructField, name, value string) bool {
-	tag := field.Tag.Get(name)
+	tag := tagWithoutDoc(field, name)
 	for len(tag) > 0 {
 		idx := strings.Index(tag, ",")
 
@@ -39,6 +39,80 @@ func HasTag(field reflect.StructField, name, value string) bool {
 	return false
 }
 
+// tagValueWithPrefix returns the rest of the first value in a StructField's tag in the form
+// `name:"foo,prefixvalue"` that starts with prefix.
+func tagValueWithPrefix(field reflect.StructField, name, prefix string) (string, bool) {
+	for _, v := range strings.Split(tagWithoutDoc(field, name), ",") {
+		if rest, ok := strings.CutPrefix(v, prefix); ok {
+			return rest, true
+		}
+	}
+	return "", false
+}
+
+// tagWithoutDoc returns a StructField's tag, without the doc: value of a blueprint tag so that
+// the commas in the description aren't taken as separating other values.
+func tagWithoutDoc(field reflect.StructField, name string) string {
+	tag := field.Tag.Get(name)
+	if name != "blueprint" {
+		return tag
+	}
+	if strings.HasPrefix(tag, "doc:") {
+		return ""
+	}
+	if i := strings.Index(tag, ",doc:"); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+// tagValueToEnd returns the rest of a StructField's tag in the form `name:"foo,prefixvalue"`
+// starting after prefix, for tag values that may themselves contain commas.
+func tagValueToEnd(field reflect.StructField, name, prefix string) (string, bool) {
+	tag := field.Tag.Get(name)
+	for len(tag) > 0 {
+		if rest, ok := strings.CutPrefix(tag, prefix); ok {
+			return rest, true
+		}
+		idx := strings.Index(tag, ",")
+		if idx < 0 {
+			break
+		}
+		tag = tag[idx+1:]
+	}
+	return "", false
+}
+
+// FieldDoc returns the description of a property from a `blueprint:"doc:Description"` tag on its
+// field, or "" if it has none.  The description may contain commas, so it must be the last value in
+// the tag.
+func FieldDoc(field reflect.StructField) string {
+	doc, _ := tagValueToEnd(field, "blueprint", "doc:")
+	return strings.TrimSpace(doc)
+}
+
+// enumTagValues returns the values allowed by a `blueprint:"enum:a,b,c"` or
+// `blueprint:"enum_ci:a,b,c"` tag on field, whether the tag was enum_ci, and whether either tag was
+// found.  The values end at a following doc: value.
+func enumTagValues(field reflect.StructField) (values []string, caseInsensitive bool, ok bool) {
+	tag, caseInsensitive := tagValueToEnd(field, "blueprint", "enum_ci:")
+	if !caseInsensitive {
+		if tag, ok = tagValueToEnd(field, "blueprint", "enum:"); !ok {
+			return nil, false, false
+		}
+	}
+	if i := strings.Index(tag, ",doc:"); i >= 0 {
+		tag = tag[:i]
+	}
+
+	for _, v := range strings.Split(tag, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values, caseInsensitive, true
+}
+
 // PropertyIndexesWithTag returns the indexes of all properties (in the form used by reflect.Value.FieldByIndex) that
 // are tagged with the given key and value, including ones found in embedded structs or pointers to structs.
 func PropertyIndexesWithTag(ps interface{}, key, value string) [][]int {
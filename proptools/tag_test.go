@@ -196,3 +196,44 @@ func TestPropertyIndexesWithTag(t *testing.T) {
 		})
 	}
 }
+
+func TestFieldDoc(t *testing.T) {
+	type docType struct {
+		NoDoc    string
+		Doc      string   `blueprint:"doc:The name of the module."`
+		Commas   []string `blueprint:"required,doc:Flags, in order."`
+		WithEnum *string  `blueprint:"enum:a,b,doc:One of a or b."`
+		Other    string   `doc:"Not a blueprint tag."`
+		Values   *string  `blueprint:"doc:Either,required,deprecated_name:x."`
+	}
+
+	tests := []struct {
+		field string
+		want  string
+		enum  []string
+	}{
+		{field: "NoDoc", want: ""},
+		{field: "Doc", want: "The name of the module."},
+		{field: "Commas", want: "Flags, in order."},
+		{field: "WithEnum", want: "One of a or b.", enum: []string{"a", "b"}},
+		{field: "Other", want: ""},
+		{field: "Values", want: "Either,required,deprecated_name:x."},
+	}
+	for _, test := range tests {
+		t.Run(test.field, func(t *testing.T) {
+			field, _ := reflect.TypeOf(docType{}).FieldByName(test.field)
+			if got := FieldDoc(field); got != test.want {
+				t.Errorf("FieldDoc(%q) = %q, want %q", field.Tag, got, test.want)
+			}
+			if enum, _, _ := enumTagValues(field); !reflect.DeepEqual(enum, test.enum) {
+				t.Errorf("enumTagValues(%q) = %q, want %q", field.Tag, enum, test.enum)
+			}
+			if test.field != "Commas" && HasTag(field, "blueprint", "required") {
+				t.Errorf(`HasTag(%q, "blueprint", "required") = true, want false`, field.Tag)
+			}
+			if name, ok := tagValueWithPrefix(field, "blueprint", "deprecated_name:"); ok {
+				t.Errorf("tagValueWithPrefix(%q) = %q, want none", field.Tag, name)
+			}
+		})
+	}
+}
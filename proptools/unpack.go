@@ -15,8 +15,10 @@
 package proptools
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -27,6 +29,14 @@ import (
 
 const maxUnpackErrors = 10
 
+// ErrUnrecognizedProperty is wrapped by the errors reported for properties in a Blueprints file
+// that don't match any field in the property structs.
+var ErrUnrecognizedProperty = errors.New("unrecognized property")
+
+// ErrMissingRequiredProperty is wrapped by the errors reported for fields tagged with
+// `blueprint:"required"` that aren't set by any property.
+var ErrMissingRequiredProperty = errors.New("missing required property")
+
 type UnpackError struct {
 	Err error
 	Pos scanner.Position
@@ -45,8 +55,11 @@ type packedProperty struct {
 // unpackContext keeps compound names and their values in a map. It is initialized from
 // parsed properties.
 type unpackContext struct {
-	propertyMap map[string]*packedProperty
-	errs        []error
+	propertyMap     map[string]*packedProperty
+	objects         []interface{}
+	missingRequired map[string]bool
+	errs            []error
+	warnings        []*UnpackError
 }
 
 // UnpackProperties populates the list of runtime values ("property structs") from the parsed properties.
@@ -63,18 +76,58 @@ type unpackContext struct {
 // then v.Foo will be set to "abc" and v.Bar will be set to 1
 // (cf. unpack_test.go for further examples)
 //
-// The type of a receiving field has to match the property type, i.e., a bool/int/string field
-// can be set from a property with bool/int/string value, a struct can be set from a map (only the
-// matching fields are set), and an slice can be set from a list.
+// The type of a receiving field has to match the property type, i.e., a bool/int64/string field
+// can be set from a property with bool/int/string value, a float64 field from a float or int
+// value, a struct can be set from a map (only the matching fields are set), and an slice can be
+// set from a list.  Use *int64 or *float64 fields when an unset property must be distinguished
+// from zero.
 // If a field of a runtime value has been already set prior to the UnpackProperties, the new value
 // is appended to it (see somewhat inappropriately named ExtendBasicType).
 // The same property can initialize fields in multiple runtime values. It is an error if any property
-// value was not used to initialize at least one field.
+// value was not used to initialize at least one field, and the error suggests the closest property
+// name if the unrecognized one looks like a typo of it.
+//
+// Fields tagged with `blueprint:"required"` must be set by a property unless they already hold a
+// non-zero value, for example a default set by the module factory.  A string field is missing if
+// it was not assigned at all, an assignment of "" satisfies the requirement.  Required fields are
+// only checked in the top level structs and in maps that appear in the properties; a missing
+// required property is reported without a position if it is not nested in a map.
+//
+// String, string pointer and string list fields tagged with `blueprint:"enum:a,b,c"` only accept
+// the listed values, and `blueprint:"enum_ci:a,b,c"` compares them case-insensitively.  Since the
+// allowed values are separated by commas the enum tag must be the last value in the tag.
+//
+// Fields of the same struct that are tagged with `blueprint:"mutually_exclusive:group"` using the
+// same group may not be set together: it is an error if more than one of them is assigned a value,
+// while leaving all of them unset is allowed.
+//
+// Fields of type map[string]string or map[string]*string, or maps from string to one of those
+// types, are filled from a map literal, with an entry for each property in the map.  The keys
+// of the map literal are not checked against any struct, so they are never reported as
+// unrecognized.  Since Go maps are unordered, code that generates output from a map property
+// should iterate over SortedKeys of the map.
+//
+// A field tagged with `blueprint:"deprecated_name:old_name"` can also be set with the property
+// old_name, to keep Blueprints files that use the name from before the property was renamed
+// working.  Each use of the old name produces a warning, which UnpackPropertiesWithWarnings
+// returns, and setting both names is an error.  The returned map lists the property under its
+// new name.
 func UnpackProperties(properties []*parser.Property, objects ...interface{}) (map[string]*parser.Property, []error) {
+	propertyMap, _, errs := UnpackPropertiesWithWarnings(properties, objects...)
+	return propertyMap, errs
+}
+
+// UnpackPropertiesWithWarnings is like UnpackProperties, but also returns the warnings about
+// uses of deprecated property names.
+func UnpackPropertiesWithWarnings(properties []*parser.Property,
+	objects ...interface{}) (propertyMap map[string]*parser.Property, warnings []*UnpackError, errs []error) {
+
 	var unpackContext unpackContext
 	unpackContext.propertyMap = make(map[string]*packedProperty)
+	unpackContext.missingRequired = make(map[string]bool)
+	unpackContext.objects = objects
 	if !unpackContext.buildPropertyMap("", properties) {
-		return nil, unpackContext.errs
+		return nil, nil, unpackContext.errs
 	}
 
 	for _, obj := range objects {
@@ -85,7 +138,7 @@ func UnpackProperties(properties []*parser.Property, objects ...interface{}) (ma
 		}
 		unpackContext.unpackToStruct("", valueObject.Elem())
 		if len(unpackContext.errs) >= maxUnpackErrors {
-			return nil, unpackContext.errs
+			return nil, unpackContext.warnings, unpackContext.errs
 		}
 	}
 
@@ -101,9 +154,42 @@ func UnpackProperties(properties []*parser.Property, objects ...interface{}) (ma
 		}
 	}
 	if len(unusedNames) == 0 && len(unpackContext.errs) == 0 {
-		return result, nil
+		return result, unpackContext.warnings, nil
+	}
+	return nil, unpackContext.warnings, unpackContext.reportUnusedNames(unusedNames)
+}
+
+// MissingRequiredProperties returns the names of the fields tagged with `blueprint:"required"` in
+// the top level of the property structs that hold a zero value.  It allows a required property to
+// be checked again after the structs have been modified, for example by applying defaults.
+func MissingRequiredProperties(objects ...interface{}) []string {
+	var missing []string
+	var walk func(namePrefix string, structValue reflect.Value)
+	walk = func(namePrefix string, structValue reflect.Value) {
+		structType := structValue.Type()
+		for i := 0; i < structValue.NumField(); i++ {
+			fieldValue := structValue.Field(i)
+			field := structType.Field(i)
+			if field.Name == "BlueprintEmbed" {
+				field.Name = ""
+				field.Anonymous = true
+			}
+			if field.PkgPath != "" {
+				continue
+			}
+			if field.Anonymous && isStruct(fieldValue.Type()) {
+				walk(namePrefix, fieldValue)
+				continue
+			}
+			if HasTag(field, "blueprint", "required") && fieldValue.IsZero() {
+				missing = append(missing, fieldPath(namePrefix, PropertyNameForField(field.Name)))
+			}
+		}
 	}
-	return nil, unpackContext.reportUnusedNames(unusedNames)
+	for _, obj := range objects {
+		walk("", reflect.ValueOf(obj).Elem())
+	}
+	return missing
 }
 
 func (ctx *unpackContext) reportUnusedNames(unusedNames []string) []error {
@@ -118,14 +204,36 @@ func (ctx *unpackContext) reportUnusedNames(unusedNames []string) []error {
 				continue
 			}
 		}
-		ctx.errs = append(ctx.errs, &UnpackError{
-			fmt.Errorf("unrecognized property %q", name),
-			ctx.propertyMap[name].property.ColonPos})
+		err := fmt.Errorf("%w %q", ErrUnrecognizedProperty, name)
+		if suggestion := ctx.similarPropertyName(name); suggestion != "" {
+			err = fmt.Errorf("%w (did you mean %q?)", err, suggestion)
+		}
+		ctx.errs = append(ctx.errs, &UnpackError{err, ctx.propertyMap[name].property.ColonPos})
 		lastReported = name
 	}
 	return ctx.errs
 }
 
+// similarPropertyName returns the property of the property structs that is closest to the
+// unrecognized property name, or "" if none is close enough to be a likely typo.  Up to one edit
+// per three characters of the last part of the name is allowed, and at most two.
+func (ctx *unpackContext) similarPropertyName(name string) string {
+	maxDistance := min(len(name[strings.LastIndex(name, ".")+1:])/3, 2)
+	if maxDistance == 0 {
+		return ""
+	}
+
+	best, bestDistance := "", maxDistance+1
+	for _, obj := range ctx.objects {
+		for _, doc := range appendPropertyDocs(nil, "", reflect.ValueOf(obj)) {
+			if d := EditDistance(name, doc.Name); d < bestDistance || (d == bestDistance && doc.Name < best) {
+				best, bestDistance = doc.Name, d
+			}
+		}
+	}
+	return best
+}
+
 // When property a.b.c is not used, (also there is no a.* or a.b.* used)
 // "a", "a.b" and "a.b.c" are all in unusedNames.
 // removeUnnecessaryUnusedNames only keeps the last "a.b.c" as the real unused
@@ -168,7 +276,8 @@ func (ctx *unpackContext) buildPropertyMap(prefix string, properties []*parser.P
 			if len(propValue.Values) == 0 {
 				continue
 			}
-			if t := propValue.Values[0].Type(); t == parser.StringType || t == parser.Int64Type || t == parser.BoolType {
+			if t := propValue.Values[0].Type(); t == parser.StringType || t == parser.Int64Type ||
+				t == parser.Float64Type || t == parser.BoolType {
 				continue
 			}
 
@@ -205,6 +314,23 @@ func (ctx *unpackContext) addError(e error) bool {
 func (ctx *unpackContext) unpackToStruct(namePrefix string, structValue reflect.Value) {
 	structType := structValue.Type()
 
+	// Properties that were set from fields tagged with `blueprint:"mutually_exclusive:group"`,
+	// keyed by group.
+	var exclusiveGroups []string
+	exclusiveSet := make(map[string][]exclusiveProperty)
+	defer func() {
+		for _, group := range exclusiveGroups {
+			if set := exclusiveSet[group]; len(set) > 1 {
+				// Report the conflict at the first assignment that conflicts with an earlier one.
+				sort.SliceStable(set, func(i, j int) bool { return set[i].pos.Offset < set[j].pos.Offset })
+				ctx.addError(&UnpackError{
+					fmt.Errorf("properties %s are mutually exclusive", quotedPropertyList(set)),
+					set[1].pos,
+				})
+			}
+		}
+	}()
+
 	for i := 0; i < structValue.NumField(); i++ {
 		fieldValue := structValue.Field(i)
 		field := structType.Field(i)
@@ -231,6 +357,13 @@ func (ctx *unpackContext) unpackToStruct(namePrefix string, structValue reflect.
 
 		// Get the property value if it was specified.
 		packedProperty, propertyIsSet := ctx.propertyMap[propertyName]
+		if oldName, ok := tagValueWithPrefix(field, "blueprint", "deprecated_name:"); ok {
+			if property, renamed := ctx.renameDeprecatedProperty(fieldPath(namePrefix, oldName), propertyName); renamed {
+				packedProperty, propertyIsSet = property, true
+			} else if len(ctx.errs) >= maxUnpackErrors {
+				return
+			}
+		}
 
 		origFieldValue := fieldValue
 
@@ -239,7 +372,7 @@ func (ctx *unpackContext) unpackToStruct(namePrefix string, structValue reflect.
 		// TODO(ccross): we don't validate types inside nil struct pointers
 		// Move type validation to a function that runs on each factory once
 		switch kind := fieldValue.Kind(); kind {
-		case reflect.Bool, reflect.String, reflect.Struct, reflect.Slice:
+		case reflect.Bool, reflect.String, reflect.Int64, reflect.Float64, reflect.Struct, reflect.Slice:
 			// Do nothing
 		case reflect.Interface:
 			if fieldValue.IsNil() {
@@ -262,12 +395,18 @@ func (ctx *unpackContext) unpackToStruct(namePrefix string, structValue reflect.
 					origFieldValue.Set(fieldValue)
 				}
 				fieldValue = fieldValue.Elem()
-			case reflect.Bool, reflect.Int64, reflect.String:
+			case reflect.Bool, reflect.Int64, reflect.Float64, reflect.String:
 				// Nothing
 			default:
 				panic(fmt.Errorf("field %s contains a pointer to %s", propertyName, ptrKind))
 			}
 
+		case reflect.Map:
+			if !isUnpackableMap(fieldValue.Type()) {
+				panic(fmt.Errorf("field %s is a %s, only maps from string to string, *string or "+
+					"another such map are supported", propertyName, fieldValue.Type()))
+			}
+
 		case reflect.Int, reflect.Uint:
 			if !HasTag(field, "blueprint", "mutated") {
 				panic(fmt.Errorf(`int field %s must be tagged blueprint:"mutated"`, propertyName))
@@ -284,12 +423,36 @@ func (ctx *unpackContext) unpackToStruct(namePrefix string, structValue reflect.
 
 		if !propertyIsSet {
 			// This property wasn't specified.
+			if HasTag(field, "blueprint", "required") && origFieldValue.IsZero() &&
+				!ctx.missingRequired[propertyName] {
+
+				ctx.missingRequired[propertyName] = true
+				// Report missing nested properties at the enclosing map, and top level properties
+				// without a position so that the caller can use the position of the module.
+				var pos scanner.Position
+				if parent, ok := ctx.propertyMap[namePrefix]; ok {
+					pos = parent.property.ColonPos
+				}
+				if !ctx.addError(&UnpackError{
+					fmt.Errorf("%w %q", ErrMissingRequiredProperty, propertyName),
+					pos,
+				}) {
+					return
+				}
+			}
 			continue
 		}
 
 		packedProperty.used = true
 		property := packedProperty.property
 
+		if group, ok := tagValueWithPrefix(field, "blueprint", "mutually_exclusive:"); ok {
+			if _, exists := exclusiveSet[group]; !exists {
+				exclusiveGroups = append(exclusiveGroups, group)
+			}
+			exclusiveSet[group] = append(exclusiveSet[group], exclusiveProperty{propertyName, property.ColonPos})
+		}
+
 		if HasTag(field, "blueprint", "mutated") {
 			if !ctx.addError(
 				&UnpackError{
@@ -325,9 +488,19 @@ func (ctx *unpackContext) unpackToStruct(namePrefix string, structValue reflect.
 			if len(ctx.errs) >= maxUnpackErrors {
 				return
 			}
+		} else if fieldValue.Kind() == reflect.Map {
+			if unpackedValue, ok := ctx.unpackToMap(propertyName, property, fieldValue.Type()); ok {
+				ExtendBasicType(fieldValue, unpackedValue, Append)
+			}
+			if len(ctx.errs) >= maxUnpackErrors {
+				return
+			}
 		} else if isSlice(fieldValue.Type()) {
 			if unpackedValue, ok := ctx.unpackToSlice(propertyName, property, fieldValue.Type()); ok {
 				ExtendBasicType(fieldValue, unpackedValue, Append)
+				if !ctx.checkEnum(field, propertyName, property) {
+					return
+				}
 			}
 			if len(ctx.errs) >= maxUnpackErrors {
 				return
@@ -338,10 +511,109 @@ func (ctx *unpackContext) unpackToStruct(namePrefix string, structValue reflect.
 				return
 			}
 			ExtendBasicType(fieldValue, unpackedValue, Append)
+			if err == nil && !ctx.checkEnum(field, propertyName, property) {
+				return
+			}
 		}
 	}
 }
 
+// renameDeprecatedProperty moves the property set with the deprecated name oldName, and any
+// properties nested in it, to newName and reports a warning.  It reports an error if both names
+// are set.  It returns the renamed property, or false if it wasn't renamed.
+func (ctx *unpackContext) renameDeprecatedProperty(oldName, newName string) (*packedProperty, bool) {
+	oldProperty, ok := ctx.propertyMap[oldName]
+	if !ok {
+		return nil, false
+	}
+	if _, ok := ctx.propertyMap[newName]; ok {
+		oldProperty.used = true
+		ctx.addError(&UnpackError{
+			fmt.Errorf("deprecated property %q can't be set together with %q", oldName, newName),
+			oldProperty.property.ColonPos,
+		})
+		return nil, false
+	}
+
+	var nested []string
+	for name := range ctx.propertyMap {
+		if rest, ok := strings.CutPrefix(name, oldName); ok && (rest == "" || rest[0] == '.' || rest[0] == '[') {
+			nested = append(nested, rest)
+		}
+	}
+	for _, rest := range nested {
+		ctx.propertyMap[newName+rest] = ctx.propertyMap[oldName+rest]
+		delete(ctx.propertyMap, oldName+rest)
+	}
+	ctx.warnings = append(ctx.warnings, &UnpackError{
+		fmt.Errorf("property %q is deprecated, use %q instead", oldName, newName),
+		oldProperty.property.ColonPos,
+	})
+	return oldProperty, true
+}
+
+// checkEnum reports an error for each string in the value of property that is not one of the
+// values allowed by a `blueprint:"enum:a,b,c"` or `blueprint:"enum_ci:a,b,c"` tag on field.  It
+// returns false if the maximum number of errors has been reached.
+func (ctx *unpackContext) checkEnum(field reflect.StructField, propertyName string, property *parser.Property) bool {
+	allowed, caseInsensitive, ok := enumTagValues(field)
+	if !ok {
+		return true
+	}
+
+	var strs []*parser.String
+	switch v := property.Value.(type) {
+	case *parser.String:
+		strs = append(strs, v)
+	case *parser.List:
+		for _, elem := range v.Values {
+			if s, ok := elem.(*parser.String); ok {
+				strs = append(strs, s)
+			}
+		}
+	}
+
+	for _, s := range strs {
+		valid := slices.ContainsFunc(allowed, func(a string) bool {
+			if caseInsensitive {
+				return strings.EqualFold(a, s.Value)
+			}
+			return a == s.Value
+		})
+		if !valid {
+			quoted := make([]string, len(allowed))
+			for i, a := range allowed {
+				quoted[i] = strconv.Quote(a)
+			}
+			if !ctx.addError(&UnpackError{
+				fmt.Errorf("invalid value %q for property %q, must be one of %s",
+					s.Value, propertyName, strings.Join(quoted, ", ")),
+				s.Pos(),
+			}) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// exclusiveProperty is a property set from a field tagged with
+// `blueprint:"mutually_exclusive:group"`.
+type exclusiveProperty struct {
+	name string
+	pos  scanner.Position
+}
+
+// quotedPropertyList formats the names of a list of properties as `"a", "b" and "c"`.
+func quotedPropertyList(properties []exclusiveProperty) string {
+	quoted := make([]string, len(properties))
+	for i, p := range properties {
+		quoted[i] = strconv.Quote(p.name)
+	}
+	return strings.Join(quoted[:len(quoted)-1], ", ") + " and " + quoted[len(quoted)-1]
+}
+
 // Converts the given property to a pointer to a configurable struct
 func (ctx *unpackContext) unpackToConfigurable(propertyName string, property *parser.Property, configurableType, configuredType reflect.Type) (reflect.Value, bool) {
 	switch v := property.Value.(type) {
@@ -561,7 +833,7 @@ func (ctx *unpackContext) unpackToSliceInner(
 	// The function to construct an item value depends on the type of list elements.
 	getItemFunc := func(property *parser.Property, t reflect.Type) (reflect.Value, bool) {
 		switch property.Value.(type) {
-		case *parser.Bool, *parser.String, *parser.Int64:
+		case *parser.Bool, *parser.String, *parser.Int64, *parser.Float64:
 			value, err := propertyToValue(t, property)
 			if err != nil {
 				ctx.addError(err)
@@ -604,6 +876,62 @@ func (ctx *unpackContext) unpackToSliceInner(
 	return value, true
 }
 
+// isUnpackableMap returns true if t is a map type that can be filled from a map literal by
+// unpackToMap.
+func isUnpackableMap(t reflect.Type) bool {
+	if t.Kind() != reflect.Map || t.Key().Kind() != reflect.String {
+		return false
+	}
+	return isStringOrStringPtr(t.Elem()) || isUnpackableMap(t.Elem())
+}
+
+// unpackToMap creates a value of a given map type from the property, which should be a map
+// literal.  It returns false if the property is not a map literal.
+func (ctx *unpackContext) unpackToMap(
+	mapName string, property *parser.Property, mapType reflect.Type) (reflect.Value, bool) {
+	propValueAsMap, ok := property.Value.(*parser.Map)
+	if !ok {
+		if err := selectOnNonConfigurablePropertyError(property); err != nil {
+			ctx.addError(err)
+		} else {
+			ctx.addError(&UnpackError{
+				fmt.Errorf("can't assign %s value to map property %q",
+					property.Value.Type(), property.Name),
+				property.Value.Pos(),
+			})
+		}
+		return reflect.Value{}, false
+	}
+
+	value := reflect.MakeMapWithSize(mapType, len(propValueAsMap.Properties))
+	elemType := mapType.Elem()
+	for _, entry := range propValueAsMap.Properties {
+		entryName := fieldPath(mapName, entry.Name)
+		if packedProperty, ok := ctx.propertyMap[entryName]; ok {
+			packedProperty.used = true
+		}
+		entryProperty := &parser.Property{
+			Name:     entryName,
+			NamePos:  entry.NamePos,
+			ColonPos: entry.ColonPos,
+			Value:    entry.Value,
+		}
+		if elemType.Kind() == reflect.Map {
+			if entryValue, ok := ctx.unpackToMap(entryName, entryProperty, elemType); ok {
+				value.SetMapIndex(reflect.ValueOf(entry.Name), entryValue)
+			}
+			continue
+		}
+		entryValue, err := propertyToValue(elemType, entryProperty)
+		if err != nil {
+			ctx.addError(err)
+			continue
+		}
+		value.SetMapIndex(reflect.ValueOf(entry.Name), entryValue)
+	}
+	return value, true
+}
+
 // propertyToValue creates a value of a given value type from the property.
 func propertyToValue(typ reflect.Type, property *parser.Property) (reflect.Value, error) {
 	var value reflect.Value
@@ -642,6 +970,21 @@ func propertyToValue(typ reflect.Type, property *parser.Property) (reflect.Value
 		}
 		value = reflect.ValueOf(b.Value)
 
+	case reflect.Float64:
+		// Integer literals are accepted as well, so that whole numbers don't need a decimal point.
+		switch f := property.Value.(type) {
+		case *parser.Float64:
+			value = reflect.ValueOf(f.Value)
+		case *parser.Int64:
+			value = reflect.ValueOf(float64(f.Value))
+		default:
+			return value, &UnpackError{
+				fmt.Errorf("can't assign %s value to float64 property %q",
+					property.Value.Type(), property.Name),
+				property.Value.Pos(),
+			}
+		}
+
 	case reflect.String:
 		s, ok := property.Value.(*parser.String)
 		if !ok {
@@ -1086,6 +1086,111 @@ var validUnpackTestCases = []struct {
 			},
 		},
 	},
+	{
+		name: "string map",
+		input: `
+			m {
+				labels: {
+					b: "2",
+					a: "1",
+				},
+				optional: {
+					x: "",
+				},
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Labels   map[string]string
+				Optional map[string]*string
+				Unset    map[string]string
+			}{
+				Labels:   map[string]string{"a": "1", "b": "2"},
+				Optional: map[string]*string{"x": StringPtr("")},
+			},
+		},
+	},
+
+	{
+		name: "nested string map",
+		input: `
+			m {
+				nested: {
+					first: {
+						a: "1",
+					},
+					second: {},
+				},
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Nested map[string]map[string]string
+			}{
+				Nested: map[string]map[string]string{
+					"first":  {"a": "1"},
+					"second": {},
+				},
+			},
+		},
+	},
+
+	{
+		name: "string map in multiple structs",
+		input: `
+			m {
+				labels: {
+					a: "1",
+				},
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Labels map[string]string
+			}{
+				Labels: map[string]string{"a": "1"},
+			},
+			&struct {
+				Labels map[string]*string
+			}{
+				Labels: map[string]*string{"a": StringPtr("1")},
+			},
+		},
+	},
+	{
+		name: "numbers",
+		input: `
+			m {
+				max: 9223372036854775807,
+				min: -9223372036854775808,
+				zero: 0,
+				scale: -1.5,
+				whole: 2,
+				ratio: 0.0,
+				list: [1.5, -2.5],
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Max   int64
+				Min   int64
+				Zero  *int64
+				Unset *int64
+				Scale float64
+				Whole float64
+				Ratio *float64
+				List  []float64
+			}{
+				Max:   9223372036854775807,
+				Min:   -9223372036854775808,
+				Zero:  Int64Ptr(0),
+				Scale: -1.5,
+				Whole: 2,
+				Ratio: Float64Ptr(0),
+				List:  []float64{1.5, -2.5},
+			},
+		},
+	},
 }
 
 func TestUnpackProperties(t *testing.T) {
@@ -1186,6 +1291,45 @@ func TestUnpackErrors(t *testing.T) {
 			},
 			errors: []string{`<input>:4:14: unrecognized property "nested.missing"`},
 		},
+		{
+			name: "typo",
+			input: `
+				m {
+					src: ["a.c"],
+					nested: {
+						enable: true,
+					},
+				}
+			`,
+			output: []interface{}{
+				&struct {
+					Srcs   []string
+					Stl    *string
+					Nested struct {
+						Enabled *bool
+					}
+				}{},
+			},
+			errors: []string{
+				`<input>:5:13: unrecognized property "nested.enable" (did you mean "nested.enabled"?)`,
+				`<input>:3:9: unrecognized property "src" (did you mean "srcs"?)`,
+			},
+		},
+		{
+			name: "no similar property",
+			input: `
+				m {
+					compile_multilib: "both",
+				}
+			`,
+			output: []interface{}{
+				&struct {
+					Srcs []string
+					Stl  *string
+				}{},
+			},
+			errors: []string{`<input>:3:22: unrecognized property "compile_multilib"`},
+		},
 		{
 			name: "mutated",
 			input: `
@@ -1274,6 +1418,25 @@ func TestUnpackErrors(t *testing.T) {
 				`<input>:3:11: can't assign string value to int64 property "int"`,
 			},
 		},
+		{
+			name: "wrong type for numbers",
+			input: `
+				m {
+					int: 1.5,
+					float: true,
+				}
+			`,
+			output: []interface{}{
+				&struct {
+					Int   int64
+					Float *float64
+				}{},
+			},
+			errors: []string{
+				`<input>:3:11: can't assign float64 value to int64 property "int"`,
+				`<input>:4:13: can't assign bool value to float64 property "float"`,
+			},
+		},
 		{
 			name: "wrong type for map",
 			input: `
@@ -1326,6 +1489,45 @@ func TestUnpackErrors(t *testing.T) {
 				`<input>:3:16: can't assign string value to list property "map_list"`,
 			},
 		},
+		{
+			name: "wrong type for string map",
+			input: `
+				m {
+					labels: ["a"],
+				}
+			`,
+			output: []interface{}{
+				&struct {
+					Labels map[string]string
+				}{},
+			},
+			errors: []string{
+				`<input>:3:14: can't assign list value to map property "labels"`,
+			},
+		},
+		{
+			name: "wrong value type for string map",
+			input: `
+				m {
+					labels: {
+						a: true,
+					},
+					nested: {
+						a: "1",
+					},
+				}
+			`,
+			output: []interface{}{
+				&struct {
+					Labels map[string]string
+					Nested map[string]map[string]string
+				}{},
+			},
+			errors: []string{
+				`<input>:4:10: can't assign bool value to string property "labels.a"`,
+				`<input>:7:10: can't assign string value to map property "nested.a"`,
+			},
+		},
 		{
 			name: "non-existent property",
 			input: `
@@ -1351,8 +1553,8 @@ func TestUnpackErrors(t *testing.T) {
 				}{},
 			},
 			errors: []string{
-				`<input>:5:16: unrecognized property "foo.foo_prop2"`,
-				`<input>:6:16: unrecognized property "foo.foo_prop3"`,
+				`<input>:5:16: unrecognized property "foo.foo_prop2" (did you mean "foo.foo_prop1"?)`,
+				`<input>:6:16: unrecognized property "foo.foo_prop3" (did you mean "foo.foo_prop1"?)`,
 				`<input>:9:15: unrecognized property "bar.bar_prop"`,
 				`<input>:11:9: unrecognized property "baz"`,
 			},
@@ -1646,3 +1848,390 @@ func TestRemoveUnnecessaryUnusedNames(t *testing.T) {
 		})
 	}
 }
+
+func TestUnpackMutuallyExclusive(t *testing.T) {
+	type props struct {
+		Srcs           []string `blueprint:"mutually_exclusive:srcs"`
+		Generated_srcs []string `blueprint:"mutually_exclusive:srcs"`
+		Host           *bool    `blueprint:"mutually_exclusive:target"`
+		Device         *bool    `blueprint:"mutually_exclusive:target"`
+		Both           *bool    `blueprint:"mutually_exclusive:target"`
+		Name           string
+	}
+
+	testCases := []struct {
+		name   string
+		input  string
+		errors []string
+	}{
+		{
+			name: "unset",
+			input: `
+				m {
+					name: "foo",
+				}
+			`,
+		},
+		{
+			name: "one set",
+			input: `
+				m {
+					srcs: ["a"],
+					device: true,
+				}
+			`,
+		},
+		{
+			name: "two fields",
+			input: `
+				m {
+					srcs: ["a"],
+					generated_srcs: ["b"],
+				}
+			`,
+			errors: []string{
+				`<input>:4:20: properties "srcs" and "generated_srcs" are mutually exclusive`,
+			},
+		},
+		{
+			name: "set to zero value",
+			input: `
+				m {
+					srcs: [],
+					generated_srcs: ["b"],
+				}
+			`,
+			errors: []string{
+				`<input>:4:20: properties "srcs" and "generated_srcs" are mutually exclusive`,
+			},
+		},
+		{
+			name: "three fields",
+			input: `
+				m {
+					host: true,
+					device: false,
+					both: true,
+				}
+			`,
+			errors: []string{
+				`<input>:4:12: properties "host", "device" and "both" are mutually exclusive`,
+			},
+		},
+		{
+			name: "multiple groups",
+			input: `
+				m {
+					generated_srcs: ["b"],
+					both: true,
+					srcs: ["a"],
+					host: true,
+				}
+			`,
+			errors: []string{
+				`<input>:5:10: properties "generated_srcs" and "srcs" are mutually exclusive`,
+				`<input>:6:10: properties "both" and "host" are mutually exclusive`,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := bytes.NewBufferString(testCase.input)
+			file, errs := parser.ParseAndEval("", r, parser.NewScope(nil))
+			if len(errs) != 0 {
+				t.Fatalf("unexpected parse errors: %v", errs)
+			}
+
+			module := file.Defs[0].(*parser.Module)
+			_, errs = UnpackProperties(module.Properties, &props{})
+
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, testCase.errors) {
+				t.Errorf("expected errors %q, got %q", testCase.errors, got)
+			}
+		})
+	}
+}
+
+func TestUnpackRequired(t *testing.T) {
+	type props struct {
+		Name    string   `blueprint:"required"`
+		Enabled *bool    `blueprint:"required"`
+		Srcs    []string `blueprint:"required"`
+		Nested  struct {
+			Value *string `blueprint:"required"`
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		input    string
+		defaults func(*props)
+		errors   []string
+	}{
+		{
+			name: "all set",
+			input: `
+				m {
+					name: "",
+					enabled: false,
+					srcs: [],
+					nested: {
+						value: "a",
+					},
+				}
+			`,
+		},
+		{
+			name: "missing",
+			input: `
+				m {
+					nested: {},
+				}
+			`,
+			errors: []string{
+				`<input>: missing required property "name"`,
+				`<input>: missing required property "enabled"`,
+				`<input>: missing required property "srcs"`,
+				`<input>:3:12: missing required property "nested.value"`,
+			},
+		},
+		{
+			name: "unset map",
+			input: `
+				m {
+					name: "foo",
+					enabled: true,
+					srcs: ["a"],
+				}
+			`,
+		},
+		{
+			name: "defaults",
+			input: `
+				m {
+					name: "foo",
+				}
+			`,
+			defaults: func(p *props) {
+				p.Enabled = BoolPtr(true)
+				p.Srcs = []string{"a"}
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := bytes.NewBufferString(testCase.input)
+			file, errs := parser.ParseAndEval("", r, parser.NewScope(nil))
+			if len(errs) != 0 {
+				t.Fatalf("unexpected parse errors: %v", errs)
+			}
+
+			p := &props{}
+			if testCase.defaults != nil {
+				testCase.defaults(p)
+			}
+
+			module := file.Defs[0].(*parser.Module)
+			_, errs = UnpackProperties(module.Properties, p)
+
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, testCase.errors) {
+				t.Errorf("expected errors %q, got %q", testCase.errors, got)
+			}
+		})
+	}
+}
+
+func TestUnpackEnum(t *testing.T) {
+	type props struct {
+		Build_type string   `blueprint:"enum:debug,release,profile"`
+		Optimize   *string  `blueprint:"enum: none , size ,speed"`
+		Sanitizers []string `blueprint:"enum:address,thread,undefined"`
+		Arch       *string  `blueprint:"required,enum_ci:arm64,x86_64"`
+	}
+
+	testCases := []struct {
+		name   string
+		input  string
+		errors []string
+	}{
+		{
+			name: "valid",
+			input: `
+				m {
+					build_type: "release",
+					optimize: "size",
+					sanitizers: ["address", "undefined"],
+					arch: "ARM64",
+				}
+			`,
+		},
+		{
+			name: "invalid",
+			input: `
+				m {
+					build_type: "Release",
+					optimize: " size",
+					arch: "riscv64",
+				}
+			`,
+			errors: []string{
+				`<input>:3:18: invalid value "Release" for property "build_type", must be one of "debug", "release", "profile"`,
+				`<input>:4:16: invalid value " size" for property "optimize", must be one of "none", "size", "speed"`,
+				`<input>:5:12: invalid value "riscv64" for property "arch", must be one of "arm64", "x86_64"`,
+			},
+		},
+		{
+			name: "list elements",
+			input: `
+				m {
+					sanitizers: ["address", "memory", "thread", "leak"],
+					arch: "x86_64",
+				}
+			`,
+			errors: []string{
+				`<input>:3:30: invalid value "memory" for property "sanitizers", must be one of "address", "thread", "undefined"`,
+				`<input>:3:50: invalid value "leak" for property "sanitizers", must be one of "address", "thread", "undefined"`,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := bytes.NewBufferString(testCase.input)
+			file, errs := parser.ParseAndEval("", r, parser.NewScope(nil))
+			if len(errs) != 0 {
+				t.Fatalf("unexpected parse errors: %v", errs)
+			}
+
+			module := file.Defs[0].(*parser.Module)
+			_, errs = UnpackProperties(module.Properties, &props{})
+
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, testCase.errors) {
+				t.Errorf("expected errors %q, got %q", testCase.errors, got)
+			}
+		})
+	}
+}
+
+func TestUnpackDeprecatedName(t *testing.T) {
+	type props struct {
+		Srcs    []string `blueprint:"deprecated_name:files"`
+		Options struct {
+			Verbose *bool
+		} `blueprint:"deprecated_name:flags"`
+	}
+
+	testCases := []struct {
+		name     string
+		input    string
+		output   props
+		warnings []string
+		errors   []string
+	}{
+		{
+			name: "old name",
+			input: `
+				m {
+					files: ["a"],
+					flags: {
+						verbose: true,
+					},
+				}
+			`,
+			output: props{Srcs: []string{"a"}, Options: struct{ Verbose *bool }{BoolPtr(true)}},
+			warnings: []string{
+				`<input>:3:11: property "files" is deprecated, use "srcs" instead`,
+				`<input>:4:11: property "flags" is deprecated, use "options" instead`,
+			},
+		},
+		{
+			name: "new name",
+			input: `
+				m {
+					srcs: ["a"],
+				}
+			`,
+			output: props{Srcs: []string{"a"}},
+		},
+		{
+			name: "both names",
+			input: `
+				m {
+					srcs: ["a"],
+					files: ["b"],
+				}
+			`,
+			errors: []string{
+				`<input>:4:11: deprecated property "files" can't be set together with "srcs"`,
+			},
+		},
+		{
+			name: "unknown property under old name",
+			input: `
+				m {
+					flags: {
+						quiet: true,
+					},
+				}
+			`,
+			warnings: []string{
+				`<input>:3:11: property "flags" is deprecated, use "options" instead`,
+			},
+			errors: []string{
+				`<input>:4:12: unrecognized property "options.quiet"`,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := bytes.NewBufferString(testCase.input)
+			file, errs := parser.ParseAndEval("", r, parser.NewScope(nil))
+			if len(errs) != 0 {
+				t.Fatalf("unexpected parse errors: %v", errs)
+			}
+
+			module := file.Defs[0].(*parser.Module)
+			var output props
+			propertyMap, warnings, errs := UnpackPropertiesWithWarnings(module.Properties, &output)
+
+			var gotWarnings, gotErrors []string
+			for _, warning := range warnings {
+				gotWarnings = append(gotWarnings, warning.Error())
+			}
+			for _, err := range errs {
+				gotErrors = append(gotErrors, err.Error())
+			}
+			if !reflect.DeepEqual(gotWarnings, testCase.warnings) {
+				t.Errorf("expected warnings %q, got %q", testCase.warnings, gotWarnings)
+			}
+			if !reflect.DeepEqual(gotErrors, testCase.errors) {
+				t.Errorf("expected errors %q, got %q", testCase.errors, gotErrors)
+			}
+			if len(errs) > 0 {
+				return
+			}
+
+			if !reflect.DeepEqual(output, testCase.output) {
+				t.Errorf("expected output %+v, got %+v", testCase.output, output)
+			}
+			if len(output.Srcs) > 0 && propertyMap["srcs"] == nil {
+				t.Errorf("expected srcs in the property map, got %v", propertyMap)
+			}
+		})
+	}
+}
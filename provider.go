@@ -195,6 +195,82 @@ func (c *Context) provider(m *moduleInfo, provider *providerKey) (any, bool) {
 	return nil, false
 }
 
+// InvalidateProviders discards the values of the providers set in GenerateBuildActions by the
+// changed modules and by every module that read a provider of a changed module with
+// OtherModuleProvider, directly or through other invalidated modules, along with their build
+// actions.  The next call to PrepareBuildActions calls GenerateBuildActions only for the
+// invalidated modules and keeps the build actions and providers of the other modules; the
+// singletons always run again.  The global variables, rules and pools referenced only by the
+// discarded build actions stop being live.  It is meant for long running processes that change
+// the state of some modules after PrepareBuildActions.
+//
+// Reads are recorded in mutators as well as in GenerateBuildActions, but the values of providers
+// associated with a mutator are kept, since mutators don't run again.  It returns
+// ErrDependenciesNotReady if called before ResolveDependencies has completed successfully.
+func (c *Context) InvalidateProviders(changed []Module) error {
+	if !c.dependenciesReady {
+		return ErrDependenciesNotReady
+	}
+
+	readers := make(map[*moduleInfo][]*moduleInfo)
+	for _, module := range c.modulesSorted {
+		for read := range module.providerReads {
+			readers[read] = append(readers[read], module)
+		}
+	}
+
+	invalid := make(map[*moduleInfo]bool)
+	var queue []*moduleInfo
+	for _, logicModule := range changed {
+		if module := c.moduleInfo[logicModule]; module != nil && !invalid[module] {
+			invalid[module] = true
+			queue = append(queue, module)
+		}
+	}
+	for len(queue) > 0 {
+		module := queue[0]
+		queue = queue[1:]
+		for _, reader := range readers[module] {
+			if !invalid[reader] {
+				invalid[reader] = true
+				queue = append(queue, reader)
+			}
+		}
+	}
+
+	// Release the globals referenced only by the discarded build actions.
+	var errs []error
+	for module := range invalid {
+		errs = append(errs, c.releaseBuildActions(&module.actionDefs)...)
+		resetModuleBuildActions(module)
+	}
+	for _, info := range c.singletonInfo {
+		errs = append(errs, c.releaseBuildActions(&info.actionDefs)...)
+	}
+	if c.outDir != nil && c.buildActionsReady {
+		c.liveGlobals.removeNinjaStringDeps(c.outDir)
+	}
+	c.resetSingletonBuildActions()
+	c.buildActionsReady = false
+	// The next PrepareBuildActions returns the dependencies of the build actions of all the modules
+	// again, including those that weren't invalidated.
+	c.generatorDeps = c.generatorDeps[:c.resolveGeneratorDeps:c.resolveGeneratorDeps]
+
+	return proptools.MergeErrors(errs)
+}
+
+// releaseBuildActions removes the references of the build definitions in actionDefs from the
+// live globals, before the build definitions are discarded.
+func (c *Context) releaseBuildActions(actionDefs *localBuildActions) []error {
+	var errs []error
+	for _, def := range actionDefs.buildDefs {
+		if err := c.liveGlobals.RemoveBuildDefDeps(def); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
 func (c *Context) mutatorFinishedForModule(mutator *mutatorInfo, m *moduleInfo) bool {
 	if c.finishedMutators[mutator] {
 		// mutator pass finished for all modules
@@ -250,7 +326,7 @@ func OtherModuleProvider[K any](ctx OtherModuleProviderContext, module Module, p
 		var k K
 		return k, false
 	}
-	return value.(K), ok
+	return typedProviderValue(provider, value), ok
 }
 
 // SingletonModuleProviderContext is a helper interface that is a subset of Context and SingletonContext for use in
@@ -266,14 +342,15 @@ var _ SingletonModuleProviderContext = SingletonContext(nil)
 // returned and the boolean is true.  If it has not been set the zero value of the provider's type  is returned
 // and the boolean is false.  The value returned may be a deep copy of the value originally passed to SetProvider.
 //
-// SingletonModuleProviderContext is a helper interface that accepts Context or SingletonContext.
+// SingletonModuleProviderContext is a helper interface that accepts Context or SingletonContext, so
+// SingletonModuleProvider can also be used to read typed provider values from a Context after the build.
 func SingletonModuleProvider[K any](ctx SingletonModuleProviderContext, module Module, provider ProviderKey[K]) (K, bool) {
 	value, ok := ctx.ModuleProvider(module, provider)
 	if !ok {
 		var k K
 		return k, false
 	}
-	return value.(K), ok
+	return typedProviderValue(provider, value), ok
 }
 
 // ModuleProviderContext is a helper interface that is a subset of ModuleContext, BottomUpMutatorContext, or
@@ -299,7 +376,18 @@ func ModuleProvider[K any](ctx ModuleProviderContext, provider ProviderKey[K]) (
 		var k K
 		return k, false
 	}
-	return value.(K), ok
+	return typedProviderValue(provider, value), ok
+}
+
+// typedProviderValue converts a value read from a provider to the provider's type.  It panics with a
+// message naming the provider if the value has a different type, which can only happen if the value
+// was set through an untyped SetProvider method.
+func typedProviderValue[K any](provider ProviderKey[K], value any) K {
+	k, ok := value.(K)
+	if !ok {
+		panic(fmt.Sprintf("Value of provider %s has unexpected type %T", provider.typ, value))
+	}
+	return k
 }
 
 // SetProviderContext is a helper interface that is a subset of ModuleContext, BottomUpMutatorContext, or
@@ -17,6 +17,8 @@ package blueprint
 import (
 	"fmt"
 	"reflect"
+	"regexp"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -423,3 +425,255 @@ func TestInvalidProvidersUsage(t *testing.T) {
 		})
 	}
 }
+
+type contextProviderTestStruct struct {
+	Name string
+	Deps []string
+}
+
+var contextProviderTestStructProvider = NewProvider[contextProviderTestStruct]()
+var contextProviderTestSliceProvider = NewProvider[[]string]()
+var contextProviderTestPointerProvider = NewProvider[*contextProviderTestStruct]()
+var contextProviderTestMismatchProvider = NewProvider[string]()
+
+type contextProviderTestModule struct {
+	SimpleName
+}
+
+func (m *contextProviderTestModule) GenerateBuildActions(ctx ModuleContext) {
+	SetProvider(ctx, contextProviderTestStructProvider, contextProviderTestStruct{
+		Name: ctx.ModuleName(),
+		Deps: []string{"x"},
+	})
+	SetProvider(ctx, contextProviderTestSliceProvider, []string{ctx.ModuleName(), "y"})
+	SetProvider(ctx, contextProviderTestPointerProvider, &contextProviderTestStruct{Name: ctx.ModuleName()})
+	// Use the untyped setter to store a value that doesn't match the provider's type.
+	ctx.SetProvider(contextProviderTestMismatchProvider, 1)
+}
+
+func TestContextTypedProviders(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("context_provider_module", func() (Module, []interface{}) {
+		m := &contextProviderTestModule{}
+		return m, []interface{}{&m.SimpleName.Properties}
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			context_provider_module {
+				name: "A",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	module := ctx.moduleGroupFromName("A", nil).moduleByVariantName("").logicModule
+
+	s, ok := SingletonModuleProvider(ctx, module, contextProviderTestStructProvider)
+	if g, w := s, (contextProviderTestStruct{Name: "A", Deps: []string{"x"}}); !ok || !reflect.DeepEqual(g, w) {
+		t.Errorf("expected struct provider %#v, got %#v (%v)", w, g, ok)
+	}
+
+	slice, ok := SingletonModuleProvider(ctx, module, contextProviderTestSliceProvider)
+	if g, w := slice, []string{"A", "y"}; !ok || !reflect.DeepEqual(g, w) {
+		t.Errorf("expected slice provider %q, got %q (%v)", w, g, ok)
+	}
+
+	ptr, ok := SingletonModuleProvider(ctx, module, contextProviderTestPointerProvider)
+	if !ok || ptr == nil || ptr.Name != "A" {
+		t.Errorf("expected pointer provider with name A, got %#v (%v)", ptr, ok)
+	}
+
+	unset, ok := SingletonModuleProvider(ctx, module, providerTestGenerateBuildActionsInfoProvider)
+	if ok || unset != nil {
+		t.Errorf("expected unset provider to return nil and false, got %#v (%v)", unset, ok)
+	}
+
+	func() {
+		defer func() {
+			r := recover()
+			if g, w := r, "Value of provider string has unexpected type int"; g != w {
+				t.Errorf("expected panic %q, got %q", w, g)
+			}
+		}()
+		SingletonModuleProvider(ctx, module, contextProviderTestMismatchProvider)
+	}()
+}
+
+type invalidateProvidersTestInfo struct {
+	Value string
+}
+
+var invalidateProvidersTestInfoProvider = NewProvider[*invalidateProvidersTestInfo]()
+
+var (
+	invalidateProvidersTestPctx = NewPackageContext("github.com/google/blueprint/provider_test")
+
+	invalidateProvidersTestStampVar = invalidateProvidersTestPctx.StaticVariable("stamp", "stamp")
+
+	invalidateProvidersTestRule = invalidateProvidersTestPctx.StaticRule("cc", RuleParams{
+		Command: "cc $in -o $out",
+	})
+)
+
+type invalidateProvidersTestModule struct {
+	SimpleName
+	properties struct {
+		Deps []string
+	}
+
+	value     string
+	generated int
+}
+
+func newInvalidateProvidersTestModule() (Module, []interface{}) {
+	m := &invalidateProvidersTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *invalidateProvidersTestModule) GenerateBuildActions(ctx ModuleContext) {
+	m.generated++
+	ctx.AddNinjaFileDeps(ctx.ModuleName() + ".dep")
+	values := []string{ctx.ModuleName() + "=" + m.value}
+	ctx.VisitDirectDeps(func(dep Module) {
+		if info, ok := OtherModuleProvider(ctx, dep, invalidateProvidersTestInfoProvider); ok {
+			values = append(values, info.Value)
+		}
+	})
+	SetProvider(ctx, invalidateProvidersTestInfoProvider, &invalidateProvidersTestInfo{
+		Value: strings.Join(values, ","),
+	})
+
+	// The build statements of all the modules share their order-only dependencies, and only the
+	// first value of A references a global variable.
+	var implicits []string
+	if ctx.ModuleName() == "A" && m.value == "1" {
+		implicits = []string{"${stamp}"}
+	}
+	ctx.Build(invalidateProvidersTestPctx, BuildParams{
+		Rule:      invalidateProvidersTestRule,
+		Outputs:   []string{ctx.ModuleName() + ".o"},
+		Inputs:    []string{ctx.ModuleName() + ".c"},
+		Implicits: implicits,
+		OrderOnly: []string{"gen/a.h", "gen/b.h"},
+	})
+}
+
+func TestInvalidateProviders(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newInvalidateProvidersTestModule)
+	ctx.RegisterBottomUpMutator("deps", func(ctx BottomUpMutatorContext) {
+		if m, ok := ctx.Module().(*invalidateProvidersTestModule); ok {
+			ctx.AddDependency(ctx.Module(), nil, m.properties.Deps...)
+		}
+	})
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test {
+				name: "A",
+				deps: ["B"],
+			}
+
+			test {
+				name: "B",
+			}
+
+			test {
+				name: "C",
+			}
+		`),
+	})
+
+	if err := ctx.InvalidateProviders(nil); err != ErrDependenciesNotReady {
+		t.Errorf("expected ErrDependenciesNotReady before ResolveDependencies, got %v", err)
+	}
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected dep errors: %v", errs)
+	}
+
+	modules := make(map[string]*invalidateProvidersTestModule)
+	for _, name := range []string{"A", "B", "C"} {
+		modules[name] = ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule.(*invalidateProvidersTestModule)
+		modules[name].value = "1"
+	}
+
+	prepare := func(t *testing.T, wantGenerated map[string]int, wantValue string) {
+		t.Helper()
+		_, errs := ctx.PrepareBuildActions(nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors from PrepareBuildActions: %v", errs)
+		}
+		for name, want := range wantGenerated {
+			if got := modules[name].generated; got != want {
+				t.Errorf("expected GenerateBuildActions to be called %d times for %s, got %d", want, name, got)
+			}
+		}
+		// The dependencies of the modules that weren't regenerated are kept, but not duplicated.
+		for _, dep := range []string{"A.dep", "B.dep", "C.dep"} {
+			if n := slices.Index(ctx.generatorDeps, dep); n < 0 {
+				t.Errorf("expected generator deps to contain %q, got %q", dep, ctx.generatorDeps)
+			} else if slices.Contains(ctx.generatorDeps[n+1:], dep) {
+				t.Errorf("expected generator deps to contain %q once, got %q", dep, ctx.generatorDeps)
+			}
+		}
+		info, _ := SingletonModuleProvider(ctx, modules["A"], invalidateProvidersTestInfoProvider)
+		if info == nil || info.Value != wantValue {
+			t.Errorf("expected provider of A to be %q, got %v", wantValue, info)
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatalf("unexpected error from WriteBuildFile: %s", err)
+		}
+		for _, phony := range regexp.MustCompile(`dedup-[0-9a-f]+`).FindAllString(buf.String(), -1) {
+			if !strings.Contains(buf.String(), "build "+phony+": phony") {
+				t.Errorf("expected a build statement for %s in:\n%s", phony, buf.String())
+			}
+		}
+	}
+
+	prepare(t, map[string]int{"A": 1, "B": 1, "C": 1}, "A=1,B=1")
+
+	t.Run("upstream changed", func(t *testing.T) {
+		modules["B"].value = "2"
+		if err := ctx.InvalidateProviders([]Module{modules["B"]}); err != nil {
+			t.Fatal(err)
+		}
+		prepare(t, map[string]int{"A": 2, "B": 2, "C": 1}, "A=1,B=2")
+	})
+
+	t.Run("unrelated module changed", func(t *testing.T) {
+		modules["C"].value = "2"
+		if err := ctx.InvalidateProviders([]Module{modules["C"]}); err != nil {
+			t.Fatal(err)
+		}
+		prepare(t, map[string]int{"A": 2, "B": 2, "C": 2}, "A=1,B=2")
+	})
+
+	t.Run("downstream changed", func(t *testing.T) {
+		modules["A"].value = "3"
+		if err := ctx.InvalidateProviders([]Module{modules["A"]}); err != nil {
+			t.Fatal(err)
+		}
+		prepare(t, map[string]int{"A": 3, "B": 2, "C": 2}, "A=3,B=2")
+		if _, ok := ctx.liveGlobals.variables[invalidateProvidersTestStampVar]; ok {
+			t.Errorf("expected the variable only used by the discarded build actions of A to be released")
+		}
+	})
+}
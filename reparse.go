@@ -0,0 +1,648 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+	"github.com/google/blueprint/pathtools"
+	"github.com/google/blueprint/proptools"
+)
+
+// ReparseMode describes how ReparseFiles updated the Context.
+type ReparseMode int
+
+const (
+	// ReparseFast means only the changed files were parsed again and the new property values
+	// were applied to the existing modules.  The dependencies of the modules are unchanged and do
+	// not need to be resolved again, but PrepareBuildActions must be called again.
+	ReparseFast ReparseMode = iota
+
+	// ReparseFull means the module graph was discarded and every Blueprints file was parsed
+	// again.  ResolveDependencies and PrepareBuildActions must be called again.
+	ReparseFull
+)
+
+func (m ReparseMode) String() string {
+	switch m {
+	case ReparseFast:
+		return "fast"
+	case ReparseFull:
+		return "full"
+	default:
+		return fmt.Sprintf("ReparseMode(%d)", int(m))
+	}
+}
+
+// An IncrementalModule is a Module that can have its properties updated in place by
+// Context.ReparseFiles.  Changes to the properties of modules that don't implement
+// IncrementalModule always cause a full reparse.
+type IncrementalModule interface {
+	Module
+
+	// DependencyProperties returns the names of the properties, as written in Blueprints files,
+	// that mutators read to add dependencies or create variants of the module.  A change to any
+	// of them causes a full reparse.
+	DependencyProperties() []string
+}
+
+// reparseState records what was parsed so that ReparseFiles can find the modules defined by a
+// changed file and compare them to their new definitions.
+type reparseState struct {
+	lock sync.Mutex
+
+	// the arguments of each call to ParseFileList
+	parses []reparseParse
+
+	// the files that were parsed, indexed by their path relative to the root directory
+	files map[string]*reparseFile
+
+	// the files that assign variables that control which other Blueprints files are parsed
+	fileListFiles map[string]bool
+
+	// set when ReparseFiles failed, the next call parses every Blueprints file again
+	failed bool
+}
+
+type reparseParse struct {
+	rootDir   string
+	filePaths []string
+}
+
+type reparseFile struct {
+	rootDir string
+
+	// true if the file was skipped or assigns a variable that affects which files are parsed
+	structural bool
+
+	modules []*reparseModule
+}
+
+type reparseModule struct {
+	name     string
+	typeName string
+	group    *moduleGroup
+
+	// copies of the property structs as they were parsed, before any mutators ran
+	properties []interface{}
+
+	// the unevaluated properties of a defaults module, which are applied to other modules by the
+	// blueprint_defaults mutator
+	defaultsProperties []*parser.Property
+}
+
+// reparseUpdate is a set of changes to the top level property fields of a module that can be
+// applied in place.
+type reparseUpdate struct {
+	group    *moduleGroup
+	mod      *reparseModule
+	newInfo  *moduleInfo
+	changed  [][]int // indexes of the changed fields of each property struct
+	variants []*moduleInfo
+}
+
+// SetIncrementalReparse controls whether the Context keeps the information that ReparseFiles
+// needs.  It must be called before the Blueprints files are parsed, and is disabled by default
+// because it retains a copy of the properties of every module.
+//
+// When enabled, GenerateBuildActions may be called more than once on the same Module and singletons
+// are recreated from their factories for each call to PrepareBuildActions, so module types must not
+// accumulate state between calls to GenerateBuildActions.
+func (c *Context) SetIncrementalReparse(incrementalReparse bool) {
+	if incrementalReparse {
+		c.reparse = newReparseState()
+	} else {
+		c.reparse = nil
+	}
+}
+
+func newReparseState() *reparseState {
+	return &reparseState{
+		files:         make(map[string]*reparseFile),
+		fileListFiles: make(map[string]bool),
+	}
+}
+
+func (s *reparseState) recordParse(rootDir string, filePaths []string) {
+	s.parses = append(s.parses, reparseParse{rootDir, slices.Clone(filePaths)})
+}
+
+// recordFile records a parsed Blueprints file.  It is called from the file handlers of
+// ParseFileList, so it must be reentrant.
+func (s *reparseState) recordFile(rootDir string, file *parser.File, skipped bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.files[file.Name] = &reparseFile{
+		rootDir:    rootDir,
+		structural: skipped || s.fileListFiles[file.Name],
+	}
+}
+
+// recordScope records whether a parsed Blueprints file assigns variables that control which other
+// Blueprints files are parsed.  It must be reentrant.
+func (s *reparseState) recordScope(relBlueprintsFile string, scope *parser.Scope) {
+	if definesFileListVariables(scope) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.fileListFiles[relBlueprintsFile] = true
+	}
+}
+
+// recordModule records a module after it has been added to the Context.
+func (s *reparseState) recordModule(module *moduleInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	file := s.files[module.relBlueprintsFile]
+	if file == nil {
+		return
+	}
+	mod := &reparseModule{
+		name:       module.group.name,
+		typeName:   module.typeName,
+		group:      module.group,
+		properties: cloneAllProperties(module.properties),
+	}
+	if defaults, ok := module.logicModule.(*defaultsModule); ok {
+		mod.defaultsProperties = defaults.properties
+	}
+	file.modules = append(file.modules, mod)
+}
+
+// definesFileListVariables returns true if the scope of a file assigns one of the variables that
+// control which other Blueprints files are parsed.
+func definesFileListVariables(scope *parser.Scope) bool {
+	for _, name := range []string{"subdirs", "optional_subdirs", "build"} {
+		if scope.GetLocal(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneAllProperties(properties []interface{}) []interface{} {
+	ret := make([]interface{}, len(properties))
+	for i, p := range properties {
+		ret[i] = proptools.CloneProperties(reflect.ValueOf(p)).Interface()
+	}
+	return ret
+}
+
+// ReparseFiles updates the Context after the given Blueprints files have changed.  The paths must
+// be in the same form as the paths originally passed to ParseFileList or ParseBlueprintsFiles.
+// SetIncrementalReparse must have been called before the files were first parsed.
+//
+// If the changed files still define the same modules, and only properties that do not affect
+// the dependencies of those modules changed, the new property values are applied to every
+// variant of the modules and ReparseFast is returned.  Otherwise, for example when a module is
+// added or removed, a file that controls which Blueprints files are parsed is changed, or a
+// property returned by IncrementalModule.DependencyProperties, a property of a defaults module or
+// the defaults of a module is changed, the module graph is
+// discarded, every Blueprints file is parsed again and ReparseFull is returned.  In both cases
+// the build actions are discarded, and PrepareBuildActions must be called again.
+//
+// If an error is returned the mode is always ReparseFull.  The build actions have been discarded
+// and the module graph may no longer match the Blueprints files, it must not be used until a later
+// call to ReparseFiles succeeds.  That call parses every Blueprints file again, whichever files
+// it is passed.
+//
+// A property is only updated in place if no mutator has modified its value on any variant of the
+// module, and if no mutator has created modules from the module.  The files are parsed again
+// without the variables inherited from their ancestor Blueprints files, if they use any the
+// full path is taken.
+func (c *Context) ReparseFiles(config interface{}, changed []string) (ReparseMode, []error) {
+	if c.reparse == nil {
+		return ReparseFull, []error{fmt.Errorf("ReparseFiles requires SetIncrementalReparse(true) before parsing")}
+	}
+
+	// Discard the build actions first so that the warnings reported by parsing the changed files
+	// again are kept.
+	c.resetBuildActions(config)
+
+	if c.reparse.failed {
+		_, errs := c.reparseAll(config)
+		return ReparseFull, errs
+	}
+
+	updates, fast, errs := c.reparseChangedFiles(config, changed)
+	if len(errs) > 0 {
+		c.reparse.failed = true
+		c.dependenciesReady = false
+		return ReparseFull, errs
+	}
+
+	if !fast {
+		_, errs = c.reparseAll(config)
+		return ReparseFull, errs
+	}
+
+	for _, update := range updates {
+		update.apply()
+	}
+	c.resolveWarnings = c.warningCount()
+	c.resolveGeneratorDeps = len(c.generatorDeps)
+
+	return ReparseFast, nil
+}
+
+// reparseChangedFiles parses the changed files and compares them to their previous contents. It
+// returns the updates to apply and true if they can be applied in place, or false if a full
+// reparse is required.
+func (c *Context) reparseChangedFiles(config interface{}, changed []string) ([]*reparseUpdate, bool, []error) {
+	var updates []*reparseUpdate
+	changedGroups := make(map[*moduleGroup]bool)
+
+	for _, path := range changed {
+		file, newModules, ok, errs := c.reparseFile(config, path)
+		if len(errs) > 0 {
+			return nil, false, errs
+		}
+		if !ok || len(newModules) != len(file.modules) {
+			return nil, false, nil
+		}
+
+		for i, mod := range file.modules {
+			newModule := newModules[i]
+			if newModule.logicModule.Name() != mod.name || newModule.typeName != mod.typeName {
+				return nil, false, nil
+			}
+
+			update, ok := mod.diff(newModule)
+			if !ok {
+				return nil, false, nil
+			}
+			updates = append(updates, update)
+			if update.changed != nil {
+				changedGroups[mod.group] = true
+			}
+		}
+	}
+
+	// Modules created by mutators are derived from the properties of the module that created
+	// them, they can't be updated in place.
+	recordedGroups := make(map[*moduleGroup]bool)
+	for _, file := range c.reparse.files {
+		for _, mod := range file.modules {
+			recordedGroups[mod.group] = true
+		}
+	}
+	for _, module := range c.moduleInfo {
+		if module.createdBy != nil && changedGroups[module.createdBy.group] && !recordedGroups[module.group] {
+			return nil, false, nil
+		}
+	}
+
+	for _, update := range updates {
+		if !update.findVariants(c) {
+			return nil, false, nil
+		}
+	}
+
+	return updates, true, nil
+}
+
+// reparseFile parses a single changed file again and returns the modules it now defines, or false if
+// the file can't be reparsed on its own.
+func (c *Context) reparseFile(config interface{}, path string) (*reparseFile, []*moduleInfo, bool, []error) {
+	var file *reparseFile
+	var relPath string
+	for _, parse := range c.reparse.parses {
+		rel, err := filepath.Rel(parse.rootDir, path)
+		if err != nil {
+			continue
+		}
+		if f := c.reparse.files[rel]; f != nil && f.rootDir == parse.rootDir {
+			file, relPath = f, rel
+			break
+		}
+	}
+	if file == nil || file.structural {
+		return nil, nil, false, nil
+	}
+
+	f, err := c.openFile(path)
+	if err != nil {
+		// The file was removed.
+		return nil, nil, false, nil
+	}
+	defer f.Close()
+
+	scope := c.newRootScope()
+	parsed, errs := parser.ParseAndEval(path, f, scope)
+	if len(errs) > 0 {
+		// The full parse will report the errors, or succeed if the file depended on variables
+		// from its ancestors.
+		return nil, nil, false, nil
+	}
+	parsed.Name = relPath
+	if definesFileListVariables(scope) || !shouldVisitFile(c, parsed).shouldVisitFile {
+		return nil, nil, false, nil
+	}
+
+	var modules []*moduleInfo
+	var scopedModuleFactories map[string]ModuleFactory
+	var addModule func(module *moduleInfo) []error
+	addModule = func(module *moduleInfo) []error {
+		newModules, _, errs := runAndRemoveLoadHooks(c, config, module, &scopedModuleFactories)
+		if len(errs) > 0 {
+			return errs
+		}
+		modules = append(modules, module)
+		for _, n := range newModules {
+			if errs := addModule(n); len(errs) > 0 {
+				return errs
+			}
+		}
+		return nil
+	}
+
+	for _, def := range parsed.Defs {
+		if def, ok := def.(*parser.Module); ok {
+			module, warnings, errs := processModuleDef(def, relPath, c.moduleFactories, scopedModuleFactories, c.ignoreUnknownModuleTypes)
+			c.warn(warnings...)
+			if len(errs) == 0 && module != nil {
+				errs = addModule(module)
+			}
+			if len(errs) > 0 {
+				return nil, nil, false, errs
+			}
+		}
+	}
+
+	return file, modules, true, nil
+}
+
+// diff compares the recorded properties of a module to the properties of its new definition.  It
+// returns false if they changed in a way that can't be applied in place.  Changes to the
+// properties of a defaults module or to the defaults of a module are never applied in place, the
+// blueprint_defaults mutator has to run again.
+func (mod *reparseModule) diff(newModule *moduleInfo) (*reparseUpdate, bool) {
+	if defaults, ok := newModule.logicModule.(*defaultsModule); ok {
+		if !equalIgnoringPositions(reflect.ValueOf(mod.defaultsProperties),
+			reflect.ValueOf(defaults.properties)) {
+			return nil, false
+		}
+	}
+
+	update := &reparseUpdate{
+		group:   mod.group,
+		mod:     mod,
+		newInfo: newModule,
+		changed: make([][]int, len(mod.properties)),
+	}
+
+	var dependencyProperties []string
+	incremental, isIncremental := newModule.logicModule.(IncrementalModule)
+	if isIncremental {
+		dependencyProperties = incremental.DependencyProperties()
+	}
+	if _, ok := newModule.logicModule.(DefaultableModule); ok {
+		dependencyProperties = append(slices.Clone(dependencyProperties), "defaults")
+	}
+
+	anyChanged := false
+	for i, oldProps := range mod.properties {
+		oldValue := reflect.ValueOf(oldProps).Elem()
+		newValue := reflect.ValueOf(newModule.properties[i]).Elem()
+		for j := 0; j < oldValue.NumField(); j++ {
+			field := oldValue.Type().Field(j)
+			if field.PkgPath != "" || reflect.DeepEqual(oldValue.Field(j).Interface(), newValue.Field(j).Interface()) {
+				continue
+			}
+			if !isIncremental || field.Anonymous ||
+				slices.Contains(dependencyProperties, proptools.PropertyNameForField(field.Name)) {
+				return nil, false
+			}
+			update.changed[i] = append(update.changed[i], j)
+			anyChanged = true
+		}
+	}
+
+	if !anyChanged {
+		// Only the positions are updated so that errors point at the right lines.
+		update.changed = nil
+	}
+	return update, true
+}
+
+var positionType = reflect.TypeOf(scanner.Position{})
+
+// equalIgnoringPositions returns true if a and b are deeply equal apart from their
+// scanner.Position fields, so that parsed properties that only moved within a file compare equal.
+func equalIgnoringPositions(a, b reflect.Value) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Struct:
+		if a.Type() == positionType {
+			return true
+		}
+		for i := 0; i < a.NumField(); i++ {
+			if !equalIgnoringPositions(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Pointer, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return equalIgnoringPositions(a.Elem(), b.Elem())
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalIgnoringPositions(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.Len() != b.Len() {
+			return false
+		}
+		iter := a.MapRange()
+		for iter.Next() {
+			value := b.MapIndex(iter.Key())
+			if !value.IsValid() || !equalIgnoringPositions(iter.Value(), value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a.Equal(b)
+	}
+}
+
+// findVariants finds the current variants of the module and verifies that the changed properties
+// have not been modified by mutators.
+func (u *reparseUpdate) findVariants(c *Context) bool {
+	for _, moduleOrAlias := range u.group.modules {
+		module := moduleOrAlias.module()
+		if module == nil {
+			continue
+		}
+		if len(module.properties) != len(u.mod.properties) {
+			return false
+		}
+		for i, fields := range u.changed {
+			oldValue := reflect.ValueOf(u.mod.properties[i]).Elem()
+			value := reflect.ValueOf(module.properties[i]).Elem()
+			if value.Type() != oldValue.Type() {
+				return false
+			}
+			for _, j := range fields {
+				if !reflect.DeepEqual(value.Field(j).Interface(), oldValue.Field(j).Interface()) {
+					return false
+				}
+			}
+		}
+		u.variants = append(u.variants, module)
+	}
+	return len(u.variants) > 0
+}
+
+// apply copies the changed properties and the new positions to every variant of the module.
+func (u *reparseUpdate) apply() {
+	for _, module := range u.variants {
+		newProperties := cloneAllProperties(u.newInfo.properties)
+		for i, fields := range u.changed {
+			value := reflect.ValueOf(module.properties[i]).Elem()
+			newValue := reflect.ValueOf(newProperties[i]).Elem()
+			for _, j := range fields {
+				value.Field(j).Set(newValue.Field(j))
+			}
+		}
+		module.pos = u.newInfo.pos
+		module.propertyPos = u.newInfo.propertyPos
+		if defaults, ok := module.logicModule.(*defaultsModule); ok {
+			// The properties are unchanged apart from their positions.
+			defaults.properties = u.newInfo.logicModule.(*defaultsModule).properties
+		}
+	}
+	u.mod.properties = cloneAllProperties(u.newInfo.properties)
+	if defaults, ok := u.newInfo.logicModule.(*defaultsModule); ok {
+		u.mod.defaultsProperties = defaults.properties
+	}
+}
+
+// resetBuildActions discards the results of PrepareBuildActions so that it can be called again.
+func (c *Context) resetBuildActions(config interface{}) {
+	c.buildActionsReady = false
+	c.truncateWarnings(c.resolveWarnings)
+	c.generatorDeps = c.generatorDeps[:c.resolveGeneratorDeps:c.resolveGeneratorDeps]
+	if c.dependenciesReady {
+		c.liveGlobals = newLiveTracker(c, config)
+	}
+
+	for _, module := range c.moduleInfo {
+		resetModuleBuildActions(module)
+	}
+
+	c.resetSingletonBuildActions()
+}
+
+// resetModuleBuildActions discards the build actions of a module and the values of the providers
+// it set in GenerateBuildActions, so that GenerateBuildActions is called for it again.
+func resetModuleBuildActions(module *moduleInfo) {
+	module.actionDefs = localBuildActions{}
+	module.ninjaFileDeps = nil
+	module.startedGenerateBuildActions = false
+	module.finishedGenerateBuildActions = false
+	for i, provider := range providerRegistry {
+		if provider.mutator != "" {
+			continue
+		}
+		if i < len(module.providers) {
+			module.providers[i] = nil
+		}
+		if i < len(module.providerInitialValueHashes) {
+			module.providerInitialValueHashes[i] = 0
+		}
+	}
+}
+
+// resetSingletonBuildActions discards the singletons and everything they set, so that new
+// singletons are created and their build actions generated again.
+func (c *Context) resetSingletonBuildActions() {
+	for _, info := range c.singletonInfo {
+		info.singleton = info.factory()
+		info.actionDefs = localBuildActions{}
+	}
+
+	c.outDir = nil
+	c.requiredNinjaMajor = 1
+	c.requiredNinjaMinor = 7
+	c.requiredNinjaMicro = 0
+	c.subninjas = nil
+}
+
+// reparseAll discards all modules and parses every Blueprints file again.
+func (c *Context) reparseAll(config interface{}) ([]string, []error) {
+	if _, ok := c.nameInterface.(*SimpleNameInterface); !ok {
+		return nil, []error{fmt.Errorf("a full reparse requires the default NameInterface, create a new Context instead")}
+	}
+
+	c.resetBuildActions(config)
+
+	c.truncateWarnings(0)
+	c.resolveWarnings = 0
+	c.generatorDeps = nil
+	c.resolveGeneratorDeps = 0
+	c.nameInterface = NewSimpleNameInterface()
+	c.moduleGroups = nil
+	c.moduleInfo = make(map[Module]*moduleInfo)
+	c.modulesSorted = nil
+	c.dependenciesReady = false
+	c.depsModified = 0
+	c.nameAliases = nil
+	c.liveGlobals = nil
+	c.cachedSortedModuleGroups = nil
+	c.cachedDepsModified = false
+	c.startedMutator = nil
+	c.finishedMutators = make(map[*mutatorInfo]bool)
+	for _, mutator := range c.transitionMutators {
+		mutator.inputVariants = nil
+	}
+	c.transitionMutators = nil
+	c.globs = make(map[globKey]pathtools.GlobResult)
+	c.emptyGlobWarnings = make(map[globKey]Warning)
+	c.globCacheResults = make(map[globKey][]globCacheDep)
+	// The globs of the cached variable functions were discarded with c.globs.
+	c.variableFuncCache = make(map[variableFuncCacheKey]*variableFuncCacheEntry)
+
+	parses := c.reparse.parses
+	c.reparse = newReparseState()
+
+	var deps []string
+	for _, parse := range parses {
+		newDeps, errs := c.ParseFileList(parse.rootDir, parse.filePaths, config)
+		if len(errs) > 0 {
+			// Keep every parse so that the next attempt parses all the files again.
+			c.reparse.parses = parses
+			c.reparse.failed = true
+			return nil, errs
+		}
+		deps = append(deps, newDeps...)
+	}
+	return deps, nil
+}
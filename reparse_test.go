@@ -0,0 +1,391 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+type reparseTestModule struct {
+	SimpleName
+	SimpleDefaultable
+	properties struct {
+		Deps      []string
+		Srcs      []string `blueprint:"deprecated_name:sources"`
+		Warning   string
+		File_deps []string
+	}
+}
+
+func newReparseTestModule() (Module, []interface{}) {
+	m := &reparseTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleDefaultable.Properties}
+}
+
+func (m *reparseTestModule) DependencyProperties() []string {
+	return []string{"deps"}
+}
+
+func (m *reparseTestModule) GenerateBuildActions(ctx ModuleContext) {
+	if m.properties.Warning != "" {
+		ctx.ModuleWarningf("test", "%s", m.properties.Warning)
+	}
+	ctx.AddNinjaFileDeps(m.properties.File_deps...)
+	if len(m.properties.Srcs) == 0 {
+		return
+	}
+	ctx.Build(ninjaDefsTestPctx, BuildParams{
+		Rule:    ninjaDefsTestCcRule,
+		Outputs: []string{ctx.ModuleName() + "_" + ctx.ModuleSubDir() + ".o"},
+		Inputs:  m.properties.Srcs,
+	})
+}
+
+var _ IncrementalModule = (*reparseTestModule)(nil)
+
+func newReparseTestContext(t *testing.T, bp string, mutators func(ctx *Context)) *Context {
+	t.Helper()
+
+	ctx := NewContext()
+	ctx.SetIncrementalReparse(true)
+	ctx.RegisterModuleType("test", newReparseTestModule)
+	ctx.RegisterBottomUpMutator("variants", func(ctx BottomUpMutatorContext) {
+		ctx.CreateVariations("a", "b")
+	})
+	ctx.RegisterBottomUpMutator("deps", func(ctx BottomUpMutatorContext) {
+		if m, ok := ctx.Module().(*reparseTestModule); ok {
+			ctx.AddDependency(ctx.Module(), nil, m.properties.Deps...)
+		}
+	})
+	if mutators != nil {
+		mutators(ctx)
+	}
+	ctx.MockFileSystem(map[string][]byte{"Android.bp": []byte(bp)})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	buildReparseTestContext(t, ctx)
+	return ctx
+}
+
+func buildReparseTestContext(t *testing.T, ctx *Context) string {
+	t.Helper()
+
+	_, errs := ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func reparseTestDepNames(ctx *Context, name, variant string) []string {
+	module := ctx.moduleGroupFromName(name, nil).moduleByVariantName(variant)
+	var deps []string
+	for _, dep := range module.directDeps {
+		deps = append(deps, dep.module.Name())
+	}
+	return deps
+}
+
+func reparse(t *testing.T, ctx *Context, bp string) ReparseMode {
+	t.Helper()
+	ctx.MockFileSystem(map[string][]byte{"Android.bp": []byte(bp)})
+	mode, errs := ctx.ReparseFiles(nil, []string{"Android.bp"})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected reparse errors: %v", errs)
+	}
+	return mode
+}
+
+const reparseTestBp = `
+	test {
+		name: "lib",
+	}
+
+	test {
+		name: "other",
+	}
+
+	test {
+		name: "app",
+		deps: ["lib"],
+		srcs: ["a.c"],
+	}
+`
+
+func TestReparseFilesPropertyChange(t *testing.T) {
+	ctx := newReparseTestContext(t, reparseTestBp, nil)
+
+	mode := reparse(t, ctx, strings.Replace(reparseTestBp, `"a.c"`, `"b.c", "c.c"`, 1))
+	if mode != ReparseFast {
+		t.Errorf("expected %s reparse, got %s", ReparseFast, mode)
+	}
+	if !ctx.dependenciesReady {
+		t.Errorf("expected dependencies to still be resolved after a fast reparse")
+	}
+
+	out := buildReparseTestContext(t, ctx)
+	for _, variant := range []string{"a", "b"} {
+		want := "build app_" + variant + ".o: g.ninja_defs_test.cc b.c c.c\n"
+		if !strings.Contains(out, want) {
+			t.Errorf("expected ninja output to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "a.c") {
+		t.Errorf("expected old sources to be removed from ninja output, got:\n%s", out)
+	}
+}
+
+func TestReparseFilesDependencyChange(t *testing.T) {
+	ctx := newReparseTestContext(t, reparseTestBp, nil)
+
+	mode := reparse(t, ctx, strings.Replace(reparseTestBp, `deps: ["lib"]`, `deps: ["lib", "other"]`, 1))
+	if mode != ReparseFull {
+		t.Errorf("expected %s reparse, got %s", ReparseFull, mode)
+	}
+	if ctx.dependenciesReady {
+		t.Errorf("expected dependencies to need resolving after a full reparse")
+	}
+
+	out := buildReparseTestContext(t, ctx)
+	if !strings.Contains(out, "build app_a.o: g.ninja_defs_test.cc a.c\n") {
+		t.Errorf("expected ninja output to contain app_a.o, got:\n%s", out)
+	}
+	if g, w := reparseTestDepNames(ctx, "app", "a"), []string{"lib", "other"}; !slices.Equal(g, w) {
+		t.Errorf("expected deps %q, got %q", w, g)
+	}
+}
+
+func TestReparseFilesWarnings(t *testing.T) {
+	bp := strings.Replace(reparseTestBp, `srcs: ["a.c"],`, `srcs: ["a.c"],
+		warning: "first",`, 1)
+	ctx := newReparseTestContext(t, bp, nil)
+
+	messages := func() []string {
+		var messages []string
+		for _, w := range ctx.Warnings() {
+			messages = append(messages, w.Message)
+		}
+		return messages
+	}
+	if g, w := messages(), []string{`module "app" variant "a": first`, `module "app" variant "b": first`}; !slices.Equal(g, w) {
+		t.Errorf("expected warnings %q, got %q", w, g)
+	}
+
+	if mode := reparse(t, ctx, strings.Replace(bp, `"first"`, `"second"`, 1)); mode != ReparseFast {
+		t.Errorf("expected %s reparse, got %s", ReparseFast, mode)
+	}
+	if g := messages(); len(g) > 0 {
+		t.Errorf("expected the warnings of the build actions to be discarded, got %q", g)
+	}
+	buildReparseTestContext(t, ctx)
+	if g, w := messages(), []string{`module "app" variant "a": second`, `module "app" variant "b": second`}; !slices.Equal(g, w) {
+		t.Errorf("expected warnings %q, got %q", w, g)
+	}
+
+	// The parse warnings are discarded by a full reparse.
+	deprecated := strings.Replace(reparseTestBp, `srcs: ["a.c"]`, `sources: ["a.c"]`, 1)
+	if mode := reparse(t, ctx, deprecated); mode != ReparseFast {
+		t.Errorf("expected %s reparse, got %s", ReparseFast, mode)
+	}
+	if g, w := messages(), []string{`property "sources" is deprecated, use "srcs" instead`}; !slices.Equal(g, w) {
+		t.Errorf("expected warnings %q, got %q", w, g)
+	}
+	if mode := reparse(t, ctx, strings.Replace(reparseTestBp, `deps: ["lib"]`, `deps: ["lib", "other"]`, 1)); mode != ReparseFull {
+		t.Errorf("expected %s reparse, got %s", ReparseFull, mode)
+	}
+	buildReparseTestContext(t, ctx)
+	if g := messages(); len(g) > 0 {
+		t.Errorf("expected no warnings after a full reparse, got %q", g)
+	}
+}
+
+func TestReparseFilesGeneratorDependencies(t *testing.T) {
+	bp := strings.Replace(reparseTestBp, `srcs: ["a.c"],`, `srcs: ["a.c"],
+		file_deps: ["first.txt"],`, 1)
+	ctx := newReparseTestContext(t, bp, nil)
+	if g, w := ctx.GeneratorDependencies(), []string{"Android.bp", "first.txt"}; !slices.Equal(g, w) {
+		t.Errorf("expected dependencies %q, got %q", w, g)
+	}
+
+	if mode := reparse(t, ctx, strings.Replace(bp, `"first.txt"`, `"second.txt"`, 1)); mode != ReparseFast {
+		t.Errorf("expected %s reparse, got %s", ReparseFast, mode)
+	}
+	buildReparseTestContext(t, ctx)
+	if g, w := ctx.GeneratorDependencies(), []string{"Android.bp", "second.txt"}; !slices.Equal(g, w) {
+		t.Errorf("expected dependencies %q, got %q", w, g)
+	}
+
+	if mode := reparse(t, ctx, strings.Replace(reparseTestBp, `deps: ["lib"]`, `deps: ["lib", "other"]`, 1)); mode != ReparseFull {
+		t.Errorf("expected %s reparse, got %s", ReparseFull, mode)
+	}
+	buildReparseTestContext(t, ctx)
+	if g, w := ctx.GeneratorDependencies(), []string{"Android.bp"}; !slices.Equal(g, w) {
+		t.Errorf("expected dependencies %q, got %q", w, g)
+	}
+}
+
+func TestReparseFilesFullPath(t *testing.T) {
+	testCases := []struct {
+		name     string
+		bp       string
+		mutators func(ctx *Context)
+	}{
+		{
+			name: "added module",
+			bp: reparseTestBp + `
+				test {
+					name: "new",
+				}
+			`,
+		},
+		{
+			name: "renamed module",
+			bp:   strings.Replace(reparseTestBp, `"other"`, `"renamed"`, 1),
+		},
+		{
+			name: "file list variable",
+			bp:   reparseTestBp + "\nsubdirs = []\n",
+		},
+		{
+			name: "property modified by mutator",
+			bp:   strings.Replace(reparseTestBp, `"a.c"`, `"b.c"`, 1),
+			mutators: func(ctx *Context) {
+				ctx.RegisterBottomUpMutator("srcs", func(ctx BottomUpMutatorContext) {
+					if m, ok := ctx.Module().(*reparseTestModule); ok && len(m.properties.Srcs) > 0 {
+						m.properties.Srcs = append(m.properties.Srcs, "generated.c")
+					}
+				})
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := newReparseTestContext(t, reparseTestBp, tc.mutators)
+			if mode := reparse(t, ctx, tc.bp); mode != ReparseFull {
+				t.Errorf("expected %s reparse, got %s", ReparseFull, mode)
+			}
+			buildReparseTestContext(t, ctx)
+		})
+	}
+}
+
+func TestReparseFilesDefaults(t *testing.T) {
+	bp := reparseTestBp + `
+		defaults {
+			name: "d",
+			srcs: ["one.c"],
+		}
+
+		test {
+			name: "with_defaults",
+			defaults: ["d"],
+		}
+	`
+
+	testCases := []struct {
+		name string
+		bp   string
+		mode ReparseMode
+		want string
+	}{
+		{
+			name: "defaults property",
+			bp:   strings.Replace(bp, `"one.c"`, `"two.c"`, 1),
+			mode: ReparseFull,
+			want: "build with_defaults_a.o: g.ninja_defs_test.cc two.c\n",
+		},
+		{
+			name: "defaults list",
+			bp:   strings.Replace(bp, `defaults: ["d"],`, `defaults: [],`, 1),
+			mode: ReparseFull,
+		},
+		{
+			name: "moved defaults",
+			bp:   "\n\n" + bp,
+			mode: ReparseFast,
+			want: "build with_defaults_a.o: g.ninja_defs_test.cc one.c\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := newReparseTestContext(t, bp, nil)
+			if mode := reparse(t, ctx, tc.bp); mode != tc.mode {
+				t.Errorf("expected %s reparse, got %s", tc.mode, mode)
+			}
+			out := buildReparseTestContext(t, ctx)
+			if tc.want != "" && !strings.Contains(out, tc.want) {
+				t.Errorf("expected ninja output to contain %q, got:\n%s", tc.want, out)
+			}
+			if tc.want == "" && strings.Contains(out, "with_defaults_a.o") {
+				t.Errorf("expected defaults to be removed from ninja output, got:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestReparseFilesParseError(t *testing.T) {
+	ctx := newReparseTestContext(t, reparseTestBp, nil)
+
+	ctx.MockFileSystem(map[string][]byte{"Android.bp": []byte(strings.Replace(reparseTestBp, `["a.c"]`, `["a.c"`, 1))})
+	mode, errs := ctx.ReparseFiles(nil, []string{"Android.bp"})
+	if len(errs) == 0 {
+		t.Fatalf("expected reparse errors")
+	}
+	if mode != ReparseFull {
+		t.Errorf("expected %s reparse after an error, got %s", ReparseFull, mode)
+	}
+	if ctx.dependenciesReady {
+		t.Errorf("expected dependencies to need resolving after a failed reparse")
+	}
+
+	// A change that could be applied in place still parses every file again after a failure.
+	mode = reparse(t, ctx, strings.Replace(reparseTestBp, `"a.c"`, `"b.c"`, 1))
+	if mode != ReparseFull {
+		t.Errorf("expected %s reparse after a failed reparse, got %s", ReparseFull, mode)
+	}
+
+	out := buildReparseTestContext(t, ctx)
+	if !strings.Contains(out, "build app_a.o: g.ninja_defs_test.cc b.c\n") {
+		t.Errorf("expected ninja output to contain app_a.o, got:\n%s", out)
+	}
+
+	mode = reparse(t, ctx, strings.Replace(reparseTestBp, `"a.c"`, `"c.c"`, 1))
+	if mode != ReparseFast {
+		t.Errorf("expected %s reparse after a successful reparse, got %s", ReparseFast, mode)
+	}
+}
+
+func TestReparseFilesNotEnabled(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newReparseTestModule)
+	ctx.MockFileSystem(map[string][]byte{"Android.bp": []byte(reparseTestBp)})
+	if _, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	_, errs := ctx.ReparseFiles(nil, []string{"Android.bp"})
+	expectedErrors(t, errs, "ReparseFiles requires SetIncrementalReparse(true) before parsing")
+}
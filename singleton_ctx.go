@@ -16,6 +16,7 @@ package blueprint
 
 import (
 	"fmt"
+	"text/scanner"
 
 	"github.com/google/blueprint/pathtools"
 )
@@ -47,6 +48,10 @@ type SingletonContext interface {
 	// BlueprintFile returns the path of the Blueprint file that defined the given module.
 	BlueprintFile(module Module) string
 
+	// ModulePosition returns the position of the module type in the definition of the given module, which is the
+	// position ModuleErrorf reports errors at.
+	ModulePosition(module Module) scanner.Position
+
 	// ModuleProvider returns the value, if any, for the provider for a module.  If the value for the
 	// provider was not set it returns the zero value of the type of the provider, which means the
 	// return value can always be type-asserted to the type of the provider.  The return value should
@@ -79,6 +84,13 @@ type SingletonContext interface {
 	// Build creates a new ninja build statement.
 	Build(pctx PackageContext, params BuildParams)
 
+	// BuildWithOutputs creates a new ninja build statement like Build, and returns its explicit
+	// outputs with the ninja variables they reference recursively expanded as Eval does, so that
+	// they can be passed to later calls to Build.  If the build statement is invalid or its outputs
+	// can't be expanded the error is reported as by Errorf, so that the singleton fails, and returned
+	// with no outputs.
+	BuildWithOutputs(pctx PackageContext, params BuildParams) ([]string, error)
+
 	// RequireNinjaVersion sets the generated ninja manifest to require at least the specified version of ninja.
 	RequireNinjaVersion(major, minor, micro int)
 
@@ -210,6 +222,10 @@ func (s *singletonContext) BlueprintFile(logicModule Module) string {
 	return s.context.BlueprintFile(logicModule)
 }
 
+func (s *singletonContext) ModulePosition(logicModule Module) scanner.Position {
+	return s.context.ModulePosition(logicModule)
+}
+
 func (s *singletonContext) error(err error) {
 	if err != nil {
 		s.errs = append(s.errs, err)
@@ -264,17 +280,43 @@ func (s *singletonContext) Rule(pctx PackageContext, name string,
 }
 
 func (s *singletonContext) Build(pctx PackageContext, params BuildParams) {
+	if err := s.build(pctx, params); err != nil {
+		panic(err)
+	}
+}
+
+// build adds a build statement to the singleton, or returns an error if params is invalid.
+func (s *singletonContext) build(pctx PackageContext, params BuildParams) error {
 	s.scope.ReparentTo(pctx)
 
 	def, err := parseBuildParams(s.scope, &params, map[string]string{
 		"module_name": s.name,
 		"module_type": "singleton",
-	})
+	}, s.config)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	s.actionDefs.buildDefs = append(s.actionDefs.buildDefs, def)
+	return nil
+}
+
+func (s *singletonContext) BuildWithOutputs(pctx PackageContext, params BuildParams) ([]string, error) {
+	if err := s.build(pctx, params); err != nil {
+		s.error(err)
+		return nil, err
+	}
+
+	outputs := make([]string, len(params.Outputs))
+	for i, output := range params.Outputs {
+		value, err := s.Eval(pctx, output)
+		if err != nil {
+			s.error(err)
+			return nil, err
+		}
+		outputs[i] = value
+	}
+	return outputs, nil
 }
 
 func (s *singletonContext) Eval(pctx PackageContext, str string) (string, error) {
@@ -0,0 +1,352 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type modulePositionTestSingleton struct {
+	positions []string
+}
+
+func (s *modulePositionTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.VisitAllModules(func(m Module) {
+		s.positions = append(s.positions, fmt.Sprintf("%s %s", ctx.ModuleName(m), ctx.ModulePosition(m)))
+		if ctx.ModuleName(m) == "bad" {
+			ctx.ModuleErrorf(m, "bad module")
+		}
+	})
+}
+
+func TestSingletonModulePosition(t *testing.T) {
+	singleton := &modulePositionTestSingleton{}
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newModuleCtxTestModule)
+	ctx.RegisterSingletonType("positions", func() Singleton { return singleton }, false)
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test {
+				name: "good",
+			}
+		`),
+		"dir/Android.bp": []byte(`
+			test {
+				name: "other",
+			}
+
+			test {
+				name: "bad",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp", "dir/Android.bp"}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected dep errors: %v", errs)
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	expectedErrors(t, errs, `dir/Android.bp:6:4: module "bad": bad module`)
+
+	want := map[string]bool{
+		"good Android.bp:2:4":      true,
+		"other dir/Android.bp:2:4": true,
+		"bad dir/Android.bp:6:4":   true,
+	}
+	if len(singleton.positions) != len(want) {
+		t.Errorf("expected %d positions, got %q", len(want), singleton.positions)
+	}
+	for _, pos := range singleton.positions {
+		if !want[pos] {
+			t.Errorf("unexpected module position %q", pos)
+		}
+	}
+}
+
+var (
+	singletonCtxTestPctx = NewPackageContext("github.com/google/blueprint/singleton_ctx_test")
+
+	singletonCtxTestGenDirVar = singletonCtxTestPctx.StaticVariable("genDir", "out/gen")
+	singletonCtxTestOutDirVar = singletonCtxTestPctx.StaticVariable("outDir", "${genDir}/..")
+
+	singletonCtxTestRule = singletonCtxTestPctx.StaticRule("gen", RuleParams{
+		Command: "gen $in -o $out",
+	})
+)
+
+type buildWithOutputsTestSingleton struct {
+	outputs []string
+	headers []string
+}
+
+func (s *buildWithOutputsTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	var err error
+	s.headers, err = ctx.BuildWithOutputs(singletonCtxTestPctx, BuildParams{
+		Rule:    singletonCtxTestRule,
+		Outputs: s.outputs,
+		Inputs:  []string{"a.def"},
+	})
+	if err != nil {
+		// The error has already been reported.
+		return
+	}
+	ctx.Build(singletonCtxTestPctx, BuildParams{
+		Rule:    singletonCtxTestRule,
+		Outputs: []string{"out/a.o"},
+		Inputs:  s.headers,
+	})
+}
+
+func TestSingletonBuildWithOutputs(t *testing.T) {
+	singleton := &buildWithOutputsTestSingleton{outputs: []string{"${genDir}/a.h", "${outDir}/b.h"}}
+
+	ctx := NewContext()
+	ctx.RegisterSingletonType("build_with_outputs", func() Singleton { return singleton }, false)
+	ctx.MockFileSystem(map[string][]byte{"Android.bp": nil})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	if g, w := singleton.headers, []string{"out/gen/a.h", "out/gen/../b.h"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("incorrect outputs\nwant: %q\n got: %q", w, g)
+	}
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, w := range []string{
+		"build ${g.singleton_ctx_test.genDir}/a.h ${g.singleton_ctx_test.outDir}/b.h: $\n" +
+			"        g.singleton_ctx_test.gen a.def\n",
+		"build out/a.o: g.singleton_ctx_test.gen out/gen/a.h out/gen/../b.h\n",
+	} {
+		if !strings.Contains(out, w) {
+			t.Errorf("expected ninja output to contain %q, got:\n%s", w, out)
+		}
+	}
+}
+
+func TestSingletonBuildWithOutputsError(t *testing.T) {
+	singleton := &buildWithOutputsTestSingleton{}
+
+	ctx := NewContext()
+	ctx.RegisterSingletonType("build_with_outputs", func() Singleton { return singleton }, false)
+	ctx.MockFileSystem(map[string][]byte{"Android.bp": nil})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	_, errs = ctx.PrepareBuildActions(nil)
+	expectedErrors(t, errs, "Outputs param has no elements")
+
+	if singleton.headers != nil {
+		t.Errorf("expected no outputs, got %q", singleton.headers)
+	}
+}
+
+type orderTestSingleton struct {
+	name string
+	run  func(name string)
+}
+
+func (s *orderTestSingleton) GenerateBuildActions(SingletonContext) {
+	s.run(s.name)
+}
+
+func TestSingletonMustRunAfter(t *testing.T) {
+	run := func(t *testing.T, register func(ctx *Context, singleton func(string) SingletonFactory)) ([]string, []error) {
+		t.Helper()
+		var lock sync.Mutex
+		var order []string
+
+		singleton := func(name string) SingletonFactory {
+			return func() Singleton {
+				return &orderTestSingleton{name: name, run: func(name string) {
+					lock.Lock()
+					defer lock.Unlock()
+					order = append(order, name)
+				}}
+			}
+		}
+
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newModuleCtxTestModule)
+		register(ctx, singleton)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				test {
+					name: "test",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		_, errs = ctx.PrepareBuildActions(nil)
+		return order, errs
+	}
+
+	t.Run("diamond", func(t *testing.T) {
+		order, errs := run(t, func(ctx *Context, singleton func(string) SingletonFactory) {
+			ctx.RegisterSingletonType("unordered", singleton("unordered"), false)
+			ctx.RegisterSingletonType("d", singleton("d"), false).MustRunAfter("b").MustRunAfter("c")
+			ctx.RegisterSingletonType("c", singleton("c"), false).MustRunAfter("a")
+			ctx.RegisterSingletonType("b", singleton("b"), false).MustRunAfter("a")
+			ctx.RegisterSingletonType("a", singleton("a"), false)
+		})
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if g, w := order, []string{"unordered", "a", "c", "b", "d"}; !reflect.DeepEqual(g, w) {
+			t.Errorf("incorrect singleton order:\nwant: %q\n got: %q", w, g)
+		}
+	})
+
+	t.Run("parallel", func(t *testing.T) {
+		order, errs := run(t, func(ctx *Context, singleton func(string) SingletonFactory) {
+			ctx.RegisterSingletonType("serial", singleton("serial"), false)
+			ctx.RegisterSingletonType("ordered_parallel", singleton("ordered_parallel"), true).MustRunAfter("serial")
+			ctx.RegisterSingletonType("parallel1", singleton("parallel1"), true)
+			ctx.RegisterSingletonType("parallel2", singleton("parallel2"), true)
+		})
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if len(order) != 4 {
+			t.Fatalf("expected 4 singletons to run, got %q", order)
+		}
+		// The unconstrained parallel singletons run concurrently before the others, in any order.
+		sort.Strings(order[:2])
+		if g, w := order, []string{"parallel1", "parallel2", "serial", "ordered_parallel"}; !reflect.DeepEqual(g, w) {
+			t.Errorf("incorrect singleton order:\nwant: %q\n got: %q", w, g)
+		}
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		_, errs := run(t, func(ctx *Context, singleton func(string) SingletonFactory) {
+			ctx.RegisterSingletonType("first", singleton("first"), false)
+			ctx.RegisterSingletonType("a", singleton("a"), false).MustRunAfter("b")
+			ctx.RegisterSingletonType("b", singleton("b"), false).MustRunAfter("c")
+			ctx.RegisterSingletonType("c", singleton("c"), false).MustRunAfter("a")
+		})
+		expectedErrors(t, errs, `singleton ordering cycle: "a" must run after "b" must run after "c" must run after "a"`)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, errs := run(t, func(ctx *Context, singleton func(string) SingletonFactory) {
+			ctx.RegisterSingletonType("a", singleton("a"), false).MustRunAfter("missing")
+		})
+		expectedErrors(t, errs, `singleton "a" must run after unknown singleton "missing"`)
+	})
+}
+
+// parallelTestSingleton reads the module graph and writes build statements and a local rule that
+// are independent of every other singleton.
+type parallelTestSingleton struct {
+	name string
+}
+
+func (s *parallelTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	rule := ctx.Rule(ninjaDefsTestPctx, "gen", RuleParams{
+		Command: "gen --singleton " + s.name + " $in -o $out",
+	})
+	ctx.VisitAllModules(func(m Module) {
+		ctx.Build(ninjaDefsTestPctx, BuildParams{
+			Rule:    rule,
+			Outputs: []string{s.name + "/" + ctx.ModuleName(m) + ".out"},
+			Inputs:  []string{ctx.ModuleName(m) + ".in"},
+		})
+	})
+}
+
+func writeParallelSingletonsTestBuildFile(t testing.TB, parallel bool) string {
+	t.Helper()
+
+	ctx := NewContext()
+	ctx.SetParallelSingletons(parallel)
+	ctx.RegisterModuleType("test", newModuleCtxTestModule)
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("singleton%d", i)
+		ctx.RegisterSingletonType(name, func() Singleton {
+			return &parallelTestSingleton{name: name}
+		}, false)
+	}
+
+	bp := &strings.Builder{}
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(bp, "test {\n    name: \"module%d\",\n}\n", i)
+	}
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(bp.String()),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestParallelSingletons(t *testing.T) {
+	serial := writeParallelSingletonsTestBuildFile(t, false)
+	if !strings.Contains(serial, "build singleton7/module49.out: ") {
+		t.Fatalf("expected build statements from every singleton, got:\n%s", serial)
+	}
+
+	for i := 0; i < 5; i++ {
+		if parallel := writeParallelSingletonsTestBuildFile(t, true); parallel != serial {
+			t.Fatalf("parallel singleton output differs from serial output")
+		}
+	}
+}
+
+func BenchmarkParallelSingletons(b *testing.B) {
+	for _, parallel := range []bool{false, true} {
+		b.Run(fmt.Sprintf("parallel=%v", parallel), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				writeParallelSingletonsTestBuildFile(b, parallel)
+			}
+		})
+	}
+}
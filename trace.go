@@ -0,0 +1,128 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// traceEvent is an event in the Chrome trace event format.
+type traceEvent struct {
+	Name  string            `json:"name"`
+	Phase string            `json:"ph"`
+	Time  int64             `json:"ts"`
+	Dur   int64             `json:"dur"`
+	Pid   int               `json:"pid"`
+	Tid   int               `json:"tid"`
+	Args  map[string]string `json:"args,omitempty"`
+}
+
+// phaseTracer records the begin and end events of the phases of the Context, and of the visits
+// to each module by the mutators.  Phases are recorded on track 0, and each concurrent mutator
+// visit is recorded on its own track.
+type phaseTracer struct {
+	lock   sync.Mutex
+	start  time.Time
+	events []traceEvent
+
+	// tracks that are not in use by a mutator visit, and the number of tracks in use by a mutator
+	// visit or in freeTracks
+	freeTracks []int
+	tracks     int
+}
+
+func newPhaseTracer() *phaseTracer {
+	return &phaseTracer{start: time.Now()}
+}
+
+func (t *phaseTracer) record(name string, phase string, tid int, args map[string]string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.events = append(t.events, traceEvent{
+		Name:  name,
+		Phase: phase,
+		Time:  time.Since(t.start).Microseconds(),
+		Tid:   tid,
+		Args:  args,
+	})
+}
+
+// acquireTrack returns the lowest numbered track that is not in use by another mutator visit.
+func (t *phaseTracer) acquireTrack() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.freeTracks) == 0 {
+		t.tracks++
+		return t.tracks
+	}
+	sort.Ints(t.freeTracks)
+	tid := t.freeTracks[0]
+	t.freeTracks = t.freeTracks[1:]
+	return tid
+}
+
+func (t *phaseTracer) releaseTrack(tid int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.freeTracks = append(t.freeTracks, tid)
+}
+
+// traceMutatorVisit records the visit of a mutator to a module on a track that isn't used by any
+// concurrent visit, and returns a function that records the end of the visit.
+func (t *phaseTracer) traceMutatorVisit(mutator string, module *moduleInfo) func() {
+	tid := t.acquireTrack()
+	args := map[string]string{"module": module.Name()}
+	if module.variant.name != "" {
+		args["variant"] = module.variant.name
+	}
+	t.record(mutator, "B", tid, args)
+	return func() {
+		t.record(mutator, "E", tid, nil)
+		t.releaseTrack(tid)
+	}
+}
+
+// SetPhaseTracing controls whether the Context records the start and end of its phases and
+// mutator passes, and of each mutator visit to a module, for WriteTrace.  Enabling it discards
+// any previously recorded events.  It is disabled by default.
+func (c *Context) SetPhaseTracing(phaseTracing bool) {
+	if phaseTracing {
+		c.phaseTracer = newPhaseTracer()
+	} else {
+		c.phaseTracer = nil
+	}
+}
+
+// WriteTrace writes the events recorded since phase tracing was enabled with SetPhaseTracing to
+// w in the Chrome trace event format, which can be loaded into chrome://tracing or Perfetto.
+// The phases are written on thread 0, and the mutator visits on the other threads.
+func (c *Context) WriteTrace(w io.Writer) error {
+	if c.phaseTracer == nil {
+		return fmt.Errorf("phase tracing is not enabled")
+	}
+
+	c.phaseTracer.lock.Lock()
+	events := append([]traceEvent(nil), c.phaseTracer.events...)
+	c.phaseTracer.lock.Unlock()
+
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}{events})
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWriteTrace(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetPhaseTracing(true)
+	ctx.RegisterModuleType("test", newNinjaSplitTestModule)
+	ctx.RegisterBottomUpMutator("first", func(BottomUpMutatorContext) {}).Parallel()
+	ctx.RegisterBottomUpMutator("second", func(BottomUpMutatorContext) {})
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test { name: "a", srcs: ["a.c"] }
+			test { name: "b", srcs: ["b.c"] }
+		`),
+	})
+
+	if _, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	if _, errs := ctx.ResolveDependencies(nil); len(errs) > 0 {
+		t.Fatalf("unexpected dependency errors: %v", errs)
+	}
+	if _, errs := ctx.PrepareBuildActions(nil); len(errs) > 0 {
+		t.Fatalf("unexpected build action errors: %v", errs)
+	}
+	if err := ctx.WriteBuildFile(&strings.Builder{}, false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteTrace(buf); err != nil {
+		t.Fatal(err)
+	}
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+		t.Fatalf("invalid trace %q: %s", buf.String(), err)
+	}
+
+	var phases []string
+	open := make(map[int][]string)
+	visits := make(map[string]int)
+	for _, event := range trace.TraceEvents {
+		switch event.Phase {
+		case "B":
+			open[event.Tid] = append(open[event.Tid], event.Name)
+			if event.Tid == 0 {
+				phases = append(phases, event.Name)
+			} else {
+				visits[event.Name+" "+event.Args["module"]]++
+			}
+		case "E":
+			stack := open[event.Tid]
+			if len(stack) == 0 || stack[len(stack)-1] != event.Name {
+				t.Fatalf("unexpected end of %q on track %d, open events %q", event.Name, event.Tid, stack)
+			}
+			open[event.Tid] = stack[:len(stack)-1]
+		default:
+			t.Errorf("unexpected event phase %q", event.Phase)
+		}
+	}
+	for tid, stack := range open {
+		if len(stack) > 0 {
+			t.Errorf("unfinished events %q on track %d", stack, tid)
+		}
+	}
+
+	want := []string{"parse", "resolve_deps", "first", "second", "prepare_build_actions", "write_build_file"}
+	var got []string
+	for _, phase := range phases {
+		if slices.Contains(want, phase) {
+			got = append(got, phase)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("incorrect phases:\nwant: %q\n got: %q\n all: %q", want, got, phases)
+	}
+
+	for _, visit := range []string{"first a", "first b", "second a", "second b"} {
+		if visits[visit] != 1 {
+			t.Errorf("expected one mutator visit %q, got %d in %v", visit, visits[visit], visits)
+		}
+	}
+}
+
+func TestWriteTraceNotEnabled(t *testing.T) {
+	ctx := NewContext()
+	if err := ctx.WriteTrace(&bytes.Buffer{}); err == nil {
+		t.Errorf("expected an error when phase tracing is not enabled")
+	}
+}
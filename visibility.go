@@ -0,0 +1,209 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/scanner"
+)
+
+// A VisibilityModule is a Module that restricts which other modules may depend on it.  Module
+// types usually implement it by adding a `visibility` property containing a list of rules and
+// returning it from Visibility.  Modules that do not implement VisibilityModule, or that return
+// no rules, are visible to every module.
+//
+// The package of a module is the directory containing the Blueprints file that defined it,
+// relative to the root of the source tree.  Modules in the same package may always depend on
+// each other.  Each rule has one of the following forms:
+//
+//	//visibility:public   visible to all modules
+//	//visibility:private  visible only to modules in the same package
+//	//some/package        visible to all modules in some/package
+//	//some/package:name   visible to the module called name in some/package
+//	:name                 visible to the module called name in the same package
+//
+// Visibility is checked after all mutators have run, and every dependency on a module that is
+// not visible to the depending module is reported as an error from ResolveDependencies, or as a
+// warning in the DiagnosticCodeVisibility category if SetVisibilityWarnings was called.
+type VisibilityModule interface {
+	Module
+
+	// Visibility returns the list of visibility rules for the module.
+	Visibility() []string
+}
+
+const (
+	visibilityPublic  = "//visibility:public"
+	visibilityPrivate = "//visibility:private"
+)
+
+// visibilityRule is a parsed visibility rule that allows access to a package, or to a single
+// module in a package when name is set.
+type visibilityRule struct {
+	pkg  string
+	name string
+}
+
+// visibilityRules is the parsed form of the visibility rules of a single module.
+type visibilityRules struct {
+	public bool
+	rules  []visibilityRule
+}
+
+func (r visibilityRules) allows(pkg, name string) bool {
+	if r.public {
+		return true
+	}
+	for _, rule := range r.rules {
+		if rule.pkg == pkg && (rule.name == "" || rule.name == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// visibilityPackage returns the package of a module, which is the directory containing its
+// Blueprints file, or "" for the root of the source tree.
+func visibilityPackage(module *moduleInfo) string {
+	pkg := filepath.ToSlash(filepath.Dir(module.relBlueprintsFile))
+	if pkg == "." {
+		return ""
+	}
+	return pkg
+}
+
+// parseVisibilityRules parses the visibility rules of a module in package pkg.
+func parseVisibilityRules(pkg string, visibility []string) (visibilityRules, error) {
+	var ret visibilityRules
+	if len(visibility) == 0 {
+		ret.public = true
+		return ret, nil
+	}
+
+	for _, v := range visibility {
+		switch {
+		case v == visibilityPublic:
+			ret.public = true
+		case v == visibilityPrivate:
+			ret.rules = append(ret.rules, visibilityRule{pkg: pkg})
+		case strings.HasPrefix(v, "//visibility:"):
+			return visibilityRules{}, fmt.Errorf("unknown visibility rule %q", v)
+		case strings.HasPrefix(v, ":"):
+			name := strings.TrimPrefix(v, ":")
+			if name == "" {
+				return visibilityRules{}, fmt.Errorf("invalid visibility rule %q: missing module name", v)
+			}
+			ret.rules = append(ret.rules, visibilityRule{pkg: pkg, name: name})
+		case strings.HasPrefix(v, "//"):
+			rulePkg, name, hasName := strings.Cut(strings.TrimPrefix(v, "//"), ":")
+			if hasName && name == "" {
+				return visibilityRules{}, fmt.Errorf("invalid visibility rule %q: missing module name", v)
+			}
+			if rulePkg != "" && filepath.ToSlash(filepath.Clean(rulePkg)) != rulePkg {
+				return visibilityRules{}, fmt.Errorf("invalid visibility rule %q: package path must be clean", v)
+			}
+			ret.rules = append(ret.rules, visibilityRule{pkg: rulePkg, name: name})
+		default:
+			return visibilityRules{}, fmt.Errorf("invalid visibility rule %q: must start with \"//\" or \":\"", v)
+		}
+	}
+
+	return ret, nil
+}
+
+// SetVisibilityWarnings controls whether dependencies on modules that are not visible to the
+// depending module are reported as warnings instead of errors, which allows visibility rules to be
+// added to existing modules before all their dependents are fixed.  Invalid visibility rules are
+// always errors.
+func (c *Context) SetVisibilityWarnings(visibilityWarnings bool) {
+	c.visibilityWarnings = visibilityWarnings
+}
+
+// checkVisibility verifies that every module only depends on modules that are visible to it.  It
+// must be called after all mutators have run.  Each invalid rule and each disallowed dependency is
+// reported once per module group, regardless of how many variants are involved.
+func (c *Context) checkVisibility() (errs []error) {
+	type groupPair struct {
+		from, to *moduleGroup
+	}
+
+	parsed := make(map[*moduleInfo]visibilityRules)
+	invalid := make(map[*moduleInfo]bool)
+	reportedInvalid := make(map[*moduleGroup]bool)
+	reportedDeps := make(map[groupPair]bool)
+
+	rulesFor := func(module *moduleInfo) (visibilityRules, bool) {
+		if rules, ok := parsed[module]; ok {
+			return rules, true
+		}
+		if invalid[module] {
+			return visibilityRules{}, false
+		}
+
+		var visibility []string
+		if v, ok := module.logicModule.(VisibilityModule); ok {
+			visibility = v.Visibility()
+		}
+
+		rules, err := parseVisibilityRules(visibilityPackage(module), visibility)
+		if err != nil {
+			invalid[module] = true
+			if !reportedInvalid[module.group] {
+				reportedInvalid[module.group] = true
+				errs = append(errs, c.PropertyErrorf(module.logicModule, "visibility", "%s", err))
+			}
+			return visibilityRules{}, false
+		}
+		parsed[module] = rules
+		return rules, true
+	}
+
+	for _, module := range c.modulesSorted {
+		if module.disabled {
+			continue
+		}
+		pkg := visibilityPackage(module)
+		for _, dep := range module.directDeps {
+			if dep.module.group == module.group {
+				continue
+			}
+			depPkg := visibilityPackage(dep.module)
+			if depPkg == pkg {
+				continue
+			}
+			rules, ok := rulesFor(dep.module)
+			if !ok || rules.allows(pkg, module.Name()) {
+				continue
+			}
+			pair := groupPair{module.group, dep.module.group}
+			if reportedDeps[pair] {
+				continue
+			}
+			reportedDeps[pair] = true
+			const format = "depends on %q in //%s, which is not visible to //%s:%s"
+			if c.visibilityWarnings {
+				c.warn(c.moduleWarning(module, scanner.Position{}, DiagnosticCodeVisibility, format,
+					dep.module.Name(), depPkg, pkg, module.Name()))
+				continue
+			}
+			errs = append(errs, c.ModuleErrorf(module.logicModule, format,
+				dep.module.Name(), depPkg, pkg, module.Name()))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,249 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"sort"
+	"testing"
+)
+
+type visibilityTestModule struct {
+	SimpleName
+	properties struct {
+		Deps       []string
+		Visibility []string
+	}
+}
+
+func newVisibilityTestModule() (Module, []interface{}) {
+	m := &visibilityTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *visibilityTestModule) GenerateBuildActions(ModuleContext) {}
+
+func (m *visibilityTestModule) Visibility() []string {
+	return m.properties.Visibility
+}
+
+func visibilityTestDepsMutator(ctx BottomUpMutatorContext) {
+	if m, ok := ctx.Module().(*visibilityTestModule); ok {
+		ctx.AddDependency(ctx.Module(), nil, m.properties.Deps...)
+	}
+}
+
+func runVisibilityTest(t *testing.T, fs map[string][]byte, visibilityWarnings bool) (*Context, []error) {
+	t.Helper()
+	ctx := NewContext()
+	ctx.SetVisibilityWarnings(visibilityWarnings)
+	ctx.RegisterModuleType("test", newVisibilityTestModule)
+	ctx.RegisterBottomUpMutator("deps", visibilityTestDepsMutator)
+	ctx.RegisterBottomUpMutator("variants", func(ctx BottomUpMutatorContext) {
+		ctx.CreateVariations("a", "b")
+	})
+
+	var files []string
+	for file := range fs {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	ctx.MockFileSystem(fs)
+
+	_, errs := ctx.ParseFileList(".", files, nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	return ctx, errs
+}
+
+func TestVisibility(t *testing.T) {
+	testCases := []struct {
+		name   string
+		fs     map[string][]byte
+		errors []string
+	}{
+		{
+			name: "default public",
+			fs: map[string][]byte{
+				"lib/Android.bp": []byte(`
+					test {
+						name: "lib",
+					}
+				`),
+				"app/Android.bp": []byte(`
+					test {
+						name: "app",
+						deps: ["lib"],
+					}
+				`),
+			},
+		},
+		{
+			name: "explicit public",
+			fs: map[string][]byte{
+				"lib/Android.bp": []byte(`
+					test {
+						name: "lib",
+						visibility: ["//visibility:public"],
+					}
+				`),
+				"app/Android.bp": []byte(`
+					test {
+						name: "app",
+						deps: ["lib"],
+					}
+				`),
+			},
+		},
+		{
+			name: "private",
+			fs: map[string][]byte{
+				"lib/Android.bp": []byte(`
+					test {
+						name: "lib",
+						visibility: ["//visibility:private"],
+					}
+
+					test {
+						name: "lib_test",
+						deps: ["lib"],
+					}
+				`),
+				"app/Android.bp": []byte(`
+					test {
+						name: "app",
+						deps: ["lib"],
+					}
+				`),
+				"other/Android.bp": []byte(`
+					test {
+						name: "other",
+						deps: ["lib"],
+					}
+				`),
+			},
+			errors: []string{
+				`app/Android.bp:2:6: module "app" variant "a": depends on "lib" in //lib, which is not visible to //app:app`,
+				`other/Android.bp:2:6: module "other" variant "a": depends on "lib" in //lib, which is not visible to //other:other`,
+			},
+		},
+		{
+			name: "package scoped",
+			fs: map[string][]byte{
+				"lib/Android.bp": []byte(`
+					test {
+						name: "lib",
+						visibility: ["//app", "//tools:gen", ":lib_test"],
+					}
+				`),
+				"app/Android.bp": []byte(`
+					test {
+						name: "app",
+						deps: ["lib"],
+					}
+				`),
+				"app/sub/Android.bp": []byte(`
+					test {
+						name: "app_sub",
+						deps: ["lib"],
+					}
+				`),
+				"tools/Android.bp": []byte(`
+					test {
+						name: "gen",
+						deps: ["lib"],
+					}
+
+					test {
+						name: "other_tool",
+						deps: ["lib"],
+					}
+				`),
+			},
+			errors: []string{
+				`app/sub/Android.bp:2:6: module "app_sub" variant "a": depends on "lib" in //lib, which is not visible to //app/sub:app_sub`,
+				`tools/Android.bp:7:6: module "other_tool" variant "a": depends on "lib" in //lib, which is not visible to //tools:other_tool`,
+			},
+		},
+		{
+			name: "root package",
+			fs: map[string][]byte{
+				"Android.bp": []byte(`
+					test {
+						name: "root",
+						deps: ["lib"],
+					}
+				`),
+				"lib/Android.bp": []byte(`
+					test {
+						name: "lib",
+						visibility: ["//:root"],
+					}
+				`),
+			},
+		},
+		{
+			name: "invalid rule",
+			fs: map[string][]byte{
+				"lib/Android.bp": []byte(`
+					test {
+						name: "lib",
+						visibility: ["app"],
+					}
+				`),
+				"app/Android.bp": []byte(`
+					test {
+						name: "app",
+						deps: ["lib"],
+					}
+				`),
+			},
+			errors: []string{
+				`lib/Android.bp:4:17: module "lib" variant "a": visibility: invalid visibility rule "app": must start with "//" or ":"`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, errs := runVisibilityTest(t, tc.fs, false)
+			sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+			expectedErrors(t, errs, tc.errors...)
+		})
+	}
+}
+
+func TestVisibilityWarnings(t *testing.T) {
+	ctx, errs := runVisibilityTest(t, map[string][]byte{
+		"lib/Android.bp": []byte(`
+			test {
+				name: "lib",
+				visibility: ["//visibility:private"],
+			}
+		`),
+		"app/Android.bp": []byte(`
+			test {
+				name: "app",
+				deps: ["lib"],
+			}
+		`),
+	}, true)
+	expectedErrors(t, errs)
+
+	expectedWarnings(t, ctx.Warnings(),
+		`app/Android.bp:2:4: warning: module "app" variant "a": depends on "lib" in //lib, which is not visible to //app:app [visibility]`)
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"text/scanner"
+)
+
+// A Warning is a problem that doesn't stop the build, like the use of a deprecated property name.
+// Warnings are collected by the Context while parsing Blueprints files, running mutators and
+// generating build actions, and returned by Context.Warnings.
+type Warning struct {
+	// Pos is the position in a Blueprints file the warning is about, or the zero Position if it
+	// isn't about a location in a file.
+	Pos scanner.Position
+
+	Message string
+
+	// Category classifies the warning so that callers can filter them.  The categories reported
+	// by Blueprint itself are the DiagnosticCode constants for warnings, like
+	// DiagnosticCodeDeprecatedProperty.
+	Category string
+}
+
+func (w Warning) String() string {
+	if !w.Pos.IsValid() {
+		return fmt.Sprintf("warning: %s [%s]", w.Message, w.Category)
+	}
+	return fmt.Sprintf("%s: warning: %s [%s]", w.Pos, w.Message, w.Category)
+}
+
+// warn records warnings so that they can be returned by Warnings.  It may be called concurrently.
+func (c *Context) warn(warnings ...Warning) {
+	if len(warnings) == 0 {
+		return
+	}
+	c.warningsLock.Lock()
+	defer c.warningsLock.Unlock()
+	c.warnings = append(c.warnings, warnings...)
+}
+
+// warningCount returns the number of warnings reported so far.
+func (c *Context) warningCount() int {
+	c.warningsLock.Lock()
+	defer c.warningsLock.Unlock()
+	return len(c.warnings)
+}
+
+// truncateWarnings discards the warnings reported after the first n, for example the warnings of
+// build actions that are generated again.
+func (c *Context) truncateWarnings(n int) {
+	c.warningsLock.Lock()
+	defer c.warningsLock.Unlock()
+	c.warnings = c.warnings[:n:n]
+}
+
+// moduleWarning returns a warning about a module at pos, or at the module definition if pos is
+// not valid.
+func (c *Context) moduleWarning(module *moduleInfo, pos scanner.Position, category, format string,
+	args ...interface{}) Warning {
+
+	if !pos.IsValid() {
+		pos = module.pos
+	}
+	return Warning{
+		Pos:      pos,
+		Message:  fmt.Sprintf("%s: %s", module, fmt.Sprintf(format, args...)),
+		Category: category,
+	}
+}
+
+// Warnings returns the warnings reported so far, sorted by their position.  A warning that was
+// reported more than once, for example for a defaults module used by several modules or for a
+// file parsed again by Reparse, is returned once.
+func (c *Context) Warnings() []Warning {
+	c.warningsLock.Lock()
+	warnings := slices.Clone(c.warnings)
+	c.warningsLock.Unlock()
+	c.globLock.Lock()
+	for _, w := range c.emptyGlobWarnings {
+		warnings = append(warnings, w)
+	}
+	c.globLock.Unlock()
+
+	slices.SortStableFunc(warnings, func(a, b Warning) int {
+		if n := cmp.Compare(a.Pos.Filename, b.Pos.Filename); n != 0 {
+			return n
+		}
+		if n := cmp.Compare(a.Pos.Offset, b.Pos.Offset); n != 0 {
+			return n
+		}
+		if n := cmp.Compare(a.Category, b.Category); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.Message, b.Message)
+	})
+	return slices.Compact(warnings)
+}
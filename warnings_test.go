@@ -0,0 +1,81 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"slices"
+	"testing"
+	"text/scanner"
+)
+
+func expectedWarnings(t *testing.T, warnings []Warning, want ...string) {
+	t.Helper()
+	var got []string
+	for _, w := range warnings {
+		got = append(got, w.String())
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected warnings:\n  %q\ngot:\n  %q", want, got)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("renamed_module", newRenamedPropertyModule)
+	ctx.RegisterBottomUpMutator("warn", func(ctx BottomUpMutatorContext) {
+		if m, ok := ctx.Module().(*renamedPropertyModule); ok && len(m.properties.Srcs) > 1 {
+			ctx.PropertyWarningf("srcs", "too-many-srcs", "found %d srcs", len(m.properties.Srcs))
+		}
+		if ctx.ModuleName() == "c" {
+			ctx.ModuleWarningf("custom", "module warning")
+		}
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			renamed_module {
+				name: "b",
+				srcs: ["b1.c", "b2.c"],
+			}
+
+			renamed_module {
+				name: "a",
+				files: ["a.c"],
+			}
+
+			renamed_module {
+				name: "c",
+			}
+		`),
+	})
+
+	if _, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	// The warnings must not fail the build.
+	_, errs := ctx.ResolveDependencies(nil)
+	expectedErrors(t, errs)
+
+	expectedWarnings(t, ctx.Warnings(),
+		`Android.bp:4:9: warning: module "b": found 2 srcs [too-many-srcs]`,
+		`Android.bp:9:10: warning: property "files" is deprecated, use "srcs" instead [deprecated-property]`,
+		`Android.bp:12:4: warning: module "c": module warning [custom]`)
+
+	deprecated := ctx.Warnings()[1]
+	wantPos := scanner.Position{Filename: "Android.bp", Line: 9, Column: 10}
+	if deprecated.Category != DiagnosticCodeDeprecatedProperty || deprecated.Pos.Filename != wantPos.Filename ||
+		deprecated.Pos.Line != wantPos.Line || deprecated.Pos.Column != wantPos.Column {
+		t.Errorf("expected a %s warning at %s, got %#v", DiagnosticCodeDeprecatedProperty, wantPos, deprecated)
+	}
+}